@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestMediaSignature(t *testing.T) {
+	cfg := &apiConfig{mediaURLKey: []byte("media-key"), mediaURLExpiry: time.Minute}
+	path := "/api/thumbnails/" + uuid.New().String()
+	signed := cfg.signMediaURL(path)
+
+	tests := []struct {
+		name string
+		url  string
+		want bool
+	}{
+		{"signed", signed, true},
+		{"unsigned", path, false},
+		{"other path", strings.Replace(signed, path, "/api/thumbnails/"+uuid.New().String(), 1), false},
+		{"tampered expiry", strings.Replace(signed, "expires=", "expires=9", 1), false},
+		{"expired", (&apiConfig{mediaURLKey: cfg.mediaURLKey, mediaURLExpiry: -time.Minute}).signMediaURL(path), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.url, nil)
+			if got := cfg.hasMediaSignature(r); got != tt.want {
+				t.Errorf("hasMediaSignature(%s) = %t, want %t", tt.url, got, tt.want)
+			}
+		})
+	}
+
+	other := &apiConfig{mediaURLKey: []byte("other-key"), mediaURLExpiry: time.Minute}
+	if other.hasMediaSignature(httptest.NewRequest("GET", signed, nil)) {
+		t.Error("signature accepted under a different key")
+	}
+}
+
+func TestCanViewVideoIgnoresTokenQuery(t *testing.T) {
+	cfg := &apiConfig{jwtSecrets: []string{"secret"}, mediaURLKey: []byte("media-key"), mediaURLExpiry: time.Minute}
+	video := database.Video{ID: uuid.New(), IsPublic: false}
+
+	r := httptest.NewRequest("GET", "/api/thumbnails/"+video.ID.String()+"?token=anything", nil)
+	if cfg.canViewVideo(r, video) {
+		t.Error("private video visible without a signature or Authorization header")
+	}
+	video.IsPublic = true
+	if !cfg.canViewVideo(r, video) {
+		t.Error("public video not visible")
+	}
+}
+
+func TestOwnsVideoIgnoresTokenQuery(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	owner, token := createTestUser(t, cfg)
+	video := database.Video{ID: uuid.New()}
+	video.UserID = owner.ID
+
+	r := httptest.NewRequest("GET", "/api/videos/"+video.ID.String()+"/download?token="+token, nil)
+	if cfg.ownsVideo(r, video) {
+		t.Error("token in the query string was accepted")
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	if !cfg.ownsVideo(r, video) {
+		t.Error("owner's Authorization header wasn't accepted")
+	}
+}
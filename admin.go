@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+var errNotAdmin = errors.New("user is not an admin")
+
+// authenticateAdmin validates the request's JWT and checks that its subject
+// is one of the configured admin users.
+func (cfg *apiConfig) authenticateAdmin(r *http.Request) (uuid.UUID, error) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	userID, err := cfg.validateJWT(token)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if !cfg.adminUserIDs[userID] {
+		return userID, errNotAdmin
+	}
+	return userID, nil
+}
+
+// requireAdmin writes the appropriate error response and returns false if
+// the request isn't from an admin.
+func (cfg *apiConfig) requireAdmin(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	userID, err := cfg.authenticateAdmin(r)
+	if errors.Is(err, errNotAdmin) {
+		cfg.audit(r, userID, uuid.Nil, auditAdmin, auditDenied, "not an admin")
+		respondWithError(w, http.StatusForbidden, "Admin access required", err)
+		return uuid.Nil, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return uuid.Nil, false
+	}
+	cfg.audit(r, userID, uuid.Nil, auditAdmin, auditAllowed, "")
+	return userID, true
+}
@@ -261,7 +261,7 @@ function viewVideo(video) {
     thumbnailImg.style.display = 'none';
   } else {
     thumbnailImg.style.display = 'block';
-    thumbnailImg.src = video.thumbnail_url;
+    thumbnailImg.src = video.signed_thumbnail_url || `/api/thumbnails/${video.id}`;
   }
 
   const videoPlayer = document.getElementById('video-player');
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 func (cfg apiConfig) ensureAssetsDir() error {
@@ -10,3 +16,60 @@ func (cfg apiConfig) ensureAssetsDir() error {
 	}
 	return nil
 }
+
+// assetPathFromURL maps a URL built by handlerUploadThumbnail back to the
+// file on disk.
+func (cfg apiConfig) assetPathFromURL(url string) (string, bool) {
+	prefix := fmt.Sprintf("http://localhost:%s/assets/", cfg.port)
+	name, ok := strings.CutPrefix(url, prefix)
+	if !ok || name == "" || strings.ContainsAny(name, `/\`) {
+		return "", false
+	}
+	return filepath.Join(cfg.assetsRoot, name), true
+}
+
+// randomFileName returns an unguessable file name with the given extension.
+func randomFileName(ext string) (string, error) {
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(randomBytes) + ext, nil
+}
+
+const (
+	thumbnailServeProxy    = "proxy"
+	thumbnailServeRedirect = "redirect"
+
+	thumbnailKeysRandom = "random"
+	thumbnailKeysVideo  = "video"
+)
+
+// thumbnailStoreConfig controls where thumbnails live and how they're
+// handed to clients when the bucket is private.
+type thumbnailStoreConfig struct {
+	useS3     bool
+	serveMode string
+	urlExpiry time.Duration
+	// keyScheme names thumbnails randomly or after their video.
+	keyScheme string
+	// frameRangeReads lets ffmpeg read video frames straight from S3
+	// instead of downloading the whole video first.
+	frameRangeReads bool
+	// frameCandidates is how many frames are compared when picking a
+	// thumbnail automatically; 1 takes the first frame.
+	frameCandidates int
+	// progressiveJPEG rewrites JPEG thumbnails as progressive with
+	// jpegtran before storing them.
+	progressiveJPEG bool
+	// perceptualHash stores a hash of each new thumbnail for finding
+	// near-duplicate videos. Decoding every thumbnail makes it opt-in.
+	perceptualHash bool
+	// storageClass is the S3 storage class new thumbnails are stored in,
+	// "" for the bucket default.
+	storageClass string
+	// coldStorageClass is where thumbnails are moved once their video
+	// hasn't been viewed for coldAfter. 0 leaves them where they are.
+	coldStorageClass string
+	coldAfter        time.Duration
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	audioStatusOK     = "ok"
+	audioStatusNone   = "none"
+	audioStatusSilent = "silent"
+
+	// silentLoudness is loudnorm's gate floor; audio measuring at or below
+	// it has nothing audible in it.
+	silentLoudness = -70.0
+)
+
+// audioConfig controls the optional EBU R128 loudness normalization.
+type audioConfig struct {
+	normalize bool
+	// targets for integrated loudness (LUFS), true peak (dBTP) and
+	// loudness range (LU).
+	targetI   float64
+	targetTP  float64
+	targetLRA float64
+}
+
+// loudnessMeasurement is the JSON summary loudnorm prints to stderr. It
+// reports numbers as strings, which may be "-inf" for silence.
+type loudnessMeasurement struct {
+	InputI       string `json:"input_i"`
+	InputTP      string `json:"input_tp"`
+	InputLRA     string `json:"input_lra"`
+	InputThresh  string `json:"input_thresh"`
+	TargetOffset string `json:"target_offset"`
+}
+
+// hasAudioStream reports whether the file has any audio stream.
+func (cfg *apiConfig) hasAudioStream(ctx context.Context, filePath string) (bool, error) {
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error",
+		"-select_streams", "a",
+		"-show_entries", "stream=index",
+		"-of", "csv=p=0",
+		filePath)
+	cmd.Stdout = &out
+	if err := cfg.ffmpeg.run(ctx, cmd); err != nil {
+		return false, fmt.Errorf("ffprobe failed: %w", err)
+	}
+	return strings.TrimSpace(out.String()) != "", nil
+}
+
+// measureLoudness runs loudnorm's analysis pass over the audio only.
+func (cfg *apiConfig) measureLoudness(ctx context.Context, filePath string) (loudnessMeasurement, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-hide_banner", "-nostats",
+		"-i", filePath,
+		"-vn",
+		"-af", cfg.loudnormFilter()+":print_format=json",
+		"-f", "null", "-")
+	cmd.Stderr = &stderr
+	if err := cfg.ffmpeg.run(ctx, cmd); err != nil {
+		return loudnessMeasurement{}, fmt.Errorf("ffmpeg loudness analysis failed: %w", err)
+	}
+
+	// The summary is the last JSON object in the log.
+	output := stderr.String()
+	start, end := strings.LastIndex(output, "{"), strings.LastIndex(output, "}")
+	if start < 0 || end < start {
+		return loudnessMeasurement{}, errors.New("loudnorm printed no measurement")
+	}
+	var m loudnessMeasurement
+	if err := json.Unmarshal([]byte(output[start:end+1]), &m); err != nil {
+		return loudnessMeasurement{}, fmt.Errorf("couldn't parse loudnorm measurement: %w", err)
+	}
+	return m, nil
+}
+
+func (cfg *apiConfig) loudnormFilter() string {
+	return fmt.Sprintf("loudnorm=I=%s:TP=%s:LRA=%s",
+		formatDB(cfg.audio.targetI), formatDB(cfg.audio.targetTP), formatDB(cfg.audio.targetLRA))
+}
+
+func formatDB(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+// checkAudio records on video whether the file has audible sound and, when
+// normalization is on, normalizes it in a second loudnorm pass using the
+// first pass's measurements. Only the audio is re-encoded; video is
+// stream-copied. It returns the path of the normalized file, or "" when
+// the file was left alone.
+func (cfg *apiConfig) checkAudio(ctx context.Context, filePath string, video *database.Video) (string, error) {
+	hasAudio, err := cfg.hasAudioStream(ctx, filePath)
+	if err != nil {
+		return "", err
+	}
+	if !hasAudio {
+		status := audioStatusNone
+		video.AudioStatus = &status
+		video.AudioNormalization = nil
+		return "", nil
+	}
+
+	m, err := cfg.measureLoudness(ctx, filePath)
+	if err != nil {
+		return "", err
+	}
+	inputI, err := strconv.ParseFloat(m.InputI, 64)
+	if err != nil {
+		return "", fmt.Errorf("unexpected loudness %q: %w", m.InputI, err)
+	}
+	status := audioStatusOK
+	if math.IsInf(inputI, -1) || inputI <= silentLoudness {
+		status = audioStatusSilent
+	}
+	video.AudioStatus = &status
+	video.AudioNormalization = nil
+	if !cfg.audio.normalize || status == audioStatusSilent {
+		return "", nil
+	}
+
+	applied := database.AudioNormalization{
+		TargetI:   cfg.audio.targetI,
+		TargetTP:  cfg.audio.targetTP,
+		TargetLRA: cfg.audio.targetLRA,
+		InputI:    inputI,
+	}
+	for _, field := range []struct {
+		s   string
+		dst *float64
+	}{
+		{m.InputTP, &applied.InputTP},
+		{m.InputLRA, &applied.InputLRA},
+		{m.InputThresh, &applied.InputThresh},
+		{m.TargetOffset, &applied.TargetOffset},
+	} {
+		v, err := strconv.ParseFloat(field.s, 64)
+		if err != nil || math.IsInf(v, 0) {
+			return "", fmt.Errorf("unexpected loudnorm measurement %q", field.s)
+		}
+		*field.dst = v
+	}
+
+	filter := fmt.Sprintf("%s:measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s:offset=%s:linear=true",
+		cfg.loudnormFilter(), formatDB(applied.InputI), formatDB(applied.InputTP),
+		formatDB(applied.InputLRA), formatDB(applied.InputThresh), formatDB(applied.TargetOffset))
+
+	outputPath := filePath + ".loudnorm"
+	args := []string{
+		"-y",
+		"-i", filePath,
+		"-c:v", "copy",
+		"-af", filter,
+		"-c:a", "aac",
+		"-b:a", "192k",
+		// loudnorm resamples to 192kHz internally.
+		"-ar", "48000",
+	}
+	args = append(args, cfg.ffmpeg.outputArgs()...)
+	args = append(args, "-f", "mp4", outputPath)
+
+	if err := cfg.ffmpeg.run(ctx, exec.CommandContext(ctx, "ffmpeg", args...)); err != nil {
+		os.Remove(outputPath)
+		return "", fmt.Errorf("ffmpeg loudness normalization failed: %w", err)
+	}
+	video.AudioNormalization = &applied
+	return outputPath, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Audit actions and results.
+const (
+	auditUpload    = "upload"
+	auditUpdate    = "update"
+	auditDelete    = "delete"
+	auditDuplicate = "duplicate"
+	auditView      = "view"
+	auditAdmin     = "admin"
+	auditAllowed   = "allowed"
+	auditDenied    = "denied"
+)
+
+// auditSink is where audit events end up. Writes happen on the audit
+// logger's goroutine, never on a request's.
+type auditSink interface {
+	writeAuditEvents(events []database.AuditEvent) error
+}
+
+// jsonLinesSink writes one JSON object per event, to stdout or a file.
+type jsonLinesSink struct {
+	w io.Writer
+}
+
+func (s jsonLinesSink) writeAuditEvents(events []database.AuditEvent) error {
+	enc := json.NewEncoder(s.w)
+	for _, event := range events {
+		if err := enc.Encode(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type dbAuditSink struct {
+	db database.Client
+}
+
+func (s dbAuditSink) writeAuditEvents(events []database.AuditEvent) error {
+	return s.db.InsertAuditEvents(events)
+}
+
+// newAuditSink builds the sink named by AUDIT_LOG_SINK, or returns nil if
+// auditing is off.
+func newAuditSink(kind, path string, db database.Client) (auditSink, error) {
+	switch kind {
+	case "", "none":
+		return nil, nil
+	case "stdout":
+		return jsonLinesSink{w: os.Stdout}, nil
+	case "file":
+		if path == "" {
+			return nil, fmt.Errorf("AUDIT_LOG_FILE must be set when AUDIT_LOG_SINK is file")
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		return jsonLinesSink{w: f}, nil
+	case "db":
+		return dbAuditSink{db: db}, nil
+	default:
+		return nil, fmt.Errorf("unknown AUDIT_LOG_SINK %q, want none, stdout, file or db", kind)
+	}
+}
+
+const auditBatchMax = 100
+
+// auditLogger queues events and hands them to the sink in batches, so a
+// slow sink never holds up a request. If the queue is full the event is
+// dropped and counted rather than blocking.
+type auditLogger struct {
+	sink    auditSink
+	events  chan database.AuditEvent
+	dropped atomic.Int64
+}
+
+func newAuditLogger(sink auditSink, queueSize int) *auditLogger {
+	return &auditLogger{
+		sink:   sink,
+		events: make(chan database.AuditEvent, queueSize),
+	}
+}
+
+func (al *auditLogger) record(event database.AuditEvent) {
+	if al == nil || al.sink == nil {
+		return
+	}
+	select {
+	case al.events <- event:
+	default:
+		al.dropped.Add(1)
+	}
+}
+
+func (al *auditLogger) run() {
+	for event := range al.events {
+		batch := []database.AuditEvent{event}
+	fill:
+		for len(batch) < auditBatchMax {
+			select {
+			case event := <-al.events:
+				batch = append(batch, event)
+			default:
+				break fill
+			}
+		}
+		if err := al.sink.writeAuditEvents(batch); err != nil {
+			log.Printf("failed to write %d audit events: %v", len(batch), err)
+		}
+		if n := al.dropped.Swap(0); n > 0 {
+			log.Printf("dropped %d audit events, queue full", n)
+		}
+	}
+}
+
+// audit records an access decision about videoID, which may be uuid.Nil
+// for decisions not tied to a video.
+func (cfg *apiConfig) audit(r *http.Request, userID, videoID uuid.UUID, action, result, detail string) {
+	event := database.AuditEvent{
+		Time:   time.Now().UTC(),
+		UserID: userID,
+		Action: action,
+		Result: result,
+		Route:  r.Pattern,
+		Detail: detail,
+	}
+	if videoID != uuid.Nil {
+		event.VideoID = &videoID
+	}
+	cfg.auditLog.record(event)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// recordingSink keeps every batch it's given.
+type recordingSink struct {
+	mu      sync.Mutex
+	batches [][]database.AuditEvent
+}
+
+func (s *recordingSink) writeAuditEvents(events []database.AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, events)
+	return nil
+}
+
+// drainAudit returns the events queued on al so far.
+func drainAudit(al *auditLogger) []database.AuditEvent {
+	var events []database.AuditEvent
+	for {
+		select {
+		case event := <-al.events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestCanViewVideoAudit(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	cfg.mediaURLKey = []byte("media-key")
+	cfg.mediaURLExpiry = time.Minute
+	cfg.auditLog = newAuditLogger(&recordingSink{}, 10)
+	owner, ownerToken := createTestUser(t, cfg)
+	other, otherToken := createTestUser(t, cfg)
+	video := createTestVideo(t, cfg, owner.ID)
+	video.IsPublic = false
+	path := "/api/thumbnails/" + video.ID.String()
+
+	tests := []struct {
+		name       string
+		url        string
+		token      string
+		want       bool
+		wantUser   uuid.UUID
+		wantResult string
+		wantDetail string
+	}{
+		{"no token", path, "", false, uuid.Nil, auditDenied, "no token"},
+		{"invalid token", path, "not-a-jwt", false, uuid.Nil, auditDenied, "invalid token"},
+		{"not owner", path, otherToken, false, other.ID, auditDenied, "not owner"},
+		{"owner", path, ownerToken, true, owner.ID, auditAllowed, ""},
+		{"signed URL", cfg.signMediaURL(path), "", true, uuid.Nil, auditAllowed, "signed URL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if got := cfg.canViewVideo(r, video); got != tt.want {
+				t.Fatalf("canViewVideo = %t, want %t", got, tt.want)
+			}
+			events := drainAudit(cfg.auditLog)
+			if len(events) != 1 {
+				t.Fatalf("audit events = %+v, want one", events)
+			}
+			e := events[0]
+			if e.Action != auditView || e.Result != tt.wantResult || e.Detail != tt.wantDetail || e.UserID != tt.wantUser {
+				t.Errorf("audit event = %+v, want %s %s %q by %s", e, auditView, tt.wantResult, tt.wantDetail, tt.wantUser)
+			}
+			if e.VideoID == nil || *e.VideoID != video.ID {
+				t.Errorf("audit video_id = %v, want %s", e.VideoID, video.ID)
+			}
+		})
+	}
+
+	video.IsPublic = true
+	if !cfg.canViewVideo(httptest.NewRequest(http.MethodGet, path, nil), video) {
+		t.Fatal("public video not visible")
+	}
+	if events := drainAudit(cfg.auditLog); len(events) != 0 {
+		t.Errorf("audit events = %+v, want none for a public video", events)
+	}
+}
+
+func TestAuditLoggerWritesQueuedEvents(t *testing.T) {
+	sink := &recordingSink{}
+	al := newAuditLogger(sink, 10)
+	for range 3 {
+		al.record(database.AuditEvent{Action: auditUpload, Result: auditAllowed})
+	}
+	go al.run()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		sink.mu.Lock()
+		n := 0
+		for _, batch := range sink.batches {
+			n += len(batch)
+		}
+		sink.mu.Unlock()
+		if n == 3 {
+			return
+		}
+		if n > 3 || time.Now().After(deadline) {
+			t.Fatalf("sink got %d events, want 3", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAuditLoggerDropsWhenFull(t *testing.T) {
+	al := newAuditLogger(&recordingSink{}, 1)
+	done := make(chan struct{})
+	go func() {
+		for range 3 {
+			al.record(database.AuditEvent{Action: auditDelete, Result: auditAllowed})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("record blocked on a full queue")
+	}
+	if n := al.dropped.Load(); n != 2 {
+		t.Errorf("dropped = %d, want 2", n)
+	}
+
+	// A disabled logger drops nothing and records nothing.
+	var off *auditLogger
+	off.record(database.AuditEvent{})
+}
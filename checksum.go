@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/textproto"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// uploadChecksum is the digest a client sent for an uploaded file, in the
+// headers S3 itself uses: Content-MD5 and x-amz-checksum-sha256, both
+// base64. Either may be empty, in which case it isn't checked.
+type uploadChecksum struct {
+	md5    string
+	sha256 string
+}
+
+// parseUploadChecksum reads the checksum headers from the file's part
+// headers, falling back to the request's.
+func parseUploadChecksum(part textproto.MIMEHeader, request http.Header) (uploadChecksum, error) {
+	get := func(name string) string {
+		if v := part.Get(name); v != "" {
+			return v
+		}
+		return request.Get(name)
+	}
+	c := uploadChecksum{
+		md5:    get("Content-MD5"),
+		sha256: get("X-Amz-Checksum-Sha256"),
+	}
+	if err := checkDigestHeader("Content-MD5", c.md5, md5.Size); err != nil {
+		return uploadChecksum{}, err
+	}
+	if err := checkDigestHeader("x-amz-checksum-sha256", c.sha256, sha256.Size); err != nil {
+		return uploadChecksum{}, err
+	}
+	return c, nil
+}
+
+func checkDigestHeader(name, value string, size int) error {
+	if value == "" {
+		return nil
+	}
+	digest, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(digest) != size {
+		return fmt.Errorf("%s must be a base64 encoded %d byte digest", name, size)
+	}
+	return nil
+}
+
+func (c uploadChecksum) isSet() bool {
+	return c.md5 != "" || c.sha256 != ""
+}
+
+// verify hashes r and compares it with the checksum.
+func (c uploadChecksum) verify(r io.Reader) error {
+	if !c.isSet() {
+		return nil
+	}
+	md5Hash, sha256Hash := md5.New(), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(md5Hash, sha256Hash), r); err != nil {
+		return err
+	}
+	if !digestMatches(c.md5, md5Hash) || !digestMatches(c.sha256, sha256Hash) {
+		return errChecksumMismatch
+	}
+	return nil
+}
+
+func (c uploadChecksum) verifyFile(path string) error {
+	if !c.isSet() {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.verify(f)
+}
+
+func digestMatches(expected string, h hash.Hash) bool {
+	if expected == "" {
+		return true
+	}
+	digest, _ := base64.StdEncoding.DecodeString(expected)
+	return bytes.Equal(digest, h.Sum(nil))
+}
+
+// apply has S3 check the object against the checksum too. Only use it
+// when the object is the uploaded bytes unchanged.
+func (c uploadChecksum) apply(input *s3.PutObjectInput) {
+	if c.md5 != "" {
+		input.ContentMD5 = &c.md5
+	}
+	if c.sha256 != "" {
+		input.ChecksumSHA256 = &c.sha256
+	}
+}
+
+func respondWithChecksumError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errChecksumMismatch) {
+		respondWithError(w, http.StatusBadRequest, "checksum mismatch", err)
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, "Couldn't verify checksum", err)
+}
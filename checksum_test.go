@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func TestUploadChecksum(t *testing.T) {
+	body := "tubely video bytes"
+	md5Sum := md5.Sum([]byte(body))
+	sha256Sum := sha256.Sum256([]byte(body))
+	goodMD5 := base64.StdEncoding.EncodeToString(md5Sum[:])
+	goodSHA256 := base64.StdEncoding.EncodeToString(sha256Sum[:])
+	otherMD5 := md5.Sum([]byte("something else"))
+	badMD5 := base64.StdEncoding.EncodeToString(otherMD5[:])
+
+	tests := []struct {
+		name     string
+		part     textproto.MIMEHeader
+		request  http.Header
+		parseErr bool
+		wantErr  error
+	}{
+		{name: "none"},
+		{name: "md5 on part", part: textproto.MIMEHeader{"Content-Md5": {goodMD5}}},
+		{name: "sha256 on request", request: http.Header{"X-Amz-Checksum-Sha256": {goodSHA256}}},
+		{name: "both", request: http.Header{"Content-Md5": {goodMD5}, "X-Amz-Checksum-Sha256": {goodSHA256}}},
+		{name: "part wins over request", part: textproto.MIMEHeader{"Content-Md5": {goodMD5}}, request: http.Header{"Content-Md5": {badMD5}}},
+		{name: "mismatch", request: http.Header{"Content-Md5": {badMD5}}, wantErr: errChecksumMismatch},
+		{name: "one of two wrong", request: http.Header{"Content-Md5": {badMD5}, "X-Amz-Checksum-Sha256": {goodSHA256}}, wantErr: errChecksumMismatch},
+		{name: "not base64", request: http.Header{"Content-Md5": {"%%%"}}, parseErr: true},
+		{name: "wrong length", request: http.Header{"X-Amz-Checksum-Sha256": {goodMD5}}, parseErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseUploadChecksum(tt.part, tt.request)
+			if tt.parseErr {
+				if err == nil {
+					t.Fatal("parseUploadChecksum accepted a malformed digest")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := c.verify(strings.NewReader(body)); !errors.Is(err, tt.wantErr) {
+				t.Errorf("verify err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHandlerUploadVideoChecksumMismatch(t *testing.T) {
+	cfg, store, ffmpeg := newTestConfig(t)
+	user, token := createTestUser(t, cfg)
+	video := createTestVideo(t, cfg, user.ID)
+
+	sum := md5.Sum([]byte("not the uploaded file"))
+	req := newUploadRequest(t, "/api/video_upload/"+video.ID.String(), "video", "clip.mp4", "video/mp4", testMP4)
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	rec := uploadVideo(cfg, req, video.ID.String(), token)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "checksum mismatch") {
+		t.Fatalf("status = %d, want 400 checksum mismatch: %s", rec.Code, rec.Body)
+	}
+	if keys := store.keys(); len(keys) != 0 {
+		t.Errorf("stored objects = %v, want none", keys)
+	}
+	if n := ffmpeg.ran("ffmpeg"); n != 0 {
+		t.Errorf("ffmpeg ran %d times, want 0", n)
+	}
+
+	sum = md5.Sum(testMP4)
+	req = newUploadRequest(t, "/api/video_upload/"+video.ID.String(), "video", "clip.mp4", "video/mp4", testMP4)
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	if rec := uploadVideo(cfg, req, video.ID.String(), token); rec.Code != http.StatusOK {
+		t.Errorf("matching checksum: status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+}
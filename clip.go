@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// clipConfig controls on-demand "share a moment" clips.
+type clipConfig struct {
+	maxLength time.Duration
+	// streamCopy cuts without re-encoding. It's much cheaper but the clip
+	// can only start on a keyframe, so it may begin a little early
+	// whichever seek mode is asked for.
+	streamCopy bool
+}
+
+// Clips are cut to share a precise moment, so by default they decode
+// their way to it.
+const defaultClipSeek = seekAccurate
+
+var errInvalidClipRange = errors.New("invalid clip range")
+
+// clipKey names a clip after the video's current object and the range, so
+// repeated requests reuse it and replacing the video's file never serves a
+// clip of the old cut. Times are rounded to the millisecond. Accurately
+// seeked clips get their own key; fast ones keep the key clips had before
+// there was a choice, since that's how they were cut.
+func (cfg *apiConfig) clipKey(video database.Video, videoKey string, start, end float64, seek seekMode) string {
+	base := strings.TrimSuffix(path.Base(videoKey), path.Ext(videoKey))
+	suffix := ""
+	if seek == seekAccurate {
+		suffix = "-accurate"
+	}
+	return fmt.Sprintf("%s%s-%d-%d%s.mp4", cfg.clipPrefix(video.ID), base, int64(start*1000), int64(end*1000), suffix)
+}
+
+// clipPrefix is the key prefix all of a video's clips are stored under.
+func (cfg *apiConfig) clipPrefix(videoID uuid.UUID) string {
+	return fmt.Sprintf("%sclips/%s/", cfg.s3KeyPrefix, videoID)
+}
+
+// createClip returns the URL of the [start, end) segment of the video,
+// cutting and uploading it unless it already exists. Clips aren't recorded
+// in the db; deleting the video removes them by their prefix.
+func (cfg *apiConfig) createClip(ctx context.Context, video database.Video, start, end float64, seek seekMode) (string, error) {
+	if start < 0 || end <= start {
+		return "", fmt.Errorf("%w: end must be after start", errInvalidClipRange)
+	}
+	if end-start > cfg.clips.maxLength.Seconds() {
+		return "", fmt.Errorf("%w: clips can be at most %s long", errInvalidClipRange, cfg.clips.maxLength)
+	}
+
+	videoKey, err := cfg.s3KeyFromURL(*video.VideoURL)
+	if err != nil {
+		return "", err
+	}
+	key := cfg.clipKey(video, videoKey, start, end, seek)
+	if _, err := cfg.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &cfg.s3Bucket,
+		Key:    &key,
+	}); err == nil {
+		return cfg.objectURL(key), nil
+	}
+
+	videoPath, err := cfg.forVideo(video).downloadObjectToTemp(ctx, videoKey)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(videoPath)
+
+	duration, err := cfg.getVideoDuration(ctx, videoPath)
+	if err != nil {
+		return "", fmt.Errorf("couldn't get video duration: %w", err)
+	}
+	if end > duration {
+		return "", fmt.Errorf("%w: the video is only %.1fs long", errInvalidClipRange, duration)
+	}
+
+	clipPath := videoPath + ".clip.mp4"
+	args := append([]string{"-y"}, seekInputArgs(seek, videoPath, start, end-start)...)
+	if cfg.clips.streamCopy {
+		args = append(args, "-c", "copy")
+	} else {
+		args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-c:a", "aac")
+	}
+	args = append(args, "-movflags", "faststart")
+	args = append(args, cfg.ffmpeg.outputArgs()...)
+	args = append(args, "-f", "mp4", clipPath)
+
+	if err := cfg.ffmpeg.run(ctx, exec.CommandContext(ctx, "ffmpeg", args...)); err != nil {
+		os.Remove(clipPath)
+		return "", fmt.Errorf("ffmpeg clip failed: %w", err)
+	}
+	defer os.Remove(clipPath)
+
+	return cfg.uploadFileToS3(ctx, clipPath, key, "video/mp4")
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// How uploads our player can't render (HDR or more than 8 bits per
+// channel) are handled.
+const (
+	colorModeReject  = "reject"
+	colorModeTonemap = "tonemap"
+	colorModeAllow   = "allow"
+)
+
+var errUnsupportedColor = errors.New("unsupported color format")
+
+// hdrTransfers are the transfer characteristics of PQ (HDR10, Dolby
+// Vision) and HLG video.
+var hdrTransfers = map[string]bool{
+	"smpte2084":    true,
+	"arib-std-b67": true,
+}
+
+// unsupportedColorReason explains why the player can't show stream as-is,
+// or returns "" if it can.
+func unsupportedColorReason(stream ffprobeStream) string {
+	if hdrTransfers[stream.ColorTransfer] {
+		return "HDR (" + stream.ColorTransfer + ")"
+	}
+	// High bit depth formats carry it in the name, e.g. yuv420p10le.
+	for _, depth := range []string{"p10", "p12", "p16"} {
+		if strings.Contains(stream.PixFmt, depth) {
+			return "high bit depth (" + stream.PixFmt + ")"
+		}
+	}
+	return ""
+}
+
+// convertToSDRIfNeeded rejects or tonemaps HDR and high bit depth video
+// according to the configured mode. It returns the path of the converted
+// file, or "" when the video was left alone.
+func (cfg *apiConfig) convertToSDRIfNeeded(ctx context.Context, filePath string, stream ffprobeStream) (string, error) {
+	reason := unsupportedColorReason(stream)
+	if reason == "" || cfg.colorMode == colorModeAllow {
+		return "", nil
+	}
+	if cfg.colorMode != colorModeTonemap {
+		return "", fmt.Errorf("%w: %s video isn't supported, please upload 8-bit SDR", errUnsupportedColor, reason)
+	}
+
+	// 10-bit SDR only needs its pixel format reduced; HDR has to be
+	// tonemapped into BT.709 first or it looks washed out.
+	filter := "format=yuv420p"
+	if hdrTransfers[stream.ColorTransfer] {
+		filter = "zscale=t=linear:npl=100,format=gbrpf32le,zscale=p=bt709," +
+			"tonemap=tonemap=hable:desat=0,zscale=t=bt709:m=bt709:r=tv,format=yuv420p"
+	}
+
+	outputPath := filePath + ".sdr"
+	args := []string{
+		"-y",
+		"-i", filePath,
+		"-vf", filter,
+		"-c:v", "libx264",
+		"-preset", "medium",
+		"-crf", "18",
+		"-c:a", "copy",
+	}
+	args = append(args, cfg.ffmpeg.outputArgs()...)
+	args = append(args, "-f", "mp4", outputPath)
+
+	if err := cfg.ffmpeg.run(ctx, exec.CommandContext(ctx, "ffmpeg", args...)); err != nil {
+		os.Remove(outputPath)
+		return "", fmt.Errorf("ffmpeg tonemap failed: %w", err)
+	}
+	return outputPath, nil
+}
+
+func nonEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var (
+	sdrStream       = ffprobeStream{CodecType: "video", Width: 1920, Height: 1080, PixFmt: "yuv420p", ColorTransfer: "bt709"}
+	tenBitStream    = ffprobeStream{CodecType: "video", Width: 1920, Height: 1080, PixFmt: "yuv420p10le", ColorTransfer: "bt709"}
+	hdr10Stream     = ffprobeStream{CodecType: "video", Width: 3840, Height: 2160, PixFmt: "yuv420p10le", ColorTransfer: "smpte2084"}
+	hlgStream       = ffprobeStream{CodecType: "video", Width: 3840, Height: 2160, PixFmt: "yuv420p10le", ColorTransfer: "arib-std-b67"}
+	twelveBitStream = ffprobeStream{CodecType: "video", Width: 1920, Height: 1080, PixFmt: "yuv422p12le"}
+)
+
+func TestUnsupportedColorReason(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream ffprobeStream
+		want   string
+	}{
+		{"8-bit SDR", sdrStream, ""},
+		{"no color metadata", ffprobeStream{CodecType: "video", PixFmt: "yuvj420p"}, ""},
+		{"10-bit SDR", tenBitStream, "high bit depth (yuv420p10le)"},
+		{"12-bit", twelveBitStream, "high bit depth (yuv422p12le)"},
+		{"HDR10", hdr10Stream, "HDR (smpte2084)"},
+		{"HLG", hlgStream, "HDR (arib-std-b67)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unsupportedColorReason(tt.stream); got != tt.want {
+				t.Errorf("unsupportedColorReason = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertToSDRIfNeeded(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		stream     ffprobeStream
+		wantErr    error
+		wantFilter string
+	}{
+		{"reject SDR", colorModeReject, sdrStream, nil, ""},
+		{"reject 10-bit", colorModeReject, tenBitStream, errUnsupportedColor, ""},
+		{"reject HDR", colorModeReject, hdr10Stream, errUnsupportedColor, ""},
+		{"tonemap SDR", colorModeTonemap, sdrStream, nil, ""},
+		{"tonemap 10-bit", colorModeTonemap, tenBitStream, nil, "format=yuv420p"},
+		{"tonemap HDR", colorModeTonemap, hdr10Stream, nil, "tonemap=tonemap=hable"},
+		{"allow 10-bit", colorModeAllow, tenBitStream, nil, ""},
+		{"allow HDR", colorModeAllow, hdr10Stream, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, _, ff := newTestConfig(t)
+			cfg.colorMode = tt.mode
+			input := filepath.Join(t.TempDir(), "clip.mp4")
+			if err := os.WriteFile(input, testMP4, 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := cfg.convertToSDRIfNeeded(context.Background(), input, tt.stream)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantFilter == "" {
+				if got != "" || ff.ran("ffmpeg") != 0 {
+					t.Errorf("converted to %q with %d ffmpeg runs, want the video left alone", got, ff.ran("ffmpeg"))
+				}
+				return
+			}
+
+			if got != input+".sdr" {
+				t.Errorf("converted path = %q, want %q", got, input+".sdr")
+			}
+			if ff.ran("ffmpeg") != 1 {
+				t.Fatalf("ffmpeg ran %d times, want 1", ff.ran("ffmpeg"))
+			}
+			args := ff.calls[len(ff.calls)-1]
+			i := slices.Index(args, "-vf")
+			if i < 0 || !strings.Contains(args[i+1], tt.wantFilter) {
+				t.Errorf("ffmpeg args %q, want a -vf with %q", args, tt.wantFilter)
+			}
+			if !strings.HasSuffix(args[i+1], "format=yuv420p") {
+				t.Errorf("filter %q doesn't end in 8-bit yuv420p", args[i+1])
+			}
+		})
+	}
+}
+
+func TestHandlerUploadVideoRejectsHDR(t *testing.T) {
+	cfg, store, ff := newTestConfig(t)
+	cfg.colorMode = colorModeReject
+	ff.streams = []ffprobeStream{hdr10Stream}
+	user, token := createTestUser(t, cfg)
+	video := createTestVideo(t, cfg, user.ID)
+
+	req := newUploadRequest(t, "/api/video_upload/"+video.ID.String(), "video", "clip.mp4", "video/mp4", testMP4)
+	rec := uploadVideo(cfg, req, video.ID.String(), token)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400: %s", rec.Code, rec.Body)
+	}
+	if !strings.Contains(rec.Body.String(), "HDR (smpte2084)") {
+		t.Errorf("body = %s, want the reason", rec.Body)
+	}
+	if keys := store.keys(); len(keys) != 0 {
+		t.Errorf("stored %v, want nothing", keys)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"slices"
+	"sync/atomic"
+)
+
+type connUploadsKey struct{}
+
+// connContext gives every connection its own upload counter, so requests
+// multiplexed over one HTTP/2 connection are counted together.
+func connContext(ctx context.Context, _ net.Conn) context.Context {
+	return context.WithValue(ctx, connUploadsKey{}, new(atomic.Int32))
+}
+
+// connUploadLimitMiddleware turns away uploads beyond UPLOADS_PER_CONNECTION
+// running at once on the same connection with a 429, so a single client
+// can't tie up every upload slot by opening many streams.
+func (cfg *apiConfig) connUploadLimitMiddleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uploads, ok := r.Context().Value(connUploadsKey{}).(*atomic.Int32)
+		if cfg.uploadsPerConn <= 0 || !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if _, pattern := mux.Handler(r); !slices.Contains(uploadRoutes, pattern) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		defer uploads.Add(-1)
+		if n := uploads.Add(1); int(n) > cfg.uploadsPerConn {
+			w.Header().Set("Retry-After", "1")
+			respondWithError(w, http.StatusTooManyRequests, "Too many concurrent uploads on this connection", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// http2Settings tunes the server's HTTP/2. maxStreams caps the streams one
+// client connection may have open at once, the protocol's own bound on how
+// many uploads it can multiplex; UPLOADS_PER_CONNECTION then caps how many
+// of those may be uploads. cleartext also accepts HTTP/2 without TLS
+// (h2c), for running behind a proxy that speaks it.
+type http2Settings struct {
+	maxStreams int
+	cleartext  bool
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+const vttContentType = "text/vtt; charset=utf-8"
+
+// contactSheetConfig controls the grid of frames generated for review.
+type contactSheetConfig struct {
+	columns int
+	rows    int
+	// width of the whole sheet in pixels; each frame gets width/columns.
+	width int
+}
+
+// parseGrid reads a grid size such as "4x4".
+func parseGrid(s string) (columns, rows int, err error) {
+	c, r, ok := strings.Cut(s, "x")
+	if ok {
+		columns, err = strconv.Atoi(c)
+	}
+	if ok && err == nil {
+		rows, err = strconv.Atoi(r)
+	}
+	if !ok || err != nil || columns < 1 || rows < 1 {
+		return 0, 0, fmt.Errorf("grid %q must look like \"4x4\"", s)
+	}
+	return columns, rows, nil
+}
+
+// fitGrid shrinks the grid until there's at least a second of video per
+// frame, so short videos don't repeat frames. The larger side goes first
+// to keep the sheet roughly the configured shape.
+func fitGrid(columns, rows int, duration float64) (int, int) {
+	for columns*rows > 1 && float64(columns*rows) > duration {
+		if columns >= rows {
+			columns--
+		} else {
+			rows--
+		}
+	}
+	return columns, rows
+}
+
+// spriteLayout describes where each sampled frame sits on a contact sheet.
+// Frame i covers i*interval seconds onwards and is tile i, counting left to
+// right, top to bottom.
+type spriteLayout struct {
+	columns    int
+	rows       int
+	tileWidth  int
+	tileHeight int
+	frames     int
+	interval   float64
+	duration   float64
+}
+
+// generateContactSheet tiles frames sampled evenly across the video at
+// videoPath into a single JPEG and returns its path, which the caller must
+// remove, and its layout. The tile filter assembles the grid in one pass, so
+// no individual frames are written. Tiles are scaled to an exact size so the
+// layout matches the image pixel for pixel.
+func (cfg *apiConfig) generateContactSheet(ctx context.Context, videoPath string) (string, spriteLayout, error) {
+	duration, err := cfg.getVideoDuration(ctx, videoPath)
+	if err != nil {
+		return "", spriteLayout{}, fmt.Errorf("couldn't get video duration: %w", err)
+	}
+	stream, err := cfg.probeVideoStream(ctx, videoPath)
+	if err != nil {
+		return "", spriteLayout{}, fmt.Errorf("couldn't probe video: %w", err)
+	}
+	if stream.Width == 0 || stream.Height == 0 {
+		return "", spriteLayout{}, errors.New("video has no dimensions")
+	}
+	columns, rows := fitGrid(cfg.contactSheet.columns, cfg.contactSheet.rows, duration)
+	layout := spriteLayout{
+		columns:  columns,
+		rows:     rows,
+		frames:   columns * rows,
+		duration: duration,
+	}
+	layout.interval = duration / float64(layout.frames)
+	layout.tileWidth = max(cfg.contactSheet.width/columns/2*2, 2)
+	layout.tileHeight = max(int(math.Round(float64(layout.tileWidth)*float64(stream.Height)/float64(stream.Width)/2))*2, 2)
+
+	outputPath := videoPath + ".contact.jpg"
+	filter := fmt.Sprintf("fps=%d/%s,scale=%d:%d,setsar=1,tile=%dx%d",
+		layout.frames, strconv.FormatFloat(duration, 'f', 3, 64), layout.tileWidth, layout.tileHeight, columns, rows)
+
+	args := []string{
+		"-y",
+		"-i", videoPath,
+		"-an",
+		"-vf", filter,
+		"-frames:v", "1",
+		"-q:v", "3",
+	}
+	args = append(args, cfg.ffmpeg.outputArgs()...)
+	args = append(args, outputPath)
+
+	if err := cfg.ffmpeg.run(ctx, exec.CommandContext(ctx, "ffmpeg", args...)); err != nil {
+		os.Remove(outputPath)
+		return "", spriteLayout{}, fmt.Errorf("ffmpeg contact sheet generation failed: %w", err)
+	}
+	return outputPath, layout, nil
+}
+
+// thumbnailsVTT is the WebVTT thumbnails track for a contact sheet served
+// at spriteURL: one cue per tile, pointing at it with a #xywh fragment.
+func thumbnailsVTT(spriteURL string, layout spriteLayout) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for i := 0; i < layout.frames; i++ {
+		start := float64(i) * layout.interval
+		end := float64(i+1) * layout.interval
+		if i == layout.frames-1 {
+			end = layout.duration
+		}
+		x := (i % layout.columns) * layout.tileWidth
+		y := (i / layout.columns) * layout.tileHeight
+		fmt.Fprintf(&b, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			vttTimestamp(start), vttTimestamp(end), spriteURL, x, y, layout.tileWidth, layout.tileHeight)
+	}
+	return b.String()
+}
+
+// vttTimestamp formats seconds as hh:mm:ss.ttt.
+func vttTimestamp(seconds float64) string {
+	ms := int64(math.Round(seconds * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// uploadContactSheet generates a contact sheet for the video at videoPath,
+// stores it in S3 along with its WebVTT thumbnails track and returns both
+// URLs.
+func (cfg *apiConfig) uploadContactSheet(ctx context.Context, videoPath string) (sheetURL, vttURL string, err error) {
+	sheetPath, layout, err := cfg.generateContactSheet(ctx, videoPath)
+	if err != nil {
+		return "", "", err
+	}
+	defer os.Remove(sheetPath)
+
+	fileName, err := randomFileName(".jpg")
+	if err != nil {
+		return "", "", err
+	}
+	key := cfg.s3KeyPrefix + "contact_sheets/" + fileName
+	sheetURL, err = cfg.uploadFileToS3(ctx, sheetPath, key, "image/jpeg")
+	if err != nil {
+		return "", "", err
+	}
+
+	vttKey := strings.TrimSuffix(key, ".jpg") + ".vtt"
+	vttURL, err = cfg.putObject(ctx, vttKey, strings.NewReader(thumbnailsVTT(sheetURL, layout)), vttContentType)
+	if err != nil {
+		cfg.deleteObjectURL(ctx, sheetURL)
+		return "", "", err
+	}
+	return sheetURL, vttURL, nil
+}
+
+// duplicateThumbnailsVTT copies the track at vttURL to sit beside the
+// copied contact sheet at newSheetURL, pointing its cues at the copy so the
+// track keeps working if the original is deleted.
+func (cfg *apiConfig) duplicateThumbnailsVTT(ctx context.Context, vttURL, oldSheetURL, newSheetURL string) (string, error) {
+	key, err := cfg.s3KeyFromURL(vttURL)
+	if err != nil {
+		return "", err
+	}
+	newSheetKey, err := cfg.s3KeyFromURL(newSheetURL)
+	if err != nil {
+		return "", err
+	}
+	out, err := cfg.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &cfg.s3Bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return "", fmt.Errorf("couldn't get object %s: %w", key, err)
+	}
+	defer out.Body.Close()
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return "", err
+	}
+
+	track := strings.ReplaceAll(string(data), oldSheetURL+"#", newSheetURL+"#")
+	newKey := strings.TrimSuffix(newSheetKey, ".jpg") + ".vtt"
+	return cfg.putObject(ctx, newKey, strings.NewReader(track), vttContentType)
+}
+
+func (cfg *apiConfig) handlerContactSheetCreate(w http.ResponseWriter, r *http.Request) {
+	videoID, err := cfg.parseVideoID(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.validateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.getVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithDBError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		cfg.audit(r, userID, video.ID, auditUpdate, auditDenied, "not owner")
+		respondWithError(w, http.StatusForbidden, "You can't update this video", nil)
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusBadRequest, "Video has not been uploaded yet", nil)
+		return
+	}
+
+	key, err := cfg.s3KeyFromURL(*video.VideoURL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't locate video file", err)
+		return
+	}
+	videoPath, err := cfg.forVideo(video).downloadObjectToTemp(r.Context(), key)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't download video", err)
+		return
+	}
+	defer os.Remove(videoPath)
+
+	sheetURL, vttURL, err := cfg.uploadContactSheet(r.Context(), videoPath)
+	if err != nil {
+		respondWithProcessingError(w, "Couldn't create contact sheet", err)
+		return
+	}
+
+	oldURLs := []*string{video.ContactSheetURL, video.ThumbnailsVTTURL}
+	video.ContactSheetURL = &sheetURL
+	video.ThumbnailsVTTURL = &vttURL
+	if err := cfg.updateVideo(r.Context(), video); err != nil {
+		respondWithDBError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	for _, oldURL := range oldURLs {
+		if oldURL != nil && cfg.deleteObjects {
+			cfg.deleteObjectURL(r.Context(), *oldURL)
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, cfg.presentVideo(video))
+}
+
+// handlerThumbnailsVTT serves the video's WebVTT thumbnails track. It's
+// proxied rather than linked so it can carry CORS headers: players fetch
+// text tracks with XHR, which the CDN may not allow cross-origin.
+func (cfg *apiConfig) handlerThumbnailsVTT(w http.ResponseWriter, r *http.Request) {
+	videoID, err := cfg.parseVideoID(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	video, err := cfg.getVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithDBError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || video.ThumbnailsVTTURL == nil || !cfg.canViewVideo(r, video) {
+		respondWithError(w, http.StatusNotFound, "Thumbnails track not found", nil)
+		return
+	}
+
+	key, err := cfg.s3KeyFromURL(*video.ThumbnailsVTTURL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't locate thumbnails track", err)
+		return
+	}
+	out, err := cfg.s3Client.GetObject(r.Context(), &s3.GetObjectInput{
+		Bucket: &cfg.s3Bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Thumbnails track not found", err)
+		return
+	}
+	defer out.Body.Close()
+
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't read thumbnails track", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", vttContentType)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", thumbnailCacheControl(video.IsPublic))
+	var modTime time.Time
+	if out.LastModified != nil {
+		modTime = *out.LastModified
+	}
+	http.ServeContent(w, r, key, modTime, bytes.NewReader(data))
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"log"
+	"mime"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// contentTypeRepairQueue bounds how many repairs can wait for the worker.
+// Repairs that don't fit are dropped and retried on a later read.
+const contentTypeRepairQueue = 256
+
+// contentTypeRepairer fixes the Content-Type of video objects as they're
+// read, so a library with objects stored under the wrong type, such as
+// early uploads saved as application/octet-stream, heals as it's watched
+// instead of needing the admin repair run over all of it. Each object is
+// only checked once per process.
+type contentTypeRepairer struct {
+	jobs chan contentTypeRepair
+
+	mu sync.Mutex
+	// checked holds the keys known to have the right type, or to have a
+	// repair queued.
+	checked map[string]bool
+}
+
+type contentTypeRepair struct {
+	video database.Video
+	key   string
+	head  *s3.HeadObjectOutput
+	want  string
+}
+
+func newContentTypeRepairer() *contentTypeRepairer {
+	return &contentTypeRepairer{
+		jobs:    make(chan contentTypeRepair, contentTypeRepairQueue),
+		checked: map[string]bool{},
+	}
+}
+
+func (c *contentTypeRepairer) isChecked(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.checked[key]
+}
+
+func (c *contentTypeRepairer) setChecked(key string, checked bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if checked {
+		c.checked[key] = true
+	} else {
+		delete(c.checked, key)
+	}
+}
+
+// servedContentType checks the Content-Type of the video's object when
+// CONTENT_TYPE_AUTO_REPAIR is on, queueing a repair if it's wrong. It
+// returns the type to serve the object as in the meantime, or "" if the
+// stored one can be trusted.
+func (cfg *apiConfig) servedContentType(ctx context.Context, video database.Video) string {
+	repairer := cfg.contentTypeRepair
+	if repairer == nil || video.VideoURL == nil {
+		return ""
+	}
+	key, err := cfg.s3KeyFromURL(*video.VideoURL)
+	if err != nil {
+		return ""
+	}
+	want := contentTypeForKey(key)
+	if want == "" || repairer.isChecked(key) {
+		// A queued repair may not have run yet, but the type is served
+		// correctly through signed URLs either way.
+		return ""
+	}
+
+	store := cfg.forVideo(video)
+	head, err := store.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &store.s3Bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		// Missing objects are reported elsewhere; this is best effort.
+		return ""
+	}
+	if mediaType, _, err := mime.ParseMediaType(aws.ToString(head.ContentType)); err == nil && mediaType == want {
+		repairer.setChecked(key, true)
+		return ""
+	}
+
+	repairer.setChecked(key, true)
+	select {
+	case repairer.jobs <- contentTypeRepair{video: video, key: key, head: head, want: want}:
+	default:
+		repairer.setChecked(key, false)
+		log.Printf("warning: content type repair queue is full, skipping %s", key)
+	}
+	return want
+}
+
+// runContentTypeRepairs works through queued repairs until the process
+// exits.
+func (cfg *apiConfig) runContentTypeRepairs() {
+	for job := range cfg.contentTypeRepair.jobs {
+		cfg.repairContentType(job)
+	}
+}
+
+// repairContentType rewrites one object's Content-Type, like the admin
+// repair does. A failed repair is forgotten so the next read retries it.
+func (cfg *apiConfig) repairContentType(job contentTypeRepair) {
+	ctx := context.Background()
+	if err := cfg.forVideo(job.video).setContentType(ctx, job.key, job.head, job.want); err != nil {
+		cfg.contentTypeRepair.setChecked(job.key, false)
+		log.Printf("warning: couldn't repair content type of video %s: %v", job.video.ID, err)
+		return
+	}
+	log.Printf("Repaired content type of %s (video %s) from %q to %q", job.key, job.video.ID, aws.ToString(job.head.ContentType), job.want)
+
+	// The copy gave the object a new Last-Modified.
+	video := job.video
+	cfg.loadVideoObjectInfo(ctx, &video)
+	if err := cfg.db.SetVideoObjectInfo(ctx, video.ID, video.VideoETag, video.VideoModifiedAt); err != nil {
+		log.Printf("warning: couldn't cache object info of video %s: %v", video.ID, err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// dbPolicy bounds how long a single db call may take and how often it is
+// retried when the database reports contention.
+type dbPolicy struct {
+	timeout time.Duration
+	retries int
+	backoff time.Duration
+}
+
+// errDBBusy wraps the error from a db call that kept hitting lock
+// contention, or ran past dbPolicy.timeout, until withDBRetry gave up.
+var errDBBusy = errors.New("database is busy")
+
+// statusClientClosedRequest is recorded for requests whose client went
+// away before they were answered, after nginx's convention. Nothing reads
+// the response, but access logs and metrics shouldn't count them as
+// successes.
+const statusClientClosedRequest = 499
+
+// withDBRetry runs fn with a per-call timeout, retrying contention errors
+// with backoff. Errors it gives up on because the database is contended
+// wrap errDBBusy; when ctx itself ends, the error wraps ctx.Err() instead,
+// since a canceled or expired request says nothing about the database.
+func (cfg *apiConfig) withDBRetry(ctx context.Context, fn func(ctx context.Context) error) error {
+	backoff := cfg.dbPolicy.backoff
+	for attempt := 0; ; attempt++ {
+		callCtx, cancel := context.WithTimeout(ctx, cfg.dbPolicy.timeout)
+		err := fn(callCtx)
+		callTimedOut := callCtx.Err() != nil
+		cancel()
+		switch {
+		case err == nil:
+			return nil
+		case ctx.Err() != nil:
+			if errors.Is(err, ctx.Err()) {
+				return err
+			}
+			return fmt.Errorf("%w: %w", ctx.Err(), err)
+		case database.IsTransient(err) && attempt < cfg.dbPolicy.retries:
+			// Retried below.
+		case database.IsTransient(err), callTimedOut:
+			return fmt.Errorf("%w: %w", errDBBusy, err)
+		default:
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (cfg *apiConfig) getVideo(ctx context.Context, id uuid.UUID) (database.Video, error) {
+	var video database.Video
+	err := cfg.withDBRetry(ctx, func(ctx context.Context) error {
+		var err error
+		video, err = cfg.db.GetVideoContext(ctx, id)
+		return err
+	})
+	return video, err
+}
+
+func (cfg *apiConfig) updateVideo(ctx context.Context, video database.Video) error {
+	return cfg.withDBRetry(ctx, func(ctx context.Context) error {
+		return cfg.db.UpdateVideoContext(ctx, video)
+	})
+}
+
+// updateVideoIfUnchanged is updateVideo guarded by video.Version, for
+// writers that read the video long before saving it.
+func (cfg *apiConfig) updateVideoIfUnchanged(ctx context.Context, video database.Video) error {
+	return cfg.withDBRetry(ctx, func(ctx context.Context) error {
+		return cfg.db.UpdateVideoIfUnchanged(ctx, video)
+	})
+}
+
+// respondWithDBError reports a busy db as 503 so clients know to retry, a
+// lost conditional update as 409, and anything else with the given code.
+// A request that ran out of time gets the same 503 as timeoutMiddleware
+// gives; one the client canceled gets no response body at all.
+func respondWithDBError(w http.ResponseWriter, code int, msg string, err error) {
+	if errors.Is(err, context.Canceled) {
+		log.Printf("info: %s: client canceled the request: %v", msg, err)
+		w.WriteHeader(statusClientClosedRequest)
+		return
+	}
+	if errors.Is(err, errDBBusy) {
+		respondWithError(w, http.StatusServiceUnavailable, "Database is busy, please try again", err)
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		respondWithError(w, http.StatusServiceUnavailable, "Request timed out", err)
+		return
+	}
+	if errors.Is(err, database.ErrVideoConflict) {
+		respondWithError(w, http.StatusConflict, "Video was changed by another request; reload and retry", err)
+		return
+	}
+	respondWithError(w, code, msg, err)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/mattn/go-sqlite3"
+)
+
+func TestWithDBRetry(t *testing.T) {
+	busy := sqlite3.Error{Code: sqlite3.ErrBusy}
+	permanent := errors.New("no such table: videos")
+
+	tests := []struct {
+		name      string
+		retries   int
+		errs      []error
+		wantErr   error
+		wantCalls int
+	}{
+		{"success", 3, []error{nil}, nil, 1},
+		{"busy then success", 3, []error{busy, busy, nil}, nil, 3},
+		{"busy past the retries", 2, []error{busy, busy, busy, nil}, busy, 3},
+		{"permanent error isn't retried", 3, []error{permanent, nil}, permanent, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &apiConfig{dbPolicy: dbPolicy{timeout: time.Second, retries: tt.retries, backoff: time.Millisecond}}
+			calls := 0
+			err := cfg.withDBRetry(context.Background(), func(ctx context.Context) error {
+				if _, ok := ctx.Deadline(); !ok {
+					t.Error("call has no deadline")
+				}
+				err := tt.errs[calls]
+				calls++
+				return err
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestWithDBRetryStopsWhenCanceled(t *testing.T) {
+	cfg := &apiConfig{dbPolicy: dbPolicy{timeout: time.Second, retries: 5, backoff: time.Hour}}
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := cfg.withDBRetry(ctx, func(context.Context) error {
+		calls++
+		cancel()
+		return sqlite3.Error{Code: sqlite3.ErrBusy}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1", calls)
+	}
+}
+
+func TestWithDBRetryBusy(t *testing.T) {
+	cfg := &apiConfig{dbPolicy: dbPolicy{timeout: 10 * time.Millisecond, retries: 1, backoff: time.Millisecond}}
+
+	err := cfg.withDBRetry(context.Background(), func(context.Context) error {
+		return sqlite3.Error{Code: sqlite3.ErrLocked}
+	})
+	if !errors.Is(err, errDBBusy) {
+		t.Errorf("contention past the retries: err = %v, want errDBBusy", err)
+	}
+
+	err = cfg.withDBRetry(context.Background(), func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if !errors.Is(err, errDBBusy) {
+		t.Errorf("call past its timeout: err = %v, want errDBBusy", err)
+	}
+
+	// The request running out of time isn't the database's fault.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	cfg.dbPolicy.timeout = time.Hour
+	err = cfg.withDBRetry(ctx, func(ctx context.Context) error {
+		<-ctx.Done()
+		return errors.New("interrupted")
+	})
+	if errors.Is(err, errDBBusy) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("request deadline: err = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestRespondWithDBError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantCode int
+		wantBody string
+	}{
+		{"busy", fmt.Errorf("%w: %w", errDBBusy, sqlite3.Error{Code: sqlite3.ErrBusy}), http.StatusServiceUnavailable, "Database is busy"},
+		{"request timed out", context.DeadlineExceeded, http.StatusServiceUnavailable, "Request timed out"},
+		{"client canceled", fmt.Errorf("%w: interrupted", context.Canceled), statusClientClosedRequest, ""},
+		{"conflict", database.ErrVideoConflict, http.StatusConflict, "changed by another request"},
+		{"other", errors.New("no such table: videos"), http.StatusNotFound, "Couldn't get video"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			respondWithDBError(rec, http.StatusNotFound, "Couldn't get video", tt.err)
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantBody == "" && rec.Body.Len() != 0 {
+				t.Errorf("body = %s, want none", rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body = %s, want it to mention %q", rec.Body, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+// diskFullUploads counts uploads that failed because the temp filesystem
+// filled up while they were written, for alerting.
+var diskFullUploads = expvar.NewInt("upload_disk_full")
+
+// requireFreeDisk fails the request with 507 when the temp filesystem has
+// less than size times the configured factor free, since an upload needs
+// room for the received file plus its processed copies. It lets the
+// request through if free space can't be determined.
+func (cfg *apiConfig) requireFreeDisk(w http.ResponseWriter, size int64) bool {
+	if cfg.diskFreeFactor <= 0 {
+		return true
+	}
+	free, err := freeDiskSpace(os.TempDir())
+	if err != nil {
+		log.Printf("warning: skipping free disk check: %v", err)
+		return true
+	}
+	need := uint64(float64(size) * cfg.diskFreeFactor)
+	if free < need {
+		respondWithError(w, http.StatusInsufficientStorage,
+			"The server is low on disk space, please try again later",
+			fmt.Errorf("%d bytes free in %s, need %d", free, os.TempDir(), need))
+		return false
+	}
+	return true
+}
+
+// respondWithDiskFull reports an upload that ran the temp filesystem out of
+// space as 507, since it's our capacity problem rather than the client's.
+// The caller must already have removed what it wrote.
+func respondWithDiskFull(w http.ResponseWriter, err error) {
+	diskFullUploads.Add(1)
+	log.Printf("ALERT: temp filesystem %s is full: %v", os.TempDir(), err)
+	respondWithError(w, http.StatusInsufficientStorage,
+		"The server ran out of disk space receiving your upload; try a smaller file or try again later", err)
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+import "errors"
+
+// The free space check is skipped on platforms without statfs.
+func freeDiskSpace(path string) (uint64, error) {
+	return 0, errors.New("checking free disk space isn't supported on this platform")
+}
+
+func isDiskFull(err error) bool {
+	return false
+}
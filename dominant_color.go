@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+)
+
+// dominantColorSamples is roughly how many pixels a side is sampled at;
+// thumbnails are downscaled to about this many squared before counting.
+const dominantColorSamples = 64
+
+// dominantColor returns the most common color of the image in r as a
+// "#rrggbb" hex string. Pixels are sampled on a grid, quantized to 4 bits
+// per channel and counted, and the winning bucket's average is returned so
+// the result isn't snapped to the quantization grid. Mostly transparent
+// pixels are ignored, so a PNG logo's color isn't washed out by its
+// background.
+func dominantColor(r io.Reader) (string, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return "", err
+	}
+	bounds := img.Bounds()
+	step := max(1, max(bounds.Dx(), bounds.Dy())/dominantColorSamples)
+
+	type bucket struct {
+		count   int
+		r, g, b int
+	}
+	var buckets [4096]bucket
+	best := -1
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A < 128 {
+				continue
+			}
+			i := int(c.R>>4)<<8 | int(c.G>>4)<<4 | int(c.B>>4)
+			b := &buckets[i]
+			b.count++
+			b.r += int(c.R)
+			b.g += int(c.G)
+			b.b += int(c.B)
+			if best < 0 || b.count > buckets[best].count {
+				best = i
+			}
+		}
+	}
+	if best < 0 {
+		return "", fmt.Errorf("image has no opaque pixels")
+	}
+	b := buckets[best]
+	return fmt.Sprintf("#%02x%02x%02x", b.r/b.count, b.g/b.count, b.b/b.count), nil
+}
+
+// thumbnailColor is the dominant color of a thumbnail being stored, or nil
+// if it can't be worked out, as for formats we can't decode like AVIF. A
+// thumbnail without a color is still stored.
+func thumbnailColor(r io.ReadSeeker) *string {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		log.Printf("warning: couldn't read thumbnail for its color: %v", err)
+		return nil
+	}
+	hex, err := dominantColor(r)
+	if err != nil {
+		log.Printf("warning: couldn't find thumbnail color: %v", err)
+		return nil
+	}
+	return &hex
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestEnvLines(t *testing.T) {
+	t.Setenv("TEST_ENV_LINES", "first,with,commas\n\n  second \r\n")
+	if got, want := envLines("TEST_ENV_LINES"), []string{"first,with,commas", "second"}; !slices.Equal(got, want) {
+		t.Errorf("envLines = %q, want %q", got, want)
+	}
+	if got := envLines("TEST_ENV_LINES_UNSET"); len(got) != 0 {
+		t.Errorf("unset envLines = %q, want none", got)
+	}
+}
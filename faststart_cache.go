@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// faststartCache keeps faststart output keyed by the SHA-256 of the input,
+// so a client retrying the same upload after a failed S3 write doesn't pay
+// for ffmpeg again. Entries are evicted by age and then least recently used
+// until the cache fits in maxBytes. Entries are copied in and out rather
+// than hard-linked, so nothing done to an upload's working file afterwards
+// can change what the cache serves.
+type faststartCache struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+
+	mu sync.Mutex
+}
+
+func newFaststartCache(dir string, maxBytes int64, maxAge time.Duration) (*faststartCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("couldn't create faststart cache dir: %w", err)
+	}
+	return &faststartCache{dir: dir, maxBytes: maxBytes, maxAge: maxAge}, nil
+}
+
+func (c *faststartCache) path(hash string) string {
+	return filepath.Join(c.dir, hash+".mp4")
+}
+
+// restore places the cached output for hash at dst and reports whether
+// there was one. The entry's mtime is bumped so eviction sees it as used.
+func (c *faststartCache) restore(hash, dst string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	src := c.path(hash)
+	info, err := os.Stat(src)
+	if err != nil || time.Since(info.ModTime()) > c.maxAge {
+		return false
+	}
+	if err := copyFile(src, dst); err != nil {
+		os.Remove(dst)
+		log.Printf("warning: couldn't restore cached faststart output %s: %v", hash, err)
+		return false
+	}
+	now := time.Now()
+	os.Chtimes(src, now, now)
+	return true
+}
+
+// store copies the processed file at src into the cache under hash.
+func (c *faststartCache) store(hash, src string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tmp := c.path(hash) + ".tmp"
+	if err := copyFile(src, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, c.path(hash)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	c.evict()
+	return nil
+}
+
+// evict must be called with c.mu held.
+func (c *faststartCache) evict() {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		log.Printf("warning: couldn't list faststart cache: %v", err)
+		return
+	}
+
+	type cached struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []cached
+	var total int64
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != ".mp4" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(c.dir, entry.Name())
+		if time.Since(info.ModTime()) > c.maxAge {
+			os.Remove(path)
+			continue
+		}
+		files = append(files, cached{path: path, size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	for _, f := range files {
+		if total <= c.maxBytes {
+			break
+		}
+		if err := os.Remove(f.path); err == nil {
+			total -= f.size
+		}
+	}
+}
+
+// linkOrCopy hard-links src to dst, copying instead when they're on
+// different filesystems.
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	return copyFile(src, dst)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// processVideoForFastStartCached is processVideoForFastStart with the
+// result reused across uploads of identical input.
+func (cfg *apiConfig) processVideoForFastStartCached(ctx context.Context, filePath string) (string, error) {
+	if cfg.faststartCache == nil {
+		return cfg.processVideoForFastStart(ctx, filePath)
+	}
+
+	hash, err := hashFile(filePath)
+	if err != nil {
+		return "", fmt.Errorf("couldn't hash upload: %w", err)
+	}
+	// Output made with different settings must never be served back, so
+	// the key covers every option passed to ffmpeg: the format, and the
+	// thread count, which changes how x264 splits up the encode.
+	settings := sha256.Sum256([]byte(strings.Join(cfg.faststartOutputArgs(), "\x00")))
+	hash = cfg.outputFormat.name + "-" + hex.EncodeToString(settings[:8]) + "-" + hash
+	outputPath := filePath + ".processing"
+	if cfg.faststartCache.restore(hash, outputPath) {
+		return outputPath, nil
+	}
+
+	outputPath, err = cfg.processVideoForFastStart(ctx, filePath)
+	if err != nil {
+		return "", err
+	}
+	if err := cfg.faststartCache.store(hash, outputPath); err != nil {
+		log.Printf("warning: couldn't cache faststart output: %v", err)
+	}
+	return outputPath, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFaststartCache(t *testing.T) {
+	cfg, _, ffmpeg := newTestConfig(t)
+	cache, err := newFaststartCache(t.TempDir(), 1<<20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.faststartCache = cache
+
+	process := func() string {
+		t.Helper()
+		input := filepath.Join(t.TempDir(), "upload.mp4")
+		if err := os.WriteFile(input, testMP4, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		output, err := cfg.processVideoForFastStartCached(context.Background(), input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return output
+	}
+
+	// Scribbling over an upload's output must not reach the cache.
+	first := process()
+	if err := os.WriteFile(first, []byte("tampered"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	second := process()
+	if n := ffmpeg.ran("ffmpeg"); n != 1 {
+		t.Errorf("ffmpeg ran %d times, want the second upload served from the cache", n)
+	}
+	if got, err := os.ReadFile(second); err != nil || !bytes.Equal(got, testMP4) {
+		t.Errorf("cached output = %q, %v; want the original output", got, err)
+	}
+
+	// Output made with other settings isn't reused.
+	cfg.ffmpeg.threads = 2
+	process()
+	if n := ffmpeg.ran("ffmpeg"); n != 2 {
+		t.Errorf("ffmpeg ran %d times, want a new thread count to miss the cache", n)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// ffmpegLimits keeps transcoding from starving the HTTP server of CPU.
+type ffmpegLimits struct {
+	// threads is passed to ffmpeg as -threads; 0 lets ffmpeg decide.
+	threads int
+	// nice is the scheduling niceness applied to ffmpeg and ffprobe; 0
+	// leaves the priority unchanged.
+	nice int
+	// probeStreams is passed to ffprobe as -select_streams when probing
+	// dimensions, e.g. "v" for every video stream or "v:0" for the first.
+	probeStreams string
+	// runner replaces process execution when set. A fake can inspect
+	// cmd.Args and write canned output to cmd.Stdout, which lets the probe
+	// and faststart helpers run without ffmpeg installed.
+	runner commandRunner
+	// slots caps how many ffmpeg processes run at once across every
+	// feature; nil means no cap. ffprobe is cheap and isn't counted.
+	slots chan struct{}
+	// slotWait is how long to wait for a free slot before giving up with
+	// errFFmpegBusy.
+	slotWait time.Duration
+}
+
+var errFFmpegBusy = errors.New("too many ffmpeg processes running")
+
+// respondWithProcessingError reports an ffmpeg failure, or a 503 asking
+// the client to retry if ffmpeg never got to run because the server was
+// saturated.
+func respondWithProcessingError(w http.ResponseWriter, msg string, err error) {
+	if errors.Is(err, errFFmpegBusy) {
+		w.Header().Set("Retry-After", "30")
+		respondWithError(w, http.StatusServiceUnavailable, "The server is busy processing other videos, please try again later", err)
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, msg, err)
+}
+
+func newFFmpegSlots(max int) chan struct{} {
+	if max <= 0 {
+		return nil
+	}
+	return make(chan struct{}, max)
+}
+
+// running reports how many ffmpeg processes hold a slot.
+func (l ffmpegLimits) running() int {
+	return len(l.slots)
+}
+
+// acquire takes a slot for an ffmpeg process, returning a func to release
+// it. A caller that gives up, such as a client disconnecting, stops
+// waiting rather than queueing work nobody will collect.
+func (l ffmpegLimits) acquire(ctx context.Context, cmd *exec.Cmd) (func(), error) {
+	if l.slots == nil || filepath.Base(cmd.Path) != "ffmpeg" {
+		return func() {}, nil
+	}
+	timer := time.NewTimer(l.slotWait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	case <-timer.C:
+		return nil, errFFmpegBusy
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// commandRunner runs an ffmpeg or ffprobe command to completion.
+type commandRunner func(cmd *exec.Cmd) error
+
+func defaultFFmpegThreads() int {
+	n := runtime.NumCPU() / 2
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// outputArgs returns the options that must precede the output file so they
+// apply to encoding rather than decoding.
+func (l ffmpegLimits) outputArgs() []string {
+	if l.threads <= 0 {
+		return nil
+	}
+	return []string{"-threads", strconv.Itoa(l.threads)}
+}
+
+// run starts cmd at the configured priority and waits for it to finish.
+func (l ffmpegLimits) run(ctx context.Context, cmd *exec.Cmd) error {
+	release, err := l.acquire(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if l.runner != nil {
+		return l.runner(cmd)
+	}
+	if l.nice != 0 {
+		withNiceness(cmd, l.nice)
+	}
+	return cmd.Run()
+}
+
+// niceBinary is nice(1), looked up once. Without it commands run at the
+// server's own priority.
+var niceBinary = sync.OnceValue(func() string {
+	path, err := exec.LookPath("nice")
+	if err != nil {
+		log.Printf("warning: FFMPEG_NICE has no effect: %v", err)
+		return ""
+	}
+	return path
+})
+
+// withNiceness makes cmd run under nice(1), so it has its priority from
+// exec on and every thread it starts inherits it. Lowering the priority of
+// the pid after Start would race ffmpeg starting its encoder threads, and
+// on Linux niceness is per thread.
+func withNiceness(cmd *exec.Cmd, nice int) {
+	path := niceBinary()
+	if path == "" || cmd.Err != nil {
+		return
+	}
+	cmd.Args = append([]string{"nice", "-n", strconv.Itoa(nice), cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = path
+}
+
+type ffprobeFormat struct {
+	Duration string `json:"duration"`
+	BitRate  string `json:"bit_rate"`
+}
+
+// probeFormat reads container-level information about a media file.
+func (cfg *apiConfig) probeFormat(ctx context.Context, filePath string) (ffprobeFormat, error) {
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-print_format", "json", "-show_format", filePath)
+	cmd.Stdout = &out
+	if err := cfg.ffmpeg.run(ctx, cmd); err != nil {
+		return ffprobeFormat{}, err
+	}
+
+	var parsed struct {
+		Format ffprobeFormat `json:"format"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &parsed); err != nil {
+		return ffprobeFormat{}, err
+	}
+	return parsed.Format, nil
+}
+
+type ffprobeChapter struct {
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+	Tags      struct {
+		Title string `json:"title"`
+	} `json:"tags"`
+}
+
+// probeChapters returns the chapter markers embedded in a media file, or
+// an empty list if it has none.
+func (cfg *apiConfig) probeChapters(ctx context.Context, filePath string) (database.ChapterList, error) {
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-print_format", "json", "-show_chapters", filePath)
+	cmd.Stdout = &out
+	if err := cfg.ffmpeg.run(ctx, cmd); err != nil {
+		return nil, err
+	}
+
+	var parsed struct {
+		Chapters []ffprobeChapter `json:"chapters"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &parsed); err != nil {
+		return nil, err
+	}
+
+	chapters := database.ChapterList{}
+	for _, c := range parsed.Chapters {
+		start, err := strconv.ParseFloat(c.StartTime, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chapter start %q: %w", c.StartTime, err)
+		}
+		end, err := strconv.ParseFloat(c.EndTime, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chapter end %q: %w", c.EndTime, err)
+		}
+		chapters = append(chapters, database.Chapter{Start: start, End: end, Title: c.Tags.Title})
+	}
+	return chapters, nil
+}
+
+// getVideoDuration returns the container duration in seconds.
+func (cfg *apiConfig) getVideoDuration(ctx context.Context, filePath string) (float64, error) {
+	format, err := cfg.probeFormat(ctx, filePath)
+	if err != nil {
+		return 0, err
+	}
+	if format.Duration == "" {
+		return 0, errors.New("ffprobe reported no duration")
+	}
+	return strconv.ParseFloat(format.Duration, 64)
+}
+
+// getVideoBitrate returns the overall bitrate in bits per second.
+func (cfg *apiConfig) getVideoBitrate(ctx context.Context, filePath string) (int64, error) {
+	format, err := cfg.probeFormat(ctx, filePath)
+	if err != nil {
+		return 0, err
+	}
+	if format.BitRate == "" {
+		return 0, errors.New("ffprobe reported no bitrate")
+	}
+	return strconv.ParseInt(format.BitRate, 10, 64)
+}
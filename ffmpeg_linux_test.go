@@ -0,0 +1,44 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// TestFFmpegLimitsNiceness checks the niceness commands actually run at,
+// as the command reads it itself once it's running: it has to be set
+// before exec, or threads started early would keep the server's.
+func TestFFmpegLimitsNiceness(t *testing.T) {
+	if _, err := exec.LookPath("nice"); err != nil {
+		t.Skip("nice isn't installed")
+	}
+	// The raw getpriority syscall returns 20-nice to avoid negative values.
+	prio, err := syscall.Getpriority(syscall.PRIO_PROCESS, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := 20 - prio
+
+	for _, nice := range []int{0, 7} {
+		var out bytes.Buffer
+		cmd := exec.Command("sh", "-c", "cut -d' ' -f19 /proc/$$/stat")
+		cmd.Stdout = &out
+		if err := (ffmpegLimits{nice: nice}).run(context.Background(), cmd); err != nil {
+			t.Fatal(err)
+		}
+		got, err := strconv.Atoi(strings.TrimSpace(out.String()))
+		if err != nil {
+			t.Fatalf("couldn't read niceness from %q: %v", out.String(), err)
+		}
+		if want := min(base+nice, 19); got != want {
+			t.Errorf("FFMPEG_NICE %d: process ran at niceness %d, want %d", nice, got, want)
+		}
+	}
+}
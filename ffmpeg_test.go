@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestFFmpegLimitsAcquireCancelled(t *testing.T) {
+	limits := ffmpegLimits{slots: newFFmpegSlots(1), slotWait: time.Minute}
+	release, err := limits.acquire(context.Background(), exec.Command("ffmpeg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	// A waiter whose request goes away stops waiting long before
+	// slotWait runs out.
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := limits.acquire(ctx, exec.Command("ffmpeg"))
+		done <- err
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("err = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("acquire kept waiting after its context was cancelled")
+	}
+	if n := limits.running(); n != 1 {
+		t.Errorf("running = %d, want only the first slot held", n)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// uploadFieldNames are the multipart fields uploads are expected in.
+type uploadFieldNames struct {
+	video     string
+	thumbnail string
+}
+
+// formFile wraps r.FormFile so that posting the file under the wrong field
+// name produces an error that says which field we wanted and which fields
+// the client actually sent. A form with a single file is accepted whatever
+// its field is called, for clients that can't choose the name.
+func formFile(r *http.Request, field string) (multipart.File, *multipart.FileHeader, error) {
+	file, fileHeader, err := r.FormFile(field)
+	if err == nil {
+		return file, fileHeader, nil
+	}
+	if !errors.Is(err, http.ErrMissingFile) || r.MultipartForm == nil {
+		return nil, nil, err
+	}
+
+	if len(r.MultipartForm.File) == 1 {
+		for name, headers := range r.MultipartForm.File {
+			if len(headers) == 1 {
+				return r.FormFile(name)
+			}
+		}
+	}
+
+	fields := []string{}
+	for name := range r.MultipartForm.File {
+		fields = append(fields, fmt.Sprintf("%q (file)", name))
+	}
+	for name := range r.MultipartForm.Value {
+		fields = append(fields, fmt.Sprintf("%q", name))
+	}
+	sort.Strings(fields)
+
+	if len(fields) == 0 {
+		return nil, nil, fmt.Errorf("expected a file in form field %q but the form is empty", field)
+	}
+	return nil, nil, fmt.Errorf("expected a file in form field %q, got fields: %s", field, strings.Join(fields, ", "))
+}
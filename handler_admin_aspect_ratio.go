@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	aspectRatioBackfillDefaultLimit = 10
+	aspectRatioBackfillMaxLimit     = 100
+	aspectRatioBackfillInterval     = time.Second
+
+	aspectPrefixReport = "report"
+	aspectPrefixMove   = "move"
+)
+
+// handlerBackfillAspectRatios detects and records the aspect ratio of a
+// batch of videos that were uploaded before detection existed. The S3
+// objects are left where they are. Pass the returned next_after back as
+// ?after= to resume with the following batch.
+func (cfg *apiConfig) handlerBackfillAspectRatios(w http.ResponseWriter, r *http.Request) {
+	type videoResult struct {
+		VideoID     uuid.UUID `json:"video_id"`
+		AspectRatio string    `json:"aspect_ratio,omitempty"`
+		Error       string    `json:"error,omitempty"`
+	}
+	type response struct {
+		Processed int           `json:"processed"`
+		Failed    int           `json:"failed"`
+		Results   []videoResult `json:"results"`
+		NextAfter *uuid.UUID    `json:"next_after"`
+	}
+
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	after := uuid.Nil
+	if s := r.URL.Query().Get("after"); s != "" {
+		var err error
+		after, err = uuid.Parse(s)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid after ID", err)
+			return
+		}
+	}
+
+	limit := aspectRatioBackfillDefaultLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > aspectRatioBackfillMaxLimit {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and 100", err)
+			return
+		}
+		limit = n
+	}
+
+	videos, err := cfg.db.GetVideosMissingAspectRatio(after, limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list videos", err)
+		return
+	}
+
+	resp := response{Results: []videoResult{}}
+	ticker := time.NewTicker(aspectRatioBackfillInterval)
+	defer ticker.Stop()
+
+	for i, video := range videos {
+		if i > 0 {
+			select {
+			case <-r.Context().Done():
+				respondWithJSON(w, http.StatusOK, resp)
+				return
+			case <-ticker.C:
+			}
+		}
+
+		result := videoResult{VideoID: video.ID}
+		ratio, err := cfg.backfillAspectRatio(r, video)
+		if err != nil {
+			result.Error = errorDetail(err)
+			resp.Failed++
+		} else {
+			result.AspectRatio = ratio
+			resp.Processed++
+		}
+		resp.Results = append(resp.Results, result)
+		id := video.ID
+		resp.NextAfter = &id
+	}
+	if len(videos) < limit {
+		resp.NextAfter = nil
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) backfillAspectRatio(r *http.Request, video database.Video) (string, error) {
+	key, err := cfg.s3KeyFromURL(*video.VideoURL)
+	if err != nil {
+		return "", err
+	}
+
+	path, err := cfg.forVideo(video).downloadObjectToTemp(r.Context(), key)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(path)
+
+	ratio, err := cfg.getVideoAspectRatio(r.Context(), path)
+	if err != nil {
+		return "", err
+	}
+
+	video.AspectRatio = &ratio
+	if err := cfg.updateVideo(r.Context(), video); err != nil {
+		return "", err
+	}
+	return ratio, nil
+}
+
+// handlerMigrateAspectPrefixes finds videos whose S3 key sits under a
+// different aspect directory than their recorded aspect ratio, which
+// happens when a video is reprocessed and classified differently. With
+// ?reprobe=true the ratio is detected again first, for when detection
+// itself has changed. ?action=move, confirmed by repeating it in ?confirm=,
+// copies each mismatched object to the right prefix (multipart for large
+// files), points the video at it and deletes the old object. Keys without
+// an aspect directory are left alone. Pages like the backfill, via ?after=.
+func (cfg *apiConfig) handlerMigrateAspectPrefixes(w http.ResponseWriter, r *http.Request) {
+	type videoResult struct {
+		VideoID     uuid.UUID `json:"video_id"`
+		AspectRatio string    `json:"aspect_ratio"`
+		OldKey      string    `json:"old_key"`
+		NewKey      string    `json:"new_key"`
+		Moved       bool      `json:"moved"`
+		Error       string    `json:"error,omitempty"`
+	}
+	type response struct {
+		Action     string        `json:"action"`
+		Checked    int           `json:"checked"`
+		Mismatched []videoResult `json:"mismatched"`
+		NextAfter  *uuid.UUID    `json:"next_after"`
+	}
+
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	action := query.Get("action")
+	if action == "" {
+		action = aspectPrefixReport
+	}
+	switch action {
+	case aspectPrefixReport:
+	case aspectPrefixMove:
+		if query.Get("confirm") != action {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s is destructive; repeat it as confirm=%s", action, action), nil)
+			return
+		}
+	default:
+		respondWithError(w, http.StatusBadRequest, "action must be report or move", nil)
+		return
+	}
+	reprobe := query.Get("reprobe") == "true"
+
+	after := uuid.Nil
+	if s := query.Get("after"); s != "" {
+		var err error
+		after, err = uuid.Parse(s)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid after ID", err)
+			return
+		}
+	}
+
+	limit := aspectRatioBackfillDefaultLimit
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > aspectRatioBackfillMaxLimit {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and 100", err)
+			return
+		}
+		limit = n
+	}
+
+	videos, err := cfg.db.GetVideosWithAspectRatio(after, limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list videos", err)
+		return
+	}
+
+	resp := response{Action: action, Mismatched: []videoResult{}}
+	for _, video := range videos {
+		if r.Context().Err() != nil {
+			break
+		}
+		id := video.ID
+		resp.NextAfter = &id
+		resp.Checked++
+
+		if reprobe {
+			ratio, err := cfg.backfillAspectRatio(r, video)
+			if err != nil {
+				resp.Mismatched = append(resp.Mismatched, videoResult{VideoID: video.ID, Error: errorDetail(err)})
+				continue
+			}
+			video.AspectRatio = &ratio
+			// backfillAspectRatio saved it, which bumped the version.
+			video.Version++
+		}
+
+		key, err := cfg.s3KeyFromURL(*video.VideoURL)
+		if err != nil {
+			continue
+		}
+		newKey, changed, ok := cfg.rekeyForAspectRatio(key, *video.AspectRatio)
+		if !ok || !changed {
+			continue
+		}
+
+		result := videoResult{
+			VideoID:     video.ID,
+			AspectRatio: *video.AspectRatio,
+			OldKey:      key,
+			NewKey:      newKey,
+		}
+		if action == aspectPrefixMove {
+			if err := cfg.moveVideoObject(r, video, key, newKey); err != nil {
+				result.Error = errorDetail(err)
+			} else {
+				result.Moved = true
+			}
+		}
+		resp.Mismatched = append(resp.Mismatched, result)
+	}
+	if len(videos) < limit {
+		resp.NextAfter = nil
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// moveVideoObject copies the video's object to newKey, switches the stored
+// URL over, and only then deletes the old object, so the video is playable
+// throughout. If the video changed while copying, say by a replacement
+// upload, the copy is dropped instead. A failed delete just leaves an
+// orphan for the cleanup job.
+func (cfg *apiConfig) moveVideoObject(r *http.Request, video database.Video, oldKey, newKey string) error {
+	store := cfg.forVideo(video)
+	if err := store.copyObject(r.Context(), oldKey, newKey); err != nil {
+		return err
+	}
+
+	url := store.objectURL(newKey)
+	video.VideoURL = &url
+	cfg.loadVideoObjectInfo(r.Context(), &video)
+	if err := cfg.updateVideoIfUnchanged(r.Context(), video); err != nil {
+		store.deleteObjectKeys(r.Context(), []string{newKey})
+		return err
+	}
+
+	if err := store.deleteObjectKeys(r.Context(), []string{oldKey}); err != nil {
+		log.Printf("warning: moved video %s but couldn't delete %s: %v", video.ID, oldKey, err)
+	}
+	return nil
+}
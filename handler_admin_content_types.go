@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	contentTypeReport = "report"
+	contentTypeFix    = "fix"
+)
+
+// contentTypeForKey is the Content-Type an object stored under key should
+// have, going by its extension, or "" if we don't know the extension.
+func contentTypeForKey(key string) string {
+	ext := strings.ToLower(path.Ext(key))
+	for _, format := range outputFormats {
+		if format.ext == ext {
+			return format.contentType
+		}
+	}
+	for mediaType, mediaExt := range mediaTypeExtensions {
+		if mediaExt == ext {
+			return mediaType
+		}
+	}
+	return ""
+}
+
+// handlerRepairContentTypes finds video objects stored with the wrong
+// Content-Type, which makes browsers download them instead of playing, such
+// as early uploads saved as application/octet-stream. It checks the video
+// and original of the ?video_id= videos (repeatable), or otherwise pages
+// through every video like the aspect ratio backfill, via ?after=.
+// ?action=fix, confirmed by repeating it in ?confirm=, rewrites each wrong
+// object's metadata in place with a copy onto itself.
+func (cfg *apiConfig) handlerRepairContentTypes(w http.ResponseWriter, r *http.Request) {
+	type objectResult struct {
+		VideoID     uuid.UUID `json:"video_id"`
+		Key         string    `json:"key"`
+		ContentType string    `json:"content_type"`
+		Want        string    `json:"want"`
+		Fixed       bool      `json:"fixed"`
+		Error       string    `json:"error,omitempty"`
+	}
+	type response struct {
+		Action    string         `json:"action"`
+		Checked   int            `json:"checked"`
+		Fixed     int            `json:"fixed"`
+		Wrong     []objectResult `json:"wrong"`
+		NextAfter *uuid.UUID     `json:"next_after"`
+	}
+
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	action := query.Get("action")
+	if action == "" {
+		action = contentTypeReport
+	}
+	switch action {
+	case contentTypeReport:
+	case contentTypeFix:
+		if query.Get("confirm") != action {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s rewrites objects; repeat it as confirm=%s", action, action), nil)
+			return
+		}
+	default:
+		respondWithError(w, http.StatusBadRequest, "action must be report or fix", nil)
+		return
+	}
+
+	var videos []database.Video
+	paged := !query.Has("video_id")
+	limit := aspectRatioBackfillDefaultLimit
+	if paged {
+		after := uuid.Nil
+		if s := query.Get("after"); s != "" {
+			var err error
+			after, err = uuid.Parse(s)
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, "Invalid after ID", err)
+				return
+			}
+		}
+		if s := query.Get("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 || n > aspectRatioBackfillMaxLimit {
+				respondWithError(w, http.StatusBadRequest, "limit must be between 1 and 100", err)
+				return
+			}
+			limit = n
+		}
+		var err error
+		videos, err = cfg.db.GetVideosWithObjects(after, limit)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't list videos", err)
+			return
+		}
+	} else {
+		ids := query["video_id"]
+		if len(ids) > aspectRatioBackfillMaxLimit {
+			respondWithError(w, http.StatusBadRequest, "At most 100 video_id values are allowed", nil)
+			return
+		}
+		for _, s := range ids {
+			videoID, err := cfg.parseVideoID(s)
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, err.Error(), err)
+				return
+			}
+			video, err := cfg.getVideo(r.Context(), videoID)
+			if err != nil {
+				respondWithDBError(w, http.StatusInternalServerError, "Couldn't get video", err)
+				return
+			}
+			if video.ID == uuid.Nil {
+				respondWithError(w, http.StatusNotFound, "Video not found: "+s, nil)
+				return
+			}
+			videos = append(videos, video)
+		}
+	}
+
+	resp := response{Action: action, Wrong: []objectResult{}}
+	for _, video := range videos {
+		if r.Context().Err() != nil {
+			break
+		}
+		id := video.ID
+		resp.NextAfter = &id
+
+		store := cfg.forVideo(video)
+		for _, url := range []*string{video.VideoURL, video.OriginalURL} {
+			if url == nil {
+				continue
+			}
+			key, err := cfg.s3KeyFromURL(*url)
+			if err != nil {
+				continue
+			}
+			want := contentTypeForKey(key)
+			if want == "" {
+				continue
+			}
+			head, err := store.s3Client.HeadObject(r.Context(), &s3.HeadObjectInput{
+				Bucket: &store.s3Bucket,
+				Key:    &key,
+			})
+			resp.Checked++
+			if err != nil {
+				resp.Wrong = append(resp.Wrong, objectResult{VideoID: video.ID, Key: key, Want: want, Error: errorDetail(err)})
+				continue
+			}
+			current := aws.ToString(head.ContentType)
+			if mediaType, _, err := mime.ParseMediaType(current); err == nil && mediaType == want {
+				continue
+			}
+
+			result := objectResult{VideoID: video.ID, Key: key, ContentType: current, Want: want}
+			if action == contentTypeFix && url == video.OriginalURL && originalArchivedIn(video) {
+				// S3 can't copy an archived object until it's restored, and
+				// copying it back into the archive bills it again, so leave
+				// it; only the owner downloads originals.
+				result.Error = "original is archived in " + *video.OriginalStorageClass + " and isn't rewritten"
+			} else if action == contentTypeFix {
+				if err := store.setContentType(r.Context(), key, head, want); err != nil {
+					result.Error = errorDetail(err)
+				} else {
+					result.Fixed = true
+					resp.Fixed++
+					if url == video.VideoURL {
+						// The copy gave the object a new Last-Modified.
+						cfg.loadVideoObjectInfo(r.Context(), &video)
+						if err := cfg.db.SetVideoObjectInfo(r.Context(), video.ID, video.VideoETag, video.VideoModifiedAt); err != nil {
+							log.Printf("warning: couldn't cache object info of video %s: %v", video.ID, err)
+						}
+					}
+				}
+			}
+			resp.Wrong = append(resp.Wrong, result)
+		}
+	}
+	if !paged || len(videos) < limit {
+		resp.NextAfter = nil
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// setContentType rewrites the Content-Type of the object at key by copying
+// it onto itself. S3 replaces all metadata on such a copy, so the other
+// headers in head are carried over. Objects too large for CopyObject go
+// through a multipart copy, which only keeps the Content-Type.
+func (cfg *apiConfig) setContentType(ctx context.Context, key string, head *s3.HeadObjectOutput, contentType string) error {
+	size := aws.ToInt64(head.ContentLength)
+	if size > s3MaxSingleCopySize {
+		return cfg.multipartCopyObject(ctx, key, key, size, &contentType)
+	}
+
+	_, err := cfg.s3Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:             &cfg.s3Bucket,
+		Key:                &key,
+		CopySource:         aws.String(copySource(cfg.s3Bucket, key)),
+		MetadataDirective:  types.MetadataDirectiveReplace,
+		ContentType:        &contentType,
+		CacheControl:       head.CacheControl,
+		ContentDisposition: head.ContentDisposition,
+		ContentEncoding:    head.ContentEncoding,
+		ContentLanguage:    head.ContentLanguage,
+		Metadata:           head.Metadata,
+		StorageClass:       head.StorageClass,
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't update content type of %s: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	reconcileReport        = "report"
+	reconcileDeleteOrphans = "delete_orphans"
+	reconcileFlagBroken    = "flag_broken"
+
+	reconcileDefaultLimit = 100
+	reconcileMaxLimit     = 1000
+)
+
+// handlerReconcileStorage compares one page of a bucket against the db
+// and one page of videos against the bucket, reporting objects no video
+// references and videos whose objects are gone. Pass next_s3_token and
+// next_after back as ?s3_token= and ?after= to continue; each side is done
+// when its cursor comes back null. ?bucket= picks the bucket listed for
+// orphans, S3_BUCKET by default; once one is done, next_bucket names the
+// next one to list, so following it covers every bucket.
+//
+// ?action=delete_orphans deletes the orphaned objects and
+// ?action=flag_broken marks the broken videos with storage_missing. Both
+// must be confirmed by repeating the action in ?confirm=.
+func (cfg *apiConfig) handlerReconcileStorage(w http.ResponseWriter, r *http.Request) {
+	type orphanedObject struct {
+		Key          string    `json:"key"`
+		Size         int64     `json:"size"`
+		LastModified time.Time `json:"last_modified"`
+		Deleted      bool      `json:"deleted"`
+		Error        string    `json:"error,omitempty"`
+	}
+	type brokenVideo struct {
+		VideoID     uuid.UUID `json:"video_id"`
+		MissingURLs []string  `json:"missing_urls"`
+		Flagged     bool      `json:"flagged"`
+		Error       string    `json:"error,omitempty"`
+	}
+	type response struct {
+		Action          string           `json:"action"`
+		OrphanedObjects []orphanedObject `json:"orphaned_objects"`
+		BrokenVideos    []brokenVideo    `json:"broken_videos"`
+		Bucket          string           `json:"bucket"`
+		NextS3Token     *string          `json:"next_s3_token"`
+		NextBucket      *string          `json:"next_bucket"`
+		NextAfter       *uuid.UUID       `json:"next_after"`
+	}
+
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	action := query.Get("action")
+	if action == "" {
+		action = reconcileReport
+	}
+	switch action {
+	case reconcileReport:
+	case reconcileDeleteOrphans, reconcileFlagBroken:
+		if query.Get("confirm") != action {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s is destructive; repeat it as confirm=%s", action, action), nil)
+			return
+		}
+	default:
+		respondWithError(w, http.StatusBadRequest, "action must be report, delete_orphans or flag_broken", nil)
+		return
+	}
+
+	after := uuid.Nil
+	if s := query.Get("after"); s != "" {
+		var err error
+		after, err = uuid.Parse(s)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid after ID", err)
+			return
+		}
+	}
+
+	limit := reconcileDefaultLimit
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > reconcileMaxLimit {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", reconcileMaxLimit), err)
+			return
+		}
+		limit = n
+	}
+
+	stores := cfg.bucketStores()
+	store := stores[0]
+	if bucket := query.Get("bucket"); bucket != "" {
+		i := slices.IndexFunc(stores, func(s *apiConfig) bool { return s.s3Bucket == bucket })
+		if i < 0 {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Bucket %q isn't configured", bucket), nil)
+			return
+		}
+		store = stores[i]
+	}
+
+	resp := response{
+		Action:          action,
+		Bucket:          store.s3Bucket,
+		OrphanedObjects: []orphanedObject{},
+		BrokenVideos:    []brokenVideo{},
+	}
+
+	// Bucket side: objects in this page that nothing references.
+	referenced, err := cfg.referencedKeys()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list referenced objects", err)
+		return
+	}
+
+	input := &s3.ListObjectsV2Input{
+		Bucket:  &store.s3Bucket,
+		Prefix:  aws.String(cfg.s3KeyPrefix),
+		MaxKeys: aws.Int32(int32(limit)),
+	}
+	if s := query.Get("s3_token"); s != "" {
+		input.ContinuationToken = &s
+	}
+	page, err := store.s3Client.ListObjectsV2(r.Context(), input)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't list bucket", err)
+		return
+	}
+
+	// Same guard as the cleanup job: a fresh object may belong to an upload
+	// that hasn't written its URL yet.
+	cutoff := time.Now().Add(-cfg.s3Cleanup.minAge)
+	for _, obj := range page.Contents {
+		key := aws.ToString(obj.Key)
+		if obj.LastModified == nil || obj.LastModified.After(cutoff) || referenced[key] {
+			continue
+		}
+		orphan := orphanedObject{
+			Key:          key,
+			Size:         aws.ToInt64(obj.Size),
+			LastModified: *obj.LastModified,
+		}
+		if action == reconcileDeleteOrphans {
+			_, err := store.s3Client.DeleteObject(r.Context(), &s3.DeleteObjectInput{
+				Bucket: &store.s3Bucket,
+				Key:    obj.Key,
+			})
+			if err != nil {
+				orphan.Error = errorDetail(err)
+			} else {
+				orphan.Deleted = true
+			}
+		}
+		resp.OrphanedObjects = append(resp.OrphanedObjects, orphan)
+	}
+	if aws.ToBool(page.IsTruncated) {
+		resp.NextS3Token = page.NextContinuationToken
+	} else if i := slices.Index(stores, store); i+1 < len(stores) {
+		resp.NextBucket = &stores[i+1].s3Bucket
+	}
+
+	// Db side: videos in this page pointing at objects that don't exist.
+	videos, err := cfg.db.GetVideosWithObjects(after, limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list videos", err)
+		return
+	}
+	for _, video := range videos {
+		id := video.ID
+		resp.NextAfter = &id
+
+		missing, err := cfg.missingObjectURLs(r.Context(), video)
+		if err != nil {
+			resp.BrokenVideos = append(resp.BrokenVideos, brokenVideo{
+				VideoID:     video.ID,
+				MissingURLs: []string{},
+				Error:       errorDetail(err),
+			})
+			continue
+		}
+
+		isBroken := len(missing) > 0
+		result := brokenVideo{VideoID: video.ID, MissingURLs: missing}
+		if action == reconcileFlagBroken && video.StorageMissing != isBroken {
+			video.StorageMissing = isBroken
+			if err := cfg.updateVideo(r.Context(), video); err != nil {
+				result.Error = errorDetail(err)
+			} else {
+				result.Flagged = isBroken
+			}
+		}
+		if isBroken {
+			resp.BrokenVideos = append(resp.BrokenVideos, result)
+		}
+	}
+	if len(videos) < limit {
+		resp.NextAfter = nil
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// missingObjectURLs returns the video's S3 URLs whose objects don't exist.
+// URLs outside our distribution, such as local thumbnails, aren't checked.
+func (cfg *apiConfig) missingObjectURLs(ctx context.Context, video database.Video) ([]string, error) {
+	videoStore := cfg.forVideo(video)
+	type check struct {
+		url   *string
+		store *apiConfig
+	}
+	checks := []check{
+		{video.VideoURL, videoStore},
+		{video.OriginalURL, videoStore},
+		{video.HLSURL, videoStore},
+		{video.ThumbnailURL, cfg},
+		{video.PreviewURL, cfg},
+		{video.ContactSheetURL, cfg},
+		{video.ThumbnailsVTTURL, cfg},
+	}
+	for _, url := range variantURLs(video) {
+		checks = append(checks, check{url, videoStore})
+	}
+
+	missing := []string{}
+	for _, check := range checks {
+		if check.url == nil {
+			continue
+		}
+		key, err := cfg.s3KeyFromURL(*check.url)
+		if err != nil {
+			continue
+		}
+		_, err = check.store.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: &check.store.s3Bucket,
+			Key:    &key,
+		})
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			missing = append(missing, *check.url)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("couldn't check %s: %w", key, err)
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	apiKeyScopeFull   = "full"
+	apiKeyScopeRead   = "read"
+	apiKeyScopeUpload = "upload"
+
+	// apiKeyTokenTTL only has to outlive the handler's JWT check, which
+	// happens as soon as the request is routed.
+	apiKeyTokenTTL = time.Minute
+	// apiKeyTouchEvery limits last_used_at writes for busy keys.
+	apiKeyTouchEvery = time.Minute
+)
+
+// apiKeyUploadRoutes are what an upload-scoped key can reach besides the
+// upload routes themselves: creating the video to upload into and checking
+// on it afterwards.
+var apiKeyUploadRoutes = []string{
+	"GET /api/config/upload",
+	"POST /api/videos",
+	"GET /api/videos/{videoID}",
+	"POST /api/video_upload/{videoID}/sessions",
+	"GET /api/uploads/{uploadID}",
+	"DELETE /api/uploads/{uploadID}",
+	"OPTIONS /api/tus",
+	"POST /api/tus",
+	"HEAD /api/tus/{uploadID}",
+	"DELETE /api/tus/{uploadID}",
+}
+
+func validAPIKeyScope(scope string) bool {
+	return scope == apiKeyScopeFull || scope == apiKeyScopeRead || scope == apiKeyScopeUpload
+}
+
+// apiKeyScopeAllows reports whether a key with scope may call the route.
+// Managing keys always takes a full-scope key or a login.
+func apiKeyScopeAllows(scope, method, pattern string) bool {
+	if scope == apiKeyScopeFull {
+		return true
+	}
+	if strings.Contains(pattern, "/api/api_keys") {
+		return false
+	}
+	switch scope {
+	case apiKeyScopeRead:
+		return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+	case apiKeyScopeUpload:
+		for _, route := range uploadRoutes {
+			if pattern == route {
+				return true
+			}
+		}
+		for _, route := range apiKeyUploadRoutes {
+			if pattern == route {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// apiKeyMiddleware accepts "Authorization: ApiKey <key>" as an alternative
+// to a JWT. A valid key within its scope is swapped for a short-lived JWT
+// for the key's user, so handlers keep a single way of authenticating.
+// Requests without an ApiKey header pass through untouched.
+func (cfg *apiConfig) apiKeyMiddleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, err := auth.GetAPIKey(r.Header)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		apiKey, err := cfg.db.GetAPIKeyByHash(auth.HashAPIKey(key))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't look up API key", err)
+			return
+		}
+		now := time.Now()
+		if apiKey.ID == uuid.Nil || apiKey.RevokedAt != nil || (apiKey.ExpiresAt != nil && now.After(*apiKey.ExpiresAt)) {
+			respondWithError(w, http.StatusUnauthorized, "Invalid API key", nil)
+			return
+		}
+
+		_, pattern := mux.Handler(r)
+		if !apiKeyScopeAllows(apiKey.Scope, r.Method, pattern) {
+			respondWithError(w, http.StatusForbidden, "API key scope doesn't allow this request", nil)
+			return
+		}
+
+		token, err := cfg.jwtKeys().Sign(apiKey.UserID, apiKeyTokenTTL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't authenticate API key", err)
+			return
+		}
+
+		if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > apiKeyTouchEvery {
+			if err := cfg.db.TouchAPIKey(apiKey.ID); err != nil {
+				log.Printf("Couldn't record use of API key %s: %v", apiKey.ID, err)
+			}
+		}
+
+		r = r.Clone(r.Context())
+		r.Header.Set("Authorization", "Bearer "+token)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handlerAPIKeysCreate issues a key. The key itself is only returned here;
+// afterwards only its hash is kept.
+func (cfg *apiConfig) handlerAPIKeysCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name             string `json:"name"`
+		Scope            string `json:"scope"`
+		ExpiresInSeconds int64  `json:"expires_in_seconds"`
+	}
+	type response struct {
+		database.APIKey
+		Key string `json:"key"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.validateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "API key name is required", nil)
+		return
+	}
+	if params.Scope == "" {
+		params.Scope = apiKeyScopeFull
+	}
+	if !validAPIKeyScope(params.Scope) {
+		respondWithError(w, http.StatusBadRequest, "scope must be full, read or upload", nil)
+		return
+	}
+	if params.ExpiresInSeconds < 0 {
+		respondWithError(w, http.StatusBadRequest, "expires_in_seconds can't be negative", nil)
+		return
+	}
+	var expiresAt *time.Time
+	if params.ExpiresInSeconds > 0 {
+		t := time.Now().UTC().Add(time.Duration(params.ExpiresInSeconds) * time.Second)
+		expiresAt = &t
+	}
+
+	key, err := auth.MakeAPIKey()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create API key", err)
+		return
+	}
+	apiKey, err := cfg.db.CreateAPIKey(database.CreateAPIKeyParams{
+		UserID:    userID,
+		Name:      params.Name,
+		KeyHash:   auth.HashAPIKey(key),
+		Scope:     params.Scope,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save API key", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{APIKey: apiKey, Key: key})
+}
+
+func (cfg *apiConfig) handlerAPIKeysRetrieve(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.validateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	keys, err := cfg.db.GetAPIKeys(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve API keys", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, keys)
+}
+
+func (cfg *apiConfig) handlerAPIKeysRevoke(w http.ResponseWriter, r *http.Request) {
+	keyID, err := uuid.Parse(r.PathValue("keyID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid API key ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.validateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	revoked, err := cfg.db.RevokeAPIKey(keyID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke API key", err)
+		return
+	}
+	if !revoked {
+		respondWithError(w, http.StatusNotFound, "API key not found", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
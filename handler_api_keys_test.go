@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// apiKeyTestServer routes a few representative endpoints through the API
+// key middleware. Each answers 200 with the user the request
+// authenticated as.
+func apiKeyTestServer(cfg *apiConfig) http.Handler {
+	whoami := func(w http.ResponseWriter, r *http.Request) {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+			return
+		}
+		userID, err := cfg.validateJWT(token)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+			return
+		}
+		w.Write([]byte(userID.String()))
+	}
+	mux := http.NewServeMux()
+	for _, pattern := range []string{
+		"GET /api/videos",
+		"POST /api/videos",
+		"DELETE /api/videos/{videoID}",
+		"POST /api/video_upload/{videoID}",
+		"GET /api/api_keys",
+		"POST /api/api_keys",
+	} {
+		mux.HandleFunc(pattern, whoami)
+	}
+	return cfg.apiKeyMiddleware(mux, mux)
+}
+
+func createTestAPIKey(t *testing.T, cfg *apiConfig, userID uuid.UUID, scope string, expiresAt *time.Time) (database.APIKey, string) {
+	t.Helper()
+	key, err := auth.MakeAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiKey, err := cfg.db.CreateAPIKey(database.CreateAPIKeyParams{
+		UserID:    userID,
+		Name:      scope,
+		KeyHash:   auth.HashAPIKey(key),
+		Scope:     scope,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return apiKey, key
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	srv := apiKeyTestServer(cfg)
+	user, _ := createTestUser(t, cfg)
+	_, full := createTestAPIKey(t, cfg, user.ID, apiKeyScopeFull, nil)
+	_, read := createTestAPIKey(t, cfg, user.ID, apiKeyScopeRead, nil)
+	_, upload := createTestAPIKey(t, cfg, user.ID, apiKeyScopeUpload, nil)
+	videoPath := "/api/videos/" + uuid.NewString()
+	uploadPath := "/api/video_upload/" + uuid.NewString()
+
+	tests := []struct {
+		name   string
+		key    string
+		method string
+		path   string
+		want   int
+	}{
+		{"full key lists videos", full, http.MethodGet, "/api/videos", http.StatusOK},
+		{"full key deletes", full, http.MethodDelete, videoPath, http.StatusOK},
+		{"full key manages keys", full, http.MethodPost, "/api/api_keys", http.StatusOK},
+		{"read key lists videos", read, http.MethodGet, "/api/videos", http.StatusOK},
+		{"read key creates a video", read, http.MethodPost, "/api/videos", http.StatusForbidden},
+		{"read key uploads", read, http.MethodPost, uploadPath, http.StatusForbidden},
+		{"read key deletes", read, http.MethodDelete, videoPath, http.StatusForbidden},
+		{"read key lists keys", read, http.MethodGet, "/api/api_keys", http.StatusForbidden},
+		{"upload key uploads", upload, http.MethodPost, uploadPath, http.StatusOK},
+		{"upload key creates a video", upload, http.MethodPost, "/api/videos", http.StatusOK},
+		{"upload key lists videos", upload, http.MethodGet, "/api/videos", http.StatusForbidden},
+		{"upload key deletes", upload, http.MethodDelete, videoPath, http.StatusForbidden},
+		{"upload key creates keys", upload, http.MethodPost, "/api/api_keys", http.StatusForbidden},
+		{"unknown key", auth.APIKeyPrefix + "nope", http.MethodGet, "/api/videos", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Authorization", "ApiKey "+tt.key)
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if tt.want == http.StatusOK && rec.Body.String() != user.ID.String() {
+				t.Errorf("authenticated as %s, want %s", rec.Body, user.ID)
+			}
+		})
+	}
+}
+
+func TestAPIKeyRevokedAndExpired(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	srv := apiKeyTestServer(cfg)
+	user, _ := createTestUser(t, cfg)
+	revokedKey, revoked := createTestAPIKey(t, cfg, user.ID, apiKeyScopeFull, nil)
+	if ok, err := cfg.db.RevokeAPIKey(revokedKey.ID, user.ID); err != nil || !ok {
+		t.Fatalf("RevokeAPIKey = %t, %v", ok, err)
+	}
+	past := time.Now().Add(-time.Minute)
+	_, expired := createTestAPIKey(t, cfg, user.ID, apiKeyScopeFull, &past)
+	future := time.Now().Add(time.Hour)
+	_, live := createTestAPIKey(t, cfg, user.ID, apiKeyScopeFull, &future)
+
+	for name, tt := range map[string]struct {
+		key  string
+		want int
+	}{
+		"revoked":     {revoked, http.StatusUnauthorized},
+		"expired":     {expired, http.StatusUnauthorized},
+		"not expired": {live, http.StatusOK},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/videos", nil)
+			req.Header.Set("Authorization", "ApiKey "+tt.key)
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const maxEmbedOrigins = 20
+
+var embedTemplate = template.Must(template.New("embed").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+{{if .OEmbedURL}}<link rel="alternate" type="application/json+oembed" href="{{.OEmbedURL}}" title="{{.Title}}">
+{{end}}<style>html,body{margin:0;height:100%;background:#000}video{width:100%;height:100%}</style>
+</head>
+<body>
+<video controls preload="metadata" src="{{.VideoURL}}"{{if .ThumbnailURL}} poster="{{.ThumbnailURL}}"{{end}}{{if .StreamOnly}} controlslist="nodownload" oncontextmenu="return false"{{end}}></video>
+</body>
+</html>
+`))
+
+func (cfg *apiConfig) handlerEmbed(w http.ResponseWriter, r *http.Request) {
+	videoID, err := cfg.parseVideoID(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	video, err := cfg.getVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithDBError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || video.VideoURL == nil || !cfg.canViewVideo(r, video) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	setFrameHeaders(w, video)
+	cfg.views.record(video.ID, viewSession(r, cfg.jwtKeys()))
+	videoURL := *video.VideoURL
+	streamOnly := cfg.streamOnly(r, video)
+	if streamOnly {
+		// The plain CDN URL never expires, so only a short-lived streaming
+		// link may be put in the page.
+		url, _, err := cfg.signedVideoURL(r.Context(), video, downloadModeInline, true)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
+			return
+		}
+		videoURL = url
+	}
+
+	video = cfg.presentVideo(video)
+	data := struct {
+		Title        string
+		VideoURL     string
+		ThumbnailURL string
+		OEmbedURL    string
+		StreamOnly   bool
+	}{
+		Title:      video.Title,
+		VideoURL:   videoURL,
+		StreamOnly: streamOnly,
+	}
+	if video.ThumbnailURL != nil {
+		data.ThumbnailURL = *video.ThumbnailURL
+	}
+	if video.IsPublic {
+		pageURL := cfg.publicBaseURL + "/embed/" + video.ID.String()
+		data.OEmbedURL = cfg.publicBaseURL + "/oembed?format=json&url=" + url.QueryEscape(pageURL)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := embedTemplate.Execute(w, data); err != nil {
+		log.Printf("Error rendering embed for video %s: %v", video.ID, err)
+	}
+}
+
+// setFrameHeaders only lets the video's allowed origins frame the response.
+// With no origins configured, framing is limited to our own pages.
+func setFrameHeaders(w http.ResponseWriter, video database.Video) {
+	ancestors := append([]string{"'self'"}, video.EmbedOrigins...)
+	w.Header().Set("Content-Security-Policy", "frame-ancestors "+strings.Join(ancestors, " "))
+	// X-Frame-Options can't express a list of origins, so only send it for
+	// older browsers when the answer is same-origin anyway.
+	if len(video.EmbedOrigins) == 0 {
+		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+	}
+}
+
+func (cfg *apiConfig) handlerVideoEmbedOriginsUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Origins []string `json:"origins"`
+	}
+
+	videoID, err := cfg.parseVideoID(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.validateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if len(params.Origins) > maxEmbedOrigins {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("At most %d embed origins are allowed", maxEmbedOrigins), nil)
+		return
+	}
+
+	origins := database.StringList{}
+	for _, o := range params.Origins {
+		origin, err := normalizeOrigin(o)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid origin %q", o), err)
+			return
+		}
+		origins = append(origins, origin)
+	}
+
+	video, err := cfg.getVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithDBError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		cfg.audit(r, userID, video.ID, auditUpdate, auditDenied, "not owner")
+		respondWithError(w, http.StatusForbidden, "You can't update this video", nil)
+		return
+	}
+
+	video.EmbedOrigins = origins
+	if err := cfg.updateVideo(r.Context(), video); err != nil {
+		respondWithDBError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, cfg.presentVideo(video))
+}
+
+// normalizeOrigin reduces an origin to scheme://host[:port] so it's safe to
+// place in a CSP header.
+func normalizeOrigin(s string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return "", errors.New("origin must use http or https")
+	}
+	if u.Host == "" || u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return "", errors.New("origin must be just a scheme and host")
+	}
+	if strings.ContainsAny(u.Host, " ;,'\"") {
+		return "", errors.New("origin host contains invalid characters")
+	}
+	return u.Scheme + "://" + strings.ToLower(u.Host), nil
+}
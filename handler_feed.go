@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// maxFeedItems bounds how many of the newest videos a feed lists; podcast
+// apps only poll for what's new.
+const maxFeedItems = 100
+
+// Podcast apps poll feeds, so let caches answer most of them.
+const feedCacheControl = "public, max-age=900"
+
+// rssFeed is an RSS 2.0 document with the iTunes podcast extensions
+// (https://help.apple.com/itc/podcasts_connect/#/itcb54353390).
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	ITunes  string     `xml:"xmlns:itunes,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string       `xml:"title"`
+	Link        string       `xml:"link"`
+	Description string       `xml:"description"`
+	Author      string       `xml:"itunes:author"`
+	Explicit    string       `xml:"itunes:explicit"`
+	Image       *itunesImage `xml:"itunes:image"`
+	Items       []rssItem    `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string       `xml:"title"`
+	Description string       `xml:"description,omitempty"`
+	Link        string       `xml:"link"`
+	GUID        rssGUID      `xml:"guid"`
+	PubDate     string       `xml:"pubDate"`
+	Enclosure   rssEnclosure `xml:"enclosure"`
+	Duration    string       `xml:"itunes:duration,omitempty"`
+	Image       *itunesImage `xml:"itunes:image"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL string `xml:"url,attr"`
+	// Length is the file's size in bytes, 0 when we don't know it.
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type itunesImage struct {
+	Href string `xml:"href,attr"`
+}
+
+// handlerUserFeed publishes a user's public videos as an RSS podcast feed,
+// newest first, so they can be followed in podcast apps. Videos whose
+// owner turned off downloads are left out, since apps need the file
+// itself. It needs no authentication.
+func (cfg *apiConfig) handlerUserFeed(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithDBError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil || user.Disabled {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	videos, err := cfg.db.ListVideos(database.ListVideosParams{
+		UserID:    userID,
+		Published: true,
+		Limit:     maxFeedItems,
+	})
+	if err != nil {
+		respondWithDBError(w, http.StatusInternalServerError, "Couldn't list videos", err)
+		return
+	}
+
+	feedURL := cfg.publicBaseURL + "/api/users/" + userID.String() + "/feed.xml"
+	feed := rssFeed{
+		Version: "2.0",
+		ITunes:  "http://www.itunes.com/dtds/podcast-1.0.dtd",
+		Channel: rssChannel{
+			Title:       "Tubely",
+			Link:        feedURL,
+			Description: "Videos published on Tubely",
+			Author:      "Tubely",
+			Explicit:    "false",
+			Items:       []rssItem{},
+		},
+	}
+	for _, video := range videos {
+		video = cfg.presentVideoTo(video, uuid.Nil)
+		if video.VideoURL == nil {
+			continue
+		}
+		item := rssItem{
+			Title:       video.Title,
+			Description: video.Description,
+			Link:        cfg.publicBaseURL + "/embed/" + video.ID.String(),
+			GUID:        rssGUID{Value: video.ID.String()},
+			PubDate:     video.CreatedAt.UTC().Format(time.RFC1123Z),
+			Enclosure: rssEnclosure{
+				URL:  *video.VideoURL,
+				Type: cfg.outputFormat.contentType,
+			},
+		}
+		if key, err := cfg.s3KeyFromURL(*video.VideoURL); err == nil {
+			if contentType := contentTypeForKey(key); contentType != "" {
+				item.Enclosure.Type = contentType
+			}
+		}
+		if video.VideoSize != nil {
+			item.Enclosure.Length = *video.VideoSize
+		}
+		if video.DurationSeconds != nil {
+			item.Duration = strconv.Itoa(int(*video.DurationSeconds + 0.5))
+		}
+		if video.ThumbnailURL != nil {
+			item.Image = &itunesImage{Href: *video.ThumbnailURL}
+			// The newest episode's artwork stands in for the show's.
+			if feed.Channel.Image == nil {
+				feed.Channel.Image = item.Image
+			}
+		}
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	encoder := xml.NewEncoder(&buf)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(feed); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't build feed", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.Header().Set("Cache-Control", feedCacheControl)
+	// Edits and deletions don't leave a reliable modification time, so
+	// revalidation goes by a hash of the feed instead.
+	sum := sha256.Sum256(buf.Bytes())
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sum[:16]))
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(buf.Bytes()))
+}
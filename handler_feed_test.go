@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandlerUserFeed(t *testing.T) {
+	cfg, store, _ := newTestConfig(t)
+	user, token := createTestUser(t, cfg)
+
+	published := createTestVideo(t, cfg, user.ID)
+	req := newUploadRequest(t, "/api/video_upload/"+published.ID.String(), "video", "clip.mp4", "video/mp4", testMP4)
+	if rec := uploadVideo(cfg, req, published.ID.String(), token); rec.Code != http.StatusOK {
+		t.Fatalf("upload: status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	private := createTestVideo(t, cfg, user.ID)
+	storeTestVideo(t, cfg, store, &private)
+	private.IsPublic = false
+	if err := cfg.db.UpdateVideo(private); err != nil {
+		t.Fatal(err)
+	}
+	// Not uploaded yet, so there's nothing to enclose.
+	createTestVideo(t, cfg, user.ID)
+
+	get := func(etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/users/"+user.ID.String()+"/feed.xml", nil)
+		req.SetPathValue("userID", user.ID.String())
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		cfg.handlerUserFeed(rec, req)
+		return rec
+	}
+
+	rec := get("")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/rss+xml; charset=utf-8" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	var feed rssFeed
+	if err := xml.Unmarshal(rec.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("couldn't parse feed: %v\n%s", err, rec.Body)
+	}
+	if len(feed.Channel.Items) != 1 {
+		t.Fatalf("feed has %d items, want just the published video:\n%s", len(feed.Channel.Items), rec.Body)
+	}
+	item := feed.Channel.Items[0]
+	if item.GUID.Value != published.ID.String() {
+		t.Errorf("guid = %s, want %s", item.GUID.Value, published.ID)
+	}
+	if item.Enclosure.Type != "video/mp4" || item.Enclosure.Length != int64(len(testMP4)) {
+		t.Errorf("enclosure = %+v, want video/mp4 of %d bytes", item.Enclosure, len(testMP4))
+	}
+	// The fake ffprobe reports 12.5 seconds. encoding/xml can't read the
+	// prefixed iTunes elements back, so look for it in the text.
+	if !strings.Contains(rec.Body.String(), "<itunes:duration>13</itunes:duration>") {
+		t.Errorf("feed lacks <itunes:duration>13</itunes:duration>:\n%s", rec.Body)
+	}
+
+	if rec := get(rec.Header().Get("ETag")); rec.Code != http.StatusNotModified {
+		t.Errorf("revalidation: status = %d, want 304", rec.Code)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+var errFolderNotFound = errors.New("folder not found")
+
+func (cfg *apiConfig) handlerFoldersCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name string `json:"name"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.validateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Folder name is required", nil)
+		return
+	}
+
+	folder, err := cfg.db.CreateFolder(database.CreateFolderParams{
+		Name:   params.Name,
+		UserID: userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create folder", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, folder)
+}
+
+func (cfg *apiConfig) handlerFoldersRetrieve(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.validateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	folders, err := cfg.db.GetFolders(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve folders", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, folders)
+}
+
+// userFolder parses an optional folder ID supplied by a client and checks
+// that it names one of the user's folders. An empty string means no folder.
+func (cfg *apiConfig) userFolder(ctx context.Context, s string, userID uuid.UUID) (*uuid.UUID, error) {
+	if s == "" {
+		return nil, nil
+	}
+	id, err := uuid.Parse(s)
+	if err != nil {
+		return nil, errFolderNotFound
+	}
+
+	var folder database.Folder
+	err = cfg.withDBRetry(ctx, func(ctx context.Context) error {
+		var err error
+		folder, err = cfg.db.GetFolder(id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if folder.ID == uuid.Nil || folder.UserID != userID {
+		return nil, errFolderNotFound
+	}
+	return &folder.ID, nil
+}
+
+// respondWithFolderError distinguishes a bad folder reference from a
+// failed lookup.
+func respondWithFolderError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errFolderNotFound) {
+		respondWithError(w, http.StatusBadRequest, "Unknown folder", err)
+		return
+	}
+	respondWithDBError(w, http.StatusInternalServerError, "Couldn't get folder", err)
+}
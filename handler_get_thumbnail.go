@@ -3,15 +3,12 @@ package main
 import (
 	"fmt"
 	"net/http"
-
-	"github.com/google/uuid"
 )
 
 func (cfg *apiConfig) handlerThumbnailGet(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
+	videoID, err := cfg.parseVideoID(r.PathValue("videoID"))
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
 		return
 	}
 
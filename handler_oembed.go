@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// oEmbed sizes for each aspect ratio, before maxwidth/maxheight apply.
+var oembedSizes = map[string][2]int{
+	"16:9":  {640, 360},
+	"9:16":  {360, 640},
+	"other": {640, 480},
+}
+
+// embedSize is the width and height a video is embedded at, from its
+// aspect ratio.
+func embedSize(video database.Video) [2]int {
+	if video.AspectRatio != nil {
+		if size, ok := oembedSizes[*video.AspectRatio]; ok {
+			return size
+		}
+	}
+	return oembedSizes["other"]
+}
+
+// handlerOEmbed describes a public video in oEmbed format
+// (https://oembed.com) so other sites can embed it from its URL. The
+// /embed/{videoID} and /watch/{videoID} pages and /api/videos/{videoID}
+// URLs are accepted. Only JSON is supported. Private videos get the same
+// 404 as missing ones so their existence isn't revealed.
+func (cfg *apiConfig) handlerOEmbed(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Version         string `json:"version"`
+		Type            string `json:"type"`
+		ProviderName    string `json:"provider_name"`
+		ProviderURL     string `json:"provider_url"`
+		Title           string `json:"title"`
+		HTML            string `json:"html"`
+		Width           int    `json:"width"`
+		Height          int    `json:"height"`
+		ThumbnailURL    string `json:"thumbnail_url,omitempty"`
+		ThumbnailWidth  int    `json:"thumbnail_width,omitempty"`
+		ThumbnailHeight int    `json:"thumbnail_height,omitempty"`
+	}
+
+	query := r.URL.Query()
+	if format := query.Get("format"); format != "" && format != "json" {
+		respondWithError(w, http.StatusNotImplemented, "Only format=json is supported", nil)
+		return
+	}
+
+	videoID, err := cfg.videoIDFromPublicURL(query.Get("url"))
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		return
+	}
+
+	video, err := cfg.getVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithDBError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || video.VideoURL == nil || !video.IsPublic {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	size := embedSize(video)
+	width, height, err := fitOEmbedSize(size[0], size[1], query.Get("maxwidth"), query.Get("maxheight"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	embedURL := cfg.publicBaseURL + "/embed/" + video.ID.String()
+	resp := response{
+		Version:      "1.0",
+		Type:         "video",
+		ProviderName: "Tubely",
+		ProviderURL:  cfg.publicBaseURL,
+		Title:        video.Title,
+		HTML: fmt.Sprintf(`<iframe src="%s" width="%d" height="%d" frameborder="0" allowfullscreen></iframe>`,
+			html.EscapeString(embedURL), width, height),
+		Width:  width,
+		Height: height,
+	}
+	if presented := cfg.presentVideo(video); presented.ThumbnailURL != nil {
+		// Thumbnails are frames of the video, so they share its shape.
+		resp.ThumbnailURL = *presented.ThumbnailURL
+		resp.ThumbnailWidth = width
+		resp.ThumbnailHeight = height
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// videoIDFromPublicURL extracts the video ID from one of our own URLs.
+func (cfg *apiConfig) videoIDFromPublicURL(rawURL string) (uuid.UUID, error) {
+	if rawURL == "" {
+		return uuid.Nil, fmt.Errorf("url is required")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	base, err := url.Parse(cfg.publicBaseURL)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if !strings.EqualFold(u.Host, base.Host) {
+		return uuid.Nil, fmt.Errorf("%s isn't one of our URLs", rawURL)
+	}
+	dir, id := path.Split(strings.TrimSuffix(u.Path, "/"))
+	if dir != "/embed/" && dir != "/watch/" && dir != "/api/videos/" {
+		return uuid.Nil, fmt.Errorf("%s isn't a video URL", rawURL)
+	}
+	return cfg.parseVideoID(id)
+}
+
+// fitOEmbedSize scales width x height down to fit the consumer's optional
+// maxwidth and maxheight, keeping the aspect ratio.
+func fitOEmbedSize(width, height int, maxWidth, maxHeight string) (int, int, error) {
+	scale := 1.0
+	for _, limit := range []struct {
+		value string
+		size  int
+	}{{maxWidth, width}, {maxHeight, height}} {
+		if limit.value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(limit.value)
+		if err != nil || n < 1 {
+			return 0, 0, fmt.Errorf("maxwidth and maxheight must be positive integers")
+		}
+		if s := float64(n) / float64(limit.size); s < scale {
+			scale = s
+		}
+	}
+	return int(float64(width) * scale), int(float64(height) * scale), nil
+}
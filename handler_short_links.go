@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	shortCodeAlphabet = "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	shortCodeLength   = 7
+	// shortCodeAttempts bounds retries on a code collision, which at this
+	// length only happens with an enormous number of links.
+	shortCodeAttempts = 5
+)
+
+// newShortCode returns a random code without look-alike characters, so
+// links survive being read aloud or retyped.
+func newShortCode() (string, error) {
+	code := make([]byte, shortCodeLength)
+	max := big.NewInt(int64(len(shortCodeAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = shortCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// handlerShortLinkCreate issues a short link to one of the user's videos.
+// The body is optional; expires_in_seconds limits how long the link works.
+func (cfg *apiConfig) handlerShortLinkCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ExpiresInSeconds int64 `json:"expires_in_seconds"`
+	}
+	type response struct {
+		database.ShortLink
+		URL string `json:"url"`
+	}
+
+	videoID, err := cfg.parseVideoID(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.validateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.ExpiresInSeconds < 0 {
+		respondWithError(w, http.StatusBadRequest, "expires_in_seconds can't be negative", nil)
+		return
+	}
+	var expiresAt *time.Time
+	if params.ExpiresInSeconds > 0 {
+		t := time.Now().UTC().Add(time.Duration(params.ExpiresInSeconds) * time.Second)
+		expiresAt = &t
+	}
+
+	video, err := cfg.getVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithDBError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if video.UserID != userID {
+		cfg.audit(r, userID, video.ID, auditUpdate, auditDenied, "not owner")
+		respondWithError(w, http.StatusForbidden, "You can't share this video", nil)
+		return
+	}
+
+	var link database.ShortLink
+	for attempt := 0; ; attempt++ {
+		code, err := newShortCode()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create short link", err)
+			return
+		}
+		link, err = cfg.db.CreateShortLink(database.CreateShortLinkParams{
+			Code:      code,
+			VideoID:   video.ID,
+			UserID:    userID,
+			ExpiresAt: expiresAt,
+		})
+		if err == nil {
+			break
+		}
+		if attempt+1 == shortCodeAttempts {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't save short link", err)
+			return
+		}
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		ShortLink: link,
+		URL:       cfg.publicBaseURL + "/s/" + link.Code,
+	})
+}
+
+// handlerShortLinkResolve redirects a short link to a presigned URL for its
+// video. The link only finds the video; the usual visibility rules still
+// decide who may watch it, so a private video needs the owner's token.
+func (cfg *apiConfig) handlerShortLinkResolve(w http.ResponseWriter, r *http.Request) {
+	link, err := cfg.db.GetShortLink(r.PathValue("code"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get short link", err)
+		return
+	}
+	if link.Code == "" {
+		respondWithError(w, http.StatusNotFound, "Link not found", nil)
+		return
+	}
+	if link.ExpiresAt != nil && time.Now().After(*link.ExpiresAt) {
+		respondWithError(w, http.StatusGone, "Link has expired", nil)
+		return
+	}
+
+	video, err := cfg.getVideo(r.Context(), link.VideoID)
+	if err != nil {
+		respondWithDBError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || video.VideoURL == nil || !cfg.canViewVideo(r, video) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	url, _, err := cfg.signedVideoURL(r.Context(), video, downloadModeInline, cfg.streamOnly(r, video))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
+		return
+	}
+	if err := cfg.db.RecordShortLinkClick(link.Code); err != nil {
+		log.Printf("warning: couldn't count click on short link %s: %v", link.Code, err)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, url, http.StatusFound)
+}
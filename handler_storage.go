@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+const (
+	storageObjectsDefaultLimit = 100
+	storageObjectsMaxLimit     = 1000
+)
+
+// handlerStorageObjects lists one page of the objects under the user's key
+// prefix in the default bucket, with the page's count and total size. Pass
+// next_token back as ?token= for the following page; the listing is done
+// when it comes back null. Only available with S3_USER_KEYS, since that's
+// what gives each user a prefix of their own.
+func (cfg *apiConfig) handlerStorageObjects(w http.ResponseWriter, r *http.Request) {
+	type object struct {
+		Key          string    `json:"key"`
+		Size         int64     `json:"size"`
+		LastModified time.Time `json:"last_modified"`
+	}
+	type response struct {
+		Objects   []object `json:"objects"`
+		Count     int      `json:"count"`
+		TotalSize int64    `json:"total_size"`
+		NextToken *string  `json:"next_token"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.validateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	if !cfg.s3UserKeys {
+		respondWithError(w, http.StatusNotFound, "Per-user storage listing isn't enabled", nil)
+		return
+	}
+
+	limit := storageObjectsDefaultLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > storageObjectsMaxLimit {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", storageObjectsMaxLimit), err)
+			return
+		}
+		limit = n
+	}
+
+	// The prefix comes only from the authenticated user, never the request.
+	prefix := cfg.s3KeyPrefix + userKeyPrefix(userID)
+	input := &s3.ListObjectsV2Input{
+		Bucket:  &cfg.s3Bucket,
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int32(int32(limit)),
+	}
+	if s := r.URL.Query().Get("token"); s != "" {
+		input.ContinuationToken = &s
+	}
+	page, err := cfg.s3Client.ListObjectsV2(r.Context(), input)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't list objects", err)
+		return
+	}
+
+	resp := response{Objects: []object{}}
+	for _, obj := range page.Contents {
+		key := aws.ToString(obj.Key)
+		// S3 already applies the prefix; this guards against a store that
+		// doesn't honor it alongside a continuation token.
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		o := object{Key: key, Size: aws.ToInt64(obj.Size)}
+		if obj.LastModified != nil {
+			o.LastModified = *obj.LastModified
+		}
+		resp.Objects = append(resp.Objects, o)
+		resp.Count++
+		resp.TotalSize += o.Size
+	}
+	if aws.ToBool(page.IsTruncated) {
+		resp.NextToken = page.NextContinuationToken
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
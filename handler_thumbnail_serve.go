@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+)
+
+// handlerThumbnailServe serves a video's thumbnail to anyone allowed to see
+// the video. Local thumbnails are served in the smallest format the
+// client's Accept header allows; thumbnails in S3 are proxied or redirected
+// to a presigned URL depending on configuration.
+func (cfg *apiConfig) handlerThumbnailServe(w http.ResponseWriter, r *http.Request) {
+	cfg.serveThumbnail(w, r, cfg.thumbnailStore.serveMode == thumbnailServeRedirect)
+}
+
+// handlerGetThumbnailBytes always answers with the image itself, never a
+// redirect to S3, so the frontend can draw it on a canvas without a
+// cross-origin fetch. Thumbnails hosted elsewhere are still redirected.
+func (cfg *apiConfig) handlerGetThumbnailBytes(w http.ResponseWriter, r *http.Request) {
+	cfg.serveThumbnail(w, r, false)
+}
+
+func (cfg *apiConfig) serveThumbnail(w http.ResponseWriter, r *http.Request, redirectS3 bool) {
+	videoID, err := cfg.parseVideoID(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	video, err := cfg.getVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithDBError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || (video.ThumbnailURL == nil && cfg.defaultThumbnail == "") {
+		respondWithError(w, http.StatusNotFound, "Thumbnail not found", nil)
+		return
+	}
+
+	if !cfg.canViewVideo(r, video) {
+		respondWithError(w, http.StatusNotFound, "Thumbnail not found", nil)
+		return
+	}
+
+	if video.ThumbnailURL == nil {
+		http.Redirect(w, r, cfg.defaultThumbnail, http.StatusFound)
+		return
+	}
+
+	if key, err := cfg.s3KeyFromURL(*video.ThumbnailURL); err == nil {
+		cfg.serveS3Thumbnail(w, r, video.IsPublic, key, redirectS3)
+		return
+	}
+
+	originalPath, ok := cfg.assetPathFromURL(*video.ThumbnailURL)
+	if !ok {
+		http.Redirect(w, r, *video.ThumbnailURL, http.StatusFound)
+		return
+	}
+
+	path, err := cfg.negotiateThumbnail(r.Context(), originalPath, r.Header.Get("Accept"))
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Thumbnail not found", err)
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open thumbnail", err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't stat thumbnail", err)
+		return
+	}
+
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("Cache-Control", thumbnailCacheControl(video.IsPublic))
+	// ServeContent sets Content-Type from the extension and handles HEAD,
+	// ranges and If-Modified-Since for us.
+	http.ServeContent(w, r, path, info.ModTime(), f)
+}
+
+// serveS3Thumbnail either redirects to a presigned URL or proxies the
+// object. Proxied thumbnails are small, so they're buffered to let
+// ServeContent answer conditional and range requests.
+func (cfg *apiConfig) serveS3Thumbnail(w http.ResponseWriter, r *http.Request, isPublic bool, key string, redirect bool) {
+	if redirect {
+		url, err := cfg.presignGetObject(r.Context(), key, presignOptions{expires: cfg.thumbnailStore.urlExpiry})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign thumbnail URL", err)
+			return
+		}
+		// The signed URL is per-request, so don't let anything cache the redirect.
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, url, http.StatusFound)
+		return
+	}
+
+	out, err := cfg.s3Client.GetObject(r.Context(), &s3.GetObjectInput{
+		Bucket: &cfg.s3Bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Thumbnail not found", err)
+		return
+	}
+	defer out.Body.Close()
+
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't read thumbnail", err)
+		return
+	}
+
+	if out.ContentType != nil {
+		w.Header().Set("Content-Type", *out.ContentType)
+	}
+	if out.ETag != nil {
+		w.Header().Set("ETag", *out.ETag)
+	}
+	var modTime time.Time
+	if out.LastModified != nil {
+		modTime = *out.LastModified
+	}
+	w.Header().Set("Cache-Control", thumbnailCacheControl(isPublic))
+	http.ServeContent(w, r, key, modTime, bytes.NewReader(data))
+}
+
+// thumbnailCacheControl keeps shared caches from storing private thumbnails.
+func thumbnailCacheControl(isPublic bool) string {
+	if isPublic {
+		return "public, max-age=300"
+	}
+	return "private, max-age=300"
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+// The tus resumable upload protocol (https://tus.io/protocols/resumable-upload),
+// core plus the creation and termination extensions, on top of the same
+// upload sessions the chunked upload API uses.
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination"
+)
+
+// tusHandler sets the headers every tus response carries and rejects
+// clients speaking another protocol version.
+func tusHandler(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+		if r.Method != http.MethodOptions && r.Header.Get("Tus-Resumable") != tusVersion {
+			w.Header().Set("Tus-Version", tusVersion)
+			respondWithError(w, http.StatusPreconditionFailed, "Unsupported tus version", nil)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// handlerTusOptions lets clients discover what the server supports.
+func (cfg *apiConfig) handlerTusOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(cfg.uploadLimits.videoSize, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerTusCreate starts an upload. The target video and file details come
+// from Upload-Metadata: videoID (required), filename, filetype and
+// folder_id.
+func (cfg *apiConfig) handlerTusCreate(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.validateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		respondWithError(w, http.StatusBadRequest, "Upload-Length is required", nil)
+		return
+	}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Length", err)
+		return
+	}
+	if length > cfg.uploadLimits.videoSize {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Upload exceeds Tus-Max-Size", nil)
+		return
+	}
+	if !cfg.requireFreeDisk(w, length) {
+		return
+	}
+
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Metadata", err)
+		return
+	}
+	videoID, err := cfg.parseVideoID(metadata["videoID"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Upload-Metadata must include a valid videoID: "+err.Error(), err)
+		return
+	}
+
+	filename := ""
+	if metadata["filename"] != "" {
+		filename = filepath.Base(metadata["filename"])
+	}
+	contentType := metadata["filetype"]
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(filename))
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !isAllowedMediaType(cfg.allowedVideoTypes, mediaType) {
+		respondWithError(w, http.StatusBadRequest, "Unsupported media type. Allowed: "+strings.Join(cfg.allowedVideoTypes, ", "), nil)
+		return
+	}
+
+	video, err := cfg.getVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithDBError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		cfg.audit(r, userID, video.ID, auditUpload, auditDenied, "not owner")
+		respondWithError(w, http.StatusForbidden, "You can't update this video", nil)
+		return
+	}
+
+	folderID, err := cfg.userFolder(r.Context(), metadata["folder_id"], userID)
+	if err != nil {
+		respondWithFolderError(w, err)
+		return
+	}
+
+	session, err := cfg.uploads.create(uploadProtocolTus, video.ID, userID, mediaType, filename, length)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start upload", err)
+		return
+	}
+	session.FolderID = folderID
+
+	w.Header().Set("Location", "/api/tus/"+session.ID.String())
+	w.WriteHeader(http.StatusCreated)
+}
+
+// handlerTusHead reports how many bytes the server has so the client can
+// resume from there.
+func (cfg *apiConfig) handlerTusHead(w http.ResponseWriter, r *http.Request) {
+	session, ok := cfg.uploadSessionForRequest(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(session.progress().ContiguousBytes, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(session.TotalSize, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// handlerTusPatch appends to the upload at Upload-Offset. The request that
+// delivers the last byte also publishes the video, so it takes as long as
+// a single-request upload would.
+func (cfg *apiConfig) handlerTusPatch(w http.ResponseWriter, r *http.Request) {
+	session, ok := cfg.uploadSessionForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream", nil)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Offset", err)
+		return
+	}
+
+	if _, err := session.appendAt(uploadProtocolTus, offset, r.Body); err != nil {
+		switch {
+		case errors.Is(err, errUploadOffset), errors.Is(err, errUploadBusy), errors.Is(err, errUploadProtocol):
+			respondWithError(w, http.StatusConflict, err.Error(), err)
+		case errors.Is(err, errUploadTooLarge):
+			respondWithError(w, http.StatusRequestEntityTooLarge, err.Error(), err)
+		case errors.Is(err, errUploadExpired):
+			respondWithError(w, http.StatusGone, err.Error(), err)
+		default:
+			// Whatever arrived before the failure was kept; the client
+			// will HEAD for the new offset and carry on.
+			respondWithError(w, http.StatusInternalServerError, "Couldn't store upload data", err)
+		}
+		return
+	}
+
+	received := session.progress().ContiguousBytes
+	if received == session.TotalSize {
+		if _, ok := cfg.publishUploadSession(w, r, session); !ok {
+			return
+		}
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(received, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerTusDelete is the termination extension.
+func (cfg *apiConfig) handlerTusDelete(w http.ResponseWriter, r *http.Request) {
+	session, ok := cfg.uploadSessionForRequest(w, r)
+	if !ok {
+		return
+	}
+	cfg.uploads.remove(session.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseTusMetadata decodes an Upload-Metadata header: comma-separated
+// pairs of a key and an optional base64 value.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
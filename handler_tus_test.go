@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestUploadSessionAppendAt(t *testing.T) {
+	st, err := newUploadSessionStore(t.TempDir(), defaultUploadSessionTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := st.create(uploadProtocolTus, uuid.New(), uuid.New(), "video/mp4", "clip.mp4", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.appendAt(uploadProtocolTus, 0, strings.NewReader("abcd")); err != nil {
+		t.Fatal(err)
+	}
+	// A client that lost track and retries from the start, or skips ahead.
+	for _, offset := range []int64{0, 2, 6} {
+		if _, err := s.appendAt(uploadProtocolTus, offset, strings.NewReader("ef")); !errors.Is(err, errUploadOffset) {
+			t.Errorf("appendAt(%d) err = %v, want errUploadOffset", offset, err)
+		}
+	}
+	if got := s.progress().ContiguousBytes; got != 4 {
+		t.Fatalf("received = %d after rejected appends, want 4", got)
+	}
+
+	// A second PATCH while the first is still sending.
+	pr, pw := io.Pipe()
+	done := make(chan error)
+	go func() {
+		_, err := s.appendAt(uploadProtocolTus, 4, pr)
+		done <- err
+	}()
+	pw.Write([]byte("ef"))
+	if _, err := s.appendAt(uploadProtocolTus, 4, strings.NewReader("ef")); !errors.Is(err, errUploadBusy) {
+		t.Errorf("concurrent appendAt err = %v, want errUploadBusy", err)
+	}
+	// Nor can a numbered part slip in while it does.
+	if _, err := s.writePart(3, strings.NewReader("ef")); !errors.Is(err, errUploadBusy) {
+		t.Errorf("writePart during appendAt err = %v, want errUploadBusy", err)
+	}
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.appendAt(uploadProtocolTus, 6, strings.NewReader("ghijk")); !errors.Is(err, errUploadTooLarge) {
+		t.Errorf("overlong appendAt err = %v, want errUploadTooLarge", err)
+	}
+	if _, err := s.appendAt(uploadProtocolTus, 6, strings.NewReader("ghij")); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.progress().ContiguousBytes; got != 10 {
+		t.Errorf("received = %d, want 10", got)
+	}
+
+	if !s.expire(time.Now().Add(defaultUploadSessionTTL)) {
+		t.Fatal("idle session wasn't expired")
+	}
+	if _, err := s.appendAt(uploadProtocolTus, 10, strings.NewReader("")); !errors.Is(err, errUploadExpired) {
+		t.Errorf("appendAt after expiry err = %v, want errUploadExpired", err)
+	}
+}
+
+func TestHandlerTusPatchOffsetConflict(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	user, token := createTestUser(t, cfg)
+	video := createTestVideo(t, cfg, user.ID)
+	session, err := cfg.uploads.create(uploadProtocolTus, video.ID, user.ID, "video/mp4", "clip.mp4", int64(len(testMP4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.appendAt(uploadProtocolTus, 0, strings.NewReader(string(testMP4[:8]))); err != nil {
+		t.Fatal(err)
+	}
+
+	patch := func(offset string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPatch, "/api/tus/"+session.ID.String(), strings.NewReader(string(testMP4[8:16])))
+		r.SetPathValue("uploadID", session.ID.String())
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.Header.Set("Tus-Resumable", tusVersion)
+		r.Header.Set("Content-Type", "application/offset+octet-stream")
+		r.Header.Set("Upload-Offset", offset)
+		rec := httptest.NewRecorder()
+		tusHandler(cfg.handlerTusPatch)(rec, r)
+		return rec
+	}
+
+	for _, offset := range []string{"0", "16"} {
+		if rec := patch(offset); rec.Code != http.StatusConflict {
+			t.Errorf("PATCH at %s: status = %d, want 409: %s", offset, rec.Code, rec.Body)
+		}
+	}
+	rec := patch("8")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("PATCH at 8: status = %d, want 204: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Upload-Offset"); got != "16" {
+		t.Errorf("Upload-Offset = %q, want 16", got)
+	}
+
+	cfg.uploads.remove(session.ID)
+	if rec := patch("16"); rec.Code != http.StatusNotFound {
+		t.Errorf("PATCH after removal: status = %d, want 404", rec.Code)
+	}
+}
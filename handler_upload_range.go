@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// handlerUploadRange appends the bytes named by Content-Range to an upload
+// session, for clients that can do ranged PUTs but not tus or numbered
+// parts. The session must have been started with "protocol": "range".
+// Ranges must arrive in order, with no gaps or overlaps. A client
+// that lost its connection asks HEAD /api/uploads/{uploadID} for the Range
+// received so far and carries on from there, then completes the upload
+// like any other session.
+func (cfg *apiConfig) handlerUploadRange(w http.ResponseWriter, r *http.Request) {
+	session, ok := cfg.uploadSessionForRequest(w, r)
+	if !ok {
+		return
+	}
+	if session.TotalSize == 0 {
+		respondWithError(w, http.StatusBadRequest, "Ranged uploads need the size declared when the session is created", nil)
+		return
+	}
+
+	start, end, size, err := parseContentRange(r.Header.Get("Content-Range"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Content-Range: "+err.Error(), err)
+		return
+	}
+	if size >= 0 && size != session.TotalSize {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Content-Range size %d doesn't match the upload's %d bytes", size, session.TotalSize), nil)
+		return
+	}
+	if end >= session.TotalSize {
+		respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Content-Range runs past the upload's %d bytes", session.TotalSize), nil)
+		return
+	}
+	length := end - start + 1
+	if r.ContentLength >= 0 && r.ContentLength != length {
+		respondWithError(w, http.StatusBadRequest, "Content-Length doesn't match Content-Range", nil)
+		return
+	}
+
+	received, err := session.appendAt(uploadProtocolRange, start, io.LimitReader(r.Body, length))
+	if err != nil {
+		switch {
+		case errors.Is(err, errUploadOffset):
+			// Tell the client where to pick up, as HEAD would.
+			setUploadRange(w, session.progress())
+			respondWithError(w, http.StatusConflict, err.Error(), err)
+		case errors.Is(err, errUploadBusy), errors.Is(err, errUploadProtocol):
+			respondWithError(w, http.StatusConflict, err.Error(), err)
+		case errors.Is(err, errUploadTooLarge):
+			respondWithError(w, http.StatusRequestEntityTooLarge, err.Error(), err)
+		case errors.Is(err, errUploadExpired):
+			respondWithError(w, http.StatusGone, err.Error(), err)
+		default:
+			// Whatever arrived before the failure was kept.
+			setUploadRange(w, session.progress())
+			respondWithError(w, http.StatusInternalServerError, "Couldn't store upload data", err)
+		}
+		return
+	}
+	if received < length {
+		setUploadRange(w, session.progress())
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Received %d of the %d bytes in Content-Range", received, length), nil)
+		return
+	}
+
+	respondWithUploadProgress(w, http.StatusOK, session.progress())
+}
+
+// parseContentRange reads a request's "bytes start-end/size" Content-Range.
+// size is -1 when the client sends "*".
+func parseContentRange(header string) (start, end, size int64, err error) {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return 0, 0, 0, errors.New(`must look like "bytes 0-99/1000"`)
+	}
+	span, total, ok := strings.Cut(spec, "/")
+	if !ok {
+		return 0, 0, 0, errors.New("missing the total size")
+	}
+	first, last, ok := strings.Cut(span, "-")
+	if !ok {
+		return 0, 0, 0, errors.New("missing the range")
+	}
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, 0, fmt.Errorf("invalid start %q", first)
+	}
+	end, err = strconv.ParseInt(last, 10, 64)
+	if err != nil || end < start {
+		return 0, 0, 0, fmt.Errorf("invalid end %q", last)
+	}
+	size = -1
+	if total != "*" {
+		size, err = strconv.ParseInt(total, 10, 64)
+		if err != nil || size <= end {
+			return 0, 0, 0, fmt.Errorf("invalid total size %q", total)
+		}
+	}
+	return start, end, size, nil
+}
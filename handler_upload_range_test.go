@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHandlerUploadRange(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	user, token := createTestUser(t, cfg)
+	video := createTestVideo(t, cfg, user.ID)
+	size := int64(len(testMP4))
+	session, err := cfg.uploads.create(uploadProtocolRange, video.ID, user.ID, "video/mp4", "clip.mp4", size)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(start, end int64, total string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPut, "/api/uploads/"+session.ID.String(), bytes.NewReader(testMP4[start:end+1]))
+		r.SetPathValue("uploadID", session.ID.String())
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%s", start, end, total))
+		rec := httptest.NewRecorder()
+		cfg.handlerUploadRange(rec, r)
+		return rec
+	}
+
+	if rec := put(0, 7, "*"); rec.Code != http.StatusOK {
+		t.Fatalf("first range: status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	// Overlapping the bytes we have, and leaving a gap after them.
+	for _, start := range []int64{4, 12} {
+		rec := put(start, start+3, "*")
+		if rec.Code != http.StatusConflict {
+			t.Errorf("range from %d: status = %d, want 409: %s", start, rec.Code, rec.Body)
+		}
+		if got := rec.Header().Get("Range"); got != "bytes=0-7" {
+			t.Errorf("range from %d: Range = %q, want bytes=0-7", start, got)
+		}
+	}
+	if rec := put(8, 11, "999"); rec.Code != http.StatusBadRequest {
+		t.Errorf("wrong total size: status = %d, want 400", rec.Code)
+	}
+
+	rec := put(8, size-1, fmt.Sprint(size))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("last range: status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if got, want := rec.Header().Get("Range"), fmt.Sprintf("bytes=0-%d", size-1); got != want {
+		t.Errorf("Range = %q, want %q", got, want)
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header           string
+		start, end, size int64
+		wantErr          bool
+	}{
+		{"bytes 0-99/1000", 0, 99, 1000, false},
+		{"bytes 100-199/*", 100, 199, -1, false},
+		{"bytes 0-99", 0, 0, 0, true},
+		{"bytes 99-0/1000", 0, 0, 0, true},
+		{"bytes -5-10/100", 0, 0, 0, true},
+		{"bytes 0-99/99", 0, 0, 0, true},
+		{"items 0-99/1000", 0, 0, 0, true},
+		{"", 0, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			start, end, size, err := parseContentRange(tt.header)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseContentRange err = %v, want error: %t", err, tt.wantErr)
+			}
+			if start != tt.start || end != tt.end || size != tt.size {
+				t.Errorf("parseContentRange = %d, %d, %d, want %d, %d, %d", start, end, size, tt.start, tt.end, tt.size)
+			}
+		})
+	}
+}
+
+// TestUploadRangeRoute sends ranged uploads through the server's own mux
+// and middleware, which treat the route as an upload: it gets the upload
+// timeout, counts against UPLOADS_PER_CONNECTION and is open to
+// upload-scoped API keys.
+func TestUploadRangeRoute(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	// Anything not on the upload timeout is out of time at once.
+	cfg.requestTimeouts = routeDurations{def: time.Nanosecond, routes: defaultRequestTimeouts(time.Minute)}
+	cfg.uploadsPerConn = 1
+	handler := cfg.serverHandler(cfg.routes(t.TempDir(), t.TempDir()))
+
+	user, _ := createTestUser(t, cfg)
+	_, key := createTestAPIKey(t, cfg, user.ID, apiKeyScopeUpload, nil)
+	video := createTestVideo(t, cfg, user.ID)
+	session, err := cfg.uploads.create(uploadProtocolRange, video.ID, user.ID, "video/mp4", "clip.mp4", int64(len(testMP4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(uploadsOnConn int32, start, end int) *httptest.ResponseRecorder {
+		t.Helper()
+		uploads := new(atomic.Int32)
+		uploads.Store(uploadsOnConn)
+		ctx := context.WithValue(context.Background(), connUploadsKey{}, uploads)
+		r := httptest.NewRequestWithContext(ctx, http.MethodPut, "/api/uploads/"+session.ID.String(), bytes.NewReader(testMP4[start:end+1]))
+		r.Header.Set("Authorization", "ApiKey "+key)
+		r.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(testMP4)))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+
+	if rec := put(0, 0, 7); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	// Another upload already running on the connection.
+	if rec := put(1, 8, 15); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("second upload on the connection: status = %d, want 429: %s", rec.Code, rec.Body)
+	}
+}
+
+// TestHandlerUploadRangeGap checks that a range continues from the bytes
+// received without gaps, not from everything stored, and never replaces a
+// stored part.
+func TestHandlerUploadRangeGap(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	user, token := createTestUser(t, cfg)
+	video := createTestVideo(t, cfg, user.ID)
+	data := []byte("0123456789abcdefghijABCDEFGHIJ")
+	session, err := cfg.uploads.create(uploadProtocolRange, video.ID, user.ID, "video/mp4", "clip.mp4", int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Parts 1 and 3 are stored but part 2 is missing.
+	for n, chunk := range map[int][]byte{1: data[:10], 3: data[20:]} {
+		if err := os.WriteFile(session.partPath(n), chunk, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		session.parts[n] = int64(len(chunk))
+	}
+
+	put := func(start, end int) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPut, "/api/uploads/"+session.ID.String(), bytes.NewReader(data[start:end+1]))
+		r.SetPathValue("uploadID", session.ID.String())
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		rec := httptest.NewRecorder()
+		cfg.handlerUploadRange(rec, r)
+		return rec
+	}
+
+	rec := put(20, 29)
+	if rec.Code != http.StatusConflict || rec.Header().Get("Range") != "bytes=0-9" {
+		t.Errorf("range past the gap: status = %d, Range %q; want 409 and bytes=0-9", rec.Code, rec.Header().Get("Range"))
+	}
+	if rec := put(10, 19); rec.Code != http.StatusOK {
+		t.Fatalf("range filling the gap: status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if got := session.progress().ContiguousBytes; got != int64(len(data)) {
+		t.Errorf("contiguous bytes = %d, want %d", got, len(data))
+	}
+	assembled := filepath.Join(t.TempDir(), "assembled")
+	if err := session.assemble(assembled); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(assembled); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("assembled %q, %v; want %q", got, err, data)
+	}
+}
+
+func TestUploadSessionProtocols(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	user, token := createTestUser(t, cfg)
+	video := createTestVideo(t, cfg, user.ID)
+	size := int64(len(testMP4))
+
+	request := func(method, target string, session *uploadSession, header, value string) *http.Request {
+		r := httptest.NewRequest(method, target, bytes.NewReader(testMP4[:8]))
+		r.SetPathValue("uploadID", session.ID.String())
+		r.SetPathValue("partNumber", "1")
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.Header.Set(header, value)
+		return r
+	}
+	rangePut := func(session *uploadSession) int {
+		rec := httptest.NewRecorder()
+		cfg.handlerUploadRange(rec, request(http.MethodPut, "/api/uploads/"+session.ID.String(), session, "Content-Range", fmt.Sprintf("bytes 0-7/%d", size)))
+		return rec.Code
+	}
+	partPut := func(session *uploadSession) int {
+		rec := httptest.NewRecorder()
+		cfg.handlerUploadPart(rec, request(http.MethodPut, "/api/uploads/"+session.ID.String()+"/parts/1", session, "Content-Type", "application/octet-stream"))
+		return rec.Code
+	}
+	tusPatch := func(session *uploadSession) int {
+		r := request(http.MethodPatch, "/api/tus/"+session.ID.String(), session, "Content-Type", "application/offset+octet-stream")
+		r.Header.Set("Tus-Resumable", tusVersion)
+		r.Header.Set("Upload-Offset", "0")
+		rec := httptest.NewRecorder()
+		tusHandler(cfg.handlerTusPatch)(rec, r)
+		return rec.Code
+	}
+
+	writes := map[uploadProtocol]func(*uploadSession) int{
+		uploadProtocolParts: partPut,
+		uploadProtocolRange: rangePut,
+		uploadProtocolTus:   tusPatch,
+	}
+	for created := range writes {
+		for used, write := range writes {
+			session, err := cfg.uploads.create(created, video.ID, user.ID, "video/mp4", "clip.mp4", size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := http.StatusConflict
+			if used == created {
+				want = http.StatusOK
+				if used == uploadProtocolTus {
+					want = http.StatusNoContent
+				}
+			}
+			if got := write(session); got != want {
+				t.Errorf("%s write to a %s session: status = %d, want %d", used, created, got, want)
+			}
+		}
+	}
+}
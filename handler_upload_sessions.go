@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerUploadSessionCreate starts a chunked upload for a video. The
+// client then PUTs numbered parts, or with "protocol": "range" appends
+// Content-Range PUTs, can ask what arrived, and completes the upload to
+// publish the video.
+func (cfg *apiConfig) handlerUploadSessionCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Filename    string `json:"filename"`
+		ContentType string `json:"content_type"`
+		Size        int64  `json:"size"`
+		FolderID    string `json:"folder_id"`
+		// Protocol is "parts" (the default) or "range".
+		Protocol string `json:"protocol"`
+	}
+
+	videoID, err := cfg.parseVideoID(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.validateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(params.ContentType)
+	if err != nil || !isAllowedMediaType(cfg.allowedVideoTypes, mediaType) {
+		respondWithError(w, http.StatusBadRequest, "Unsupported media type. Allowed: "+strings.Join(cfg.allowedVideoTypes, ", "), nil)
+		return
+	}
+	protocol := uploadProtocolParts
+	switch uploadProtocol(params.Protocol) {
+	case "", uploadProtocolParts:
+	case uploadProtocolRange:
+		protocol = uploadProtocolRange
+	default:
+		respondWithError(w, http.StatusBadRequest, `protocol must be "parts" or "range"`, nil)
+		return
+	}
+	if params.Size < 0 {
+		respondWithError(w, http.StatusBadRequest, "size must not be negative", nil)
+		return
+	}
+	if protocol == uploadProtocolRange && params.Size == 0 {
+		respondWithError(w, http.StatusBadRequest, "Ranged uploads need the size declared", nil)
+		return
+	}
+	if params.Size > cfg.uploadLimits.videoSize {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Video exceeds the maximum upload size", nil)
+		return
+	}
+	size := params.Size
+	if size == 0 {
+		size = cfg.uploadLimits.videoSize
+	}
+	if !cfg.requireFreeDisk(w, size) {
+		return
+	}
+
+	video, err := cfg.getVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithDBError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		cfg.audit(r, userID, video.ID, auditUpload, auditDenied, "not owner")
+		respondWithError(w, http.StatusForbidden, "You can't update this video", nil)
+		return
+	}
+
+	folderID, err := cfg.userFolder(r.Context(), params.FolderID, userID)
+	if err != nil {
+		respondWithFolderError(w, err)
+		return
+	}
+
+	filename := ""
+	if params.Filename != "" {
+		filename = filepath.Base(params.Filename)
+	}
+	session, err := cfg.uploads.create(protocol, video.ID, userID, mediaType, filename, params.Size)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start upload", err)
+		return
+	}
+	session.FolderID = folderID
+
+	respondWithJSON(w, http.StatusCreated, session.progress())
+}
+
+// handlerUploadPart stores one part of a chunked upload. Parts are numbered
+// from 1 and may be sent in any order or re-sent.
+func (cfg *apiConfig) handlerUploadPart(w http.ResponseWriter, r *http.Request) {
+	session, ok := cfg.uploadSessionForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	partNumber, err := strconv.Atoi(r.PathValue("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > maxUploadParts {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Part number must be between 1 and %d", maxUploadParts), err)
+		return
+	}
+
+	if _, err := session.writePart(partNumber, r.Body); err != nil {
+		if errors.Is(err, errUploadExpired) {
+			respondWithError(w, http.StatusGone, err.Error(), err)
+			return
+		}
+		if errors.Is(err, errUploadBusy) || errors.Is(err, errUploadProtocol) {
+			respondWithError(w, http.StatusConflict, err.Error(), err)
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Couldn't store part", err)
+		return
+	}
+
+	respondWithUploadProgress(w, http.StatusOK, session.progress())
+}
+
+// handlerUploadProgress reports which parts of a chunked upload the server
+// has, so a client that lost its connection knows where to resume. It also
+// answers HEAD, for ranged uploads that only need the Range header.
+func (cfg *apiConfig) handlerUploadProgress(w http.ResponseWriter, r *http.Request) {
+	session, ok := cfg.uploadSessionForRequest(w, r)
+	if !ok {
+		return
+	}
+	respondWithUploadProgress(w, http.StatusOK, session.progress())
+}
+
+// respondWithUploadProgress also sets a Range header covering the bytes
+// received without gaps, in the style of other resumable upload APIs.
+func respondWithUploadProgress(w http.ResponseWriter, code int, progress uploadProgress) {
+	setUploadRange(w, progress)
+	respondWithJSON(w, code, progress)
+}
+
+func setUploadRange(w http.ResponseWriter, progress uploadProgress) {
+	if progress.ContiguousBytes > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", progress.ContiguousBytes-1))
+	}
+}
+
+// handlerUploadComplete assembles the parts and publishes the video the
+// same way a single-request upload would.
+func (cfg *apiConfig) handlerUploadComplete(w http.ResponseWriter, r *http.Request) {
+	session, ok := cfg.uploadSessionForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	video, ok := cfg.publishUploadSession(w, r, session)
+	if !ok {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, cfg.presentVideo(video))
+}
+
+// publishUploadSession assembles a finished upload, publishes it and
+// removes the session, writing an error response if any step fails.
+func (cfg *apiConfig) publishUploadSession(w http.ResponseWriter, r *http.Request, session *uploadSession) (database.Video, bool) {
+	video, err := cfg.getVideo(r.Context(), session.VideoID)
+	if err != nil {
+		respondWithDBError(w, http.StatusNotFound, "Couldn't get video", err)
+		return database.Video{}, false
+	}
+	if video.UserID != session.UserID {
+		cfg.audit(r, session.UserID, video.ID, auditUpload, auditDenied, "not owner")
+		respondWithError(w, http.StatusForbidden, "You can't update this video", nil)
+		return database.Video{}, false
+	}
+
+	if session.FolderID != nil {
+		video.FolderID = session.FolderID
+	}
+
+	ext := extensionForMediaType(session.MediaType)
+	tempFile, err := os.CreateTemp("", "tubely-upload*"+ext)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not create temp file", err)
+		return database.Video{}, false
+	}
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
+
+	if err := session.assemble(tempFile.Name()); err != nil {
+		if errors.Is(err, errUploadIncomplete) {
+			respondWithError(w, http.StatusConflict, err.Error(), err)
+			return database.Video{}, false
+		}
+		if errors.Is(err, errUploadExpired) {
+			respondWithError(w, http.StatusGone, err.Error(), err)
+			return database.Video{}, false
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't assemble upload", err)
+		return database.Video{}, false
+	}
+
+	// Completing twice, or the last tus PATCH racing a retry of it, mustn't
+	// publish the file twice.
+	contentHash, err := hashFile(tempFile.Name())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read upload", err)
+		return database.Video{}, false
+	}
+	release, ok := cfg.claimInFlightUpload(w, session.UserID, contentHash)
+	if !ok {
+		return database.Video{}, false
+	}
+	defer release()
+
+	assembled, err := os.Open(tempFile.Name())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read upload", err)
+		return database.Video{}, false
+	}
+	header, err := readHeader(assembled)
+	assembled.Close()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Could not read video file", err)
+		return database.Video{}, false
+	}
+	if err := checkMediaTypeConsistency(session.MediaType, session.Filename, header); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Video type mismatch: "+err.Error(), err)
+		return database.Video{}, false
+	}
+
+	// A checksum of the whole file may come with the completing request.
+	checksum, err := parseUploadChecksum(nil, r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return database.Video{}, false
+	}
+	if err := checksum.verifyFile(tempFile.Name()); err != nil {
+		respondWithChecksumError(w, err)
+		return database.Video{}, false
+	}
+
+	if err := cfg.publishVideo(r.Context(), &video, tempFile.Name(), session.MediaType, session.Filename, checksum); err != nil {
+		respondWithPublishError(w, err)
+		return database.Video{}, false
+	}
+	if err := cfg.updateVideo(r.Context(), video); err != nil {
+		respondWithDBError(w, http.StatusInternalServerError, "Failed to update video metadata", err)
+		return database.Video{}, false
+	}
+	cfg.audit(r, session.UserID, video.ID, auditUpload, auditAllowed, "video")
+	cfg.uploads.remove(session.ID)
+	return video, true
+}
+
+// handlerUploadAbort discards a chunked upload and its parts.
+func (cfg *apiConfig) handlerUploadAbort(w http.ResponseWriter, r *http.Request) {
+	session, ok := cfg.uploadSessionForRequest(w, r)
+	if !ok {
+		return
+	}
+	cfg.uploads.remove(session.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// uploadSessionForRequest looks up the session named in the path and checks
+// that it belongs to the caller, writing an error response if not.
+func (cfg *apiConfig) uploadSessionForRequest(w http.ResponseWriter, r *http.Request) (*uploadSession, bool) {
+	uploadID, err := uuid.Parse(r.PathValue("uploadID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid upload ID", err)
+		return nil, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return nil, false
+	}
+	userID, err := cfg.validateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return nil, false
+	}
+
+	session := cfg.uploads.get(uploadID)
+	if session == nil || session.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Upload not found", nil)
+		return nil, false
+	}
+	return session, true
+}
@@ -1,25 +1,25 @@
 package main
 
 import (
-	"fmt"
-	"io"
+	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
-	"mime"
 
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/google/uuid"
 )
 
 func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
+	videoID, err := cfg.parseVideoID(r.PathValue("videoID"))
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
 		return
 	}
 
@@ -29,7 +29,7 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := cfg.validateJWT(token)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
@@ -39,90 +39,195 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 
 	const maxMemory = 10 << 20 // 10 MB
 
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.uploadLimits.thumbnailSize)
 	if err := r.ParseMultipartForm(maxMemory); err != nil {
-		http.Error(w, "Could not parse multipart form: "+err.Error(), http.StatusBadRequest)
+		respondWithError(w, http.StatusBadRequest, "Could not parse multipart form", err)
 		return
 	}
 
-	file, fileHeader, err := r.FormFile("thumbnail")
+	file, fileHeader, err := formFile(r, cfg.uploadFields.thumbnail)
 	if err != nil {
-		http.Error(w, "Could not get thumbnail from form: "+err.Error(), http.StatusBadRequest)
+		respondWithError(w, http.StatusBadRequest, "Could not get thumbnail from form", err)
 		return
 	}
 	defer file.Close()
 
-	contentType := fileHeader.Header.Get("Content-Type")
-	mediaType, _, err := mime.ParseMediaType(contentType)
+	checksum, err := parseUploadChecksum(fileHeader.Header, r.Header)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type header", err)
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
 		return
 	}
-
-	if mediaType != "image/jpeg" && mediaType != "image/png" {
-		respondWithError(w, http.StatusBadRequest, "Unsupported media type. Only image/jpeg and image/png are allowed", nil)
+	if err := checksum.verify(file); err != nil {
+		respondWithChecksumError(w, err)
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not read thumbnail", err)
 		return
 	}
 
-	video, err := cfg.db.GetVideo(videoID)
+	header, err := readHeader(file)
 	if err != nil {
-		http.Error(w, "Video not found", http.StatusNotFound)
+		respondWithError(w, http.StatusBadRequest, "Could not read thumbnail", err)
 		return
 	}
 
-	if video.UserID != userID {
-		http.Error(w, "Unauthorized: you do not own this video", http.StatusUnauthorized)
+	mediaType, err := uploadMediaType(fileHeader.Header.Get("Content-Type"), header, cfg.allowedImageTypes)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid thumbnail type: "+err.Error(), err)
+		return
+	}
+	if err := checkMediaTypeConsistency(mediaType, fileHeader.Filename, header); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Thumbnail type mismatch: "+err.Error(), err)
 		return
 	}
 
-	ext := getExtensionFromContentType(contentType)
-	if ext == "" {
-		http.Error(w, "Unsupported content type: "+contentType, http.StatusBadRequest)
+	video, err := cfg.getVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithDBError(w, http.StatusNotFound, "Video not found", err)
 		return
 	}
 
-	var randomBytes [32]byte
-	if _, err := rand.Read(randomBytes[:]); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to generate file name", err)
+	if video.UserID != userID {
+		cfg.audit(r, userID, video.ID, auditUpload, auditDenied, "not owner")
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized: you do not own this video", nil)
 		return
 	}
-	randomBase64 := base64.RawURLEncoding.EncodeToString(randomBytes[:])
 
-	filename := fmt.Sprintf("%s%s", randomBase64, ext)
-	fullPath := filepath.Join(cfg.assetsRoot, filename)
+	if extensionForMediaType(mediaType) == "" {
+		respondWithError(w, http.StatusBadRequest, "Unsupported content type: "+mediaType, nil)
+		return
+	}
 
-	outFile, err := os.Create(fullPath)
+	oldURL := video.ThumbnailURL
+	url, err := cfg.storeThumbnail(r.Context(), video.ID, file, mediaType, checksum.apply)
 	if err != nil {
-		http.Error(w, "Failed to create file: "+err.Error(), http.StatusInternalServerError)
+		respondWithError(w, http.StatusInternalServerError, "Failed to store thumbnail", err)
 		return
 	}
-	defer outFile.Close()
+	video.ThumbnailURL = &url
+	video.DominantColor = thumbnailColor(file)
+	video.PerceptualHash = cfg.thumbnailHash(file)
 
-	if _, err := io.Copy(outFile, file); err != nil {
-		http.Error(w, "Failed to save file: "+err.Error(), http.StatusInternalServerError)
+	if err := cfg.updateVideo(r.Context(), video); err != nil {
+		respondWithDBError(w, http.StatusInternalServerError, "Failed to update video metadata", err)
 		return
 	}
 
-	url := fmt.Sprintf("http://localhost:%s/assets/%s", cfg.port, filename)
-	video.ThumbnailURL = &url
+	cfg.removeReplacedThumbnail(r.Context(), oldURL, url)
 
-	if err := cfg.db.UpdateVideo(video); err != nil {
-		http.Error(w, "Failed to update video metadata: "+err.Error(), http.StatusInternalServerError)
-		return
+	cfg.audit(r, userID, video.ID, auditUpload, auditAllowed, "thumbnail")
+	respondWithJSON(w, http.StatusOK, cfg.presentVideo(video))
+}
+
+// storeThumbnail saves a thumbnail image for the video, in S3 or the
+// assets dir depending on THUMBNAIL_STORAGE, and returns its URL. It's
+// named by THUMBNAIL_KEYS: randomly, or after the video, replacing its
+// previous thumbnail in the same format. optFns only apply to S3 uploads,
+// and are dropped if a JPEG is made progressive since they may describe
+// the bytes as uploaded.
+func (cfg *apiConfig) storeThumbnail(ctx context.Context, videoID uuid.UUID, body io.Reader, mediaType string, optFns ...func(*s3.PutObjectInput)) (string, error) {
+	if cfg.thumbnailStore.progressiveJPEG && normalizeMediaType(mediaType) == "image/jpeg" {
+		spooled, converted, cleanup, err := cfg.progressiveThumbnail(ctx, body)
+		if err != nil {
+			return "", err
+		}
+		defer cleanup()
+		body = spooled
+		if converted {
+			optFns = nil
+		}
+	}
+
+	name := videoID.String()
+	if cfg.thumbnailStore.keyScheme != thumbnailKeysVideo {
+		var randomBytes [32]byte
+		if _, err := rand.Read(randomBytes[:]); err != nil {
+			return "", fmt.Errorf("couldn't generate file name: %w", err)
+		}
+		name = base64.RawURLEncoding.EncodeToString(randomBytes[:])
+	}
+
+	filename := fmt.Sprintf("%s%s", name, extensionForMediaType(mediaType))
+
+	if cfg.thumbnailStore.useS3 {
+		key := cfg.s3KeyPrefix + "thumbnails/" + filename
+		optFns = append(optFns, withStorageClass(cfg.thumbnailStore.storageClass))
+		return cfg.putObject(ctx, key, body, normalizeMediaType(mediaType), optFns...)
+	}
+
+	fullPath := filepath.Join(cfg.assetsRoot, filename)
+	// Variants generated from a thumbnail we're replacing would be stale.
+	cfg.removeAsset(fullPath)
+	outFile, err := os.Create(fullPath)
+	if err != nil {
+		return "", err
 	}
+	defer outFile.Close()
 
-	respondWithJSON(w, http.StatusOK, video)
+	if _, err := io.Copy(outFile, body); err != nil {
+		os.Remove(fullPath)
+		return "", err
+	}
+	return fmt.Sprintf("http://localhost:%s/assets/%s", cfg.port, filename), nil
 }
 
-func getExtensionFromContentType(contentType string) string {
-	switch strings.ToLower(contentType) {
-	case "image/jpeg", "image/jpg":
-		return ".jpg"
-	case "image/png":
-		return ".png"
-	case "image/gif":
-		return ".gif"
-	default:
-		return ""
+// progressiveThumbnail spools body to disk and converts it to a progressive
+// JPEG, returning the image to store in body's place and whether it was
+// converted. If conversion isn't needed or fails, the spooled original is
+// returned; a thumbnail that stays baseline is better than none. cleanup
+// removes the temp files.
+func (cfg *apiConfig) progressiveThumbnail(ctx context.Context, body io.Reader) (image io.Reader, converted bool, cleanup func(), err error) {
+	spool, err := os.CreateTemp("", "tubely-thumbnail-*.jpg")
+	if err != nil {
+		return nil, false, nil, err
+	}
+	files := []*os.File{spool}
+	cleanup = func() {
+		for _, f := range files {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}
+	if _, err := io.Copy(spool, body); err != nil {
+		cleanup()
+		return nil, false, nil, err
+	}
+
+	progressivePath, err := cfg.progressiveJPEG(ctx, spool.Name())
+	if err != nil || progressivePath == "" {
+		if err != nil {
+			log.Printf("warning: storing thumbnail as uploaded: %v", err)
+		}
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			cleanup()
+			return nil, false, nil, err
+		}
+		return spool, false, cleanup, nil
+	}
+	out, err := os.Open(progressivePath)
+	if err != nil {
+		os.Remove(progressivePath)
+		cleanup()
+		return nil, false, nil, err
 	}
+	files = append(files, out)
+	return out, true, cleanup, nil
 }
 
+// removeReplacedThumbnail deletes the thumbnail at oldURL once a video has
+// switched to newURL, when thumbnails are named after their video. Random
+// names are left for the orphan cleanup as before. A thumbnail replaced in
+// place, with the same URL, is kept of course.
+func (cfg *apiConfig) removeReplacedThumbnail(ctx context.Context, oldURL *string, newURL string) {
+	if cfg.thumbnailStore.keyScheme != thumbnailKeysVideo || oldURL == nil || *oldURL == newURL {
+		return
+	}
+	if path, ok := cfg.assetPathFromURL(*oldURL); ok {
+		cfg.removeAsset(path)
+		return
+	}
+	if err := cfg.deleteObjectURL(ctx, *oldURL); err != nil {
+		log.Printf("warning: couldn't delete replaced thumbnail: %v", err)
+	}
+}
@@ -1,33 +1,40 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"mime"
+	"log"
 	"net/http"
 	"os"
-	"bytes"
-	"encoding/json"
-	"errors"
 	"os/exec"
-	"log"
+	"path/filepath"
+	"slices"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
-	"encoding/base64"
-	"crypto/rand"
 )
 
 func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
-	const maxUploadSize = 1 << 30 // 1 GB
-	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	if !cfg.requireFreeDisk(w, cfg.uploadLimits.videoSize) {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.uploadLimits.videoSize)
 
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
+	videoID, err := cfg.parseVideoID(r.PathValue("videoID"))
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
 		return
 	}
 
@@ -37,144 +44,487 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := cfg.validateJWT(token)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Invalid JWT", err)
 		return
 	}
 
-	video, err := cfg.db.GetVideo(videoID)
+	video, err := cfg.getVideo(r.Context(), videoID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		respondWithDBError(w, http.StatusNotFound, "Video not found", err)
 		return
 	}
 
 	if video.UserID != userID {
+		cfg.audit(r, userID, video.ID, auditUpload, auditDenied, "not owner")
 		respondWithError(w, http.StatusUnauthorized, "You do not own this video", nil)
 		return
 	}
 
-	file, fileHeader, err := r.FormFile("video")
+	// A client that sends the checksum up front is turned away before the
+	// file is read. Without the header the content isn't known until the
+	// whole body has been received and hashed, so a duplicate is only
+	// caught then, though still before any processing or S3 writes.
+	var release func()
+	if digest := r.Header.Get("X-Amz-Checksum-Sha256"); digest != "" && checkDigestHeader("x-amz-checksum-sha256", digest, sha256.Size) == nil {
+		raw, _ := base64.StdEncoding.DecodeString(digest)
+		var ok bool
+		if release, ok = cfg.claimInFlightUpload(w, userID, hex.EncodeToString(raw)); !ok {
+			return
+		}
+		defer release()
+	}
+
+	upload, ok := cfg.receiveVideoFile(w, r)
+	if !ok {
+		return
+	}
+	defer cfg.tempFiles.remove(upload.path)
+
+	if release == nil {
+		if release, ok = cfg.claimInFlightUpload(w, userID, upload.sha256); !ok {
+			return
+		}
+		defer release()
+	}
+
+	if folderID := r.FormValue("folder_id"); folderID != "" {
+		video.FolderID, err = cfg.userFolder(r.Context(), folderID, userID)
+		if err != nil {
+			respondWithFolderError(w, err)
+			return
+		}
+	}
+
+	if err := cfg.publishVideo(r.Context(), &video, upload.path, upload.mediaType, upload.filename, upload.checksum); err != nil {
+		respondWithPublishError(w, err)
+		return
+	}
+
+	if err := cfg.updateVideo(r.Context(), video); err != nil {
+		respondWithDBError(w, http.StatusInternalServerError, "Failed to update video metadata", err)
+		return
+	}
+
+	cfg.audit(r, userID, video.ID, auditUpload, auditAllowed, "video")
+	respondWithJSON(w, http.StatusOK, cfg.presentVideo(video))
+}
+
+// claimInFlightUpload marks the user's upload of the content with the
+// given hex SHA-256 as processing, writing a 409 and returning false if
+// the same file is already being processed for them. Otherwise the caller
+// must call release when it's done.
+func (cfg *apiConfig) claimInFlightUpload(w http.ResponseWriter, userID uuid.UUID, contentHash string) (release func(), ok bool) {
+	release, ok = cfg.uploads.claimInFlight(userID, contentHash)
+	if !ok {
+		respondWithError(w, http.StatusConflict, "duplicate upload in progress", nil)
+	}
+	return release, ok
+}
+
+// receivedVideo is a video file from a multipart upload, saved to a temp
+// file the caller must remove.
+type receivedVideo struct {
+	path      string
+	mediaType string
+	// filename is the base name the client sent, if any.
+	filename string
+	checksum uploadChecksum
+	// sha256 is the hex SHA-256 of the file.
+	sha256 string
+}
+
+// receiveVideoFile validates the uploaded video form file and saves it to
+// disk, writing an error response and returning false if it can't.
+func (cfg *apiConfig) receiveVideoFile(w http.ResponseWriter, r *http.Request) (receivedVideo, bool) {
+	file, fileHeader, err := formFile(r, cfg.uploadFields.video)
+	if isDiskFull(err) {
+		// The multipart reader removes the parts it spooled when it fails.
+		respondWithDiskFull(w, err)
+		return receivedVideo{}, false
+	}
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Could not read video file", err)
-		return
+		return receivedVideo{}, false
 	}
 	defer file.Close()
 
-	contentType := fileHeader.Header.Get("Content-Type")
-	mediaType, _, err := mime.ParseMediaType(contentType)
-	if err != nil || mediaType != "video/mp4" {
-		respondWithError(w, http.StatusBadRequest, "Only video/mp4 is supported", nil)
-		return
+	checksum, err := parseUploadChecksum(fileHeader.Header, r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return receivedVideo{}, false
+	}
+
+	header, err := readHeader(file)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Could not read video file", err)
+		return receivedVideo{}, false
 	}
 
-	tempFile, err := os.CreateTemp("", "tubely-upload.mp4")
+	mediaType, err := uploadMediaType(fileHeader.Header.Get("Content-Type"), header, cfg.allowedVideoTypes)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video type: "+err.Error(), err)
+		return receivedVideo{}, false
+	}
+	ext := extensionForMediaType(mediaType)
+	if err := checkMediaTypeConsistency(mediaType, fileHeader.Filename, header); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Video type mismatch: "+err.Error(), err)
+		return receivedVideo{}, false
+	}
+
+	tempFile, err := os.CreateTemp("", "tubely-upload*"+ext)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Could not create temp file", err)
-		return
+		return receivedVideo{}, false
 	}
-	defer os.Remove(tempFile.Name())
-	defer tempFile.Close()
 
-	if _, err := io.Copy(tempFile, file); err != nil {
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tempFile, hash), file)
+	if closeErr := tempFile.Close(); err == nil {
+		// A full disk can first show up when buffered writes are flushed.
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+		if isDiskFull(err) {
+			respondWithDiskFull(w, err)
+			return receivedVideo{}, false
+		}
 		respondWithError(w, http.StatusInternalServerError, "Could not write temp file", err)
-		return
+		return receivedVideo{}, false
+	}
+	if err := checksum.verifyFile(tempFile.Name()); err != nil {
+		os.Remove(tempFile.Name())
+		respondWithChecksumError(w, err)
+		return receivedVideo{}, false
 	}
 
-	if _, err := tempFile.Seek(0, io.SeekStart); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to rewind file", err)
+	upload := receivedVideo{path: tempFile.Name(), mediaType: mediaType, checksum: checksum, sha256: hex.EncodeToString(hash.Sum(nil))}
+	if fileHeader.Filename != "" {
+		upload.filename = filepath.Base(fileHeader.Filename)
+	}
+	return upload, true
+}
+
+// publishError carries the message shown to the client when a step of
+// publishVideo fails.
+type publishError struct {
+	msg string
+	err error
+}
+
+func (e *publishError) Error() string { return e.msg + ": " + e.err.Error() }
+func (e *publishError) Unwrap() error { return e.err }
+
+func respondWithPublishError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errAspectRatioUndetected) {
+		// The cause is ffprobe's output, which is for the logs.
+		respondWithError(w, http.StatusBadRequest, errAspectRatioUndetected.Error(), err)
+		return
+	}
+	if errors.Is(err, errUnsupportedColor) || errors.Is(err, errVideoTooLong) || errors.Is(err, errUploadPolicy) {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
 		return
 	}
+	if errors.Is(err, errWriteConflict) {
+		respondWithError(w, http.StatusConflict, "Another upload wrote this video first; retry", err)
+		return
+	}
+	var perr *publishError
+	if errors.As(err, &perr) {
+		respondWithProcessingError(w, perr.msg, perr.err)
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, "Video processing failed", err)
+}
 
-	processedPath, err := processVideoForFastStart(tempFile.Name())
+// publishVideo runs a fully received upload at uploadPath through
+// transcoding and faststart, stores it in S3 and records the result on
+// video. The caller persists video. checksum is the client's checksum of
+// the upload, which S3 verifies again if the original is kept.
+func (cfg *apiConfig) publishVideo(ctx context.Context, video *database.Video, uploadPath, mediaType, originalFilename string, checksum uploadChecksum) error {
+	if err := cfg.checkUploadPolicy(ctx, video.UserID, uploadPath, mediaType); err != nil {
+		return err
+	}
+
+	video.DurationSeconds = nil
+	duration, err := cfg.getVideoDuration(ctx, uploadPath)
+	if limit := cfg.uploadLimits.videoDuration; limit > 0 {
+		if err != nil {
+			return &publishError{"Couldn't read video duration", err}
+		}
+		if time.Duration(duration*float64(time.Second)) > limit {
+			return fmt.Errorf("%w: the limit is %s", errVideoTooLong, limit)
+		}
+	}
 	if err != nil {
-		log.Println("Failed to process video for fast start:", err)
-		respondWithError(w, http.StatusInternalServerError, "Video processing failed", err)
-		return
+		log.Println("warning: failed to read video duration:", err)
+	} else {
+		video.DurationSeconds = &duration
 	}
-	defer os.Remove(processedPath) // Clean up processed file
 
-	aspectRatio, err := getVideoAspectRatio(tempFile.Name())
+	chapters, err := cfg.probeChapters(ctx, uploadPath)
 	if err != nil {
-		log.Println("warning: failed to get aspect ratio:", err)
-		aspectRatio = "other"
+		log.Println("warning: failed to read chapters:", err)
+		chapters = database.ChapterList{}
 	}
+	video.Chapters = chapters
+
+	inputPath := uploadPath
+	// A ratio that's merely unusual is classified "other" either way; only a
+	// file we couldn't measure at all is rejected in strict mode.
+	aspectRatio := "other"
+	// sourceHeight stays 0, and no variants are made, if we can't probe.
+	sourceHeight := 0
+	stream, err := cfg.probeVideoStream(ctx, uploadPath)
+	if err != nil {
+		if err := cfg.aspectRatioUndetected(ctx, err); err != nil {
+			return err
+		}
+		log.Println("warning: failed to probe video stream:", err)
+	} else {
+		if ratio, err := classifyAspectRatio(stream.Width, stream.Height); err != nil {
+			if err := cfg.aspectRatioUndetected(ctx, err); err != nil {
+				return err
+			}
+			log.Println("warning: failed to get aspect ratio:", err)
+		} else {
+			aspectRatio = ratio
+		}
+		sourceHeight = stream.Height
+		video.PixelFormat = nonEmpty(stream.PixFmt)
+		video.ColorTransfer = nonEmpty(stream.ColorTransfer)
 
-	prefix := "other/"
-	if aspectRatio == "16:9" {
-		prefix = "landscape/"
-	} else if aspectRatio == "9:16" {
-		prefix = "portrait/"
+		sdrPath, err := cfg.convertToSDRIfNeeded(ctx, inputPath, stream)
+		if errors.Is(err, errUnsupportedColor) {
+			return err
+		}
+		if err != nil {
+			return &publishError{"Color conversion failed", err}
+		}
+		if sdrPath != "" {
+			defer cfg.tempFiles.remove(sdrPath)
+			inputPath = sdrPath
+		}
+	}
+
+	downscaledPath, err := cfg.downscaleIfNeeded(ctx, inputPath)
+	if err != nil {
+		return &publishError{"Video transcoding failed", err}
+	}
+	if downscaledPath != "" {
+		defer cfg.tempFiles.remove(downscaledPath)
+		inputPath = downscaledPath
+		sourceHeight = min(sourceHeight, cfg.transcode.maxHeight)
+	}
+
+	watermarkedPath, err := cfg.watermarkIfNeeded(ctx, inputPath, video.UserID)
+	if err != nil {
+		return &publishError{"Watermarking failed", err}
+	}
+	if watermarkedPath != "" {
+		defer cfg.tempFiles.remove(watermarkedPath)
+		inputPath = watermarkedPath
+	}
+
+	normalizedPath, err := cfg.checkAudio(ctx, inputPath, video)
+	if err != nil {
+		if cfg.audio.normalize {
+			return &publishError{"Audio normalization failed", err}
+		}
+		log.Println("warning: failed to check audio:", err)
+	}
+	if normalizedPath != "" {
+		defer cfg.tempFiles.remove(normalizedPath)
+		inputPath = normalizedPath
+	}
+
+	video.Faststart = true
+	processedPath, err := cfg.processVideoForFastStartCached(ctx, inputPath)
+	if err != nil {
+		if !cfg.canSkipFaststart(ctx, inputPath, err) {
+			log.Println("Failed to process video for fast start:", err)
+			return &publishError{"Video processing failed", err}
+		}
+		log.Printf("warning: publishing video %s without faststart: %v", video.ID, err)
+		os.Remove(inputPath + ".processing")
+		video.Faststart = false
+		processedPath = inputPath
+	} else {
+		defer cfg.tempFiles.remove(processedPath)
 	}
 
 	randomBytes := make([]byte, 32)
 	if _, err := rand.Read(randomBytes); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to generate random key", err)
-		return
+		return &publishError{"Failed to generate random key", err}
 	}
-	fileName := base64.RawURLEncoding.EncodeToString(randomBytes) + ".mp4"
+	baseName := base64.RawURLEncoding.EncodeToString(randomBytes)
+	fileName := baseName + cfg.outputFormat.ext
+
+	s3Key := cfg.s3KeyPrefix + cfg.videoKeyPrefix(aspectRatio, video.UserID, video.FolderID, time.Now()) + fileName
 
-	s3Key := prefix + fileName
+	target := cfg
+	video.S3Bucket = nil
+	if bucket, ok := cfg.s3AspectBuckets[aspectRatio]; ok {
+		target = cfg.withBucket(bucket)
+		video.S3Bucket = &bucket
+	}
 
 	processedFile, err := os.Open(processedPath)
 	if err != nil {
 		log.Println("Failed to open processed video:", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to read processed video", err)
-		return
+		return &publishError{"Failed to read processed video", err}
 	}
 	defer processedFile.Close()
+	video.VideoSize = nil
+	if info, err := processedFile.Stat(); err == nil {
+		size := info.Size()
+		video.VideoSize = &size
+	}
 
-	_, err = cfg.s3Client.PutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket:      &cfg.s3Bucket,
+	_, err = target.s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      &target.s3Bucket,
 		Key:         &s3Key,
 		Body:        processedFile,
-		ContentType: &mediaType,
+		ContentType: &cfg.outputFormat.contentType,
+		IfNoneMatch: aws.String("*"),
 	})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to upload to S3", err)
-		return
+		return &publishError{"Failed to upload to S3", conditionalWriteError(err)}
+	}
+
+	if err := target.waitForObject(ctx, s3Key); err != nil {
+		return &publishError{"Uploaded video is not readable yet", err}
 	}
 
-	url := fmt.Sprintf("https://%s/%s", cfg.s3CfDistribution, s3Key)
+	video.OriginalStorageClass = nil
+	if (downscaledPath != "" && cfg.transcode.keepOriginal) || cfg.transcode.originalStorageClass != "" {
+		originalKey := cfg.s3KeyPrefix + "originals/" + baseName + extensionForMediaType(mediaType)
+		class := cfg.transcode.originalStorageClass
+		originalURL, err := target.uploadFileToS3(ctx, uploadPath, originalKey, mediaType, checksum.apply, ifAbsent, withStorageClass(class))
+		if err != nil {
+			return &publishError{"Failed to upload original video", err}
+		}
+		video.OriginalURL = &originalURL
+		if class != "" {
+			video.OriginalStorageClass = &class
+		}
+	}
+
+	video.Variants = cfg.renderVariants(ctx, target, processedPath, sourceHeight, baseName)
+	video.HLSURL, video.HLSIndex = nil, nil
+	if cfg.transcode.hlsByteRange {
+		video.HLSURL, video.HLSIndex = cfg.renderHLS(ctx, target, processedPath, baseName)
+	}
+
+	url := target.objectURL(s3Key)
 	video.VideoURL = &url
+	cfg.loadVideoObjectInfo(ctx, video)
+	video.AspectRatio = &aspectRatio
+	if originalFilename != "" {
+		video.OriginalFilename = &originalFilename
+	}
+	return nil
+}
 
-	if err := cfg.db.UpdateVideo(video); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to update video metadata", err)
-		return
+var knownAspectRatios = []string{"16:9", "9:16", "other"}
+
+var errAspectRatioUndetected = errors.New("couldn't determine the video's aspect ratio")
+
+// aspectRatioUndetected decides what a failed probe means for the upload.
+// Leniently it's nil and the video is filed as "other"; in strict mode the
+// file is rejected as unreadable. Running out of ffmpeg slots or time says
+// nothing about the file, so strict mode fails those as processing errors
+// instead.
+func (cfg *apiConfig) aspectRatioUndetected(ctx context.Context, err error) error {
+	if !cfg.aspectRatioStrict {
+		return nil
+	}
+	if errors.Is(err, errFFmpegBusy) || ctx.Err() != nil {
+		return &publishError{"Couldn't probe video", err}
 	}
+	return fmt.Errorf("%w: %v", errAspectRatioUndetected, err)
+}
 
-	respondWithJSON(w, http.StatusOK, video)
+func isKnownAspectRatio(ratio string) bool {
+	for _, known := range knownAspectRatios {
+		if ratio == known {
+			return true
+		}
+	}
+	return false
+}
+
+type ffprobeStream struct {
+	CodecType     string `json:"codec_type"`
+	Width         int    `json:"width"`
+	Height        int    `json:"height"`
+	PixFmt        string `json:"pix_fmt"`
+	ColorTransfer string `json:"color_transfer"`
+	Disposition   struct {
+		AttachedPic int `json:"attached_pic"`
+	} `json:"disposition"`
 }
 
 type ffprobeOutput struct {
-	Streams []struct {
-		Width  int `json:"width"`
-		Height int `json:"height"`
-	} `json:"streams"`
+	Streams []ffprobeStream `json:"streams"`
+}
+
+// primaryVideoStream picks the stream to classify. The first stream is
+// often audio, and cover art shows up as a tiny video stream flagged
+// attached_pic, so we take the largest real video stream.
+func primaryVideoStream(streams []ffprobeStream) (ffprobeStream, bool) {
+	var best ffprobeStream
+	found := false
+	for _, s := range streams {
+		if s.CodecType != "video" || s.Disposition.AttachedPic != 0 {
+			continue
+		}
+		if !found || s.Width*s.Height > best.Width*best.Height {
+			best, found = s, true
+		}
+	}
+	return best, found
 }
 
-func getVideoAspectRatio(filePath string) (string, error) {
+// probeVideoStream returns the primary video stream of the file.
+func (cfg *apiConfig) probeVideoStream(ctx context.Context, filePath string) (ffprobeStream, error) {
 	var out bytes.Buffer
 
-	cmd := exec.Command("ffprobe", "-v", "error", "-print_format", "json", "-show_streams", filePath)
+	args := []string{"-v", "error", "-print_format", "json", "-show_streams"}
+	if cfg.ffmpeg.probeStreams != "" {
+		args = append(args, "-select_streams", cfg.ffmpeg.probeStreams)
+	}
+	args = append(args, filePath)
+	cmd := exec.CommandContext(ctx, "ffprobe", args...)
 	cmd.Stdout = &out
 
-	if err := cmd.Run(); err != nil {
-		return "", err
+	if err := cfg.ffmpeg.run(ctx, cmd); err != nil {
+		return ffprobeStream{}, err
 	}
 
 	var parsed ffprobeOutput
 	if err := json.Unmarshal(out.Bytes(), &parsed); err != nil {
-		return "", err
+		return ffprobeStream{}, err
 	}
 
-	if len(parsed.Streams) == 0 {
-		return "", errors.New("no streams found in ffprobe output")
+	stream, ok := primaryVideoStream(parsed.Streams)
+	if !ok {
+		return ffprobeStream{}, errors.New("no video stream found in ffprobe output")
 	}
+	return stream, nil
+}
 
-	width := parsed.Streams[0].Width
-	height := parsed.Streams[0].Height
+func (cfg *apiConfig) getVideoAspectRatio(ctx context.Context, filePath string) (string, error) {
+	stream, err := cfg.probeVideoStream(ctx, filePath)
+	if err != nil {
+		return "", err
+	}
+	return classifyAspectRatio(stream.Width, stream.Height)
+}
 
+func classifyAspectRatio(width, height int) (string, error) {
 	if width == 0 || height == 0 {
 		return "", errors.New("invalid dimensions")
 	}
@@ -202,19 +552,40 @@ func abs(x float64) float64 {
 	return x
 }
 
-func processVideoForFastStart(filePath string) (string, error) {
+// canSkipFaststart decides whether a video whose faststart processing
+// failed with err may be published as is. That takes FASTSTART_FALLBACK,
+// a failure in the file rather than a lack of ffmpeg slots or time, and a
+// file already in the output container.
+func (cfg *apiConfig) canSkipFaststart(ctx context.Context, path string, err error) bool {
+	if !cfg.faststartFallback || errors.Is(err, errFFmpegBusy) || ctx.Err() != nil {
+		return false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	header, err := readHeader(f)
+	return err == nil && sniffMediaType(header) == cfg.outputFormat.contentType
+}
+
+// faststartOutputArgs returns every ffmpeg option that shapes the faststart
+// output.
+func (cfg *apiConfig) faststartOutputArgs() []string {
+	return append(slices.Clone(cfg.outputFormat.args), cfg.ffmpeg.outputArgs()...)
+}
+
+// processVideoForFastStart produces the file we publish, in the configured
+// output format. For mp4 that's a stream copy with the moov atom moved to
+// the front.
+func (cfg *apiConfig) processVideoForFastStart(ctx context.Context, filePath string) (string, error) {
 	outputPath := filePath + ".processing"
 
-	cmd := exec.Command(
-		"ffmpeg",
-		"-i", filePath,
-		"-c", "copy",
-		"-movflags", "faststart",
-		"-f", "mp4",
-		outputPath,
-	)
+	args := append([]string{"-i", filePath}, cfg.faststartOutputArgs()...)
+	args = append(args, outputPath)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
 
-	if err := cmd.Run(); err != nil {
+	if err := cfg.ffmpeg.run(ctx, cmd); err != nil {
 		return "", fmt.Errorf("ffmpeg faststart processing failed: %w", err)
 	}
 
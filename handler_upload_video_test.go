@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func uploadVideo(cfg *apiConfig, req *http.Request, videoID, token string) *httptest.ResponseRecorder {
+	req.SetPathValue("videoID", videoID)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	cfg.handlerUploadVideo(rec, req)
+	return rec
+}
+
+func TestHandlerUploadVideo(t *testing.T) {
+	cfg, store, ffmpeg := newTestConfig(t)
+	user, token := createTestUser(t, cfg)
+	video := createTestVideo(t, cfg, user.ID)
+
+	req := newUploadRequest(t, "/api/video_upload/"+video.ID.String(), "video", "clip.mp4", "video/mp4", testMP4)
+	rec := uploadVideo(cfg, req, video.ID.String(), token)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	var got database.Video
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("couldn't decode response: %v", err)
+	}
+	if got.VideoURL == nil || !strings.HasPrefix(*got.VideoURL, "https://cdn.example/landscape/") {
+		t.Errorf("video_url = %v, want a landscape URL on the distribution", got.VideoURL)
+	}
+	if got.AspectRatio == nil || *got.AspectRatio != "16:9" {
+		t.Errorf("aspect_ratio = %v, want 16:9", got.AspectRatio)
+	}
+
+	keys := store.keys()
+	if len(keys) != 1 {
+		t.Fatalf("stored objects = %v, want just the video", keys)
+	}
+	key := strings.TrimPrefix(*got.VideoURL, "https://cdn.example/")
+	if keys[0] != "tubely-test/"+key {
+		t.Errorf("stored object = %s, want tubely-test/%s", keys[0], key)
+	}
+	if n := ffmpeg.ran("ffmpeg"); n != 1 {
+		t.Errorf("ffmpeg ran %d times, want 1 for faststart", n)
+	}
+
+	stored, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.VideoURL == nil || *stored.VideoURL != *got.VideoURL {
+		t.Errorf("stored video_url = %v, want %s", stored.VideoURL, *got.VideoURL)
+	}
+}
+
+func TestHandlerUploadVideoErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		token       func(t *testing.T, cfg *apiConfig, owner string) string
+		filename    string
+		contentType string
+		body        []byte
+		setup       func(store *fakeS3, ffmpeg *fakeFFmpeg)
+		want        int
+	}{
+		{
+			name:  "missing token",
+			token: func(*testing.T, *apiConfig, string) string { return "" },
+			want:  http.StatusUnauthorized,
+		},
+		{
+			name:  "invalid token",
+			token: func(*testing.T, *apiConfig, string) string { return "not-a-jwt" },
+			want:  http.StatusUnauthorized,
+		},
+		{
+			name: "not the owner",
+			token: func(t *testing.T, cfg *apiConfig, _ string) string {
+				_, other := createTestUser(t, cfg)
+				return other
+			},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name:        "type not allowed",
+			filename:    "clip.mov",
+			contentType: "video/quicktime",
+			want:        http.StatusBadRequest,
+		},
+		{
+			name:        "contents don't match type",
+			contentType: "video/mp4",
+			body:        []byte("\x89PNG\r\n\x1a\n0000000000000000"),
+			want:        http.StatusBadRequest,
+		},
+		{
+			name:  "ffmpeg fails",
+			setup: func(_ *fakeS3, ffmpeg *fakeFFmpeg) { ffmpeg.fail["ffmpeg"] = errors.New("exit status 1") },
+			want:  http.StatusInternalServerError,
+		},
+		{
+			name:  "S3 upload fails",
+			setup: func(store *fakeS3, _ *fakeFFmpeg) { store.putErr = errors.New("access denied") },
+			want:  http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, store, ffmpeg := newTestConfig(t)
+			user, token := createTestUser(t, cfg)
+			video := createTestVideo(t, cfg, user.ID)
+			if tt.token != nil {
+				token = tt.token(t, cfg, token)
+			}
+			if tt.filename == "" {
+				tt.filename = "clip.mp4"
+			}
+			if tt.contentType == "" {
+				tt.contentType = "video/mp4"
+			}
+			if tt.body == nil {
+				tt.body = testMP4
+			}
+			if tt.setup != nil {
+				tt.setup(store, ffmpeg)
+			}
+
+			req := newUploadRequest(t, "/api/video_upload/"+video.ID.String(), "video", tt.filename, tt.contentType, tt.body)
+			rec := uploadVideo(cfg, req, video.ID.String(), token)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+
+			if keys := store.keys(); len(keys) != 0 {
+				t.Errorf("stored objects = %v, want none after a failed upload", keys)
+			}
+			stored, err := cfg.db.GetVideo(video.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.VideoURL != nil {
+				t.Errorf("video_url = %s, want it unset after a failed upload", *stored.VideoURL)
+			}
+		})
+	}
+}
+
+// ffprobe -show_streams output for a phone recording whose first stream
+// is audio, with cover art after the video.
+const audioFirstStreams = `{"streams": [
+	{"index": 0, "codec_type": "audio", "codec_name": "aac"},
+	{"index": 1, "codec_type": "video", "codec_name": "h264", "width": 1080, "height": 1920, "pix_fmt": "yuv420p"},
+	{"index": 2, "codec_type": "video", "codec_name": "mjpeg", "width": 3000, "height": 3000, "disposition": {"attached_pic": 1}}
+]}`
+
+func TestPrimaryVideoStream(t *testing.T) {
+	audio := ffprobeStream{CodecType: "audio"}
+	small := ffprobeStream{CodecType: "video", Width: 640, Height: 360}
+	large := ffprobeStream{CodecType: "video", Width: 1920, Height: 1080}
+	cover := ffprobeStream{CodecType: "video", Width: 3000, Height: 3000}
+	cover.Disposition.AttachedPic = 1
+
+	tests := []struct {
+		name    string
+		streams []ffprobeStream
+		want    ffprobeStream
+		wantOK  bool
+	}{
+		{"audio first", []ffprobeStream{audio, large}, large, true},
+		{"cover art", []ffprobeStream{cover, small}, small, true},
+		{"largest video", []ffprobeStream{small, large}, large, true},
+		{"audio only", []ffprobeStream{audio}, ffprobeStream{}, false},
+		{"only cover art", []ffprobeStream{audio, cover}, ffprobeStream{}, false},
+		{"no streams", nil, ffprobeStream{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := primaryVideoStream(tt.streams)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("primaryVideoStream = %+v, %t, want %+v, %t", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGetVideoAspectRatioAudioFirst(t *testing.T) {
+	for _, probeStreams := range []string{"", "v"} {
+		t.Run("select_streams="+probeStreams, func(t *testing.T) {
+			cfg, _, ff := newTestConfig(t)
+			cfg.ffmpeg.probeStreams = probeStreams
+			ff.streamsJSON = audioFirstStreams
+
+			got, err := cfg.getVideoAspectRatio(context.Background(), "clip.mp4")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != "9:16" {
+				t.Errorf("aspect ratio = %q, want 9:16 from the video stream", got)
+			}
+
+			args := ff.calls[len(ff.calls)-1]
+			i := slices.Index(args, "-select_streams")
+			if probeStreams == "" {
+				if i >= 0 {
+					t.Errorf("ffprobe args %q select streams, want all", args)
+				}
+				return
+			}
+			if i < 0 || i+1 >= len(args) || args[i+1] != probeStreams {
+				t.Errorf("ffprobe args %q, want -select_streams %s", args, probeStreams)
+			}
+		})
+	}
+}
+
+func TestHandlerUploadVideoAspectRatioModes(t *testing.T) {
+	square := []ffprobeStream{{CodecType: "video", Width: 1000, Height: 1000, PixFmt: "yuv420p"}}
+	noDimensions := []ffprobeStream{{CodecType: "video", PixFmt: "yuv420p"}}
+	audioOnly := []ffprobeStream{{CodecType: "audio"}}
+
+	tests := []struct {
+		name      string
+		strict    bool
+		streams   []ffprobeStream
+		probeErr  error
+		want      int
+		wantRatio string
+	}{
+		{"lenient unusual ratio", false, square, nil, http.StatusOK, "other"},
+		{"lenient probe failed", false, nil, errors.New("invalid data found"), http.StatusOK, "other"},
+		{"lenient no video stream", false, audioOnly, nil, http.StatusOK, "other"},
+		{"lenient no dimensions", false, noDimensions, nil, http.StatusOK, "other"},
+		{"strict unusual ratio", true, square, nil, http.StatusOK, "other"},
+		{"strict probe failed", true, nil, errors.New("invalid data found"), http.StatusBadRequest, ""},
+		{"strict no video stream", true, audioOnly, nil, http.StatusBadRequest, ""},
+		{"strict no dimensions", true, noDimensions, nil, http.StatusBadRequest, ""},
+		// Says nothing about the file, so it isn't blamed on the upload.
+		{"strict ffmpeg busy", true, nil, errFFmpegBusy, http.StatusServiceUnavailable, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, store, ff := newTestConfig(t)
+			cfg.aspectRatioStrict = tt.strict
+			if tt.streams != nil {
+				ff.streams = tt.streams
+			}
+			if tt.probeErr != nil {
+				ff.fail["streams"] = tt.probeErr
+			}
+			user, token := createTestUser(t, cfg)
+			video := createTestVideo(t, cfg, user.ID)
+
+			req := newUploadRequest(t, "/api/video_upload/"+video.ID.String(), "video", "clip.mp4", "video/mp4", testMP4)
+			rec := uploadVideo(cfg, req, video.ID.String(), token)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+
+			stored, err := cfg.db.GetVideo(video.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == http.StatusBadRequest {
+				// Whatever ffprobe said stays in the logs.
+				var body struct {
+					Error  string `json:"error"`
+					Detail string `json:"detail"`
+				}
+				if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+					t.Fatal(err)
+				}
+				if body.Error != errAspectRatioUndetected.Error() || body.Detail != "" {
+					t.Errorf("body = %s, want just %q", rec.Body, errAspectRatioUndetected)
+				}
+			}
+			if tt.want != http.StatusOK {
+				if keys := store.keys(); len(keys) != 0 {
+					t.Errorf("stored %v, want nothing", keys)
+				}
+				if stored.VideoURL != nil {
+					t.Errorf("video_url = %s, want it unset", *stored.VideoURL)
+				}
+				return
+			}
+			if stored.AspectRatio == nil || *stored.AspectRatio != tt.wantRatio {
+				t.Errorf("aspect_ratio = %v, want %s", stored.AspectRatio, tt.wantRatio)
+			}
+		})
+	}
+}
+
+func TestHandlerUploadVideoDuplicateInFlight(t *testing.T) {
+	cfg, store, ffmpeg := newTestConfig(t)
+	user, token := createTestUser(t, cfg)
+	sum := sha256.Sum256(testMP4)
+
+	// upload returns the response and how much of the body went unread.
+	upload := func(token string, userID uuid.UUID, checksumHeader bool) (*httptest.ResponseRecorder, int) {
+		t.Helper()
+		video := createTestVideo(t, cfg, userID)
+		req := newUploadRequest(t, "/api/video_upload/"+video.ID.String(), "video", "clip.mp4", "video/mp4", testMP4)
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unread := bytes.NewReader(body)
+		req.Body = io.NopCloser(unread)
+		if checksumHeader {
+			req.Header.Set("X-Amz-Checksum-Sha256", base64.StdEncoding.EncodeToString(sum[:]))
+		}
+		return uploadVideo(cfg, req, video.ID.String(), token), unread.Len()
+	}
+
+	// The first submission of the file is still processing.
+	release, ok := cfg.uploads.claimInFlight(user.ID, hex.EncodeToString(sum[:]))
+	if !ok {
+		t.Fatal("couldn't claim the first upload")
+	}
+	// With the checksum header the duplicate is refused before its body is
+	// read; without it the body has to be hashed first.
+	for _, checksumHeader := range []bool{false, true} {
+		rec, unread := upload(token, user.ID, checksumHeader)
+		if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "duplicate upload in progress") {
+			t.Errorf("with checksum header %v: got %d %s, want 409", checksumHeader, rec.Code, rec.Body)
+		}
+		if checksumHeader && unread == 0 {
+			t.Error("with checksum header: the body was read before the duplicate was refused")
+		}
+		if !checksumHeader && unread != 0 {
+			t.Errorf("without checksum header: %d bytes unread, want the body hashed", unread)
+		}
+	}
+	if n := ffmpeg.ran("ffmpeg"); n != 0 || len(store.keys()) != 0 {
+		t.Errorf("duplicates ran ffmpeg %d times and stored %v, want neither", n, store.keys())
+	}
+
+	// Someone else uploading the same file isn't a double submit.
+	other, otherToken := createTestUser(t, cfg)
+	if rec, _ := upload(otherToken, other.ID, false); rec.Code != http.StatusOK {
+		t.Errorf("other user: status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	release()
+	if rec, _ := upload(token, user.ID, true); rec.Code != http.StatusOK {
+		t.Fatalf("after the first finished: status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	// Finishing released the claim.
+	if release, ok := cfg.uploads.claimInFlight(user.ID, hex.EncodeToString(sum[:])); !ok {
+		t.Error("the upload's claim outlived it")
+	} else {
+		release()
+	}
+}
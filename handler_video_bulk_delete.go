@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	bulkDeleteMaxVideos = 100
+
+	bulkDeleteDeleted   = "deleted"
+	bulkDeleteNotFound  = "not_found"
+	bulkDeleteForbidden = "forbidden"
+	bulkDeleteFailed    = "error"
+)
+
+// handlerBulkDeleteVideos deletes each video in a JSON array of IDs that
+// the user owns. Every ID gets its own result and nothing is rolled back,
+// so a client can retry just the ones that failed.
+func (cfg *apiConfig) handlerBulkDeleteVideos(w http.ResponseWriter, r *http.Request) {
+	type result struct {
+		VideoID uuid.UUID `json:"video_id"`
+		Status  string    `json:"status"`
+		Error   string    `json:"error,omitempty"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.validateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	var videoIDs []uuid.UUID
+	if err := json.NewDecoder(r.Body).Decode(&videoIDs); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Body must be a JSON array of video IDs", err)
+		return
+	}
+	if len(videoIDs) == 0 {
+		respondWithError(w, http.StatusBadRequest, "No video IDs given", nil)
+		return
+	}
+	if len(videoIDs) > bulkDeleteMaxVideos {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("At most %d videos can be deleted at once", bulkDeleteMaxVideos), nil)
+		return
+	}
+
+	results := []result{}
+	deleted := []database.Video{}
+	seen := map[uuid.UUID]bool{}
+	for _, videoID := range videoIDs {
+		if seen[videoID] {
+			continue
+		}
+		seen[videoID] = true
+
+		video, err := cfg.getVideo(r.Context(), videoID)
+		if err != nil {
+			results = append(results, result{VideoID: videoID, Status: bulkDeleteFailed, Error: errorDetail(err)})
+			continue
+		}
+		if video.ID == uuid.Nil {
+			results = append(results, result{VideoID: videoID, Status: bulkDeleteNotFound})
+			continue
+		}
+		if video.UserID != userID {
+			cfg.audit(r, userID, video.ID, auditDelete, auditDenied, "not owner")
+			results = append(results, result{VideoID: videoID, Status: bulkDeleteForbidden})
+			continue
+		}
+
+		if err := cfg.db.DeleteVideo(videoID); err != nil {
+			results = append(results, result{VideoID: videoID, Status: bulkDeleteFailed, Error: errorDetail(err)})
+			continue
+		}
+		cfg.audit(r, userID, videoID, auditDelete, auditAllowed, "bulk")
+		deleted = append(deleted, video)
+		results = append(results, result{VideoID: videoID, Status: bulkDeleteDeleted})
+	}
+
+	if cfg.deleteObjects && len(deleted) > 0 {
+		cfg.deleteVideosObjects(r.Context(), deleted)
+	}
+
+	respondWithJSON(w, http.StatusOK, results)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestHandlerBulkDeleteVideos(t *testing.T) {
+	cfg, store, _ := newTestConfig(t)
+	cfg.deleteObjects = true
+	user, token := createTestUser(t, cfg)
+	other, _ := createTestUser(t, cfg)
+
+	mine := createTestVideo(t, cfg, user.ID)
+	storeTestVideo(t, cfg, store, &mine)
+	alsoMine := createTestVideo(t, cfg, user.ID)
+	storeTestVideo(t, cfg, store, &alsoMine)
+	theirs := createTestVideo(t, cfg, other.ID)
+	storeTestVideo(t, cfg, store, &theirs)
+	missing := uuid.New()
+
+	ids := []uuid.UUID{mine.ID, theirs.ID, missing, alsoMine.ID, mine.ID}
+	body, err := json.Marshal(ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/videos/bulk_delete", strings.NewReader(string(body)))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	cfg.handlerBulkDeleteVideos(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	var results []struct {
+		VideoID uuid.UUID `json:"video_id"`
+		Status  string    `json:"status"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	want := map[uuid.UUID]string{
+		mine.ID:     bulkDeleteDeleted,
+		theirs.ID:   bulkDeleteForbidden,
+		missing:     bulkDeleteNotFound,
+		alsoMine.ID: bulkDeleteDeleted,
+	}
+	if len(results) != len(want) {
+		t.Fatalf("results = %+v, want one per distinct ID", results)
+	}
+	for _, r := range results {
+		if r.Status != want[r.VideoID] {
+			t.Errorf("%s: status = %s, want %s", r.VideoID, r.Status, want[r.VideoID])
+		}
+	}
+
+	for _, id := range []uuid.UUID{mine.ID, alsoMine.ID} {
+		if v, err := cfg.db.GetVideo(id); err != nil || v.ID != uuid.Nil {
+			t.Errorf("video %s still exists (err %v)", id, err)
+		}
+	}
+	wantKey := cfg.s3Bucket + "/landscape/" + theirs.ID.String() + ".mp4"
+	if keys := store.keys(); len(keys) != 1 || keys[0] != wantKey {
+		t.Errorf("bucket has %v, want only %s", keys, wantKey)
+	}
+}
+
+func TestHandlerBulkDeleteVideosLimits(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	_, token := createTestUser(t, cfg)
+
+	tooMany := make([]string, bulkDeleteMaxVideos+1)
+	for i := range tooMany {
+		tooMany[i] = `"` + uuid.NewString() + `"`
+	}
+	for name, body := range map[string]string{
+		"empty":    "[]",
+		"not JSON": "nope",
+		"too many": "[" + strings.Join(tooMany, ",") + "]",
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/videos/bulk_delete", strings.NewReader(body))
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			cfg.handlerBulkDeleteVideos(rec, req)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400", rec.Code)
+			}
+		})
+	}
+}
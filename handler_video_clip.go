@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+// handlerPreviewClip cuts a short segment out of a video for sharing. Any
+// signed-in user who can view the video can clip it. Cutting a new range
+// runs ffmpeg, so anonymous callers are turned away rather than left free
+// to queue re-encodes; ranges that were already cut are reused from S3.
+func (cfg *apiConfig) handlerPreviewClip(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		// Start and End are offsets into the video in seconds.
+		Start float64 `json:"start"`
+		End   float64 `json:"end"`
+		// Seek is "accurate" (the default) or "fast", which is quicker
+		// but may start the clip at a keyframe a little early.
+		Seek string `json:"seek"`
+	}
+	type response struct {
+		URL   string   `json:"url"`
+		Start float64  `json:"start"`
+		End   float64  `json:"end"`
+		Seek  seekMode `json:"seek"`
+	}
+
+	videoID, err := cfg.parseVideoID(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	if _, err := cfg.validateJWT(token); err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	seek, err := parseSeekMode(params.Seek, defaultClipSeek)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	video, err := cfg.getVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithDBError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !cfg.canViewVideo(r, video) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusBadRequest, "Video has not been uploaded yet", nil)
+		return
+	}
+
+	url, err := cfg.createClip(r.Context(), video, params.Start, params.End, seek)
+	if errors.Is(err, errInvalidClipRange) {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if err != nil {
+		respondWithProcessingError(w, "Couldn't create clip", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		URL:   url,
+		Start: params.Start,
+		End:   params.End,
+		Seek:  seek,
+	})
+}
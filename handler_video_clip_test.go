@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestHandlerPreviewClip(t *testing.T) {
+	cfg, store, ffmpeg := newTestConfig(t)
+	cfg.clips = clipConfig{maxLength: 5 * time.Second}
+	owner, ownerToken := createTestUser(t, cfg)
+	_, otherToken := createTestUser(t, cfg)
+	video := createTestVideo(t, cfg, owner.ID)
+	storeTestVideo(t, cfg, store, &video)
+
+	clip := func(token, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/videos/"+video.ID.String()+"/clips", strings.NewReader(body))
+		req.SetPathValue("videoID", video.ID.String())
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		cfg.handlerPreviewClip(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		body  string
+		want  int
+	}{
+		{"anonymous", "", `{"start":1,"end":3}`, http.StatusUnauthorized},
+		{"invalid token", "not-a-jwt", `{"start":1,"end":3}`, http.StatusUnauthorized},
+		{"end before start", otherToken, `{"start":3,"end":1}`, http.StatusBadRequest},
+		{"negative start", otherToken, `{"start":-1,"end":1}`, http.StatusBadRequest},
+		{"longer than the maximum", otherToken, `{"start":0,"end":6}`, http.StatusBadRequest},
+		{"past the end of the video", otherToken, `{"start":11,"end":14}`, http.StatusBadRequest},
+		{"unknown seek mode", otherToken, `{"start":1,"end":3,"seek":"exact"}`, http.StatusBadRequest},
+		{"another user", otherToken, `{"start":1,"end":3}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := clip(tt.token, tt.body); rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+
+	// Clipping the same range again reuses the first cut.
+	encodes := ffmpeg.ran("ffmpeg")
+	rec := clip(ownerToken, `{"start":1,"end":3}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if n := ffmpeg.ran("ffmpeg") - encodes; n != 0 {
+		t.Errorf("repeated clip ran ffmpeg %d times, want it reused", n)
+	}
+	var got struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got.URL, "clips/"+video.ID.String()+"/") {
+		t.Errorf("url = %q, want a clip of the video", got.URL)
+	}
+
+	// Only the owner can clip a private video; everyone else gets the
+	// same 404 as for a missing one.
+	video.IsPublic = false
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+	if rec := clip(otherToken, `{"start":1,"end":3}`); rec.Code != http.StatusNotFound {
+		t.Errorf("private video, another user: status = %d, want 404", rec.Code)
+	}
+	if rec := clip(ownerToken, `{"start":1,"end":3}`); rec.Code != http.StatusOK {
+		t.Errorf("private video, owner: status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestDeleteVideoObjectsRemovesClips(t *testing.T) {
+	cfg, store, _ := newTestConfig(t)
+	cfg.clips = clipConfig{maxLength: 5 * time.Second}
+	user, _ := createTestUser(t, cfg)
+	deleted, kept := createTestVideo(t, cfg, user.ID), createTestVideo(t, cfg, user.ID)
+	for _, video := range []*database.Video{&deleted, &kept} {
+		storeTestVideo(t, cfg, store, video)
+		if _, err := cfg.createClip(context.Background(), *video, 1, 3, seekFast); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg.deleteVideoObjects(context.Background(), deleted)
+	var clips []string
+	for _, key := range store.keys() {
+		if strings.Contains(key, "/clips/") {
+			clips = append(clips, key)
+		}
+	}
+	if len(clips) != 1 || !strings.Contains(clips[0], kept.ID.String()) {
+		t.Errorf("clips left = %v, want only the other video's", clips)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"mime"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerVideoDownload redirects to the video file. HEAD is answered here
+// too, with the same status and Location as GET, so the two can't drift;
+// it also checks the object exists and doesn't count as a view. When the
+// owner has turned downloads off, everyone else gets a 403 whatever the
+// mode: they stream through /url and the embed page instead, whose links
+// don't last long enough to be passed around.
+func (cfg *apiConfig) handlerVideoDownload(w http.ResponseWriter, r *http.Request) {
+	videoID, err := cfg.parseVideoID(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	video, err := cfg.getVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithDBError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || video.VideoURL == nil || !cfg.canViewVideo(r, video) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if cfg.streamOnly(r, video) {
+		respondWithError(w, http.StatusForbidden, "Downloads are disabled for this video", nil)
+		return
+	}
+
+	isHead := r.Method == http.MethodHead
+	if isHead && !cfg.checkVideoObject(w, r, video) {
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode == "" && !video.DownloadAllowed {
+		// Only the owner gets this far, but the plain CDN URL never
+		// expires and could still be passed on, so sign one.
+		mode = downloadModeInline
+	}
+	if cfg.servedContentType(r.Context(), video) != "" && mode == "" {
+		// The plain URL would be served under the wrong type until the
+		// repair lands, and browsers download rather than play that. A
+		// signed URL sets the right one.
+		mode = downloadModeInline
+	}
+	if mode == "" {
+		setFrameHeaders(w, video)
+		if !isHead {
+			cfg.views.record(video.ID, viewSession(r, cfg.jwtKeys()))
+		}
+		http.Redirect(w, r, *video.VideoURL, http.StatusFound)
+		return
+	}
+
+	if mode != downloadModeInline && mode != downloadModeAttachment {
+		respondWithError(w, http.StatusBadRequest, "mode must be inline or attachment", nil)
+		return
+	}
+
+	url, _, err := cfg.signedVideoURL(r.Context(), video, mode, false)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
+		return
+	}
+
+	setFrameHeaders(w, video)
+	if mode == downloadModeInline && !isHead {
+		cfg.views.record(video.ID, viewSession(r, cfg.jwtKeys()))
+	}
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// handlerVideoURL returns a presigned link to the video file, so clients can
+// offer separate "play" and "download" links for the same object.
+func (cfg *apiConfig) handlerVideoURL(w http.ResponseWriter, r *http.Request) {
+	videoID, err := cfg.parseVideoID(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = downloadModeInline
+	}
+	if mode != downloadModeInline && mode != downloadModeAttachment {
+		respondWithError(w, http.StatusBadRequest, "mode must be inline or attachment", nil)
+		return
+	}
+
+	video, err := cfg.getVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithDBError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || video.VideoURL == nil || !cfg.canViewVideo(r, video) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	streamOnly := cfg.streamOnly(r, video)
+	if streamOnly && mode == downloadModeAttachment {
+		respondWithError(w, http.StatusForbidden, "Downloads are disabled for this video", nil)
+		return
+	}
+
+	// The signed URL sets the right type either way; this just queues a
+	// repair of the object if it needs one.
+	cfg.servedContentType(r.Context(), video)
+	url, expiresAt, err := cfg.signedVideoURL(r.Context(), video, mode, streamOnly)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		URL       string    `json:"url"`
+		Mode      string    `json:"mode"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}{
+		URL:       url,
+		Mode:      mode,
+		ExpiresAt: expiresAt,
+	})
+}
+
+const (
+	downloadModeInline     = "inline"
+	downloadModeAttachment = "attachment"
+)
+
+// signedVideoURL presigns the video object with a Content-Disposition of
+// mode, naming the file after the upload so "save as" gets a sensible name.
+// A streamOnly URL has no disposition and only lasts STREAM_URL_EXPIRY.
+func (cfg *apiConfig) signedVideoURL(ctx context.Context, video database.Video, mode string, streamOnly bool) (string, time.Time, error) {
+	key, err := cfg.s3KeyFromURL(*video.VideoURL)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	ext := path.Ext(key)
+	// Set from the key rather than trusting the object's metadata, which
+	// is wrong for some early uploads.
+	contentType := contentTypeForKey(key)
+	if contentType == "" {
+		contentType = mime.TypeByExtension(ext)
+	}
+	opts := presignOptions{
+		expires:     cfg.downloadURLExpiry,
+		contentType: contentType,
+	}
+	if streamOnly {
+		opts.expires = cfg.streamURLExpiry
+	} else {
+		opts.contentDisposition = mime.FormatMediaType(mode, map[string]string{
+			"filename": downloadFilename(video, ext),
+		})
+	}
+	expiresAt := time.Now().Add(opts.expires)
+	url, err := cfg.forVideo(video).presignGetObject(ctx, key, opts)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return url, expiresAt, nil
+}
+
+// downloadFilename prefers the name the file was uploaded with and falls
+// back to the title for videos uploaded before that was recorded.
+func downloadFilename(video database.Video, ext string) string {
+	if video.OriginalFilename != nil && *video.OriginalFilename != "" {
+		// The stored file may be in a different container than the upload.
+		name := *video.OriginalFilename
+		return strings.TrimSuffix(name, path.Ext(name)) + ext
+	}
+	name := strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`/\"`, r) {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(video.Title))
+	if name == "" {
+		name = video.ID.String()
+	}
+	return name + ext
+}
+
+// viewSession identifies the viewer for debouncing: the user ID when the
+// request carries a valid JWT, otherwise the client's address.
+func viewSession(r *http.Request, keys auth.Keys) string {
+	if token, err := auth.GetBearerToken(r.Header); err == nil {
+		if userID, err := keys.Validate(token); err == nil {
+			return userID.String()
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// checkVideoObject is HEAD's check that the video's object is still in
+// S3, so a client can find a missing file without following the redirect.
+// Only a missing object is a 404; any other failure, such as throttling or
+// bad credentials, is the storage's fault and a 502. A video stored
+// outside our bucket can't be checked and is passed.
+func (cfg *apiConfig) checkVideoObject(w http.ResponseWriter, r *http.Request, video database.Video) bool {
+	key, err := cfg.s3KeyFromURL(*video.VideoURL)
+	if err != nil {
+		return true
+	}
+	store := cfg.forVideo(video)
+	_, err = store.s3Client.HeadObject(r.Context(), &s3.HeadObjectInput{
+		Bucket: &store.s3Bucket,
+		Key:    &key,
+	})
+	var notFound *types.NotFound
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &notFound) || errors.As(err, &noSuchKey) {
+		respondWithError(w, http.StatusNotFound, "Video file not found", err)
+		return false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't check video file", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// newStreamTestVideo stores a public video with downloads allowed or not,
+// returning the owner's token too.
+func newStreamTestVideo(t *testing.T, downloadAllowed bool) (*apiConfig, database.Video, string) {
+	t.Helper()
+	cfg, store, _ := newTestConfig(t)
+	user, token := createTestUser(t, cfg)
+	video := createTestVideo(t, cfg, user.ID)
+	video.IsPublic = true
+	video.DownloadAllowed = downloadAllowed
+	original := "https://" + cfg.s3CfDistribution + "/originals/" + video.ID.String() + ".mov"
+	video.OriginalURL = &original
+	storeTestVideo(t, cfg, store, &video)
+	return cfg, video, token
+}
+
+func TestHandlerVideoDownloadRestrictions(t *testing.T) {
+	tests := []struct {
+		name            string
+		downloadAllowed bool
+		owner           bool
+		mode            string
+		want            int
+		// wantDisposition is the disposition type the signed URL sets, ""
+		// for none.
+		wantDisposition string
+		wantExpires     string
+	}{
+		{"allowed attachment", true, false, "attachment", http.StatusFound, "attachment", "3600"},
+		{"allowed inline", true, false, "inline", http.StatusFound, "inline", "3600"},
+		{"disallowed attachment", false, false, "attachment", http.StatusForbidden, "", ""},
+		{"disallowed inline", false, false, "inline", http.StatusForbidden, "", ""},
+		{"disallowed default", false, false, "", http.StatusForbidden, "", ""},
+		{"disallowed owner attachment", false, true, "attachment", http.StatusFound, "attachment", "3600"},
+		{"disallowed owner default", false, true, "", http.StatusFound, "inline", "3600"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, video, token := newStreamTestVideo(t, tt.downloadAllowed)
+
+			target := "/api/videos/" + video.ID.String() + "/download"
+			if tt.mode != "" {
+				target += "?mode=" + tt.mode
+			}
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			req.SetPathValue("videoID", video.ID.String())
+			if tt.owner {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			rec := httptest.NewRecorder()
+			cfg.handlerVideoDownload(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+
+			// HEAD makes the same decision.
+			head := req.Clone(req.Context())
+			head.Method = http.MethodHead
+			headRec := httptest.NewRecorder()
+			cfg.handlerVideoDownload(headRec, head)
+			if headRec.Code != rec.Code {
+				t.Errorf("HEAD status = %d, want %d like GET", headRec.Code, rec.Code)
+			}
+			if tt.want != http.StatusFound {
+				return
+			}
+			location, err := url.Parse(rec.Header().Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if location.Host != "presigned.example" {
+				t.Fatalf("redirected to %s, want a presigned URL", location)
+			}
+			disposition, _, _ := strings.Cut(location.Query().Get("response-content-disposition"), ";")
+			if disposition != tt.wantDisposition {
+				t.Errorf("disposition = %q, want %q", disposition, tt.wantDisposition)
+			}
+			if got := location.Query().Get("X-Amz-Expires"); got != tt.wantExpires {
+				t.Errorf("expires = %s, want %s", got, tt.wantExpires)
+			}
+		})
+	}
+}
+
+func TestHandlerVideoGetFileURLs(t *testing.T) {
+	tests := []struct {
+		name            string
+		downloadAllowed bool
+		owner           bool
+		wantVideoURL    bool
+		wantOriginalURL bool
+	}{
+		{"allowed", true, false, true, false},
+		{"allowed owner", true, true, true, true},
+		{"disallowed", false, false, false, false},
+		{"disallowed owner", false, true, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, video, token := newStreamTestVideo(t, tt.downloadAllowed)
+
+			req := httptest.NewRequest(http.MethodGet, "/api/videos/"+video.ID.String(), nil)
+			req.SetPathValue("videoID", video.ID.String())
+			if tt.owner {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			rec := httptest.NewRecorder()
+			cfg.handlerVideoGet(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+			}
+
+			var got database.Video
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if (got.VideoURL != nil) != tt.wantVideoURL {
+				t.Errorf("video_url = %v, want present: %t", got.VideoURL, tt.wantVideoURL)
+			}
+			if (got.OriginalURL != nil) != tt.wantOriginalURL {
+				t.Errorf("original_url = %v, want present: %t", got.OriginalURL, tt.wantOriginalURL)
+			}
+		})
+	}
+}
+
+func TestHandlerEmbedStreamOnly(t *testing.T) {
+	tests := []struct {
+		name            string
+		downloadAllowed bool
+		wantPresigned   bool
+	}{
+		{"allowed", true, false},
+		{"disallowed", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, video, _ := newStreamTestVideo(t, tt.downloadAllowed)
+
+			req := httptest.NewRequest(http.MethodGet, "/embed/"+video.ID.String(), nil)
+			req.SetPathValue("videoID", video.ID.String())
+			rec := httptest.NewRecorder()
+			cfg.handlerEmbed(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+			}
+
+			page := rec.Body.String()
+			if got := strings.Contains(page, "https://presigned.example/"); got != tt.wantPresigned {
+				t.Errorf("page uses a presigned URL: %t, want %t", got, tt.wantPresigned)
+			}
+			if got := strings.Contains(page, *video.VideoURL); got == tt.wantPresigned {
+				t.Errorf("page uses the CDN URL: %t, want %t", got, !tt.wantPresigned)
+			}
+			if got := strings.Contains(page, `controlslist="nodownload"`); got != tt.wantPresigned {
+				t.Errorf("page disables the download control: %t, want %t", got, tt.wantPresigned)
+			}
+		})
+	}
+}
+
+func TestHandlerVideoDownloadRepairsContentType(t *testing.T) {
+	cfg, store, _ := newTestConfig(t)
+	cfg.contentTypeRepair = newContentTypeRepairer()
+	user, _ := createTestUser(t, cfg)
+	video := createTestVideo(t, cfg, user.ID)
+	video.IsPublic = true
+	video.DownloadAllowed = true
+	storeTestVideo(t, cfg, store, &video)
+	key := "landscape/" + video.ID.String() + ".mp4"
+	store.put(cfg.s3Bucket, key, testMP4, "application/octet-stream")
+
+	download := func() *url.URL {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/videos/"+video.ID.String()+"/download", nil)
+		req.SetPathValue("videoID", video.ID.String())
+		rec := httptest.NewRecorder()
+		cfg.handlerVideoDownload(rec, req)
+		if rec.Code != http.StatusFound {
+			t.Fatalf("status = %d, want 302: %s", rec.Code, rec.Body)
+		}
+		location, err := url.Parse(rec.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return location
+	}
+
+	location := download()
+	if location.Host != "presigned.example" || location.Query().Get("response-content-type") != "video/mp4" {
+		t.Errorf("wrongly typed object redirected to %s, want a presigned URL setting video/mp4", location)
+	}
+	if len(cfg.contentTypeRepair.jobs) != 1 {
+		t.Fatalf("%d repairs queued, want 1", len(cfg.contentTypeRepair.jobs))
+	}
+	// Later reads don't check or queue it again.
+	download()
+	if len(cfg.contentTypeRepair.jobs) != 1 {
+		t.Fatalf("%d repairs queued after a second read, want 1", len(cfg.contentTypeRepair.jobs))
+	}
+
+	cfg.repairContentType(<-cfg.contentTypeRepair.jobs)
+	if got := store.objects[cfg.s3Bucket+"/"+key].contentType; got != "video/mp4" {
+		t.Errorf("content type after repair = %q, want video/mp4", got)
+	}
+	if location := download(); location.Host != cfg.s3CfDistribution {
+		t.Errorf("repaired object redirected to %s, want the plain URL", location)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerDuplicateVideo creates a new video with the same metadata and
+// files as an existing one. Objects are copied server-side by S3.
+func (cfg *apiConfig) handlerDuplicateVideo(w http.ResponseWriter, r *http.Request) {
+	videoID, err := cfg.parseVideoID(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.validateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	original, err := cfg.getVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithDBError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if original.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if original.UserID != userID {
+		cfg.audit(r, userID, original.ID, auditDuplicate, auditDenied, "not owner")
+		respondWithError(w, http.StatusForbidden, "You can't duplicate this video", nil)
+		return
+	}
+
+	// Copy the files first so a failure doesn't leave a half-populated record.
+	copied := original
+	if original.VideoURL != nil {
+		url, err := cfg.forVideo(original).duplicateObject(r.Context(), *original.VideoURL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't copy video file", err)
+			return
+		}
+		copied.VideoURL = &url
+	}
+	copied.Variants = database.VariantList{}
+	for _, variant := range original.Variants {
+		url, err := cfg.forVideo(original).duplicateObject(r.Context(), variant.URL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't copy video variant", err)
+			return
+		}
+		copied.Variants = append(copied.Variants, database.VideoVariant{Height: variant.Height, URL: url})
+	}
+	if original.HLSURL != nil {
+		url, err := cfg.forVideo(original).duplicateObject(r.Context(), *original.HLSURL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't copy HLS file", err)
+			return
+		}
+		copied.HLSURL = &url
+	}
+	if original.PreviewURL != nil {
+		url, err := cfg.duplicateObject(r.Context(), *original.PreviewURL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't copy preview", err)
+			return
+		}
+		copied.PreviewURL = &url
+	}
+	if original.ContactSheetURL != nil {
+		url, err := cfg.duplicateObject(r.Context(), *original.ContactSheetURL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't copy contact sheet", err)
+			return
+		}
+		copied.ContactSheetURL = &url
+
+		if original.ThumbnailsVTTURL != nil {
+			vttURL, err := cfg.duplicateThumbnailsVTT(r.Context(), *original.ThumbnailsVTTURL, *original.ContactSheetURL, url)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't copy thumbnails track", err)
+				return
+			}
+			copied.ThumbnailsVTTURL = &vttURL
+		}
+	}
+	if original.ThumbnailURL != nil {
+		url, err := cfg.duplicateThumbnail(*original.ThumbnailURL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't copy thumbnail", err)
+			return
+		}
+		copied.ThumbnailURL = &url
+	}
+
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{
+		Title:       original.Title,
+		Description: original.Description,
+		UserID:      userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
+		return
+	}
+	video.ThumbnailURL = copied.ThumbnailURL
+	video.DominantColor = original.DominantColor
+	video.PerceptualHash = original.PerceptualHash
+	video.VideoURL = copied.VideoURL
+	video.Variants = copied.Variants
+	video.HLSURL = copied.HLSURL
+	video.HLSIndex = original.HLSIndex
+	video.PreviewURL = copied.PreviewURL
+	video.ContactSheetURL = copied.ContactSheetURL
+	video.ThumbnailsVTTURL = copied.ThumbnailsVTTURL
+	video.AspectRatio = copied.AspectRatio
+	video.S3Bucket = copied.S3Bucket
+	video.EmbedOrigins = copied.EmbedOrigins
+	video.DownloadAllowed = original.DownloadAllowed
+	video.Faststart = original.Faststart
+	cfg.loadVideoObjectInfo(r.Context(), &video)
+	if err := cfg.updateVideo(r.Context(), video); err != nil {
+		respondWithDBError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, cfg.presentVideo(video))
+}
+
+// duplicateObject copies the object behind url to a fresh key in the same
+// folder and returns the new URL.
+func (cfg *apiConfig) duplicateObject(ctx context.Context, url string) (string, error) {
+	srcKey, err := cfg.s3KeyFromURL(url)
+	if err != nil {
+		return "", err
+	}
+	fileName, err := randomFileName(path.Ext(srcKey))
+	if err != nil {
+		return "", err
+	}
+	dstKey := path.Join(path.Dir(srcKey), fileName)
+	if err := cfg.copyObject(ctx, srcKey, dstKey); err != nil {
+		return "", err
+	}
+	return cfg.objectURL(dstKey), nil
+}
+
+// duplicateThumbnail copies a thumbnail stored in the assets directory.
+// Thumbnails hosted elsewhere are shared rather than copied.
+func (cfg *apiConfig) duplicateThumbnail(url string) (string, error) {
+	srcPath, ok := cfg.assetPathFromURL(url)
+	if !ok {
+		return url, nil
+	}
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	fileName, err := randomFileName(filepath.Ext(srcPath))
+	if err != nil {
+		return "", err
+	}
+	dst, err := os.Create(filepath.Join(cfg.assetsRoot, fileName))
+	if err != nil {
+		return "", err
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+	return fmt.Sprintf("http://localhost:%s/assets/%s", cfg.port, fileName), nil
+}
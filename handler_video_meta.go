@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"net/http"
+	"strings"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
@@ -19,7 +20,7 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := cfg.validateJWT(token)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
@@ -40,14 +41,13 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, video)
+	respondWithJSON(w, http.StatusCreated, cfg.presentVideo(video))
 }
 
 func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
+	videoID, err := cfg.parseVideoID(r.PathValue("videoID"))
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
 		return
 	}
 
@@ -56,18 +56,19 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := cfg.validateJWT(token)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
 	}
 
-	video, err := cfg.db.GetVideo(videoID)
+	video, err := cfg.getVideo(r.Context(), videoID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		respondWithDBError(w, http.StatusNotFound, "Couldn't get video", err)
 		return
 	}
 	if video.UserID != userID {
+		cfg.audit(r, userID, video.ID, auditDelete, auditDenied, "not owner")
 		respondWithError(w, http.StatusForbidden, "You can't delete this video", err)
 		return
 	}
@@ -77,25 +78,52 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
 		return
 	}
+	if cfg.deleteObjects {
+		cfg.deleteVideoObjects(r.Context(), video)
+	}
 
+	cfg.audit(r, userID, videoID, auditDelete, auditAllowed, "")
 	w.WriteHeader(http.StatusNoContent)
 }
 
 func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
+	videoID, err := cfg.parseVideoID(r.PathValue("videoID"))
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
 		return
 	}
 
-	video, err := cfg.db.GetVideo(videoID)
+	video, err := cfg.getVideo(r.Context(), videoID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		respondWithDBError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !cfg.canViewVideo(r, video) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
 		return
 	}
+	cfg.ensureVideoObjectInfo(r.Context(), &video)
+	cfg.servedContentType(r.Context(), video)
+	cfg.loadTranscript(r.Context(), &video)
 
-	respondWithJSON(w, http.StatusOK, video)
+	type response struct {
+		database.Video
+		SignedThumbnailURL *string `json:"signed_thumbnail_url,omitempty"`
+		// HLSURL is the master playlist, signed for private videos.
+		HLSURL *string `json:"hls_url,omitempty"`
+	}
+	resp := response{Video: cfg.presentVideoTo(video, cfg.viewerID(r))}
+	if video.VideoURL != nil && video.HLSURL != nil && video.HLSIndex != nil {
+		playlist := cfg.hlsPlaylistPath(video, "master.m3u8")
+		resp.HLSURL = &playlist
+	}
+	// A private thumbnail can't be loaded by an <img> tag without this.
+	if !video.IsPublic && video.ThumbnailURL != nil {
+		signed := cfg.signMediaURL("/api/thumbnails/" + video.ID.String())
+		resp.SignedThumbnailURL = &signed
+	}
+
+	respondWithVideoFields(w, r, resp)
 }
 
 func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
@@ -104,17 +132,42 @@ func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Reque
 		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := cfg.validateJWT(token)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
 	}
 
-	videos, err := cfg.db.GetVideos(userID)
+	params := database.ListVideosParams{UserID: userID}
+	query := r.URL.Query()
+	if ratio := query.Get("aspectRatio"); ratio != "" {
+		if !isKnownAspectRatio(ratio) {
+			respondWithError(w, http.StatusBadRequest, "aspectRatio must be one of "+strings.Join(knownAspectRatios, ", "), nil)
+			return
+		}
+		params.AspectRatio = ratio
+	}
+	params.FolderID, err = cfg.userFolder(r.Context(), query.Get("folderID"), userID)
+	if err != nil {
+		respondWithFolderError(w, err)
+		return
+	}
+	params.Tags, err = parseTagFilters(query)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	params.Limit, params.Offset, err = parsePagination(query)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	videos, err := cfg.db.ListVideos(params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, videos)
+	respondWithVideoFields(w, r, cfg.presentVideos(videos))
 }
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func deleteVideo(cfg *apiConfig, videoID, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodDelete, "/api/videos/"+videoID, nil)
+	req.SetPathValue("videoID", videoID)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	cfg.handlerVideoMetaDelete(rec, req)
+	return rec
+}
+
+func TestHandlerVideoMetaDeleteRemovesObjects(t *testing.T) {
+	cfg, store, _ := newTestConfig(t)
+	cfg.deleteObjects = true
+	user, token := createTestUser(t, cfg)
+
+	video := createTestVideo(t, cfg, user.ID)
+	storeTestVideo(t, cfg, store, &video)
+	thumbnailURL := "https://" + cfg.s3CfDistribution + "/thumbnails/" + video.ID.String() + ".png"
+	video.ThumbnailURL = &thumbnailURL
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+	store.put(cfg.s3Bucket, "thumbnails/"+video.ID.String()+".png", testPNG, "image/png")
+	// Another video's objects must survive.
+	other := createTestVideo(t, cfg, user.ID)
+	storeTestVideo(t, cfg, store, &other)
+
+	if rec := deleteVideo(cfg, video.ID.String(), token); rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want 204: %s", rec.Code, rec.Body)
+	}
+
+	want := cfg.s3Bucket + "/landscape/" + other.ID.String() + ".mp4"
+	if keys := store.keys(); len(keys) != 1 || keys[0] != want {
+		t.Errorf("bucket has %v, want only %s", keys, want)
+	}
+}
+
+func TestHandlerVideoMetaDeleteRemovesLocalThumbnail(t *testing.T) {
+	cfg, store, _ := newTestConfig(t)
+	cfg.deleteObjects = true
+	cfg.assetsRoot = t.TempDir()
+	cfg.port = "8091"
+	user, token := createTestUser(t, cfg)
+
+	video := createTestVideo(t, cfg, user.ID)
+	storeTestVideo(t, cfg, store, &video)
+	thumbnailURL := "http://localhost:8091/assets/thumb.png"
+	video.ThumbnailURL = &thumbnailURL
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+	thumbnail := filepath.Join(cfg.assetsRoot, "thumb.png")
+	paths := []string{thumbnail}
+	for _, variant := range thumbnailVariants {
+		paths = append(paths, variantFilePath(thumbnail, variant))
+	}
+	for _, p := range paths {
+		if err := os.WriteFile(p, testPNG, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if rec := deleteVideo(cfg, video.ID.String(), token); rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want 204: %s", rec.Code, rec.Body)
+	}
+
+	if keys := store.keys(); len(keys) != 0 {
+		t.Errorf("bucket has %v, want nothing", keys)
+	}
+	for _, p := range paths {
+		if _, err := os.Stat(p); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s still exists", filepath.Base(p))
+		}
+	}
+}
+
+func TestHandlerVideoMetaDeleteMissingThumbnail(t *testing.T) {
+	cfg, store, _ := newTestConfig(t)
+	cfg.deleteObjects = true
+	user, token := createTestUser(t, cfg)
+
+	video := createTestVideo(t, cfg, user.ID)
+	storeTestVideo(t, cfg, store, &video)
+	// The thumbnail was never stored, or is already gone.
+	thumbnailURL := "https://" + cfg.s3CfDistribution + "/thumbnails/missing.png"
+	video.ThumbnailURL = &thumbnailURL
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := deleteVideo(cfg, video.ID.String(), token); rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want 204: %s", rec.Code, rec.Body)
+	}
+	if keys := store.keys(); len(keys) != 0 {
+		t.Errorf("bucket has %v, want nothing", keys)
+	}
+}
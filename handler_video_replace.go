@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+// handlerReplaceVideoFile swaps in a new file for an existing video, keeping
+// its ID, metadata and view count. The new file goes to a new key and the
+// old objects are only deleted once the record points at it, so a failed
+// replacement leaves the video playing as before.
+func (cfg *apiConfig) handlerReplaceVideoFile(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireFreeDisk(w, cfg.uploadLimits.videoSize) {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.uploadLimits.videoSize)
+
+	videoID, err := cfg.parseVideoID(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.validateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.getVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithDBError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if video.UserID != userID {
+		cfg.audit(r, userID, video.ID, auditUpload, auditDenied, "not owner")
+		respondWithError(w, http.StatusForbidden, "You can't update this video", nil)
+		return
+	}
+
+	upload, ok := cfg.receiveVideoFile(w, r)
+	if !ok {
+		return
+	}
+	defer cfg.tempFiles.remove(upload.path)
+
+	replaced := append([]*string{video.VideoURL, video.OriginalURL, video.HLSURL}, variantURLs(video)...)
+	replacedStore := cfg.forVideo(video)
+	video.OriginalURL = nil
+	video.OriginalStorageClass = nil
+	if err := cfg.publishVideo(r.Context(), &video, upload.path, upload.mediaType, upload.filename, upload.checksum); err != nil {
+		respondWithPublishError(w, err)
+		return
+	}
+	// The upload can take minutes, so only save it if nothing else
+	// changed the video meanwhile; otherwise the older request would win.
+	if err := cfg.updateVideoIfUnchanged(r.Context(), video); err != nil {
+		for _, url := range append([]*string{video.VideoURL, video.OriginalURL, video.HLSURL}, variantURLs(video)...) {
+			if url != nil {
+				cfg.forVideo(video).deleteObjectURL(r.Context(), *url)
+			}
+		}
+		respondWithDBError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	for _, url := range replaced {
+		if url == nil {
+			continue
+		}
+		if err := replacedStore.deleteObjectURL(r.Context(), *url); err != nil {
+			// The orphan cleanup will get it eventually.
+			log.Printf("warning: replaced video %s: %v", video.ID, err)
+		}
+	}
+
+	cfg.audit(r, userID, video.ID, auditUpload, auditAllowed, "replace")
+	respondWithJSON(w, http.StatusOK, cfg.presentVideo(video))
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	searchBackendLike     = "like"
+	searchBackendFullText = "fulltext"
+
+	searchScopeOwner  = "owner"
+	searchScopePublic = "public"
+
+	maxSearchQueryLen = 200
+	// maxSearchTerms bounds the words of a search query we look up.
+	maxSearchTerms = 10
+)
+
+// handlerSearchVideos finds videos by ?q= in their title, description, tags
+// or transcript, best match first and paginated like the video list. It
+// searches the user's own videos, or every public one with
+// ?scope=public. SEARCH_BACKEND picks between scanning with LIKE and a
+// full-text index.
+func (cfg *apiConfig) handlerSearchVideos(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.validateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	query := r.URL.Query()
+	params := database.SearchVideosParams{
+		UserID:   userID,
+		Query:    strings.TrimSpace(query.Get("q")),
+		FullText: cfg.searchBackend == searchBackendFullText,
+	}
+	if params.Query == "" {
+		respondWithError(w, http.StatusBadRequest, "q is required", nil)
+		return
+	}
+	if utf8.RuneCountInString(params.Query) > maxSearchQueryLen {
+		respondWithError(w, http.StatusBadRequest, "q may be at most 200 characters", nil)
+		return
+	}
+	params.Terms = transcriptTerms(params.Query)
+	if len(params.Terms) > maxSearchTerms {
+		respondWithError(w, http.StatusBadRequest, "q may contain at most 10 words", nil)
+		return
+	}
+	if params.FullText && len(params.Terms) == 0 {
+		respondWithError(w, http.StatusBadRequest, "q must contain a word of at least two characters", nil)
+		return
+	}
+	switch query.Get("scope") {
+	case "", searchScopeOwner:
+	case searchScopePublic:
+		params.PublicOnly = true
+	default:
+		respondWithError(w, http.StatusBadRequest, "scope must be owner or public", nil)
+		return
+	}
+	params.Limit, params.Offset, err = parsePagination(query)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	var videos []database.Video
+	err = cfg.withDBRetry(r.Context(), func(ctx context.Context) error {
+		var err error
+		videos, err = cfg.db.SearchVideos(ctx, params)
+		return err
+	})
+	if err != nil {
+		respondWithDBError(w, http.StatusInternalServerError, "Couldn't search videos", err)
+		return
+	}
+
+	respondWithVideoFields(w, r, cfg.presentVideosTo(videos, userID))
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestHandlerSearchVideos(t *testing.T) {
+	for _, backend := range []string{searchBackendLike, searchBackendFullText} {
+		t.Run(backend, func(t *testing.T) {
+			cfg, _, _ := newTestConfig(t)
+			cfg.searchBackend = backend
+			if backend == searchBackendFullText {
+				if err := cfg.db.EnableFullTextSearch(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			user, token := createTestUser(t, cfg)
+			other, _ := createTestUser(t, cfg)
+
+			create := func(userID uuid.UUID, title, description string) database.Video {
+				t.Helper()
+				video, err := cfg.db.CreateVideo(database.CreateVideoParams{
+					Title:       title,
+					Description: description,
+					UserID:      userID,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return video
+			}
+			inTitle := create(user.ID, "Gopher tricks", "")
+			inDescription := create(user.ID, "Weekend vlog", "Feeding the gopher")
+			create(user.ID, "Cooking", "Pasta")
+			theirs := create(other.ID, "Gopher gossip", "")
+			theirs.IsPublic = false
+			if err := cfg.db.UpdateVideo(theirs); err != nil {
+				t.Fatal(err)
+			}
+
+			search := func(query string) (int, []uuid.UUID) {
+				t.Helper()
+				req := httptest.NewRequest(http.MethodGet, "/api/videos/search?"+query, nil)
+				req.Header.Set("Authorization", "Bearer "+token)
+				rec := httptest.NewRecorder()
+				cfg.handlerSearchVideos(rec, req)
+				if rec.Code != http.StatusOK {
+					return rec.Code, nil
+				}
+				var videos []database.Video
+				if err := json.Unmarshal(rec.Body.Bytes(), &videos); err != nil {
+					t.Fatal(err)
+				}
+				ids := []uuid.UUID{}
+				for _, v := range videos {
+					ids = append(ids, v.ID)
+				}
+				return rec.Code, ids
+			}
+
+			// The title match ranks first even though it's the older video.
+			want := []uuid.UUID{inTitle.ID, inDescription.ID}
+			if _, got := search("q=GOPHER"); !slices.Equal(got, want) {
+				t.Errorf("search = %v, want %v", got, want)
+			}
+			if _, got := search("q=gopher&limit=1&offset=1"); !slices.Equal(got, want[1:]) {
+				t.Errorf("second page = %v, want %v", got, want[1:])
+			}
+			if _, got := search("q=gopher&scope=public"); slices.Contains(got, theirs.ID) {
+				t.Errorf("public search = %v, includes a private video", got)
+			}
+
+			for _, query := range []string{
+				"",
+				"q=+",
+				"q=" + url.QueryEscape(strings.Repeat("a", maxSearchQueryLen+1)),
+				"q=gopher&scope=everyone",
+			} {
+				if code, _ := search(query); code != http.StatusBadRequest {
+					t.Errorf("search %q: status = %d, want 400", query, code)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// defaultSimilarDistance is how many bits two thumbnails' perceptual
+// hashes may differ by and still count as the same picture.
+const defaultSimilarDistance = 5
+
+// handlerVideoSimilar lists the user's other videos whose thumbnails look
+// like this one's, closest first, to catch re-uploads that only differ in
+// encoding. ?max_distance= (0-7) widens or narrows the match. It needs
+// THUMBNAIL_PERCEPTUAL_HASH to have been on when the thumbnails were
+// stored.
+func (cfg *apiConfig) handlerVideoSimilar(w http.ResponseWriter, r *http.Request) {
+	videoID, err := cfg.parseVideoID(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.validateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	maxDistance := defaultSimilarDistance
+	if v := r.URL.Query().Get("max_distance"); v != "" {
+		maxDistance, err = strconv.Atoi(v)
+		if err != nil || maxDistance < 0 || maxDistance > database.MaxSimilarDistance {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("max_distance must be between 0 and %d", database.MaxSimilarDistance), err)
+			return
+		}
+	}
+
+	video, err := cfg.getVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithDBError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't view this video's matches", nil)
+		return
+	}
+	if video.PerceptualHash == nil {
+		respondWithError(w, http.StatusConflict, "Video has no perceptual hash; store its thumbnail with THUMBNAIL_PERCEPTUAL_HASH on", nil)
+		return
+	}
+
+	var similar []database.SimilarVideo
+	err = cfg.withDBRetry(r.Context(), func(ctx context.Context) error {
+		var err error
+		similar, err = cfg.db.SimilarVideos(ctx, database.SimilarVideosParams{
+			UserID:      userID,
+			VideoID:     video.ID,
+			Hash:        *video.PerceptualHash,
+			MaxDistance: maxDistance,
+		})
+		return err
+	})
+	if err != nil {
+		respondWithDBError(w, http.StatusInternalServerError, "Couldn't find similar videos", err)
+		return
+	}
+
+	for i := range similar {
+		similar[i].Video = cfg.presentVideo(similar[i].Video)
+	}
+	respondWithJSON(w, http.StatusOK, similar)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	maxTagsPerVideo = 20
+	maxTagValueLen  = 256
+)
+
+// handlerVideoTagsUpdate replaces a video's tags with the given map.
+func (cfg *apiConfig) handlerVideoTagsUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Tags map[string]string `json:"tags"`
+	}
+
+	videoID, err := cfg.parseVideoID(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.validateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if err := validateTags(params.Tags); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	video, err := cfg.getVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithDBError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		cfg.audit(r, userID, video.ID, auditUpdate, auditDenied, "not owner")
+		respondWithError(w, http.StatusForbidden, "You can't update this video", nil)
+		return
+	}
+
+	video.Tags = database.StringMap(params.Tags)
+	if err := cfg.updateVideo(r.Context(), video); err != nil {
+		respondWithDBError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, cfg.presentVideo(video))
+}
+
+func validateTags(tags map[string]string) error {
+	if len(tags) > maxTagsPerVideo {
+		return fmt.Errorf("at most %d tags are allowed", maxTagsPerVideo)
+	}
+	for key, value := range tags {
+		if !database.ValidTagKey(key) {
+			return fmt.Errorf("tag key %q must be 1-64 letters, digits, '_' or '-'", key)
+		}
+		if len(value) > maxTagValueLen {
+			return fmt.Errorf("tag %q is longer than %d bytes", key, maxTagValueLen)
+		}
+	}
+	return nil
+}
+
+// parseTagFilters reads repeated ?tag=key:value query parameters.
+func parseTagFilters(query url.Values) (map[string]string, error) {
+	filters := map[string]string{}
+	for _, tag := range query["tag"] {
+		key, value, ok := strings.Cut(tag, ":")
+		if !ok || !database.ValidTagKey(key) {
+			return nil, errors.New("tag filters must look like key:value")
+		}
+		filters[key] = value
+	}
+	return filters, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerVideoTranscriptUpload sets a video's transcript, making it
+// searchable by what's said in it. A multipart form uploads one, as
+// SubRip, WebVTT or plain text in the "transcript" field; any other
+// request has the configured Transcriber generate it.
+func (cfg *apiConfig) handlerVideoTranscriptUpload(w http.ResponseWriter, r *http.Request) {
+	videoID, err := cfg.parseVideoID(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.validateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.getVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithDBError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if video.UserID != userID {
+		cfg.audit(r, userID, video.ID, auditUpdate, auditDenied, "not owner")
+		respondWithError(w, http.StatusForbidden, "You can't update this video", nil)
+		return
+	}
+
+	transcript := database.Transcript{VideoID: video.ID}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		r.Body = http.MaxBytesReader(w, r.Body, maxTranscriptSize+1<<20)
+		if err := r.ParseMultipartForm(maxTranscriptSize); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Could not parse multipart form", err)
+			return
+		}
+		file, fileHeader, err := formFile(r, "transcript")
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Could not get transcript from form", err)
+			return
+		}
+		defer file.Close()
+
+		data, err := io.ReadAll(io.LimitReader(file, maxTranscriptSize+1))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Could not read transcript", err)
+			return
+		}
+		if len(data) > maxTranscriptSize {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "Transcript is larger than 5 MiB", nil)
+			return
+		}
+		transcript.Source = transcriptSourceUpload
+		transcript.Content = string(data)
+		transcript.Format = detectTranscriptFormat(fileHeader.Filename, transcript.Content)
+	} else {
+		if video.VideoURL == nil {
+			respondWithError(w, http.StatusBadRequest, "Video has no file to transcribe yet", nil)
+			return
+		}
+		mediaURL, _, err := cfg.signedVideoURL(r.Context(), video, downloadModeInline, false)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
+			return
+		}
+		transcript.Source = transcriptSourceTranscriber
+		transcript.Content, transcript.Format, err = cfg.transcriber.Transcribe(r.Context(), video, mediaURL)
+		if errors.Is(err, errNoTranscriber) {
+			respondWithError(w, http.StatusNotImplemented, "Transcripts can't be generated here; upload one as a multipart form instead", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusBadGateway, "Couldn't transcribe video", err)
+			return
+		}
+		switch transcript.Format {
+		case transcriptFormatSRT, transcriptFormatVTT, transcriptFormatText:
+		default:
+			respondWithError(w, http.StatusBadGateway, "Transcriber returned an unknown format: "+transcript.Format, nil)
+			return
+		}
+	}
+	if !utf8.ValidString(transcript.Content) {
+		respondWithError(w, http.StatusBadRequest, "Transcript must be UTF-8 text", nil)
+		return
+	}
+
+	terms := transcriptTerms(transcriptText(transcript.Format, transcript.Content))
+	err = cfg.withDBRetry(r.Context(), func(ctx context.Context) error {
+		return cfg.db.SetTranscript(ctx, transcript, terms)
+	})
+	if err != nil {
+		respondWithDBError(w, http.StatusInternalServerError, "Couldn't save transcript", err)
+		return
+	}
+
+	cfg.audit(r, userID, video.ID, auditUpload, auditAllowed, "transcript")
+	cfg.loadTranscript(r.Context(), &video)
+	respondWithJSON(w, http.StatusOK, cfg.presentVideo(video))
+}
+
+// loadTranscript attaches the video's transcript, if any, for single-video
+// responses. A failure is logged and leaves it off.
+func (cfg *apiConfig) loadTranscript(ctx context.Context, video *database.Video) {
+	var transcript database.Transcript
+	err := cfg.withDBRetry(ctx, func(ctx context.Context) error {
+		var err error
+		transcript, err = cfg.db.GetTranscript(ctx, video.ID)
+		return err
+	})
+	if err != nil {
+		log.Printf("warning: couldn't load transcript of video %s: %v", video.ID, err)
+		return
+	}
+	if transcript.VideoID != uuid.Nil {
+		video.Transcript = &transcript
+	}
+}
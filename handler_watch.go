@@ -0,0 +1,122 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var watchTemplate = template.Must(template.New("watch").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<link rel="canonical" href="{{.PageURL}}">
+<link rel="alternate" type="application/json+oembed" href="{{.OEmbedURL}}" title="{{.Title}}">
+<meta property="og:type" content="video.other">
+<meta property="og:site_name" content="Tubely">
+<meta property="og:url" content="{{.PageURL}}">
+<meta property="og:title" content="{{.Title}}">
+{{if .Description}}<meta property="og:description" content="{{.Description}}">
+{{end}}{{if .ImageURL}}<meta property="og:image" content="{{.ImageURL}}">
+<meta property="og:image:width" content="{{.Width}}">
+<meta property="og:image:height" content="{{.Height}}">
+{{end}}{{if .VideoURL}}<meta property="og:video" content="{{.VideoURL}}">
+{{if .SecureVideo}}<meta property="og:video:secure_url" content="{{.VideoURL}}">
+{{end}}{{if .VideoType}}<meta property="og:video:type" content="{{.VideoType}}">
+{{end}}<meta property="og:video:width" content="{{.Width}}">
+<meta property="og:video:height" content="{{.Height}}">
+{{end}}<meta name="twitter:card" content="player">
+<meta name="twitter:title" content="{{.Title}}">
+{{if .Description}}<meta name="twitter:description" content="{{.Description}}">
+{{end}}{{if .ImageURL}}<meta name="twitter:image" content="{{.ImageURL}}">
+{{end}}<meta name="twitter:player" content="{{.EmbedURL}}">
+<meta name="twitter:player:width" content="{{.Width}}">
+<meta name="twitter:player:height" content="{{.Height}}">
+{{if .VideoURL}}<meta name="twitter:player:stream" content="{{.VideoURL}}">
+{{if .VideoType}}<meta name="twitter:player:stream:content_type" content="{{.VideoType}}">
+{{end}}{{end}}<style>body{margin:0 auto;max-width:{{.Width}}px;font-family:sans-serif}iframe{border:0;width:100%;aspect-ratio:{{.Width}}/{{.Height}}}</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<iframe src="{{.EmbedURL}}" allowfullscreen></iframe>
+{{if .Description}}<p>{{.Description}}</p>
+{{end}}</body>
+</html>
+`))
+
+// handlerWatch serves the page to share a public video by. Its Open Graph
+// and Twitter Card tags give links to it a rich preview with the title,
+// thumbnail and a player sized for the aspect ratio. The player is the
+// embed page, which only plays inline on sites allowed to frame it (see
+// embed_origins); other sites show the thumbnail and link here. Previews
+// are cached long after the page is fetched, so the image and file are
+// linked through this server's own endpoints rather than URLs that may be
+// presigned and expire. The file is only advertised when the owner allows
+// downloads. Private videos get the same 404 as missing ones.
+func (cfg *apiConfig) handlerWatch(w http.ResponseWriter, r *http.Request) {
+	videoID, err := cfg.parseVideoID(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	video, err := cfg.getVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithDBError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || video.VideoURL == nil || !video.IsPublic {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	// Views are counted by the embedded player, not here, so link
+	// previews being fetched don't count.
+	videoType := ""
+	if key, err := cfg.s3KeyFromURL(*video.VideoURL); err == nil {
+		videoType = contentTypeForKey(key)
+	}
+	video = cfg.presentVideo(video)
+	pageURL := cfg.publicBaseURL + "/watch/" + video.ID.String()
+	size := embedSize(video)
+	data := struct {
+		Title       string
+		Description string
+		PageURL     string
+		EmbedURL    string
+		OEmbedURL   string
+		ImageURL    string
+		VideoURL    string
+		VideoType   string
+		SecureVideo bool
+		Width       int
+		Height      int
+	}{
+		Title:       video.Title,
+		Description: video.Description,
+		PageURL:     pageURL,
+		EmbedURL:    cfg.publicBaseURL + "/embed/" + video.ID.String(),
+		OEmbedURL:   cfg.publicBaseURL + "/oembed?format=json&url=" + url.QueryEscape(pageURL),
+		Width:       size[0],
+		Height:      size[1],
+	}
+	if video.ThumbnailURL != nil {
+		// Thumbnails are frames of the video, so they share its shape.
+		data.ImageURL = cfg.publicBaseURL + "/api/thumbnails/" + video.ID.String()
+	}
+	if video.DownloadAllowed {
+		data.VideoURL = cfg.publicBaseURL + "/api/videos/" + video.ID.String() + "/download"
+		data.SecureVideo = strings.HasPrefix(data.VideoURL, "https://")
+		data.VideoType = videoType
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := watchTemplate.Execute(w, data); err != nil {
+		log.Printf("Error rendering watch page for video %s: %v", video.ID, err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandlerWatch(t *testing.T) {
+	cfg, store, _ := newTestConfig(t)
+	user, _ := createTestUser(t, cfg)
+	video := createTestVideo(t, cfg, user.ID)
+	video.Title = `Cats & "dogs"`
+	video.Description = "A portrait clip"
+	portrait := "9:16"
+	video.AspectRatio = &portrait
+	thumbnail := "https://cdn.example/thumbnails/cat.png"
+	video.ThumbnailURL = &thumbnail
+	store.put(cfg.s3Bucket, "thumbnails/cat.png", testPNG, "image/png")
+	storeTestVideo(t, cfg, store, &video)
+
+	watch := func() *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/watch/"+video.ID.String(), nil)
+		req.SetPathValue("videoID", video.ID.String())
+		rec := httptest.NewRecorder()
+		cfg.handlerWatch(rec, req)
+		return rec
+	}
+
+	rec := watch()
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("status = %d, Content-Type %q: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+	}
+	page := rec.Body.String()
+	pageURL := "https://tubely.example/watch/" + video.ID.String()
+	embedURL := "https://tubely.example/embed/" + video.ID.String()
+	imageURL := "https://tubely.example/api/thumbnails/" + video.ID.String()
+	fileURL := "https://tubely.example/api/videos/" + video.ID.String() + "/download"
+	for _, want := range []string{
+		`<meta property="og:title" content="Cats &amp; &#34;dogs&#34;">`,
+		`<meta property="og:description" content="A portrait clip">`,
+		`<meta property="og:url" content="` + pageURL + `">`,
+		`<meta property="og:image" content="` + imageURL + `">`,
+		`<meta property="og:image:width" content="360">`,
+		`<meta property="og:image:height" content="640">`,
+		`<meta property="og:video" content="` + fileURL + `">`,
+		`<meta property="og:video:secure_url" content="` + fileURL + `">`,
+		`<meta property="og:video:type" content="video/mp4">`,
+		`<meta name="twitter:card" content="player">`,
+		`<meta name="twitter:player" content="` + embedURL + `">`,
+		`<meta name="twitter:player:width" content="360">`,
+		`<meta name="twitter:player:height" content="640">`,
+		`<meta name="twitter:player:stream" content="` + fileURL + `">`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("page lacks %s", want)
+		}
+	}
+	if strings.Contains(page, thumbnail) || strings.Contains(page, *video.VideoURL) {
+		t.Errorf("page links to storage URLs, which may expire:\n%s", page)
+	}
+	if n := cfg.views.pending[video.ID]; n != 0 {
+		t.Errorf("fetching the page recorded %d views, want none", n)
+	}
+
+	// The linked endpoints serve the thumbnail and lead to the file.
+	mux := cfg.routes(t.TempDir(), t.TempDir())
+	get := func(target string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, strings.TrimPrefix(target, cfg.publicBaseURL), nil))
+		return rec
+	}
+	if rec := get(imageURL); rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), testPNG) {
+		t.Errorf("GET %s: status %d with %d bytes, want the thumbnail", imageURL, rec.Code, rec.Body.Len())
+	}
+	if rec := get(fileURL); rec.Code != http.StatusFound || rec.Header().Get("Location") != *video.VideoURL {
+		t.Errorf("GET %s: status %d, Location %q; want a redirect to the file", fileURL, rec.Code, rec.Header().Get("Location"))
+	}
+
+	// With downloads off the permanent file URL isn't shared; the player
+	// still is.
+	video.DownloadAllowed = false
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+	page = watch().Body.String()
+	if strings.Contains(page, "og:video") || strings.Contains(page, "twitter:player:stream") || strings.Contains(page, "/download") {
+		t.Errorf("page shares the file of a video without downloads:\n%s", page)
+	}
+	if !strings.Contains(page, `<meta name="twitter:player" content="`+embedURL+`">`) {
+		t.Errorf("page lacks the player:\n%s", page)
+	}
+
+	// Shared links to the page can be looked up over oEmbed.
+	if id, err := cfg.videoIDFromPublicURL(pageURL); err != nil || id != video.ID {
+		t.Errorf("videoIDFromPublicURL(%s) = %s, %v", pageURL, id, err)
+	}
+
+	video.IsPublic = false
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+	if rec := watch(); rec.Code != http.StatusNotFound {
+		t.Errorf("private video: status = %d, want 404", rec.Code)
+	}
+}
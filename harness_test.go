@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const testJWTSecret = "test-secret-that-is-long-enough-for-hs256"
+
+// fakeObject is an object stored in fakeS3.
+type fakeObject struct {
+	body         []byte
+	contentType  string
+	modified     time.Time
+	storageClass types.StorageClass
+	// restore is the x-amz-restore header of an archived object.
+	restore string
+}
+
+// fakeS3 is an in-memory s3API. Setting putErr makes every PutObject fail.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]fakeObject
+	putErr  error
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: map[string]fakeObject{}}
+}
+
+func fakeS3Key(bucket, key *string) string {
+	return aws.ToString(bucket) + "/" + aws.ToString(key)
+}
+
+// keys lists the stored objects as bucket/key, sorted.
+func (f *fakeS3) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]string, 0, len(f.objects))
+	for k := range f.objects {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func (f *fakeS3) put(bucket, key string, body []byte, contentType string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[bucket+"/"+key] = fakeObject{body: body, contentType: contentType, modified: time.Now()}
+}
+
+func (f *fakeS3) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if f.putErr != nil {
+		return nil, f.putErr
+	}
+	body, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	k := fakeS3Key(params.Bucket, params.Key)
+	f.objects[k] = fakeObject{body: body, contentType: aws.ToString(params.ContentType), storageClass: params.StorageClass}
+	return &s3.PutObjectOutput{ETag: aws.String(fmt.Sprintf("%q", k))}, nil
+}
+
+func (f *fakeS3) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	obj, ok := f.objects[fakeS3Key(params.Bucket, params.Key)]
+	if !ok {
+		return nil, &types.NoSuchKey{}
+	}
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewReader(obj.body)),
+		ContentLength: aws.Int64(int64(len(obj.body))),
+		ContentType:   aws.String(obj.contentType),
+	}, nil
+}
+
+func (f *fakeS3) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	k := fakeS3Key(params.Bucket, params.Key)
+	obj, ok := f.objects[k]
+	if !ok {
+		return nil, &types.NotFound{}
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len(obj.body))),
+		ContentType:   aws.String(obj.contentType),
+		ETag:          aws.String(fmt.Sprintf("%q", k)),
+		LastModified:  aws.Time(obj.modified),
+		StorageClass:  obj.storageClass,
+		Restore:       nonEmpty(obj.restore),
+	}, nil
+}
+
+func (f *fakeS3) CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	obj, ok := f.objects[aws.ToString(params.CopySource)]
+	if !ok {
+		return nil, &types.NoSuchKey{}
+	}
+	obj.modified = time.Now()
+	if params.ContentType != nil {
+		obj.contentType = *params.ContentType
+	}
+	f.objects[fakeS3Key(params.Bucket, params.Key)] = obj
+	return &s3.CopyObjectOutput{}, nil
+}
+
+func (f *fakeS3) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.objects, fakeS3Key(params.Bucket, params.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (f *fakeS3) DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := &s3.DeleteObjectsOutput{}
+	for _, obj := range params.Delete.Objects {
+		delete(f.objects, fakeS3Key(params.Bucket, obj.Key))
+		out.Deleted = append(out.Deleted, types.DeletedObject{Key: obj.Key})
+	}
+	return out, nil
+}
+
+func (f *fakeS3) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	prefix := aws.ToString(params.Bucket) + "/" + aws.ToString(params.Prefix)
+	out := &s3.ListObjectsV2Output{}
+	for _, k := range f.keys() {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		f.mu.Lock()
+		obj := f.objects[k]
+		f.mu.Unlock()
+		out.Contents = append(out.Contents, types.Object{
+			Key:          aws.String(strings.TrimPrefix(k, aws.ToString(params.Bucket)+"/")),
+			Size:         aws.Int64(int64(len(obj.body))),
+			LastModified: aws.Time(obj.modified),
+		})
+	}
+	out.KeyCount = aws.Int32(int32(len(out.Contents)))
+	return out, nil
+}
+
+func (f *fakeS3) ListMultipartUploads(ctx context.Context, params *s3.ListMultipartUploadsInput, optFns ...func(*s3.Options)) (*s3.ListMultipartUploadsOutput, error) {
+	return &s3.ListMultipartUploadsOutput{}, nil
+}
+
+func (f *fakeS3) CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	return nil, fmt.Errorf("fakeS3: multipart uploads aren't supported")
+}
+
+func (f *fakeS3) UploadPartCopy(ctx context.Context, params *s3.UploadPartCopyInput, optFns ...func(*s3.Options)) (*s3.UploadPartCopyOutput, error) {
+	return nil, fmt.Errorf("fakeS3: multipart uploads aren't supported")
+}
+
+func (f *fakeS3) CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	return nil, fmt.Errorf("fakeS3: multipart uploads aren't supported")
+}
+
+func (f *fakeS3) AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+// RestoreObject starts a restore that never finishes; tests set restore
+// to finish it.
+func (f *fakeS3) RestoreObject(ctx context.Context, params *s3.RestoreObjectInput, optFns ...func(*s3.Options)) (*s3.RestoreObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	k := fakeS3Key(params.Bucket, params.Key)
+	obj, ok := f.objects[k]
+	if !ok {
+		return nil, &types.NoSuchKey{}
+	}
+	if obj.restore == "" {
+		obj.restore = `ongoing-request="true"`
+		f.objects[k] = obj
+	}
+	return &s3.RestoreObjectOutput{}, nil
+}
+
+// fakePresigner signs nothing; its URLs name the object and carry the
+// expiry and any disposition as query parameters, for tests to check.
+type fakePresigner struct{}
+
+func (fakePresigner) PresignGetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error) {
+	var opts s3.PresignOptions
+	for _, fn := range optFns {
+		fn(&opts)
+	}
+	query := url.Values{}
+	query.Set("X-Amz-Expires", strconv.Itoa(int(opts.Expires.Seconds())))
+	if params.ResponseContentDisposition != nil {
+		query.Set("response-content-disposition", *params.ResponseContentDisposition)
+	}
+	if params.ResponseContentType != nil {
+		query.Set("response-content-type", *params.ResponseContentType)
+	}
+	return &v4.PresignedHTTPRequest{
+		URL:    "https://presigned.example/" + fakeS3Key(params.Bucket, params.Key) + "?" + query.Encode(),
+		Method: http.MethodGet,
+	}, nil
+}
+
+// fakeFFmpeg is a commandRunner answering ffprobe with canned output and
+// standing in for ffmpeg by copying its input to its output. streamsJSON,
+// when set, is the -show_streams output verbatim instead of streams. fail
+// makes the commands of a kind (see commandKind) fail.
+type fakeFFmpeg struct {
+	mu          sync.Mutex
+	streams     []ffprobeStream
+	streamsJSON string
+	duration    string
+	bitrate     string
+	fail        map[string]error
+	calls       [][]string
+}
+
+func newFakeFFmpeg() *fakeFFmpeg {
+	return &fakeFFmpeg{
+		streams:  []ffprobeStream{{CodecType: "video", Width: 1920, Height: 1080, PixFmt: "yuv420p"}},
+		duration: "12.5",
+		bitrate:  "1000000",
+		fail:     map[string]error{},
+	}
+}
+
+// commandKind names what a command does: "streams", "format" and
+// "chapters" for the probes, "ffmpeg" for anything ffmpeg runs.
+func commandKind(args []string) string {
+	if filepath.Base(args[0]) == "ffmpeg" {
+		return "ffmpeg"
+	}
+	switch {
+	case slices.Contains(args, "-show_streams"):
+		return "streams"
+	case slices.Contains(args, "-show_format"):
+		return "format"
+	case slices.Contains(args, "-show_chapters"):
+		return "chapters"
+	}
+	return "ffprobe"
+}
+
+// ran counts the commands of a kind run so far.
+func (f *fakeFFmpeg) ran(kind string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, args := range f.calls {
+		if commandKind(args) == kind {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeFFmpeg) run(cmd *exec.Cmd) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, cmd.Args)
+	kind := commandKind(cmd.Args)
+	if err := f.fail[kind]; err != nil {
+		return err
+	}
+
+	var out any
+	switch kind {
+	case "streams":
+		if f.streamsJSON != "" {
+			_, err := io.WriteString(cmd.Stdout, f.streamsJSON)
+			return err
+		}
+		out = ffprobeOutput{Streams: f.streams}
+	case "format":
+		out = map[string]any{"format": map[string]string{"duration": f.duration, "bit_rate": f.bitrate}}
+	case "chapters":
+		out = map[string]any{"chapters": []any{}}
+	case "ffmpeg":
+		input := cmd.Args[slices.Index(cmd.Args, "-i")+1]
+		data, err := os.ReadFile(input)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(cmd.Args[len(cmd.Args)-1], data, 0o600)
+	default:
+		return nil
+	}
+	return json.NewEncoder(cmd.Stdout).Encode(out)
+}
+
+// newTestConfig returns a config backed by a fresh database, fakeS3 and
+// fakeFFmpeg, with the defaults main would use where a test doesn't care.
+func newTestConfig(t *testing.T) (*apiConfig, *fakeS3, *fakeFFmpeg) {
+	t.Helper()
+	db, err := database.NewClient(filepath.Join(t.TempDir(), "tubely.db"))
+	if err != nil {
+		t.Fatalf("couldn't create database: %v", err)
+	}
+	uploads, err := newUploadSessionStore(t.TempDir(), defaultUploadSessionTTL)
+	if err != nil {
+		t.Fatalf("couldn't create upload session store: %v", err)
+	}
+	store := newFakeS3()
+	ffmpeg := newFakeFFmpeg()
+	cfg := &apiConfig{
+		db:                db,
+		dbPolicy:          dbPolicy{timeout: 5 * time.Second},
+		jwtSecrets:        []string{testJWTSecret},
+		platform:          "dev",
+		s3Bucket:          "tubely-test",
+		s3Region:          "us-east-1",
+		s3CfDistribution:  "cdn.example",
+		aspectKeySegments: defaultAspectKeySegments,
+		s3Client:          store,
+		s3Presigner:       fakePresigner{},
+		ffmpeg:            ffmpegLimits{runner: ffmpeg.run},
+		allowedVideoTypes: defaultVideoMediaTypes,
+		uploadLimits:      uploadLimits{videoSize: 1 << 20, thumbnailSize: 1 << 20},
+		colorMode:         colorModeAllow,
+		outputFormat:      outputFormats["mp4"],
+		downloadURLExpiry: time.Hour,
+		streamURLExpiry:   time.Minute,
+		publicBaseURL:     "https://tubely.example",
+		views:             newViewCounter(db, viewDebounceWindow),
+		uploads:           uploads,
+	}
+	return cfg, store, ffmpeg
+}
+
+// createTestUser adds a user and returns it with a token for it.
+func createTestUser(t *testing.T, cfg *apiConfig) (*database.User, string) {
+	t.Helper()
+	user, err := cfg.db.CreateUser(database.CreateUserParams{
+		Email:    uuid.NewString() + "@example.com",
+		Password: "unused",
+	})
+	if err != nil {
+		t.Fatalf("couldn't create user: %v", err)
+	}
+	token, err := cfg.jwtKeys().Sign(user.ID, time.Hour)
+	if err != nil {
+		t.Fatalf("couldn't make token: %v", err)
+	}
+	return user, token
+}
+
+func createTestVideo(t *testing.T, cfg *apiConfig, userID uuid.UUID) database.Video {
+	t.Helper()
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{
+		Title:  "Test video",
+		UserID: userID,
+	})
+	if err != nil {
+		t.Fatalf("couldn't create video: %v", err)
+	}
+	return video
+}
+
+// storeTestVideo puts a file for video in its bucket, whose fake is
+// store, points the video at it on the default distribution and saves it.
+func storeTestVideo(t *testing.T, cfg *apiConfig, store *fakeS3, video *database.Video) {
+	t.Helper()
+	key := "landscape/" + video.ID.String() + ".mp4"
+	store.put(cfg.forVideo(*video).s3Bucket, key, testMP4, "video/mp4")
+	url := "https://" + cfg.s3CfDistribution + "/" + key
+	video.VideoURL = &url
+	if err := cfg.db.UpdateVideo(*video); err != nil {
+		t.Fatalf("couldn't update video: %v", err)
+	}
+}
+
+// newUploadRequest builds a multipart POST with body as the part field,
+// declared as contentType.
+func newUploadRequest(t *testing.T, target, field, filename, contentType string, body []byte) *http.Request {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, field, filename))
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(body)
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, target, &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const hlsPlaylistType = "application/vnd.apple.mpegurl"
+
+// renderHLS makes a fragmented copy of the video at inputPath, one
+// fragment per keyframe, and uploads it to target under hls/ with the
+// index HLS playlists need to address its fragments by byte range. Seeking
+// then only fetches the fragments it needs without storing a file per
+// segment. It fails soft like the variants: the video plays fine without.
+func (cfg *apiConfig) renderHLS(ctx context.Context, target *apiConfig, inputPath, baseName string) (*string, *database.HLSIndex) {
+	outputPath := inputPath + ".hls.mp4"
+	args := []string{
+		"-y",
+		"-i", inputPath,
+		"-c", "copy",
+		"-movflags", "frag_keyframe+empty_moov+default_base_moof",
+	}
+	args = append(args, cfg.ffmpeg.outputArgs()...)
+	args = append(args, "-f", "mp4", outputPath)
+	if err := cfg.ffmpeg.run(ctx, exec.CommandContext(ctx, "ffmpeg", args...)); err != nil {
+		os.Remove(outputPath)
+		log.Printf("warning: skipping HLS copy: ffmpeg fragmenting failed: %v", err)
+		return nil, nil
+	}
+	defer cfg.tempFiles.remove(outputPath)
+
+	index, err := indexFragmentsOf(outputPath)
+	if err != nil {
+		log.Printf("warning: skipping HLS copy: couldn't index fragments: %v", err)
+		return nil, nil
+	}
+
+	key := cfg.s3KeyPrefix + "hls/" + baseName + ".mp4"
+	url, err := target.uploadFileToS3(ctx, outputPath, key, "video/mp4", ifAbsent)
+	if err != nil {
+		log.Printf("warning: skipping HLS copy: %v", err)
+		return nil, nil
+	}
+	return &url, &index
+}
+
+func indexFragmentsOf(path string) (database.HLSIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return database.HLSIndex{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return database.HLSIndex{}, err
+	}
+	return indexFragmentedMP4(f, info.Size())
+}
+
+// handlerHLSMaster serves the entry playlist of a video's HLS copy, which
+// lists its media playlist and its I-frame playlist for fast scrubbing.
+// The playlists it points at are signed for private videos, since players
+// can't send the JWT.
+func (cfg *apiConfig) handlerHLSMaster(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.hlsVideoForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	index := video.HLSIndex
+	var bandwidth, iframeBandwidth int64
+	for _, segment := range index.Segments {
+		bandwidth = max(bandwidth, segmentBandwidth(segment.Range.Length, segment.Duration))
+		iframeBandwidth = max(iframeBandwidth, segmentBandwidth(segment.Keyframe.Length, segment.Duration))
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-INDEPENDENT-SEGMENTS\n")
+	fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d\n%s\n", bandwidth, cfg.hlsPlaylistPath(video, "media.m3u8"))
+	fmt.Fprintf(&b, "#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=%d,URI=\"%s\"\n", iframeBandwidth, cfg.hlsPlaylistPath(video, "iframes.m3u8"))
+	writeHLSPlaylist(w, b.String())
+}
+
+// handlerHLSMedia serves the playlist of every fragment of a video's HLS
+// copy, addressed by byte range in one presigned URL.
+func (cfg *apiConfig) handlerHLSMedia(w http.ResponseWriter, r *http.Request) {
+	cfg.serveHLSSegments(w, r, false)
+}
+
+// handlerHLSIFrames serves the playlist of just the leading keyframe of
+// each fragment, which players use for trick play and scrubbing previews.
+func (cfg *apiConfig) handlerHLSIFrames(w http.ResponseWriter, r *http.Request) {
+	cfg.serveHLSSegments(w, r, true)
+}
+
+func (cfg *apiConfig) serveHLSSegments(w http.ResponseWriter, r *http.Request, iframes bool) {
+	video, ok := cfg.hlsVideoForRequest(w, r)
+	if !ok {
+		return
+	}
+	key, err := cfg.s3KeyFromURL(*video.HLSURL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't locate HLS file", err)
+		return
+	}
+	// Players don't reload a VOD playlist, so the URL has to last the
+	// whole viewing rather than STREAM_URL_EXPIRY. Ranges aren't part of
+	// the signature, so one URL serves every fragment.
+	url, err := cfg.forVideo(video).presignGetObject(r.Context(), key, presignOptions{
+		expires:     cfg.downloadURLExpiry,
+		contentType: "video/mp4",
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign HLS file", err)
+		return
+	}
+
+	index := video.HLSIndex
+	target := 1.0
+	for _, segment := range index.Segments {
+		target = max(target, math.Ceil(segment.Duration))
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:7\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(target))
+	b.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-INDEPENDENT-SEGMENTS\n")
+	if iframes {
+		b.WriteString("#EXT-X-I-FRAMES-ONLY\n")
+	}
+	fmt.Fprintf(&b, "#EXT-X-MAP:URI=\"%s\",BYTERANGE=\"%d@%d\"\n", url, index.Init.Length, index.Init.Offset)
+	for _, segment := range index.Segments {
+		rng := segment.Range
+		if iframes {
+			rng = segment.Keyframe
+		}
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n#EXT-X-BYTERANGE:%d@%d\n%s\n", segment.Duration, rng.Length, rng.Offset, url)
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+
+	if !iframes {
+		cfg.views.record(video.ID, viewSession(r, cfg.jwtKeys()))
+	}
+	writeHLSPlaylist(w, b.String())
+}
+
+// hlsVideoForRequest loads the video named in the path and checks the
+// caller may watch it and it has an HLS copy, writing an error if not.
+func (cfg *apiConfig) hlsVideoForRequest(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	videoID, err := cfg.parseVideoID(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return database.Video{}, false
+	}
+	video, err := cfg.getVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithDBError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return database.Video{}, false
+	}
+	if video.ID == uuid.Nil || video.VideoURL == nil || !cfg.canViewVideo(r, video) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return database.Video{}, false
+	}
+	if video.HLSURL == nil || video.HLSIndex == nil {
+		respondWithError(w, http.StatusNotFound, "Video has no HLS copy", nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
+
+// hlsPlaylistPath is the path of one of the video's playlists, signed
+// unless the video is public.
+func (cfg *apiConfig) hlsPlaylistPath(video database.Video, name string) string {
+	path := "/api/videos/" + video.ID.String() + "/hls/" + name
+	if video.IsPublic {
+		return path
+	}
+	return cfg.signMediaURL(path)
+}
+
+// segmentBandwidth is the bitrate, in bits per second, of length bytes
+// played over duration seconds.
+func segmentBandwidth(length int64, duration float64) int64 {
+	if duration <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(float64(length) * 8 / duration))
+}
+
+func writeHLSPlaylist(w http.ResponseWriter, playlist string) {
+	w.Header().Set("Content-Type", hlsPlaylistType)
+	// They carry expiring signatures.
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(playlist))
+}
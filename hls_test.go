@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func mp4TestBox(kind string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	box := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(box, kind...), body...)
+}
+
+func u32s(values ...uint32) []byte {
+	var b []byte
+	for _, v := range values {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	return b
+}
+
+func mp4TestTrak(id, timescale uint32, handler string) []byte {
+	return mp4TestBox("trak",
+		mp4TestBox("tkhd", u32s(0, 0, 0, id, 0, 0)),
+		mp4TestBox("mdia",
+			mp4TestBox("mdhd", u32s(0, 0, 0, timescale, 0, 0)),
+			mp4TestBox("hdlr", u32s(0, 0), []byte(handler), u32s(0, 0, 0)),
+		),
+	)
+}
+
+// mp4TestFragment builds a moof and mdat with one traf per track: video
+// samples of the given sizes and, when durations is nil, the trex
+// default duration; and one 50-byte audio sample.
+func mp4TestFragment(sizes, durations []uint32) []byte {
+	build := func(videoOffset, audioOffset uint32) []byte {
+		flags := uint32(0x01 | 0x200)
+		var samples []byte
+		for i, size := range sizes {
+			if durations != nil {
+				samples = append(samples, u32s(durations[i])...)
+			}
+			samples = append(samples, u32s(size)...)
+		}
+		if durations != nil {
+			flags |= 0x100
+		}
+		return mp4TestBox("moof",
+			mp4TestBox("mfhd", u32s(0, 1)),
+			mp4TestBox("traf",
+				mp4TestBox("tfhd", u32s(0x020000, 1)),
+				mp4TestBox("trun", u32s(flags, uint32(len(sizes)), videoOffset), samples),
+			),
+			mp4TestBox("traf",
+				mp4TestBox("tfhd", u32s(0x020000|0x10, 2, 50)),
+				mp4TestBox("trun", u32s(0x01, 1, audioOffset)),
+			),
+		)
+	}
+	var total uint32
+	for _, size := range sizes {
+		total += size
+	}
+	moofSize := uint32(len(build(0, 0)))
+	moof := build(moofSize+8, moofSize+8+total)
+	return append(moof, mp4TestBox("mdat", make([]byte, total+50))...)
+}
+
+func TestIndexFragmentedMP4(t *testing.T) {
+	ftyp := mp4TestBox("ftyp", []byte("isom"), u32s(512), []byte("isomiso6"))
+	moov := mp4TestBox("moov",
+		mp4TestBox("mvhd", make([]byte, 100)),
+		mp4TestTrak(1, 1000, "vide"),
+		mp4TestTrak(2, 48000, "soun"),
+		mp4TestBox("mvex",
+			mp4TestBox("trex", u32s(0, 1, 1, 1000, 0, 0)),
+			mp4TestBox("trex", u32s(0, 2, 1, 1024, 0, 0)),
+		),
+	)
+	first := mp4TestFragment([]uint32{500, 100, 100}, nil)
+	second := mp4TestFragment([]uint32{400, 80}, []uint32{500, 250})
+	file := bytes.Join([][]byte{ftyp, moov, first, second, mp4TestBox("mfra", make([]byte, 16))}, nil)
+
+	index, err := indexFragmentedMP4(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	initLen := int64(len(ftyp) + len(moov))
+	if index.Init != (database.ByteRange{Offset: 0, Length: initLen}) {
+		t.Errorf("init = %+v, want 0 to %d", index.Init, initLen)
+	}
+	moofLen := func(fragment []byte) int64 { return int64(binary.BigEndian.Uint32(fragment)) }
+	want := []database.HLSSegment{{
+		Duration: 3,
+		Range:    database.ByteRange{Offset: initLen, Length: int64(len(first))},
+		Keyframe: database.ByteRange{Offset: initLen, Length: moofLen(first) + 8 + 500},
+	}, {
+		Duration: 0.75,
+		Range:    database.ByteRange{Offset: initLen + int64(len(first)), Length: int64(len(second))},
+		Keyframe: database.ByteRange{Offset: initLen + int64(len(first)), Length: moofLen(second) + 8 + 400},
+	}}
+	if fmt.Sprint(index.Segments) != fmt.Sprint(want) {
+		t.Errorf("segments = %+v\nwant %+v", index.Segments, want)
+	}
+
+	// A regular MP4 has its samples in the moov.
+	if _, err := indexFragmentedMP4(bytes.NewReader(testMP4), int64(len(testMP4))); err == nil {
+		t.Error("indexed an unfragmented MP4")
+	}
+}
+
+func TestHandlerHLSPlaylists(t *testing.T) {
+	cfg, store, _ := newTestConfig(t)
+	cfg.mediaURLKey = []byte("media-key")
+	cfg.mediaURLExpiry = time.Minute
+	user, token := createTestUser(t, cfg)
+	video := createTestVideo(t, cfg, user.ID)
+	video.IsPublic = false
+	storeTestVideo(t, cfg, store, &video)
+	hlsURL := "https://" + cfg.s3CfDistribution + "/hls/abc.mp4"
+	video.HLSURL = &hlsURL
+	video.HLSIndex = &database.HLSIndex{
+		Init: database.ByteRange{Offset: 0, Length: 800},
+		Segments: []database.HLSSegment{
+			{Duration: 2.002, Range: database.ByteRange{Offset: 800, Length: 50000}, Keyframe: database.ByteRange{Offset: 800, Length: 9000}},
+			{Duration: 1.5, Range: database.ByteRange{Offset: 50800, Length: 30000}, Keyframe: database.ByteRange{Offset: 50800, Length: 7000}},
+		},
+	}
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(target string, h http.HandlerFunc, auth bool) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.SetPathValue("videoID", video.ID.String())
+		if auth {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec
+	}
+	base := "/api/videos/" + video.ID.String() + "/hls/"
+
+	if rec := get(base+"master.m3u8", cfg.handlerHLSMaster, false); rec.Code != http.StatusNotFound {
+		t.Errorf("private master without auth: status = %d, want 404", rec.Code)
+	}
+	rec := get(base+"master.m3u8", cfg.handlerHLSMaster, true)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != hlsPlaylistType {
+		t.Fatalf("master: %d %s: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+	}
+	// 50000 bytes over 2.002s is the peak.
+	if !strings.Contains(rec.Body.String(), "#EXT-X-STREAM-INF:BANDWIDTH=199801\n") {
+		t.Errorf("master lacks the peak bandwidth:\n%s", rec.Body)
+	}
+	var mediaPath string
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if strings.HasPrefix(line, base+"media.m3u8?") {
+			mediaPath = line
+		}
+	}
+	if mediaPath == "" {
+		t.Fatalf("master has no signed media playlist:\n%s", rec.Body)
+	}
+
+	// The player follows the signed link without the JWT.
+	rec = get(mediaPath, cfg.handlerHLSMedia, false)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("signed media playlist: status = %d: %s", rec.Code, rec.Body)
+	}
+	media := rec.Body.String()
+	for _, want := range []string{
+		"#EXT-X-TARGETDURATION:3\n",
+		"#EXT-X-MAP:URI=\"https://presigned.example/tubely-test/hls/abc.mp4?",
+		`BYTERANGE="800@0"`,
+		"#EXTINF:2.002,\n#EXT-X-BYTERANGE:50000@800\nhttps://presigned.example/",
+		"#EXT-X-BYTERANGE:30000@50800\n",
+		"#EXT-X-ENDLIST\n",
+	} {
+		if !strings.Contains(media, want) {
+			t.Errorf("media playlist lacks %q:\n%s", want, media)
+		}
+	}
+	if strings.Contains(media, "I-FRAMES-ONLY") {
+		t.Error("media playlist is marked I-frames only")
+	}
+
+	rec = get(base+"iframes.m3u8", cfg.handlerHLSIFrames, true)
+	iframes := rec.Body.String()
+	for _, want := range []string{"#EXT-X-I-FRAMES-ONLY\n", "#EXT-X-BYTERANGE:9000@800\n", "#EXT-X-BYTERANGE:7000@50800\n"} {
+		if !strings.Contains(iframes, want) {
+			t.Errorf("I-frame playlist lacks %q:\n%s", want, iframes)
+		}
+	}
+
+	// Without an HLS copy there's nothing to play.
+	other := createTestVideo(t, cfg, user.ID)
+	storeTestVideo(t, cfg, store, &other)
+	req := httptest.NewRequest(http.MethodGet, "/api/videos/"+other.ID.String()+"/hls/master.m3u8", nil)
+	req.SetPathValue("videoID", other.ID.String())
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec = httptest.NewRecorder()
+	cfg.handlerHLSMaster(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("video without HLS copy: status = %d, want 404", rec.Code)
+	}
+}
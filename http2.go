@@ -0,0 +1,20 @@
+//go:build go1.24
+
+package main
+
+import "net/http"
+
+// configureHTTP2 applies settings to srv. Without cleartext the server
+// only speaks HTTP/2 over TLS, which ListenAndServe never negotiates, so
+// the stream limit matters once the server is run behind TLS or h2c is on.
+func configureHTTP2(srv *http.Server, settings http2Settings) error {
+	srv.HTTP2 = &http.HTTP2Config{
+		MaxConcurrentStreams: settings.maxStreams,
+	}
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(settings.cleartext)
+	srv.Protocols = &protocols
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build !go1.24
+
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// configureHTTP2 needs the HTTP/2 settings net/http gained in Go 1.24.
+// Older builds keep the defaults of the bundled HTTP/2 server, which
+// already caps streams per connection, but can't serve h2c.
+func configureHTTP2(srv *http.Server, settings http2Settings) error {
+	if settings.cleartext {
+		return errors.New("HTTP2_CLEARTEXT needs a server built with Go 1.24 or later")
+	}
+	return nil
+}
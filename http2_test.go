@@ -0,0 +1,104 @@
+//go:build go1.24
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// newH2CServer serves mux over cleartext HTTP/2 with the upload limit in
+// front, and returns a client that only speaks h2c to it.
+func newH2CServer(t *testing.T, cfg *apiConfig, mux *http.ServeMux) (*httptest.Server, *http.Client) {
+	t.Helper()
+	ts := httptest.NewUnstartedServer(cfg.connUploadLimitMiddleware(mux, mux))
+	ts.Config.ConnContext = connContext
+	if err := configureHTTP2(ts.Config, http2Settings{maxStreams: 10, cleartext: true}); err != nil {
+		t.Fatal(err)
+	}
+	ts.Start()
+	t.Cleanup(ts.Close)
+
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: &protocols}}
+	t.Cleanup(client.CloseIdleConnections)
+	return ts, client
+}
+
+func TestHTTP2LargeUpload(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/video_upload/{videoID}", func(w http.ResponseWriter, r *http.Request) {
+		n, err := io.Copy(io.Discard, r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("X-Proto", r.Proto)
+		w.Write([]byte(strconv.FormatInt(n, 10)))
+	})
+	ts, client := newH2CServer(t, &apiConfig{uploadsPerConn: 1}, mux)
+
+	const size = 64 << 20
+	resp, err := client.Post(ts.URL+"/api/video_upload/abc", "application/octet-stream", bytes.NewReader(make([]byte, size)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", resp.StatusCode, body)
+	}
+	if got := resp.Header.Get("X-Proto"); got != "HTTP/2.0" {
+		t.Errorf("server saw %s, want HTTP/2.0", got)
+	}
+	if string(body) != strconv.Itoa(size) {
+		t.Errorf("server read %s bytes, want %d", body, size)
+	}
+}
+
+func TestHTTP2UploadsPerConnection(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/video_upload/{videoID}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("videoID") == "slow" {
+			close(started)
+			<-release
+		}
+		io.Copy(io.Discard, r.Body)
+	})
+	ts, client := newH2CServer(t, &apiConfig{uploadsPerConn: 1}, mux)
+
+	slow := make(chan int)
+	go func() {
+		resp, err := client.Post(ts.URL+"/api/video_upload/slow", "application/octet-stream", nil)
+		if err != nil {
+			slow <- 0
+			return
+		}
+		resp.Body.Close()
+		slow <- resp.StatusCode
+	}()
+	<-started
+
+	// The second stream shares the first's connection, so it's counted
+	// against the same limit.
+	resp, err := client.Post(ts.URL+"/api/video_upload/fast", "application/octet-stream", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("second upload status = %d, want 429", resp.StatusCode)
+	}
+
+	close(release)
+	if code := <-slow; code != http.StatusOK {
+		t.Errorf("first upload status = %d, want 200", code)
+	}
+}
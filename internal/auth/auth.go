@@ -2,6 +2,8 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -40,26 +42,50 @@ func MakeJWT(
 	expiresIn time.Duration,
 ) (string, error) {
 	signingKey := []byte(tokenSecret)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims(userID, expiresIn))
+	return token.SignedString(signingKey)
+}
+
+func accessClaims(userID uuid.UUID, expiresIn time.Duration) jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{
 		Issuer:    string(TokenTypeAccess),
 		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
 		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
 		Subject:   userID.String(),
-	})
-	return token.SignedString(signingKey)
+	}
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claimsStruct := jwt.RegisteredClaims{}
-	token, err := jwt.ParseWithClaims(
-		tokenString,
-		&claimsStruct,
-		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
-	)
+// ValidateJWT accepts a token signed with any of tokenSecrets, so a secret
+// can be rotated by signing with a new one while tokens signed with the old
+// one run out.
+func ValidateJWT(tokenString string, tokenSecrets []string) (uuid.UUID, error) {
+	if len(tokenSecrets) == 0 {
+		return uuid.Nil, errors.New("no token secrets configured")
+	}
+	var token *jwt.Token
+	var err error
+	for _, secret := range tokenSecrets {
+		claimsStruct := jwt.RegisteredClaims{}
+		token, err = jwt.ParseWithClaims(
+			tokenString,
+			&claimsStruct,
+			func(token *jwt.Token) (interface{}, error) { return []byte(secret), nil },
+		)
+		// Only a bad signature means another secret might fit; an expired
+		// or malformed token is rejected whichever secret signed it.
+		if !errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+			break
+		}
+	}
 	if err != nil {
 		return uuid.Nil, err
 	}
+	return accessSubject(token)
+}
 
+// accessSubject checks a verified token is an access token and returns
+// the user it was issued to.
+func accessSubject(token *jwt.Token) (uuid.UUID, error) {
 	userIDString, err := token.Claims.GetSubject()
 	if err != nil {
 		return uuid.Nil, err
@@ -114,3 +140,23 @@ func GetAPIKey(headers http.Header) (string, error) {
 
 	return splitAuth[1], nil
 }
+
+// APIKeyPrefix marks tubely API keys so they're recognizable in configs and
+// secret scanners.
+const APIKeyPrefix = "tbly_"
+
+func MakeAPIKey() (string, error) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", err
+	}
+	return APIKeyPrefix + base64.RawURLEncoding.EncodeToString(key), nil
+}
+
+// HashAPIKey is what gets stored and looked up. The key is random enough
+// that a fast hash is safe, unlike passwords.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
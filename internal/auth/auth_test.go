@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func TestValidateJWTSecretRotation(t *testing.T) {
+	userID := uuid.New()
+	before := []string{"old-secret"}
+	during := []string{"new-secret", "old-secret"}
+	after := []string{"new-secret"}
+
+	oldToken, err := MakeJWT(userID, before[0], time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newToken, err := MakeJWT(userID, during[0], time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		secrets []string
+		wantErr bool
+	}{
+		{"old token before rotation", oldToken, before, false},
+		{"old token during rotation", oldToken, during, false},
+		{"old token after the old secret is removed", oldToken, after, true},
+		{"new token during rotation", newToken, during, false},
+		{"new token after rotation", newToken, after, false},
+		{"new token on a server without the new secret", newToken, before, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ValidateJWT(tt.token, tt.secrets)
+			if tt.wantErr {
+				if !errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+					t.Errorf("err = %v, want an invalid signature", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != userID {
+				t.Errorf("user ID = %s, want %s", got, userID)
+			}
+		})
+	}
+}
+
+func TestValidateJWTExpiredWithOldSecret(t *testing.T) {
+	token, err := MakeJWT(uuid.New(), "old-secret", -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWT(token, []string{"new-secret", "old-secret"}); !errors.Is(err, jwt.ErrTokenExpired) {
+		t.Errorf("err = %v, want an expired token", err)
+	}
+	if _, err := ValidateJWT(token, nil); err == nil {
+		t.Error("token accepted with no secrets configured")
+	}
+}
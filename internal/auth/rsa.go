@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// MinRSAKeyBits is the smallest RS256 key accepted.
+const MinRSAKeyBits = 2048
+
+// RSAKey is an RS256 key named by its kid, the RFC 7638 thumbprint of its
+// public half. Private is nil for keys that only verify.
+type RSAKey struct {
+	ID      string
+	Public  *rsa.PublicKey
+	Private *rsa.PrivateKey
+}
+
+// ParseRSAKey reads a PEM private key, PKCS #1 or PKCS #8, or a PEM
+// public key, which can verify tokens but not sign them.
+func ParseRSAKey(data []byte) (RSAKey, error) {
+	var key RSAKey
+	if private, err := jwt.ParseRSAPrivateKeyFromPEM(data); err == nil {
+		key.Private, key.Public = private, &private.PublicKey
+	} else if public, err := jwt.ParseRSAPublicKeyFromPEM(data); err == nil {
+		key.Public = public
+	} else {
+		return RSAKey{}, errors.New("not a PEM RSA private or public key")
+	}
+	if bits := key.Public.N.BitLen(); bits < MinRSAKeyBits {
+		return RSAKey{}, fmt.Errorf("key is %d bits but must be at least %d", bits, MinRSAKeyBits)
+	}
+	key.ID = rsaThumbprint(key.Public)
+	return key, nil
+}
+
+// rsaThumbprint is the RFC 7638 thumbprint of the key: the SHA-256 of its
+// required JWK members in lexical order.
+func rsaThumbprint(key *rsa.PublicKey) string {
+	jwk, _ := json.Marshal(struct {
+		E   string `json:"e"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+	}{rsaExponent(key), "RSA", base64.RawURLEncoding.EncodeToString(key.N.Bytes())})
+	sum := sha256.Sum256(jwk)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func rsaExponent(key *rsa.PublicKey) string {
+	return base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+}
+
+// JWK is the public half of an RSAKey as published in a JWKS.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKS is a JSON Web Key Set, RFC 7517.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Keys are what access tokens are signed and verified with. With RSA keys
+// set, new tokens are signed RS256 by the first of them and carry its kid,
+// and the rest still verify, so a key can be rotated out once its tokens
+// have expired. Tokens signed HS256 with any of Secrets stay valid either
+// way, so moving to RS256 doesn't log anyone out.
+type Keys struct {
+	Secrets []string
+	RSA     []RSAKey
+}
+
+// Sign makes an access token for userID with the key that signs new
+// tokens.
+func (k Keys) Sign(userID uuid.UUID, expiresIn time.Duration) (string, error) {
+	if len(k.RSA) == 0 {
+		if len(k.Secrets) == 0 {
+			return "", errors.New("no token secrets configured")
+		}
+		return MakeJWT(userID, k.Secrets[0], expiresIn)
+	}
+	key := k.RSA[0]
+	if key.Private == nil {
+		return "", fmt.Errorf("signing key %s has no private key", key.ID)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, accessClaims(userID, expiresIn))
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+// Validate accepts an RS256 token signed by the key its kid names, or by
+// any of the keys if it has none, and an HS256 token as ValidateJWT does.
+func (k Keys) Validate(tokenString string) (uuid.UUID, error) {
+	if len(k.RSA) == 0 {
+		return ValidateJWT(tokenString, k.Secrets)
+	}
+	claims := jwt.RegisteredClaims{}
+	token, _, err := jwt.NewParser().ParseUnverified(tokenString, &claims)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if token.Method.Alg() != jwt.SigningMethodRS256.Alg() {
+		return ValidateJWT(tokenString, k.Secrets)
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	keys := k.RSA
+	if kid != "" {
+		keys = nil
+		for _, key := range k.RSA {
+			if key.ID == kid {
+				keys = []RSAKey{key}
+				break
+			}
+		}
+		if keys == nil {
+			return uuid.Nil, fmt.Errorf("%w: unknown kid %q", jwt.ErrTokenSignatureInvalid, kid)
+		}
+	}
+	for _, key := range keys {
+		claims = jwt.RegisteredClaims{}
+		token, err = jwt.ParseWithClaims(tokenString, &claims,
+			func(*jwt.Token) (interface{}, error) { return key.Public, nil },
+			jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		)
+		if !errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+			break
+		}
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return accessSubject(token)
+}
+
+// JWKS is the set of public keys that verify RS256 tokens, for services
+// that check our tokens themselves.
+func (k Keys) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range k.RSA {
+		set.Keys = append(set.Keys, JWK{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: jwt.SigningMethodRS256.Alg(),
+			Kid: key.ID,
+			N:   base64.RawURLEncoding.EncodeToString(key.Public.N.Bytes()),
+			E:   rsaExponent(key.Public),
+		})
+	}
+	return set
+}
@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func newTestRSAKey(t *testing.T, bits int) (private, public []byte) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	private = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	public = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	return private, public
+}
+
+func mustParseRSAKey(t *testing.T, data []byte) RSAKey {
+	t.Helper()
+	key, err := ParseRSAKey(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestParseRSAKey(t *testing.T) {
+	private, public := newTestRSAKey(t, 2048)
+	priv := mustParseRSAKey(t, private)
+	pub := mustParseRSAKey(t, public)
+	if priv.Private == nil || pub.Private != nil {
+		t.Errorf("private key parsed with Private %v, public key with %v", priv.Private != nil, pub.Private != nil)
+	}
+	if priv.ID == "" || priv.ID != pub.ID {
+		t.Errorf("kids %q and %q, want the same thumbprint for both halves", priv.ID, pub.ID)
+	}
+
+	short, _ := newTestRSAKey(t, 1024)
+	if _, err := ParseRSAKey(short); err == nil {
+		t.Error("accepted a 1024-bit key")
+	}
+	if _, err := ParseRSAKey([]byte("not a key")); err == nil {
+		t.Error("accepted garbage")
+	}
+}
+
+func TestKeysRSARotation(t *testing.T) {
+	userID := uuid.New()
+	oldPrivate, oldPublic := newTestRSAKey(t, 2048)
+	newPrivate, _ := newTestRSAKey(t, 2048)
+	oldKey, newKey := mustParseRSAKey(t, oldPrivate), mustParseRSAKey(t, newPrivate)
+	secrets := []string{"hmac-secret"}
+
+	before := Keys{Secrets: secrets, RSA: []RSAKey{oldKey}}
+	during := Keys{Secrets: secrets, RSA: []RSAKey{newKey, mustParseRSAKey(t, oldPublic)}}
+	after := Keys{Secrets: secrets, RSA: []RSAKey{newKey}}
+
+	oldToken, err := before.Sign(userID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newToken, err := during.Sign(userID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hmacToken, err := MakeJWT(userID, secrets[0], time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, _, err := jwt.NewParser().ParseUnverified(newToken, &jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Method.Alg() != "RS256" || parsed.Header["kid"] != newKey.ID {
+		t.Errorf("new token is %s with kid %v, want RS256 with kid %s", parsed.Method.Alg(), parsed.Header["kid"], newKey.ID)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		keys    Keys
+		wantErr bool
+	}{
+		{"old token during rotation", oldToken, during, false},
+		{"old token after the old key is removed", oldToken, after, true},
+		{"new token during rotation", newToken, during, false},
+		{"new token on a server without the new key", newToken, before, true},
+		{"HS256 token alongside RSA keys", hmacToken, after, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.keys.Validate(tt.token)
+			if tt.wantErr {
+				if !errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+					t.Errorf("err = %v, want an invalid signature", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != userID {
+				t.Errorf("user ID = %s, want %s", got, userID)
+			}
+		})
+	}
+
+	// A retired key can't sign.
+	retired := Keys{RSA: []RSAKey{mustParseRSAKey(t, oldPublic)}}
+	if _, err := retired.Sign(userID, time.Hour); err == nil {
+		t.Error("signed with a public key")
+	}
+}
+
+func TestKeysJWKS(t *testing.T) {
+	private, _ := newTestRSAKey(t, 2048)
+	keys := Keys{RSA: []RSAKey{mustParseRSAKey(t, private)}}
+	token, err := keys.Sign(uuid.New(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Verify the way another service would, from the published set alone.
+	set := keys.JWKS()
+	_, err = jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+		for _, jwk := range set.Keys {
+			if jwk.Kid != token.Header["kid"] {
+				continue
+			}
+			n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+			if err != nil {
+				return nil, err
+			}
+			e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+			if err != nil {
+				return nil, err
+			}
+			return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+		}
+		return nil, errors.New("no key with the token's kid")
+	}, jwt.WithValidMethods([]string{"RS256"}))
+	if err != nil {
+		t.Errorf("token doesn't verify against the JWKS: %v", err)
+	}
+
+	if got := (Keys{Secrets: []string{"hmac-secret"}}).JWKS(); len(got.Keys) != 0 {
+		t.Errorf("JWKS without RSA keys = %+v, want it empty", got)
+	}
+}
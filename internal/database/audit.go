@@ -0,0 +1,51 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditEvent records one access decision made by a handler.
+type AuditEvent struct {
+	Time    time.Time  `json:"time"`
+	UserID  uuid.UUID  `json:"user_id"`
+	VideoID *uuid.UUID `json:"video_id,omitempty"`
+	Action  string     `json:"action"`
+	Result  string     `json:"result"`
+	Route   string     `json:"route,omitempty"`
+	Detail  string     `json:"detail,omitempty"`
+}
+
+// InsertAuditEvents writes a batch of events in one transaction.
+func (c Client) InsertAuditEvents(events []AuditEvent) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+	INSERT INTO audit_log (
+		created_at,
+		user_id,
+		video_id,
+		action,
+		result,
+		route,
+		detail
+	) VALUES (?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, event := range events {
+		_, err := stmt.Exec(event.Time, event.UserID, event.VideoID, event.Action, event.Result, event.Route, event.Detail)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
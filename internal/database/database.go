@@ -72,6 +72,194 @@ func (c *Client) autoMigrate() error {
 	if err != nil {
 		return err
 	}
+
+	if err := c.addColumnIfMissing("users", "disabled", "BOOLEAN NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	folderTable := `
+	CREATE TABLE IF NOT EXISTS folders (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		name TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		UNIQUE(user_id, name),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	if _, err := c.db.Exec(folderTable); err != nil {
+		return err
+	}
+
+	auditTable := `
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at TIMESTAMP NOT NULL,
+		user_id TEXT NOT NULL,
+		video_id TEXT,
+		action TEXT NOT NULL,
+		result TEXT NOT NULL,
+		route TEXT NOT NULL DEFAULT '',
+		detail TEXT NOT NULL DEFAULT ''
+	);
+	`
+	if _, err := c.db.Exec(auditTable); err != nil {
+		return err
+	}
+
+	apiKeyTable := `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		last_used_at TIMESTAMP,
+		revoked_at TIMESTAMP,
+		user_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		key_hash TEXT NOT NULL UNIQUE,
+		scope TEXT NOT NULL,
+		expires_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	if _, err := c.db.Exec(apiKeyTable); err != nil {
+		return err
+	}
+
+	shortLinkTable := `
+	CREATE TABLE IF NOT EXISTS short_links (
+		code TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		clicks INTEGER NOT NULL DEFAULT 0,
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		expires_at TIMESTAMP,
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	if _, err := c.db.Exec(shortLinkTable); err != nil {
+		return err
+	}
+
+	transcriptTables := []string{
+		`CREATE TABLE IF NOT EXISTS transcripts (
+			video_id TEXT PRIMARY KEY,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			source TEXT NOT NULL,
+			format TEXT NOT NULL,
+			content TEXT NOT NULL,
+			FOREIGN KEY(video_id) REFERENCES videos(id)
+		)`,
+		// An inverted index of the words in each transcript, for search.
+		`CREATE TABLE IF NOT EXISTS transcript_terms (
+			term TEXT NOT NULL,
+			video_id TEXT NOT NULL,
+			PRIMARY KEY(term, video_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_transcript_terms_video ON transcript_terms(video_id)`,
+	}
+	for _, table := range transcriptTables {
+		if _, err := c.db.Exec(table); err != nil {
+			return err
+		}
+	}
+
+	// Columns added after the videos table was first released.
+	addedVideoColumns := []struct {
+		name       string
+		definition string
+	}{
+		{"view_count", "INTEGER NOT NULL DEFAULT 0"},
+		{"aspect_ratio", "TEXT"},
+		{"embed_origins", "TEXT NOT NULL DEFAULT '[]'"},
+		{"preview_url", "TEXT"},
+		{"is_public", "BOOLEAN NOT NULL DEFAULT 1"},
+		{"original_url", "TEXT"},
+		{"tags", "TEXT NOT NULL DEFAULT '{}'"},
+		{"original_filename", "TEXT"},
+		{"folder_id", "TEXT REFERENCES folders(id)"},
+		{"s3_bucket", "TEXT"},
+		{"pix_fmt", "TEXT"},
+		{"color_transfer", "TEXT"},
+		{"storage_missing", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"chapters", "TEXT NOT NULL DEFAULT '[]'"},
+		{"contact_sheet_url", "TEXT"},
+		{"version", "INTEGER NOT NULL DEFAULT 0"},
+		{"audio_status", "TEXT"},
+		{"audio_normalization", "TEXT"},
+		{"thumbnails_vtt_url", "TEXT"},
+		{"last_viewed_at", "TIMESTAMP"},
+		{"dominant_color", "TEXT"},
+		{"video_etag", "TEXT"},
+		{"video_last_modified", "TIMESTAMP"},
+		{"download_allowed", "BOOLEAN NOT NULL DEFAULT 1"},
+		{"faststart", "BOOLEAN NOT NULL DEFAULT 1"},
+		{"variants", "TEXT NOT NULL DEFAULT '[]'"},
+		{"perceptual_hash", "TEXT"},
+		{"original_storage_class", "TEXT"},
+		{"duration_seconds", "REAL"},
+		{"video_size", "INTEGER"},
+		{"hls_url", "TEXT"},
+		{"hls_index", "TEXT"},
+	}
+	for _, col := range addedVideoColumns {
+		if err := c.addColumnIfMissing("videos", col.name, col.definition); err != nil {
+			return err
+		}
+	}
+
+	videoIndexes := []string{
+		`CREATE INDEX IF NOT EXISTS idx_videos_user_aspect_ratio ON videos(user_id, aspect_ratio, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_videos_user_folder ON videos(user_id, folder_id, created_at)`,
+	}
+	for band := range perceptualHashBands {
+		videoIndexes = append(videoIndexes, fmt.Sprintf(
+			`CREATE INDEX IF NOT EXISTS idx_videos_perceptual_hash_%d ON videos(%s) WHERE perceptual_hash IS NOT NULL`,
+			band, perceptualHashBandExpr(band)))
+	}
+	for _, index := range videoIndexes {
+		if _, err := c.db.Exec(index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumnIfMissing lets autoMigrate evolve tables that were created by an
+// older version of the schema, since CREATE TABLE IF NOT EXISTS won't.
+func (c *Client) addColumnIfMissing(table, column, definition string) error {
+	rows, err := c.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = c.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
 	return nil
 }
 
@@ -79,6 +267,12 @@ func (c Client) Reset() error {
 	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
 		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
 	}
+	if _, err := c.db.Exec("DELETE FROM api_keys"); err != nil {
+		return fmt.Errorf("failed to reset table api_keys: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM short_links"); err != nil {
+		return fmt.Errorf("failed to reset table short_links: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM users"); err != nil {
 		return fmt.Errorf("failed to reset table users: %w", err)
 	}
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Folder struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	CreateFolderParams
+}
+
+type CreateFolderParams struct {
+	Name   string    `json:"name"`
+	UserID uuid.UUID `json:"user_id"`
+}
+
+func (c Client) CreateFolder(params CreateFolderParams) (Folder, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO folders (
+		id,
+		created_at,
+		updated_at,
+		name,
+		user_id
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, params.Name, params.UserID)
+	if err != nil {
+		return Folder{}, err
+	}
+
+	return c.GetFolder(id)
+}
+
+// GetFolder returns an empty Folder if there is none with that ID.
+func (c Client) GetFolder(id uuid.UUID) (Folder, error) {
+	query := `
+	SELECT id, created_at, updated_at, name, user_id
+	FROM folders
+	WHERE id = ?
+	`
+	var folder Folder
+	err := c.db.QueryRow(query, id).Scan(
+		&folder.ID,
+		&folder.CreatedAt,
+		&folder.UpdatedAt,
+		&folder.Name,
+		&folder.UserID,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Folder{}, nil
+		}
+		return Folder{}, err
+	}
+	return folder, nil
+}
+
+func (c Client) GetFolders(userID uuid.UUID) ([]Folder, error) {
+	query := `
+	SELECT id, created_at, updated_at, name, user_id
+	FROM folders
+	WHERE user_id = ?
+	ORDER BY name
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	folders := []Folder{}
+	for rows.Next() {
+		var folder Folder
+		if err := rows.Scan(
+			&folder.ID,
+			&folder.CreatedAt,
+			&folder.UpdatedAt,
+			&folder.Name,
+			&folder.UserID,
+		); err != nil {
+			return nil, err
+		}
+		folders = append(folders, folder)
+	}
+
+	return folders, rows.Err()
+}
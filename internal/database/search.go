@@ -0,0 +1,153 @@
+package database
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+type SearchVideosParams struct {
+	// UserID scopes the search to the user's videos; with PublicOnly it
+	// searches every public video instead.
+	UserID     uuid.UUID
+	PublicOnly bool
+	// Query is matched case-insensitively as a phrase against the title,
+	// description and tags.
+	Query string
+	// Terms are the distinct words of the query. A video whose transcript
+	// contains all of them matches too, and with FullText they replace the
+	// phrase match on the other fields.
+	Terms []string
+	// FullText finds matches through the index made by
+	// EnableFullTextSearch rather than scanning with LIKE.
+	FullText bool
+	// Limit of 0 returns every matching video.
+	Limit  int
+	Offset int
+}
+
+// EnableFullTextSearch creates an FTS4 index over video titles,
+// descriptions and tags, kept current by triggers. It's opt-in since the
+// triggers make those writes slower; the first call indexes every video.
+func (c Client) EnableFullTextSearch() error {
+	var exists bool
+	err := c.db.QueryRow(`SELECT COUNT(*) > 0 FROM sqlite_master WHERE name = 'videos_fts'`).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+
+	statements := []string{
+		`CREATE VIRTUAL TABLE videos_fts USING fts4(content="videos", title, description, tags, tokenize=unicode61)`,
+		`CREATE TRIGGER videos_fts_before_update BEFORE UPDATE OF title, description, tags ON videos BEGIN
+			DELETE FROM videos_fts WHERE docid = old.rowid;
+		END`,
+		`CREATE TRIGGER videos_fts_before_delete BEFORE DELETE ON videos BEGIN
+			DELETE FROM videos_fts WHERE docid = old.rowid;
+		END`,
+		`CREATE TRIGGER videos_fts_after_update AFTER UPDATE OF title, description, tags ON videos BEGIN
+			INSERT INTO videos_fts(docid, title, description, tags) VALUES (new.rowid, new.title, new.description, new.tags);
+		END`,
+		`CREATE TRIGGER videos_fts_after_insert AFTER INSERT ON videos BEGIN
+			INSERT INTO videos_fts(docid, title, description, tags) VALUES (new.rowid, new.title, new.description, new.tags);
+		END`,
+		`INSERT INTO videos_fts(videos_fts) VALUES ('rebuild')`,
+	}
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SearchVideos returns the videos matching the query, best first: title
+// matches rank above description matches, which rank above tag and
+// transcript matches. Ties go to the newest video.
+func (c Client) SearchVideos(ctx context.Context, params SearchVideosParams) ([]Video, error) {
+	pattern := "%" + escapeLike(strings.ToLower(params.Query)) + "%"
+	transcriptMatch := "0"
+	var termArgs []any
+	if len(params.Terms) > 0 {
+		transcriptMatch = `id IN (
+			SELECT video_id FROM transcript_terms
+			WHERE term IN (?` + strings.Repeat(", ?", len(params.Terms)-1) + `)
+			GROUP BY video_id
+			HAVING COUNT(*) = ?
+		)`
+		for _, term := range params.Terms {
+			termArgs = append(termArgs, term)
+		}
+		termArgs = append(termArgs, len(params.Terms))
+	}
+
+	scope := "user_id = ?"
+	args := []any{pattern, pattern, pattern}
+	// The transcript match is used twice, for the rank and on its own.
+	args = append(args, termArgs...)
+	args = append(args, termArgs...)
+	scopeArg := any(params.UserID)
+	if params.PublicOnly {
+		scope, scopeArg = "is_public = ?", true
+	}
+	args = append(args, scopeArg)
+
+	match := "search_rank > 0"
+	if params.FullText {
+		match = "(rowid IN (SELECT docid FROM videos_fts WHERE videos_fts MATCH ?) OR in_transcript)"
+		args = append(args, ftsQuery(params.Terms))
+	}
+
+	query := `
+	SELECT` + videoColumns + `
+	FROM (
+		SELECT *,
+			(CASE WHEN lower(title) LIKE ? ESCAPE '\' THEN 4 ELSE 0 END) +
+			(CASE WHEN lower(description) LIKE ? ESCAPE '\' THEN 2 ELSE 0 END) +
+			(CASE WHEN lower(tags) LIKE ? ESCAPE '\' THEN 1 ELSE 0 END) +
+			(CASE WHEN ` + transcriptMatch + ` THEN 1 ELSE 0 END) AS search_rank,
+			` + transcriptMatch + ` AS in_transcript,
+			rowid
+		FROM videos
+		WHERE ` + scope + `
+	)
+	WHERE ` + match + `
+	ORDER BY search_rank DESC, created_at DESC`
+	if params.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, params.Limit, params.Offset)
+	}
+
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
+
+// escapeLike makes s match literally in a LIKE pattern using ESCAPE '\'.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// ftsQuery matches documents containing every term, the last one as a
+// prefix so results show up while the user is still typing. Terms are
+// lowercase letters and digits, so they can't form query operators.
+func ftsQuery(terms []string) string {
+	return strings.Join(terms, " ") + "*"
+}
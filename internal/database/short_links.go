@@ -0,0 +1,81 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ShortLink maps a short code to a video for sharing.
+type ShortLink struct {
+	CreatedAt time.Time `json:"created_at"`
+	Clicks    int64     `json:"clicks"`
+	CreateShortLinkParams
+}
+
+type CreateShortLinkParams struct {
+	Code      string     `json:"code"`
+	VideoID   uuid.UUID  `json:"video_id"`
+	UserID    uuid.UUID  `json:"user_id"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+const shortLinkColumns = `
+	code,
+	created_at,
+	clicks,
+	video_id,
+	user_id,
+	expires_at`
+
+func scanShortLink(row rowScanner) (ShortLink, error) {
+	var link ShortLink
+	err := row.Scan(
+		&link.Code,
+		&link.CreatedAt,
+		&link.Clicks,
+		&link.VideoID,
+		&link.UserID,
+		&link.ExpiresAt,
+	)
+	return link, err
+}
+
+// CreateShortLink fails with a constraint error if the code is taken.
+func (c Client) CreateShortLink(params CreateShortLinkParams) (ShortLink, error) {
+	query := `
+	INSERT INTO short_links (
+		code,
+		created_at,
+		video_id,
+		user_id,
+		expires_at
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, params.Code, params.VideoID, params.UserID, params.ExpiresAt)
+	if err != nil {
+		return ShortLink{}, err
+	}
+
+	return scanShortLink(c.db.QueryRow(`SELECT`+shortLinkColumns+` FROM short_links WHERE code = ?`, params.Code))
+}
+
+// GetShortLink returns an empty ShortLink if no link has that code.
+func (c Client) GetShortLink(code string) (ShortLink, error) {
+	link, err := scanShortLink(c.db.QueryRow(`SELECT`+shortLinkColumns+` FROM short_links WHERE code = ?`, code))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ShortLink{}, nil
+		}
+		return ShortLink{}, err
+	}
+	return link, nil
+}
+
+// RecordShortLinkClick counts one resolution of the link.
+func (c Client) RecordShortLinkClick(code string) error {
+	_, err := c.db.Exec(`UPDATE short_links SET clicks = clicks + 1 WHERE code = ?`, code)
+	return err
+}
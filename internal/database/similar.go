@@ -0,0 +1,94 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// perceptualHashBands is how many two-digit slices of a perceptual hash
+// are indexed. Hashes differing in fewer bits than there are bands must
+// agree on at least one band, so only videos that do need comparing.
+const perceptualHashBands = 8
+
+// MaxSimilarDistance is the furthest apart, in bits, that SimilarVideos
+// can reliably find hashes.
+const MaxSimilarDistance = perceptualHashBands - 1
+
+// perceptualHashBandExpr must match the expression the band indexes are
+// created with exactly, or SQLite won't use them.
+func perceptualHashBandExpr(band int) string {
+	return fmt.Sprintf("substr(perceptual_hash, %d, 2)", band*2+1)
+}
+
+type SimilarVideo struct {
+	Video
+	// Distance is how many bits the perceptual hashes differ by.
+	Distance int `json:"distance"`
+}
+
+type SimilarVideosParams struct {
+	UserID uuid.UUID
+	// VideoID is the video being matched, which is left out.
+	VideoID uuid.UUID
+	Hash    string
+	// MaxDistance is at most MaxSimilarDistance.
+	MaxDistance int
+}
+
+// SimilarVideos returns the user's videos whose perceptual hash is within
+// MaxDistance bits of Hash, closest first.
+func (c Client) SimilarVideos(ctx context.Context, params SimilarVideosParams) ([]SimilarVideo, error) {
+	target, err := strconv.ParseUint(params.Hash, 16, 64)
+	if err != nil || len(params.Hash) != 16 {
+		return nil, fmt.Errorf("invalid perceptual hash %q", params.Hash)
+	}
+
+	bands := make([]string, perceptualHashBands)
+	args := []any{params.UserID, params.VideoID}
+	for band := range bands {
+		bands[band] = perceptualHashBandExpr(band) + " = ?"
+		args = append(args, params.Hash[band*2:band*2+2])
+	}
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE perceptual_hash IS NOT NULL
+		AND user_id = ?
+		AND id != ?
+		AND (` + strings.Join(bands, " OR ") + `)
+	ORDER BY created_at DESC`
+
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	similar := []SimilarVideo{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		hash, err := strconv.ParseUint(*video.PerceptualHash, 16, 64)
+		if err != nil {
+			continue
+		}
+		if distance := bits.OnesCount64(target ^ hash); distance <= params.MaxDistance {
+			similar = append(similar, SimilarVideo{Video: video, Distance: distance})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(similar, func(i, j int) bool {
+		return similar[i].Distance < similar[j].Distance
+	})
+	return similar, nil
+}
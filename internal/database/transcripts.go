@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Transcript is the spoken content of a video, as uploaded or generated.
+// Content is kept in its original format so cue timings survive.
+type Transcript struct {
+	VideoID   uuid.UUID `json:"video_id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Source is "upload" or "transcriber".
+	Source  string `json:"source"`
+	Format  string `json:"format"`
+	Content string `json:"content"`
+}
+
+// SetTranscript stores the video's transcript, replacing any previous one,
+// and indexes it under terms for SearchVideos.
+func (c Client) SetTranscript(ctx context.Context, transcript Transcript, terms []string) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO transcripts (video_id, created_at, updated_at, source, format, content)
+	VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+	ON CONFLICT(video_id) DO UPDATE SET
+		updated_at = CURRENT_TIMESTAMP,
+		source = excluded.source,
+		format = excluded.format,
+		content = excluded.content
+	`
+	if _, err := tx.ExecContext(ctx, query, transcript.VideoID, transcript.Source, transcript.Format, transcript.Content); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM transcript_terms WHERE video_id = ?`, transcript.VideoID); err != nil {
+		return err
+	}
+	insert, err := tx.PrepareContext(ctx, `INSERT INTO transcript_terms (term, video_id) VALUES (?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	for _, term := range terms {
+		if _, err := insert.ExecContext(ctx, term, transcript.VideoID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetTranscript returns an empty Transcript if the video has none.
+func (c Client) GetTranscript(ctx context.Context, videoID uuid.UUID) (Transcript, error) {
+	query := `
+	SELECT video_id, created_at, updated_at, source, format, content
+	FROM transcripts
+	WHERE video_id = ?
+	`
+	var t Transcript
+	err := c.db.QueryRowContext(ctx, query, videoID).Scan(&t.VideoID, &t.CreatedAt, &t.UpdatedAt, &t.Source, &t.Format, &t.Content)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Transcript{}, nil
+	}
+	return t, err
+}
@@ -0,0 +1,209 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// StringList is stored as a JSON array in a TEXT column.
+type StringList []string
+
+func (l *StringList) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*l = StringList{}
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), l)
+	case []byte:
+		return json.Unmarshal(v, l)
+	default:
+		return fmt.Errorf("cannot scan %T into StringList", src)
+	}
+}
+
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		l = StringList{}
+	}
+	dat, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	return string(dat), nil
+}
+
+// StringMap is stored as a JSON object in a TEXT column.
+type StringMap map[string]string
+
+func (m *StringMap) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*m = StringMap{}
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), m)
+	case []byte:
+		return json.Unmarshal(v, m)
+	default:
+		return fmt.Errorf("cannot scan %T into StringMap", src)
+	}
+}
+
+func (m StringMap) Value() (driver.Value, error) {
+	if m == nil {
+		m = StringMap{}
+	}
+	dat, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(dat), nil
+}
+
+// Chapter is a named section of a video, in seconds from the start.
+type Chapter struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Title string  `json:"title"`
+}
+
+// ChapterList is stored as a JSON array in a TEXT column.
+type ChapterList []Chapter
+
+func (l *ChapterList) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*l = ChapterList{}
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), l)
+	case []byte:
+		return json.Unmarshal(v, l)
+	default:
+		return fmt.Errorf("cannot scan %T into ChapterList", src)
+	}
+}
+
+func (l ChapterList) Value() (driver.Value, error) {
+	if l == nil {
+		l = ChapterList{}
+	}
+	dat, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	return string(dat), nil
+}
+
+// VideoVariant is an extra rendition of a video at a lower resolution, for
+// players to offer as a quality choice.
+type VideoVariant struct {
+	Height int    `json:"height"`
+	URL    string `json:"url"`
+}
+
+// VariantList is stored as a JSON array in a TEXT column.
+type VariantList []VideoVariant
+
+func (l *VariantList) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*l = VariantList{}
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), l)
+	case []byte:
+		return json.Unmarshal(v, l)
+	default:
+		return fmt.Errorf("cannot scan %T into VariantList", src)
+	}
+}
+
+func (l VariantList) Value() (driver.Value, error) {
+	if l == nil {
+		l = VariantList{}
+	}
+	dat, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	return string(dat), nil
+}
+
+// AudioNormalization records the loudnorm pass applied to a video's audio:
+// the targets it aimed for and what it measured beforehand, all in LUFS,
+// dBTP or LU. It's stored as a JSON object in a TEXT column.
+type AudioNormalization struct {
+	TargetI      float64 `json:"target_i"`
+	TargetTP     float64 `json:"target_tp"`
+	TargetLRA    float64 `json:"target_lra"`
+	InputI       float64 `json:"input_i"`
+	InputTP      float64 `json:"input_tp"`
+	InputLRA     float64 `json:"input_lra"`
+	InputThresh  float64 `json:"input_thresh"`
+	TargetOffset float64 `json:"target_offset"`
+}
+
+func (n *AudioNormalization) Scan(src any) error {
+	switch v := src.(type) {
+	case string:
+		return json.Unmarshal([]byte(v), n)
+	case []byte:
+		return json.Unmarshal(v, n)
+	default:
+		return fmt.Errorf("cannot scan %T into AudioNormalization", src)
+	}
+}
+
+func (n AudioNormalization) Value() (driver.Value, error) {
+	dat, err := json.Marshal(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(dat), nil
+}
+
+// ByteRange is a span of an object, in bytes.
+type ByteRange struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// HLSSegment is one fragment of a fragmented MP4.
+type HLSSegment struct {
+	Duration float64   `json:"duration"`
+	Range    ByteRange `json:"range"`
+	// Keyframe spans the fragment from its start through its leading
+	// keyframe, which is all an I-frame playlist needs of it.
+	Keyframe ByteRange `json:"keyframe"`
+}
+
+// HLSIndex locates the fragments of a fragmented MP4 so HLS playlists can
+// address them by byte range in the one object. It's stored as a JSON
+// object in a TEXT column.
+type HLSIndex struct {
+	// Init is the ftyp and moov boxes every fragment needs.
+	Init     ByteRange    `json:"init"`
+	Segments []HLSSegment `json:"segments"`
+}
+
+func (x *HLSIndex) Scan(src any) error {
+	switch v := src.(type) {
+	case string:
+		return json.Unmarshal([]byte(v), x)
+	case []byte:
+		return json.Unmarshal(v, x)
+	default:
+		return fmt.Errorf("cannot scan %T into HLSIndex", src)
+	}
+}
+
+func (x HLSIndex) Value() (driver.Value, error) {
+	dat, err := json.Marshal(x)
+	if err != nil {
+		return nil, err
+	}
+	return string(dat), nil
+}
@@ -1,19 +1,79 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 type Video struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
+	ID               uuid.UUID   `json:"id"`
+	CreatedAt        time.Time   `json:"created_at"`
+	UpdatedAt        time.Time   `json:"updated_at"`
+	ThumbnailURL     *string     `json:"thumbnail_url"`
+	DominantColor    *string     `json:"dominant_color"`
+	VideoURL         *string     `json:"video_url"`
+	VideoETag        *string     `json:"video_etag"`
+	VideoModifiedAt  *time.Time  `json:"video_last_modified"`
+	Faststart        bool        `json:"faststart"`
+	Variants         VariantList `json:"variants"`
+	ViewCount        int64       `json:"view_count"`
+	LastViewedAt     *time.Time  `json:"last_viewed_at"`
+	AspectRatio      *string     `json:"aspect_ratio"`
+	EmbedOrigins     StringList  `json:"embed_origins"`
+	PreviewURL       *string     `json:"preview_url"`
+	IsPublic         bool        `json:"is_public"`
+	DownloadAllowed  bool        `json:"download_allowed"`
+	OriginalURL      *string     `json:"original_url"`
+	Tags             StringMap   `json:"tags"`
+	OriginalFilename *string     `json:"original_filename"`
+	FolderID         *uuid.UUID  `json:"folder_id"`
+	S3Bucket         *string     `json:"s3_bucket"`
+	PixelFormat      *string     `json:"pix_fmt"`
+	ColorTransfer    *string     `json:"color_transfer"`
+	StorageMissing   bool        `json:"storage_missing"`
+	Chapters         ChapterList `json:"chapters"`
+	ContactSheetURL  *string     `json:"contact_sheet_url"`
+	// ThumbnailsVTTURL is the WebVTT track mapping playback times to tiles
+	// of the contact sheet, for players' scrubbing previews.
+	ThumbnailsVTTURL *string `json:"thumbnails_vtt_url"`
+	// AudioStatus is "ok", "none" for no audio stream or "silent", and
+	// nil until the audio has been checked.
+	AudioStatus        *string             `json:"audio_status"`
+	AudioNormalization *AudioNormalization `json:"audio_normalization"`
+	// PerceptualHash is a difference hash of the thumbnail as 16 hex
+	// digits, for finding near-duplicates. It's nil unless the deployment
+	// computes them.
+	PerceptualHash *string `json:"perceptual_hash"`
+	// OriginalStorageClass is the S3 storage class OriginalURL was stored
+	// in, nil for the bucket default. Archive classes have to be restored
+	// before the original can be read.
+	OriginalStorageClass *string `json:"original_storage_class"`
+	// DurationSeconds and VideoSize describe the published file. Both are
+	// nil for videos published before they were recorded.
+	DurationSeconds *float64 `json:"duration_seconds"`
+	VideoSize       *int64   `json:"video_size"`
+	// HLSURL is a fragmented copy of the video that HLS playlists address
+	// by byte range, and HLSIndex where its fragments are. Both are nil
+	// unless the deployment makes them. Clients get the playlists from
+	// the API rather than these.
+	HLSURL   *string   `json:"-"`
+	HLSIndex *HLSIndex `json:"-"`
+	// Version goes up with every update, so a writer can tell whether the
+	// video changed since it read it.
+	Version int64 `json:"version"`
+	// ThumbnailIsDefault is set on responses that substitute the
+	// deployment's placeholder for a missing thumbnail. It isn't stored.
+	ThumbnailIsDefault bool `json:"thumbnail_is_default"`
+	// Transcript is set on single-video responses when the video has one.
+	// It's stored in its own table.
+	Transcript *Transcript `json:"transcript,omitempty"`
 	CreateVideoParams
 }
 
@@ -23,23 +83,178 @@ type CreateVideoParams struct {
 	UserID      uuid.UUID `json:"user_id"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
+var tagKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ValidTagKey reports whether key may be used as a tag key. Keys are
+// restricted so they can be inlined into JSON paths and index names.
+func ValidTagKey(key string) bool {
+	return tagKeyPattern.MatchString(key)
+}
+
+// tagExpr must match the expression used by CreateTagIndex exactly, or
+// SQLite won't use the index.
+func tagExpr(key string) string {
+	return fmt.Sprintf("json_extract(tags, '$.%s')", key)
+}
+
+// CreateTagIndex adds an expression index for a frequently queried tag key.
+func (c Client) CreateTagIndex(key string) error {
+	if !ValidTagKey(key) {
+		return fmt.Errorf("invalid tag key %q", key)
+	}
+	query := fmt.Sprintf(
+		"CREATE INDEX IF NOT EXISTS idx_videos_tag_%s ON videos(%s)",
+		strings.ReplaceAll(key, "-", "_"),
+		tagExpr(key),
+	)
+	_, err := c.db.Exec(query)
+	return err
+}
+
+const videoColumns = `
 		id,
 		created_at,
 		updated_at,
 		title,
 		description,
 		thumbnail_url,
+		dominant_color,
+		perceptual_hash,
 		video_url,
-		user_id
+		video_etag,
+		video_last_modified,
+		duration_seconds,
+		video_size,
+		hls_url,
+		hls_index,
+		faststart,
+		variants,
+		view_count,
+		last_viewed_at,
+		aspect_ratio,
+		embed_origins,
+		preview_url,
+		is_public,
+		download_allowed,
+		original_url,
+		original_storage_class,
+		tags,
+		original_filename,
+		folder_id,
+		s3_bucket,
+		pix_fmt,
+		color_transfer,
+		storage_missing,
+		chapters,
+		contact_sheet_url,
+		thumbnails_vtt_url,
+		audio_status,
+		audio_normalization,
+		version,
+		user_id`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanVideo(row rowScanner) (Video, error) {
+	var video Video
+	err := row.Scan(
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.DominantColor,
+		&video.PerceptualHash,
+		&video.VideoURL,
+		&video.VideoETag,
+		&video.VideoModifiedAt,
+		&video.DurationSeconds,
+		&video.VideoSize,
+		&video.HLSURL,
+		&video.HLSIndex,
+		&video.Faststart,
+		&video.Variants,
+		&video.ViewCount,
+		&video.LastViewedAt,
+		&video.AspectRatio,
+		&video.EmbedOrigins,
+		&video.PreviewURL,
+		&video.IsPublic,
+		&video.DownloadAllowed,
+		&video.OriginalURL,
+		&video.OriginalStorageClass,
+		&video.Tags,
+		&video.OriginalFilename,
+		&video.FolderID,
+		&video.S3Bucket,
+		&video.PixelFormat,
+		&video.ColorTransfer,
+		&video.StorageMissing,
+		&video.Chapters,
+		&video.ContactSheetURL,
+		&video.ThumbnailsVTTURL,
+		&video.AudioStatus,
+		&video.AudioNormalization,
+		&video.Version,
+		&video.UserID,
+	)
+	return video, err
+}
+
+func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
+	return c.ListVideos(ListVideosParams{UserID: userID})
+}
+
+type ListVideosParams struct {
+	UserID uuid.UUID
+	// AspectRatio filters to one classification when set.
+	AspectRatio string
+	// Tags filters to videos having every one of these key/value pairs.
+	Tags map[string]string
+	// FolderID filters to one folder when set.
+	FolderID *uuid.UUID
+	// Published keeps to public videos that have been uploaded.
+	Published bool
+	// Limit of 0 returns every matching video.
+	Limit  int
+	Offset int
+}
+
+func (c Client) ListVideos(params ListVideosParams) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
 	FROM videos
 	WHERE user_id = ?
-	ORDER BY created_at DESC
 	`
+	args := []any{params.UserID}
+	if params.AspectRatio != "" {
+		query += " AND aspect_ratio = ?"
+		args = append(args, params.AspectRatio)
+	}
+	if params.FolderID != nil {
+		query += " AND folder_id = ?"
+		args = append(args, *params.FolderID)
+	}
+	if params.Published {
+		query += " AND is_public = 1 AND video_url IS NOT NULL"
+	}
+	for key, value := range params.Tags {
+		if !ValidTagKey(key) {
+			return nil, fmt.Errorf("invalid tag key %q", key)
+		}
+		query += " AND " + tagExpr(key) + " = ?"
+		args = append(args, value)
+	}
+	query += " ORDER BY created_at DESC"
+	if params.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, params.Limit, params.Offset)
+	}
 
-	rows, err := c.db.Query(query, userID)
+	rows, err := c.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -47,23 +262,14 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
 	}
 
-	return videos, nil
+	return videos, rows.Err()
 }
 
 func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
@@ -87,30 +293,17 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 }
 
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
+	return c.GetVideoContext(context.Background(), id)
+}
+
+func (c Client) GetVideoContext(ctx context.Context, id uuid.UUID) (Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT` + videoColumns + `
 	FROM videos
 	WHERE id = ?
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.db.QueryRowContext(ctx, query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -121,31 +314,340 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	return video, nil
 }
 
+// UpdateVideo deliberately leaves view_count and last_viewed_at alone so that a metadata edit
+// can't overwrite increments that landed while the handler was running.
 func (c Client) UpdateVideo(video Video) error {
+	return c.UpdateVideoContext(context.Background(), video)
+}
+
+func (c Client) UpdateVideoContext(ctx context.Context, video Video) error {
+	_, err := c.updateVideo(ctx, video, false)
+	return err
+}
+
+// ErrVideoConflict means the video was updated by someone else since it was
+// read.
+var ErrVideoConflict = errors.New("video was modified concurrently")
+
+// UpdateVideoIfUnchanged is UpdateVideoContext that only applies if the
+// stored version still matches video.Version, returning ErrVideoConflict
+// otherwise.
+func (c Client) UpdateVideoIfUnchanged(ctx context.Context, video Video) error {
+	n, err := c.updateVideo(ctx, video, true)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrVideoConflict
+	}
+	return nil
+}
+
+func (c Client) updateVideo(ctx context.Context, video Video, checkVersion bool) (int64, error) {
 	query := `
 	UPDATE videos
 	SET
 		title = ?,
 		description = ?,
 		thumbnail_url = ?,
+		dominant_color = ?,
+		perceptual_hash = ?,
 		video_url = ?,
+		video_etag = ?,
+		video_last_modified = ?,
+		duration_seconds = ?,
+		video_size = ?,
+		hls_url = ?,
+		hls_index = ?,
+		faststart = ?,
+		variants = ?,
+		aspect_ratio = ?,
+		embed_origins = ?,
+		preview_url = ?,
+		is_public = ?,
+		download_allowed = ?,
+		original_url = ?,
+		original_storage_class = ?,
+		tags = ?,
+		original_filename = ?,
+		folder_id = ?,
+		s3_bucket = ?,
+		pix_fmt = ?,
+		color_transfer = ?,
+		storage_missing = ?,
+		chapters = ?,
+		contact_sheet_url = ?,
+		thumbnails_vtt_url = ?,
+		audio_status = ?,
+		audio_normalization = ?,
+		version = version + 1,
 		user_id = ?
 	WHERE id = ?
 	`
-
-	_, err := c.db.Exec(
-		query,
+	args := []any{
 		video.Title,
 		video.Description,
 		&video.ThumbnailURL,
+		video.DominantColor,
+		video.PerceptualHash,
 		&video.VideoURL,
+		video.VideoETag,
+		video.VideoModifiedAt,
+		video.DurationSeconds,
+		video.VideoSize,
+		video.HLSURL,
+		video.HLSIndex,
+		video.Faststart,
+		video.Variants,
+		video.AspectRatio,
+		video.EmbedOrigins,
+		video.PreviewURL,
+		video.IsPublic,
+		video.DownloadAllowed,
+		video.OriginalURL,
+		video.OriginalStorageClass,
+		video.Tags,
+		video.OriginalFilename,
+		video.FolderID,
+		video.S3Bucket,
+		video.PixelFormat,
+		video.ColorTransfer,
+		video.StorageMissing,
+		video.Chapters,
+		video.ContactSheetURL,
+		video.ThumbnailsVTTURL,
+		video.AudioStatus,
+		video.AudioNormalization,
 		video.UserID,
 		video.ID,
-	)
+	}
+	if checkVersion {
+		query += " AND version = ?"
+		args = append(args, video.Version)
+	}
+
+	result, err := c.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetVideosMissingAspectRatio pages through uploaded videos that have no
+// recorded aspect ratio, ordered by ID so a backfill can resume after the
+// last ID it handled.
+func (c Client) GetVideosMissingAspectRatio(after uuid.UUID, limit int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE aspect_ratio IS NULL
+		AND video_url IS NOT NULL
+		AND id > ?
+	ORDER BY id
+	LIMIT ?
+	`
+
+	rows, err := c.db.Query(query, after.String(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+
+	return videos, rows.Err()
+}
+
+// GetVideosWithAspectRatio pages through uploaded videos that have a
+// recorded aspect ratio, ordered by ID like GetVideosMissingAspectRatio.
+func (c Client) GetVideosWithAspectRatio(after uuid.UUID, limit int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE aspect_ratio IS NOT NULL
+		AND video_url IS NOT NULL
+		AND id > ?
+	ORDER BY id
+	LIMIT ?
+	`
+
+	rows, err := c.db.Query(query, after.String(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+
+	return videos, rows.Err()
+}
+
+// GetVideosMissingThumbnail pages through uploaded videos without a
+// thumbnail, ordered by ID like GetVideosMissingAspectRatio.
+func (c Client) GetVideosMissingThumbnail(after uuid.UUID, limit int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE thumbnail_url IS NULL
+		AND video_url IS NOT NULL
+		AND id > ?
+	ORDER BY id
+	LIMIT ?
+	`
+
+	rows, err := c.db.Query(query, after.String(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+
+	return videos, rows.Err()
+}
+
+// CountVideosMissingThumbnail counts the uploaded videos without a
+// thumbnail, for reporting a backfill's progress.
+func (c Client) CountVideosMissingThumbnail() (int, error) {
+	var n int
+	err := c.db.QueryRow(`
+	SELECT COUNT(*)
+	FROM videos
+	WHERE thumbnail_url IS NULL
+		AND video_url IS NOT NULL
+	`).Scan(&n)
+	return n, err
+}
+
+// GetVideosWithObjects pages through videos that reference at least one
+// stored object, ordered by ID like GetVideosMissingAspectRatio.
+func (c Client) GetVideosWithObjects(after uuid.UUID, limit int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE (video_url IS NOT NULL
+		OR thumbnail_url IS NOT NULL
+		OR preview_url IS NOT NULL
+		OR original_url IS NOT NULL
+		OR contact_sheet_url IS NOT NULL
+		OR thumbnails_vtt_url IS NOT NULL
+		OR hls_url IS NOT NULL
+		OR variants != '[]')
+		AND id > ?
+	ORDER BY id
+	LIMIT ?
+	`
+
+	rows, err := c.db.Query(query, after.String(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+
+	return videos, rows.Err()
+}
+
+// GetReferencedObjectURLs returns every stored URL that points at an
+// uploaded object, so storage cleanup can tell which objects are orphaned.
+func (c Client) GetReferencedObjectURLs() ([]string, error) {
+	query := `
+	SELECT video_url FROM videos WHERE video_url IS NOT NULL
+	UNION
+	SELECT thumbnail_url FROM videos WHERE thumbnail_url IS NOT NULL
+	UNION
+	SELECT preview_url FROM videos WHERE preview_url IS NOT NULL
+	UNION
+	SELECT original_url FROM videos WHERE original_url IS NOT NULL
+	UNION
+	SELECT contact_sheet_url FROM videos WHERE contact_sheet_url IS NOT NULL
+	UNION
+	SELECT thumbnails_vtt_url FROM videos WHERE thumbnails_vtt_url IS NOT NULL
+	UNION
+	SELECT hls_url FROM videos WHERE hls_url IS NOT NULL
+	UNION
+	SELECT json_extract(variant.value, '$.url') FROM videos, json_each(videos.variants) AS variant
+	`
+
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	urls := []string{}
+	for rows.Next() {
+		var url string
+		if err := rows.Scan(&url); err != nil {
+			return nil, err
+		}
+		urls = append(urls, url)
+	}
+
+	return urls, rows.Err()
+}
+
+// IncrementViewCount adds by to the stored view count and marks the video
+// as viewed now. Callers batch views in memory and flush them here so
+// playback never waits on a write.
+func (c Client) IncrementViewCount(id uuid.UUID, by int64) error {
+	query := `
+	UPDATE videos
+	SET view_count = view_count + ?, last_viewed_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, by, id)
+	return err
+}
+
+// SetVideoObjectInfo caches the ETag and Last-Modified of the video's
+// object. Like IncrementViewCount it leaves version alone, since it
+// records what's in storage rather than editing the video.
+func (c Client) SetVideoObjectInfo(ctx context.Context, id uuid.UUID, etag *string, lastModified *time.Time) error {
+	query := `
+	UPDATE videos
+	SET video_etag = ?, video_last_modified = ?
+	WHERE id = ?
+	`
+	_, err := c.db.ExecContext(ctx, query, etag, lastModified, id)
 	return err
 }
 
 func (c Client) DeleteVideo(id uuid.UUID) error {
+	for _, table := range []string{"short_links", "transcripts", "transcript_terms"} {
+		if _, err := c.db.Exec(`DELETE FROM `+table+` WHERE video_id = ?`, id); err != nil {
+			return err
+		}
+	}
 	query := `
 	DELETE FROM videos
 	WHERE id = ?
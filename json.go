@@ -1,24 +1,59 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"log"
 	"net/http"
 )
 
+// exposeErrorDetails adds the internal error to error responses. It's for
+// development only: the text can include file paths, SQL or ffmpeg output.
+// Otherwise clients get just the message and a correlation ID to quote,
+// which finds the full error in the logs.
+var exposeErrorDetails bool
+
 func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
+	id := newCorrelationID()
 	if err != nil {
-		log.Println(err)
+		log.Printf("[%s] %s: %v", id, msg, err)
 	}
 	if code > 499 {
-		log.Printf("Responding with 5XX error: %s", msg)
+		log.Printf("[%s] Responding with 5XX error: %s", id, msg)
 	}
 	type errorResponse struct {
-		Error string `json:"error"`
+		Error         string `json:"error"`
+		CorrelationID string `json:"correlation_id"`
+		Detail        string `json:"detail,omitempty"`
+	}
+	resp := errorResponse{
+		Error:         msg,
+		CorrelationID: id,
+	}
+	if exposeErrorDetails && err != nil {
+		resp.Detail = err.Error()
+	}
+	w.Header().Set("X-Correlation-ID", id)
+	respondWithJSON(w, code, resp)
+}
+
+// errorDetail is how an internal error is reported inside a successful
+// response, such as one item of a batch: the error itself when details are
+// exposed, otherwise a reference to where it was logged.
+func errorDetail(err error) string {
+	if exposeErrorDetails {
+		return err.Error()
 	}
-	respondWithJSON(w, code, errorResponse{
-		Error: msg,
-	})
+	id := newCorrelationID()
+	log.Printf("[%s] %v", id, err)
+	return "internal error, correlation ID " + id
+}
+
+func newCorrelationID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
 }
 
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+// jwksMaxAge is how long verifiers may cache the key set. A new signing
+// key should be published at least this long before it's moved first.
+const jwksMaxAge = 300
+
+// loadRSAKeys reads the JWT_RSA_KEYS PEM files. The first signs new
+// tokens, so it has to be a private key.
+func loadRSAKeys(key string, paths []string) ([]auth.RSAKey, error) {
+	keys := []auth.RSAKey{}
+	seen := map[string]bool{}
+	for i, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		rsaKey, err := auth.ParseRSAKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", key, path, err)
+		}
+		if i == 0 && rsaKey.Private == nil {
+			return nil, fmt.Errorf("%s: %s signs new tokens so it must be a private key", key, path)
+		}
+		if seen[rsaKey.ID] {
+			return nil, fmt.Errorf("%s lists the key in %s more than once", key, path)
+		}
+		seen[rsaKey.ID] = true
+		keys = append(keys, rsaKey)
+	}
+	return keys, nil
+}
+
+// jwtKeys are the keys access tokens are signed and verified with.
+func (cfg *apiConfig) jwtKeys() auth.Keys {
+	return auth.Keys{Secrets: cfg.jwtSecrets, RSA: cfg.jwtRSAKeys}
+}
+
+// handlerJWKS publishes the public JWT_RSA_KEYS so other services can
+// verify our tokens, matching them by the kid in the token header. The
+// set is empty when tokens are signed HS256, since those secrets can't be
+// shared.
+func (cfg *apiConfig) handlerJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", jwksMaxAge))
+	respondWithJSON(w, http.StatusOK, cfg.jwtKeys().JWKS())
+}
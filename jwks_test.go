@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func writeTestRSAKey(t *testing.T, public bool) string {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := &pem.Block{Type: "PRIVATE KEY"}
+	if public {
+		block.Type = "PUBLIC KEY"
+		block.Bytes, err = x509.MarshalPKIXPublicKey(&key.PublicKey)
+	} else {
+		block.Bytes, err = x509.MarshalPKCS8PrivateKey(key)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadRSAKeys(t *testing.T) {
+	private, public := writeTestRSAKey(t, false), writeTestRSAKey(t, true)
+	keys, err := loadRSAKeys("JWT_RSA_KEYS", []string{private, public})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].Private == nil || keys[1].Private != nil {
+		t.Errorf("keys = %+v, want a private then a public key", keys)
+	}
+
+	for name, paths := range map[string][]string{
+		"public key first": {public, private},
+		"duplicate key":    {private, private},
+		"missing file":     {filepath.Join(t.TempDir(), "missing.pem")},
+	} {
+		if _, err := loadRSAKeys("JWT_RSA_KEYS", paths); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestHandlerJWKS(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	keys, err := loadRSAKeys("JWT_RSA_KEYS", []string{writeTestRSAKey(t, false), writeTestRSAKey(t, true)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.jwtRSAKeys = keys
+	user, token := createTestUser(t, cfg)
+
+	rec := httptest.NewRecorder()
+	cfg.handlerJWKS(rec, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Cache-Control"), "public") {
+		t.Fatalf("status = %d, Cache-Control %q", rec.Code, rec.Header().Get("Cache-Control"))
+	}
+	var set auth.JWKS
+	if err := json.Unmarshal(rec.Body.Bytes(), &set); err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Keys) != 2 {
+		t.Fatalf("published %d keys, want both", len(set.Keys))
+	}
+
+	// The token createTestUser issued names the first key.
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := parsed.Header["kid"]; kid != set.Keys[0].Kid || set.Keys[0].Alg != "RS256" {
+		t.Errorf("token kid = %v, want the first published key %+v", kid, set.Keys[0])
+	}
+	if got, err := cfg.validateJWT(token); err != nil || got != user.ID {
+		t.Errorf("validateJWT = %s, %v; want %s", got, err, user.ID)
+	}
+
+	// Tokens issued before the switch to RS256 keep working.
+	old, err := auth.MakeJWT(user.ID, testJWTSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.validateJWT(old); err != nil {
+		t.Errorf("HS256 token rejected: %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+var errInactiveUser = errors.New("token subject no longer exists or is disabled")
+
+// validateJWT checks the token's signature and claims and, when subject
+// verification is enabled, that its user still exists and isn't disabled.
+// Without the lookup a deleted or banned account keeps working until its
+// token expires.
+func (cfg *apiConfig) validateJWT(token string) (uuid.UUID, error) {
+	userID, err := cfg.jwtKeys().Validate(token)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if cfg.userStatus == nil {
+		return userID, nil
+	}
+
+	active, err := cfg.userStatus.active(userID, cfg.db.GetUser)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if !active {
+		return uuid.Nil, errInactiveUser
+	}
+	return userID, nil
+}
+
+// userStatusCache remembers whether a user is active for ttl so that
+// authenticated requests don't each cost a users query.
+type userStatusCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[uuid.UUID]userStatus
+}
+
+type userStatus struct {
+	active  bool
+	expires time.Time
+}
+
+func newUserStatusCache(ttl time.Duration) *userStatusCache {
+	return &userStatusCache{ttl: ttl, entries: map[uuid.UUID]userStatus{}}
+}
+
+func (c *userStatusCache) active(userID uuid.UUID, getUser func(uuid.UUID) (*database.User, error)) (bool, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[userID]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.active, nil
+	}
+
+	user, err := getUser(userID)
+	if err != nil {
+		return false, err
+	}
+	active := user != nil && !user.Disabled
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for id, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, id)
+		}
+	}
+	c.entries[userID] = userStatus{active: active, expires: now.Add(c.ttl)}
+	return active, nil
+}
@@ -2,10 +2,15 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
 
@@ -17,16 +22,75 @@ import (
 )
 
 type apiConfig struct {
-	db               database.Client
-	jwtSecret        string
-	platform         string
-	filepathRoot     string
-	assetsRoot       string
-	s3Bucket         string
-	s3Region         string
-	s3CfDistribution string
-	s3Client         *s3.Client
-	port             string
+	db                database.Client
+	dbPolicy          dbPolicy
+	jwtSecrets        []string
+	jwtRSAKeys        []auth.RSAKey
+	platform          string
+	filepathRoot      string
+	assetsRoot        string
+	s3Bucket          string
+	s3Region          string
+	s3CfDistribution  string
+	s3Client          s3API
+	s3Presigner       s3Presigner
+	s3Buckets         map[string]s3BucketTarget
+	s3AspectBuckets   map[string]string
+	s3VerifyUploads   bool
+	s3VerifyDownloads string
+	s3KeyPrefix       string
+	s3FolderKeys      bool
+	s3UserKeys        bool
+	s3KeyScheme       string
+	// aspectKeySegments maps each aspect ratio to the key directory its
+	// videos are filed under.
+	aspectKeySegments map[string]string
+	s3Cleanup         s3CleanupConfig
+	deleteObjects     bool
+	ffmpeg            ffmpegLimits
+	adminUserIDs      map[uuid.UUID]bool
+	adminSignature    *adminSignatureConfig
+	preview           previewConfig
+	contactSheet      contactSheetConfig
+	clips             clipConfig
+	allowedVideoTypes []string
+	allowedImageTypes []string
+	uploadLimits      uploadLimits
+	uploadPolicies    []uploadPolicy
+	userTiers         map[uuid.UUID]string
+	uploadFields      uploadFieldNames
+	diskFreeFactor    float64
+	uploadsPerConn    int
+	thumbnailStore    thumbnailStoreConfig
+	mediaURLKey       []byte
+	mediaURLExpiry    time.Duration
+	transcode         transcodeConfig
+	watermark         watermarkConfig
+	audio             audioConfig
+	colorMode         string
+	aspectRatioStrict bool
+	faststartFallback bool
+	outputFormat      outputFormat
+	downloadURLExpiry time.Duration
+	streamURLExpiry   time.Duration
+	slowRequests      routeDurations
+	requestTimeouts   routeDurations
+	faststartCache    *faststartCache
+	userStatus        *userStatusCache
+	uploads           *uploadSessionStore
+	storageUsage      storageUsageConfig
+	storageIndex      *storageIndex
+	tempFiles         *tempJanitor
+	defaultThumbnail  string
+	auditLog          *auditLogger
+	port              string
+	publicBaseURL     string
+	videoIDVersion    int
+	views             *viewCounter
+	transcriber       Transcriber
+	searchBackend     string
+	// contentTypeRepair is nil unless CONTENT_TYPE_AUTO_REPAIR is on.
+	contentTypeRepair *contentTypeRepairer
 }
 
 type thumbnail struct {
@@ -49,15 +113,68 @@ func main() {
 		log.Fatalf("Couldn't connect to database: %v", err)
 	}
 
-	jwtSecret := os.Getenv("JWT_SECRET")
+	dbPolicy := dbPolicy{
+		timeout: envDuration("DB_TIMEOUT", 5*time.Second),
+		retries: envInt("DB_RETRIES", 3),
+		backoff: 50 * time.Millisecond,
+	}
+	// A zero timeout would cancel every query before it starts.
+	if dbPolicy.timeout <= 0 {
+		log.Fatal("DB_TIMEOUT must be greater than 0")
+	}
+	if dbPolicy.retries < 0 {
+		log.Fatal("DB_RETRIES must not be negative")
+	}
+
+	for _, key := range envList("INDEXED_TAG_KEYS", nil) {
+		if err := db.CreateTagIndex(key); err != nil {
+			log.Fatalf("Couldn't index tag %q: %v", key, err)
+		}
+	}
+
+	searchBackend := os.Getenv("SEARCH_BACKEND")
+	switch searchBackend {
+	case "":
+		searchBackend = searchBackendLike
+	case searchBackendLike:
+	case searchBackendFullText:
+		if err := db.EnableFullTextSearch(); err != nil {
+			log.Fatalf("Couldn't create the full-text search index: %v", err)
+		}
+	default:
+		log.Fatalf("SEARCH_BACKEND must be %q or %q", searchBackendLike, searchBackendFullText)
+	}
+
+	// Secrets are often pasted or mounted from files with a trailing
+	// newline, which would otherwise silently become part of the key.
+	// JWT_SECRET signs; previous secrets are still accepted during a
+	// rotation. Secrets may contain any character, so JWT_SECRET is never
+	// split and the previous ones go one per line.
+	jwtSecret := strings.TrimSpace(os.Getenv("JWT_SECRET"))
 	if jwtSecret == "" {
 		log.Fatal("JWT_SECRET environment variable is not set")
 	}
+	minLen := envInt("JWT_SECRET_MIN_LENGTH", 32)
+	if len(jwtSecret) < minLen {
+		log.Fatalf("JWT_SECRET is %d bytes but must be at least %d; generate one with `openssl rand -base64 64`", len(jwtSecret), minLen)
+	}
+	jwtSecrets := []string{jwtSecret}
+	for i, secret := range envLines("JWT_PREVIOUS_SECRETS") {
+		if len(secret) < minLen {
+			log.Fatalf("JWT_PREVIOUS_SECRETS line %d is %d bytes but must be at least %d", i+1, len(secret), minLen)
+		}
+		jwtSecrets = append(jwtSecrets, secret)
+	}
+	jwtRSAKeys, err := loadRSAKeys("JWT_RSA_KEYS", envList("JWT_RSA_KEYS", nil))
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	platform := os.Getenv("PLATFORM")
 	if platform == "" {
 		log.Fatal("PLATFORM environment variable is not set")
 	}
+	exposeErrorDetails = envBool("ERROR_DETAILS", platform == "dev")
 
 	filepathRoot := os.Getenv("FILEPATH_ROOT")
 	if filepathRoot == "" {
@@ -84,11 +201,309 @@ func main() {
 		log.Fatal("S3_CF_DISTRO environment variable is not set")
 	}
 
+	s3VerifyUploads := envBool("S3_VERIFY_UPLOADS", false)
+	s3VerifyDownloads := os.Getenv("S3_VERIFY_DOWNLOADS")
+	if s3VerifyDownloads == "" {
+		s3VerifyDownloads = downloadVerifySize
+	}
+	if s3VerifyDownloads != downloadVerifyOff && s3VerifyDownloads != downloadVerifySize && s3VerifyDownloads != downloadVerifyChecksum {
+		log.Fatalf("S3_VERIFY_DOWNLOADS must be %q, %q or %q", downloadVerifyOff, downloadVerifySize, downloadVerifyChecksum)
+	}
+
+	// Lets several environments share one bucket, e.g. "prod/" and "staging/".
+	s3KeyPrefix := strings.Trim(os.Getenv("S3_KEY_PREFIX"), "/")
+	if s3KeyPrefix != "" {
+		s3KeyPrefix += "/"
+	}
+	s3KeyScheme, err := parseKeyScheme(os.Getenv("S3_KEY_SCHEME"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	aspectKeySegments, err := parseAspectKeySegments("ASPECT_KEY_PREFIXES", envList("ASPECT_KEY_PREFIXES", nil))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s3Cleanup := s3CleanupConfig{
+		interval: envDuration("S3_CLEANUP_INTERVAL", 0),
+		minAge:   envDuration("S3_CLEANUP_MIN_AGE", 24*time.Hour),
+		delete:   envBool("S3_CLEANUP_DELETE", false),
+	}
+
+	ffmpeg := ffmpegLimits{
+		threads:  envInt("FFMPEG_THREADS", defaultFFmpegThreads()),
+		nice:     envInt("FFMPEG_NICE", 10),
+		slots:    newFFmpegSlots(envInt("FFMPEG_MAX_PROCESSES", 0)),
+		slotWait: envDuration("FFMPEG_SLOT_WAIT", 30*time.Second),
+	}
+	ffmpeg.probeStreams = os.Getenv("FFPROBE_SELECT_STREAMS")
+	if ffmpeg.probeStreams == "" {
+		ffmpeg.probeStreams = "v"
+	}
+
+	adminUserIDs := map[uuid.UUID]bool{}
+	for _, s := range strings.Split(os.Getenv("ADMIN_USER_IDS"), ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		id, err := uuid.Parse(s)
+		if err != nil {
+			log.Fatalf("ADMIN_USER_IDS contains an invalid user ID %q: %v", s, err)
+		}
+		adminUserIDs[id] = true
+	}
+
+	adminSignature := &adminSignatureConfig{
+		secret: []byte(os.Getenv("ADMIN_SIGNING_SECRET")),
+		window: envDuration("ADMIN_SIGNATURE_WINDOW", 30*time.Second),
+		seen:   map[string]time.Time{},
+	}
+	if len(adminSignature.secret) > 0 {
+		if len(adminSignature.secret) < 32 {
+			log.Fatal("ADMIN_SIGNING_SECRET must be at least 32 bytes")
+		}
+		if adminSignature.window <= 0 || adminSignature.window > 5*time.Minute {
+			log.Fatal("ADMIN_SIGNATURE_WINDOW must be greater than 0 and at most 5m")
+		}
+	}
+
+	preview := previewConfig{
+		length: envDuration("PREVIEW_LENGTH", 3*time.Second),
+		start:  envDuration("PREVIEW_START", 0),
+		format: os.Getenv("PREVIEW_FORMAT"),
+		width:  envInt("PREVIEW_WIDTH", 320),
+	}
+	if preview.format == "" {
+		preview.format = "webp"
+	}
+	if _, ok := previewMediaTypes[preview.format]; !ok {
+		log.Fatalf("PREVIEW_FORMAT must be webp or gif, got %q", preview.format)
+	}
+	if preview.length <= 0 || preview.width <= 0 {
+		log.Fatal("PREVIEW_LENGTH and PREVIEW_WIDTH must be positive")
+	}
+
+	contactSheet := contactSheetConfig{width: envInt("CONTACT_SHEET_WIDTH", 1280)}
+	grid := os.Getenv("CONTACT_SHEET_GRID")
+	if grid == "" {
+		grid = "4x4"
+	}
+	contactSheet.columns, contactSheet.rows, err = parseGrid(grid)
+	if err != nil {
+		log.Fatalf("CONTACT_SHEET_GRID: %v", err)
+	}
+	if contactSheet.width < contactSheet.columns {
+		log.Fatal("CONTACT_SHEET_WIDTH must be at least one pixel per column")
+	}
+
+	watermarkPosition := os.Getenv("WATERMARK_POSITION")
+	if watermarkPosition == "" {
+		watermarkPosition = "bottom-right"
+	}
+	watermark := watermarkConfig{
+		image:    os.Getenv("WATERMARK_IMAGE"),
+		userDir:  os.Getenv("WATERMARK_USER_DIR"),
+		position: watermarkPosition,
+		opacity:  envFloat("WATERMARK_OPACITY", 0.7),
+		margin:   envInt("WATERMARK_MARGIN", 16),
+	}
+	if err := watermark.validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	audio := audioConfig{
+		normalize: envBool("AUDIO_NORMALIZE", false),
+		targetI:   envFloat("AUDIO_TARGET_LUFS", -16),
+		targetTP:  envFloat("AUDIO_TARGET_TRUE_PEAK", -1.5),
+		targetLRA: envFloat("AUDIO_TARGET_LRA", 11),
+	}
+	if audio.targetI < -70 || audio.targetI > -5 {
+		log.Fatal("AUDIO_TARGET_LUFS must be between -70 and -5")
+	}
+
+	outputFormat, err := parseOutputFormat(os.Getenv("VIDEO_OUTPUT_FORMAT"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	colorMode := os.Getenv("HDR_MODE")
+	if colorMode == "" {
+		colorMode = colorModeReject
+	}
+	if colorMode != colorModeReject && colorMode != colorModeTonemap && colorMode != colorModeAllow {
+		log.Fatalf("HDR_MODE must be %q, %q or %q", colorModeReject, colorModeTonemap, colorModeAllow)
+	}
+
+	clips := clipConfig{
+		maxLength:  envDuration("CLIP_MAX_LENGTH", time.Minute),
+		streamCopy: envBool("CLIP_STREAM_COPY", false),
+	}
+
+	allowedVideoTypes := envList("ALLOWED_VIDEO_TYPES", defaultVideoMediaTypes)
+	allowedImageTypes := envList("ALLOWED_IMAGE_TYPES", defaultImageMediaTypes)
+	uploadLimits := uploadLimits{
+		videoSize:     int64(envInt("MAX_VIDEO_UPLOAD_MB", 1024)) << 20,
+		thumbnailSize: int64(envInt("MAX_THUMBNAIL_UPLOAD_MB", 10)) << 20,
+		videoDuration: envDuration("MAX_VIDEO_DURATION", 0),
+	}
+	uploadPolicies, err := parseUploadPolicies("UPLOAD_POLICIES", envList("UPLOAD_POLICIES", nil))
+	if err != nil {
+		log.Fatal(err)
+	}
+	userTiers, err := parseUserTiers("USER_TIERS", envList("USER_TIERS", nil))
+	if err != nil {
+		log.Fatal(err)
+	}
+	uploadFields := uploadFieldNames{
+		video:     os.Getenv("UPLOAD_VIDEO_FIELD"),
+		thumbnail: os.Getenv("UPLOAD_THUMBNAIL_FIELD"),
+	}
+	if uploadFields.video == "" {
+		uploadFields.video = "video"
+	}
+	if uploadFields.thumbnail == "" {
+		uploadFields.thumbnail = "thumbnail"
+	}
+	for mediaType, ext := range parseMediaTypeExtensions("MEDIA_TYPE_EXTENSIONS", envList("MEDIA_TYPE_EXTENSIONS", nil)) {
+		mediaTypeExtensions[mediaType] = ext
+	}
+	for _, t := range append(append([]string{}, allowedVideoTypes...), allowedImageTypes...) {
+		if extensionForMediaType(t) == "" {
+			log.Fatalf("media type %q is allowed but we don't know how to store it", t)
+		}
+	}
+
+	thumbnailStore := thumbnailStoreConfig{
+		useS3:           os.Getenv("THUMBNAIL_STORAGE") == "s3",
+		serveMode:       os.Getenv("THUMBNAIL_SERVE_MODE"),
+		urlExpiry:       envDuration("THUMBNAIL_URL_EXPIRY", 5*time.Minute),
+		keyScheme:       os.Getenv("THUMBNAIL_KEYS"),
+		frameRangeReads: envBool("THUMBNAIL_FRAME_RANGE_READS", true),
+		frameCandidates: envInt("THUMBNAIL_FRAME_CANDIDATES", 5),
+		progressiveJPEG: envBool("THUMBNAIL_PROGRESSIVE_JPEG", false),
+		perceptualHash:  envBool("THUMBNAIL_PERCEPTUAL_HASH", false),
+		storageClass:    parseStorageClass("THUMBNAIL_STORAGE_CLASS", os.Getenv("THUMBNAIL_STORAGE_CLASS")),
+		coldAfter:       envDuration("THUMBNAIL_COLD_AFTER", 0),
+	}
+	thumbnailStore.coldStorageClass = parseStorageClass("THUMBNAIL_COLD_STORAGE_CLASS", os.Getenv("THUMBNAIL_COLD_STORAGE_CLASS"))
+	if thumbnailStore.coldStorageClass == "" {
+		thumbnailStore.coldStorageClass = "STANDARD_IA"
+	}
+	if thumbnailStore.serveMode == "" {
+		thumbnailStore.serveMode = thumbnailServeProxy
+	}
+	if thumbnailStore.serveMode != thumbnailServeProxy && thumbnailStore.serveMode != thumbnailServeRedirect {
+		log.Fatalf("THUMBNAIL_SERVE_MODE must be %q or %q", thumbnailServeProxy, thumbnailServeRedirect)
+	}
+	if thumbnailStore.keyScheme == "" {
+		thumbnailStore.keyScheme = thumbnailKeysRandom
+	}
+	if thumbnailStore.keyScheme != thumbnailKeysRandom && thumbnailStore.keyScheme != thumbnailKeysVideo {
+		log.Fatalf("THUMBNAIL_KEYS must be %q or %q", thumbnailKeysRandom, thumbnailKeysVideo)
+	}
+
+	// Signed media URLs only need to outlive a page load, so a random key
+	// that changes on restart is fine when no secret is configured.
+	mediaURLKey := []byte(os.Getenv("MEDIA_URL_SECRET"))
+	if len(mediaURLKey) == 0 {
+		mediaURLKey = make([]byte, 32)
+		if _, err := rand.Read(mediaURLKey); err != nil {
+			log.Fatalf("Couldn't generate media URL key: %v", err)
+		}
+	}
+	mediaURLExpiry := envDuration("MEDIA_URL_EXPIRY", 5*time.Minute)
+	if mediaURLExpiry <= 0 {
+		log.Fatal("MEDIA_URL_EXPIRY must be greater than 0")
+	}
+
+	transcode := transcodeConfig{
+		maxBitrate:    int64(envInt("TRANSCODE_MAX_BITRATE", 0)),
+		targetBitrate: int64(envInt("TRANSCODE_TARGET_BITRATE", 8_000_000)),
+		maxHeight:     envInt("TRANSCODE_MAX_HEIGHT", 1080),
+		keepOriginal:  envBool("TRANSCODE_KEEP_ORIGINAL", false),
+		variants:      parseVariantHeights("VIDEO_VARIANTS", envList("VIDEO_VARIANTS", nil)),
+		hlsByteRange:  envBool("HLS_BYTERANGE", false),
+
+		originalStorageClass: parseOriginalStorageClass("ORIGINAL_STORAGE_CLASS", os.Getenv("ORIGINAL_STORAGE_CLASS")),
+		originalRestoreDays:  envInt("ORIGINAL_RESTORE_DAYS", 7),
+	}
+	if transcode.originalRestoreDays < 1 {
+		log.Fatal("ORIGINAL_RESTORE_DAYS must be at least 1")
+	}
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		log.Fatal("PORT environment variable is not set")
 	}
 
+	// Where the site is reachable from outside, for links we hand to
+	// other sites such as oEmbed responses.
+	publicBaseURL := strings.TrimSuffix(os.Getenv("PUBLIC_BASE_URL"), "/")
+	if publicBaseURL == "" {
+		publicBaseURL = "http://localhost:" + port
+	}
+
+	slowRequests := routeDurations{
+		def:    envDuration("SLOW_REQUEST_THRESHOLD", 10*time.Second),
+		routes: parseRouteDurations("SLOW_REQUEST_ROUTE_THRESHOLDS", envList("SLOW_REQUEST_ROUTE_THRESHOLDS", nil)),
+	}
+
+	requestTimeouts := routeDurations{
+		def:    envDuration("REQUEST_TIMEOUT", time.Minute),
+		routes: defaultRequestTimeouts(envDuration("UPLOAD_REQUEST_TIMEOUT", 30*time.Minute)),
+	}
+	for pattern, d := range parseRouteDurations("REQUEST_TIMEOUT_ROUTES", envList("REQUEST_TIMEOUT_ROUTES", nil)) {
+		requestTimeouts.routes[pattern] = d
+	}
+
+	var faststartCache *faststartCache
+	if dir := os.Getenv("FASTSTART_CACHE_DIR"); dir != "" {
+		maxBytes := int64(envInt("FASTSTART_CACHE_MAX_MB", 10240)) << 20
+		faststartCache, err = newFaststartCache(dir, maxBytes, envDuration("FASTSTART_CACHE_MAX_AGE", 24*time.Hour))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var userStatus *userStatusCache
+	if envBool("JWT_VERIFY_SUBJECT", false) {
+		userStatus = newUserStatusCache(envDuration("JWT_SUBJECT_CACHE_TTL", 30*time.Second))
+	}
+
+	storageUsage := storageUsageConfig{
+		refresh: envDuration("STORAGE_USAGE_REFRESH", time.Hour),
+		prices:  parseStoragePrices("STORAGE_PRICES", envList("STORAGE_PRICES", nil)),
+	}
+
+	tempKeepDir := os.Getenv("TEMP_FILE_KEEP_DIR")
+	if tempKeepDir == "" {
+		tempKeepDir = filepath.Join(os.TempDir(), "tubely-kept")
+	}
+	tempFiles, err := newTempJanitor(tempKeepDir, envDuration("TEMP_FILE_GRACE", 0))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	uploadSessionDir := os.Getenv("UPLOAD_SESSION_DIR")
+	if uploadSessionDir == "" {
+		uploadSessionDir = filepath.Join(os.TempDir(), "tubely-uploads")
+	}
+	uploadSessionTTL := envDuration("UPLOAD_SESSION_TTL", defaultUploadSessionTTL)
+	if uploadSessionTTL < 0 {
+		log.Fatalf("UPLOAD_SESSION_TTL must not be negative")
+	}
+	uploads, err := newUploadSessionStore(uploadSessionDir, uploadSessionTTL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	auditSink, err := newAuditSink(os.Getenv("AUDIT_LOG_SINK"), os.Getenv("AUDIT_LOG_FILE"), db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	auditLog := newAuditLogger(auditSink, envInt("AUDIT_LOG_QUEUE", 1024))
+
 	// Create an empty context
 	ctx := context.TODO()
 
@@ -100,18 +515,116 @@ func main() {
 
 	// Create an S3 client
 	s3Client := s3.NewFromConfig(cfg_s3)
+	if envBool("S3_ACCELERATE", false) {
+		warnIfAccelerationDisabled(ctx, s3Client, s3Bucket)
+		s3Client = s3.NewFromConfig(cfg_s3, func(o *s3.Options) {
+			o.UseAccelerate = true
+		})
+	}
+
+	bucketTiers := parseBucketTiers(envList("S3_BUCKET_TIERS", nil))
+	s3Buckets := map[string]s3BucketTarget{}
+	for _, tier := range bucketTiers {
+		client := s3.NewFromConfig(cfg_s3, func(o *s3.Options) {
+			o.Region = tier.region
+		})
+		s3Buckets[tier.bucket] = s3BucketTarget{
+			bucket:       tier.bucket,
+			distribution: tier.distribution,
+			client:       client,
+			presigner:    s3.NewPresignClient(client),
+		}
+		log.Printf("S3 bucket tier %s: %s in %s, served from %s", tier.name, tier.bucket, tier.region, tier.distribution)
+	}
 
 	cfg := apiConfig{
-		db:               db,
-		jwtSecret:        jwtSecret,
-		platform:         platform,
-		filepathRoot:     filepathRoot,
-		assetsRoot:       assetsRoot,
-		s3Bucket:         s3Bucket,
-		s3Region:         s3Region,
-		s3CfDistribution: s3CfDistribution,
-		s3Client:         s3Client,
-		port:             port,
+		db:                db,
+		dbPolicy:          dbPolicy,
+		jwtSecrets:        jwtSecrets,
+		jwtRSAKeys:        jwtRSAKeys,
+		platform:          platform,
+		filepathRoot:      filepathRoot,
+		assetsRoot:        assetsRoot,
+		s3Bucket:          s3Bucket,
+		s3Region:          s3Region,
+		s3CfDistribution:  s3CfDistribution,
+		s3Client:          s3Client,
+		s3Presigner:       s3.NewPresignClient(s3Client),
+		s3Buckets:         s3Buckets,
+		s3AspectBuckets:   parseAspectTiers(envList("S3_TIER_BY_ASPECT_RATIO", nil), bucketTiers),
+		s3VerifyUploads:   s3VerifyUploads,
+		s3VerifyDownloads: s3VerifyDownloads,
+		s3KeyPrefix:       s3KeyPrefix,
+		s3FolderKeys:      envBool("S3_FOLDER_KEYS", false),
+		s3UserKeys:        envBool("S3_USER_KEYS", false),
+		s3KeyScheme:       s3KeyScheme,
+		aspectKeySegments: aspectKeySegments,
+		s3Cleanup:         s3Cleanup,
+		deleteObjects:     envBool("DELETE_VIDEO_OBJECTS", true),
+		ffmpeg:            ffmpeg,
+		adminUserIDs:      adminUserIDs,
+		adminSignature:    adminSignature,
+		preview:           preview,
+		contactSheet:      contactSheet,
+		clips:             clips,
+		allowedVideoTypes: allowedVideoTypes,
+		allowedImageTypes: allowedImageTypes,
+		uploadLimits:      uploadLimits,
+		uploadPolicies:    uploadPolicies,
+		userTiers:         userTiers,
+		uploadFields:      uploadFields,
+		diskFreeFactor:    envFloat("DISK_FREE_FACTOR", 3),
+		uploadsPerConn:    envInt("UPLOADS_PER_CONNECTION", 0),
+		thumbnailStore:    thumbnailStore,
+		mediaURLKey:       mediaURLKey,
+		mediaURLExpiry:    mediaURLExpiry,
+		transcode:         transcode,
+		watermark:         watermark,
+		audio:             audio,
+		colorMode:         colorMode,
+		aspectRatioStrict: envBool("ASPECT_RATIO_STRICT", false),
+		faststartFallback: envBool("FASTSTART_FALLBACK", false),
+		outputFormat:      outputFormat,
+		downloadURLExpiry: envDuration("DOWNLOAD_URL_EXPIRY", time.Hour),
+		streamURLExpiry:   envDuration("STREAM_URL_EXPIRY", 10*time.Minute),
+		slowRequests:      slowRequests,
+		requestTimeouts:   requestTimeouts,
+		faststartCache:    faststartCache,
+		userStatus:        userStatus,
+		uploads:           uploads,
+		storageUsage:      storageUsage,
+		storageIndex:      &storageIndex{},
+		tempFiles:         tempFiles,
+		defaultThumbnail:  os.Getenv("DEFAULT_THUMBNAIL_URL"),
+		auditLog:          auditLog,
+		port:              port,
+		publicBaseURL:     publicBaseURL,
+		videoIDVersion:    envInt("VIDEO_ID_VERSION", 0),
+		views:             newViewCounter(db, viewDebounceWindow),
+		transcriber:       uploadOnlyTranscriber{},
+		searchBackend:     searchBackend,
+	}
+	go cfg.views.run(viewFlushInterval)
+	cfg.publishMetrics()
+	if auditSink != nil {
+		go cfg.auditLog.run()
+	}
+	if cfg.s3Cleanup.interval > 0 {
+		go cfg.runS3Cleanup()
+	}
+	go cfg.uploads.run()
+	if envBool("CONTENT_TYPE_AUTO_REPAIR", false) {
+		cfg.contentTypeRepair = newContentTypeRepairer()
+		go cfg.runContentTypeRepairs()
+	}
+	if cfg.storageUsage.refresh > 0 {
+		go cfg.runStorageIndexRefresh()
+	}
+	if cfg.tempFiles.grace > 0 {
+		go cfg.tempFiles.run()
+	}
+	if cfg.thumbnailStore.useS3 && cfg.thumbnailStore.coldAfter > 0 {
+		go cfg.runThumbnailTiering()
 	}
 
 	err = cfg.ensureAssetsDir()
@@ -119,31 +632,21 @@ func main() {
 		log.Fatalf("Couldn't create assets directory: %v", err)
 	}
 
-	mux := http.NewServeMux()
-	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
-	mux.Handle("/app/", appHandler)
-
-	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
-	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
-
-	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
-	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
-	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
-
-	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
-
-	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
-	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
-	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
-	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
-	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
-	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
-
-	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
-
+	mux := cfg.routes(filepathRoot, assetsRoot)
 	srv := &http.Server{
-		Addr:    ":" + port,
-		Handler: mux,
+		Addr:        ":" + port,
+		Handler:     cfg.serverHandler(mux),
+		ConnContext: connContext,
+	}
+	http2 := http2Settings{
+		maxStreams: envInt("HTTP2_MAX_CONCURRENT_STREAMS", 100),
+		cleartext:  envBool("HTTP2_CLEARTEXT", false),
+	}
+	if http2.maxStreams < 1 {
+		log.Fatal("HTTP2_MAX_CONCURRENT_STREAMS must be at least 1")
+	}
+	if err := configureHTTP2(srv, http2); err != nil {
+		log.Fatal(err)
 	}
 
 	log.Printf("Serving on: http://localhost:%s/app/\n", port)
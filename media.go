@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// sniffLen is how many leading bytes we read to detect a file's real type.
+const sniffLen = 512
+
+// mediaTypeExtensions maps the media types we store to the extension of
+// their key. MEDIA_TYPE_EXTENSIONS adds to or overrides it at startup.
+var mediaTypeExtensions = map[string]string{
+	"video/mp4":  ".mp4",
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/avif": ".avif",
+}
+
+// mediaTypeAliases maps nonstandard media types clients still send to the
+// registered type.
+var mediaTypeAliases = map[string]string{
+	"image/jpg": "image/jpeg",
+}
+
+var (
+	defaultVideoMediaTypes = []string{"video/mp4"}
+	defaultImageMediaTypes = []string{"image/jpeg", "image/png", "image/avif"}
+)
+
+// isAllowedMediaType reports whether mediaType is in the allowlist.
+func isAllowedMediaType(allowed []string, mediaType string) bool {
+	mediaType = normalizeMediaType(mediaType)
+	for _, t := range allowed {
+		if normalizeMediaType(t) == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeMediaType folds nonstandard aliases clients still send into the
+// registered type.
+func normalizeMediaType(mediaType string) string {
+	mediaType = strings.ToLower(mediaType)
+	if registered, ok := mediaTypeAliases[mediaType]; ok {
+		return registered
+	}
+	return mediaType
+}
+
+// extensionForMediaType returns the file extension we store a media type
+// under, or "" if we don't support it. Types missing from
+// mediaTypeExtensions fall back to the system MIME table, and ones it
+// doesn't know either are logged so operators can see what clients send.
+func extensionForMediaType(mediaType string) string {
+	mediaType = normalizeMediaType(mediaType)
+	if ext, ok := mediaTypeExtensions[mediaType]; ok {
+		return ext
+	}
+	// ExtensionsByType sorts its result, so the choice is stable.
+	exts, err := mime.ExtensionsByType(mediaType)
+	if err != nil || len(exts) == 0 {
+		log.Printf("No file extension known for media type %q", mediaType)
+		return ""
+	}
+	return exts[0]
+}
+
+// parseMediaTypeExtensions reads MEDIA_TYPE_EXTENSIONS-style entries such
+// as "image/webp=.webp".
+func parseMediaTypeExtensions(key string, entries []string) map[string]string {
+	extensions := map[string]string{}
+	for _, entry := range entries {
+		mediaType, ext, ok := strings.Cut(entry, "=")
+		mediaType = normalizeMediaType(strings.TrimSpace(mediaType))
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if !ok || !strings.Contains(mediaType, "/") || len(ext) < 2 || ext[0] != '.' || strings.ContainsAny(ext[1:], "./") {
+			log.Fatalf("%s entry %q must look like \"image/webp=.webp\"", key, entry)
+		}
+		extensions[mediaType] = ext
+	}
+	return extensions
+}
+
+// sniffMediaType detects a file's type from its leading bytes.
+func sniffMediaType(header []byte) string {
+	// http.DetectContentType doesn't know about AVIF.
+	if isAVIF(header) {
+		return "image/avif"
+	}
+	mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(header))
+	return mediaType
+}
+
+// uploadMediaType decides the media type of an uploaded file from the
+// part's declared Content-Type. Some clients omit it, so in that case the
+// type is sniffed from header rather than rejecting a perfectly good file.
+func uploadMediaType(declared string, header []byte, allowed []string) (string, error) {
+	if declared == "" {
+		sniffed := sniffMediaType(header)
+		if !isAllowedMediaType(allowed, sniffed) {
+			return "", fmt.Errorf("the file part has no Content-Type and its contents look like %s; allowed: %s", sniffed, strings.Join(allowed, ", "))
+		}
+		return normalizeMediaType(sniffed), nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(declared)
+	if err != nil {
+		return "", fmt.Errorf("invalid Content-Type %q", declared)
+	}
+	if !isAllowedMediaType(allowed, mediaType) {
+		return "", fmt.Errorf("unsupported content type %s; allowed: %s", mediaType, strings.Join(allowed, ", "))
+	}
+	return mediaType, nil
+}
+
+// readHeader reads the first sniffLen bytes of f and rewinds it.
+func readHeader(f io.ReadSeeker) ([]byte, error) {
+	header := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return header[:n], nil
+}
+
+// checkMediaTypeConsistency makes sure the declared media type, the type
+// sniffed from the content, and the filename's extension (if it has one)
+// all agree, so e.g. a PNG can never be stored under a .mp4 key.
+func checkMediaTypeConsistency(declared, filename string, header []byte) error {
+	declared = normalizeMediaType(declared)
+
+	if sniffed := sniffMediaType(header); sniffed != declared {
+		return fmt.Errorf("content type %s does not match file contents (%s)", declared, sniffed)
+	}
+
+	ext := strings.ToLower(filepath.Ext(filename))
+	if ext == "" {
+		return nil
+	}
+	extType, _, err := mime.ParseMediaType(mime.TypeByExtension(ext))
+	if err != nil || normalizeMediaType(extType) != declared {
+		return fmt.Errorf("file extension %s does not match content type %s", ext, declared)
+	}
+	return nil
+}
+
+// isAVIF checks for an ISO-BMFF ftyp box whose major or compatible brands
+// include avif (still image) or avis (image sequence).
+func isAVIF(header []byte) bool {
+	if len(header) < 16 || string(header[4:8]) != "ftyp" {
+		return false
+	}
+	boxSize := int(binary.BigEndian.Uint32(header[0:4]))
+	if boxSize < 16 {
+		return false
+	}
+	if boxSize > len(header) {
+		boxSize = len(header)
+	}
+
+	isAVIFBrand := func(brand string) bool {
+		return brand == "avif" || brand == "avis"
+	}
+	if isAVIFBrand(string(header[8:12])) {
+		return true
+	}
+	// Bytes 12-16 are the minor version; compatible brands follow.
+	for i := 16; i+4 <= boxSize; i += 4 {
+		if isAVIFBrand(string(header[i : i+4])) {
+			return true
+		}
+	}
+	return false
+}
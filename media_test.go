@@ -0,0 +1,148 @@
+package main
+
+import "testing"
+
+var (
+	testMP4  = []byte("\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom")
+	testPNG  = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	testJPEG = []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00")
+	testAVIF = []byte("\x00\x00\x00\x18ftypavif\x00\x00\x00\x00avifmif1")
+)
+
+func TestSniffMediaType(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		want   string
+	}{
+		{"mp4", testMP4, "video/mp4"},
+		{"png", testPNG, "image/png"},
+		{"jpeg", testJPEG, "image/jpeg"},
+		{"avif", testAVIF, "image/avif"},
+		{"text", []byte("just some text"), "text/plain"},
+		{"empty", nil, "text/plain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sniffMediaType(tt.header); got != tt.want {
+				t.Errorf("sniffMediaType = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckMediaTypeConsistency(t *testing.T) {
+	tests := []struct {
+		name     string
+		declared string
+		filename string
+		header   []byte
+		wantErr  bool
+	}{
+		{"mp4", "video/mp4", "clip.mp4", testMP4, false},
+		{"no extension", "video/mp4", "clip", testMP4, false},
+		{"uppercase extension", "video/mp4", "CLIP.MP4", testMP4, false},
+		{"alias", "image/jpg", "photo.jpg", testJPEG, false},
+		{"png declared as mp4", "video/mp4", "clip.mp4", testPNG, true},
+		{"png renamed to mp4", "image/png", "photo.mp4", testPNG, true},
+		{"mp4 with a png extension", "video/mp4", "clip.png", testMP4, true},
+		{"mp4 with an unknown extension", "video/mp4", "clip.xyz", testMP4, true},
+		{"png declared as jpeg", "image/jpeg", "photo.jpg", testPNG, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkMediaTypeConsistency(tt.declared, tt.filename, tt.header)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkMediaTypeConsistency = %v, want error: %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIsAllowedMediaType(t *testing.T) {
+	t.Setenv("ALLOWED_IMAGE_TYPES", " image/png, image/webp ,")
+	allowed := envList("ALLOWED_IMAGE_TYPES", defaultImageMediaTypes)
+
+	tests := []struct {
+		mediaType string
+		want      bool
+	}{
+		{"image/png", true},
+		{"image/webp", true},
+		{"IMAGE/PNG", true},
+		{"image/jpeg", false},
+		{"image/jpg", false},
+		{"video/mp4", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mediaType, func(t *testing.T) {
+			if got := isAllowedMediaType(allowed, tt.mediaType); got != tt.want {
+				t.Errorf("isAllowedMediaType(%q, %q) = %t, want %t", allowed, tt.mediaType, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUploadMediaTypeAllowlist(t *testing.T) {
+	allowed := []string{"image/png", "image/jpeg"}
+	tests := []struct {
+		name     string
+		declared string
+		header   []byte
+		want     string
+		wantErr  bool
+	}{
+		{"declared allowed", "image/png", testPNG, "image/png", false},
+		{"declared with parameters", "image/jpeg; q=0.9", testJPEG, "image/jpeg", false},
+		{"declared alias", "image/jpg", testJPEG, "image/jpg", false},
+		{"declared outside the list", "image/avif", testAVIF, "", true},
+		{"sniffed allowed", "", testPNG, "image/png", false},
+		{"sniffed outside the list", "", testMP4, "", true},
+		{"invalid declared type", "image/", testPNG, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := uploadMediaType(tt.declared, tt.header, allowed)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("uploadMediaType = %q, %v, want error: %t", got, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("uploadMediaType = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtensionForMediaType(t *testing.T) {
+	for mediaType, ext := range parseMediaTypeExtensions("MEDIA_TYPE_EXTENSIONS", []string{" video/QuickTime = .MOV", "image/png=.PNG"}) {
+		old, had := mediaTypeExtensions[mediaType]
+		mediaTypeExtensions[mediaType] = ext
+		t.Cleanup(func() {
+			if had {
+				mediaTypeExtensions[mediaType] = old
+			} else {
+				delete(mediaTypeExtensions, mediaType)
+			}
+		})
+	}
+
+	tests := []struct {
+		mediaType string
+		want      string
+	}{
+		{"image/jpeg", ".jpg"},
+		{"image/jpg", ".jpg"},
+		{"video/quicktime", ".mov"},
+		{"image/png", ".png"},
+		{"image/bmp", ".bmp"},
+		{"application/x-tubely-unknown", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mediaType, func(t *testing.T) {
+			if got := extensionForMediaType(tt.mediaType); got != tt.want {
+				t.Errorf("extensionForMediaType(%q) = %q, want %q", tt.mediaType, got, tt.want)
+			}
+		})
+	}
+}
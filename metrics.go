@@ -0,0 +1,25 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+)
+
+// publishMetrics registers our gauges with expvar, alongside the runtime's
+// memstats.
+func (cfg *apiConfig) publishMetrics() {
+	expvar.Publish("ffmpeg_running", expvar.Func(func() any {
+		return cfg.ffmpeg.running()
+	}))
+	expvar.Publish("ffmpeg_max_processes", expvar.Func(func() any {
+		return cap(cfg.ffmpeg.slots)
+	}))
+}
+
+// handlerMetrics serves the expvar metrics as JSON to admins.
+func (cfg *apiConfig) handlerMetrics(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	expvar.Handler().ServeHTTP(w, r)
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// maxMP4HeaderBox bounds the moov and moof boxes read into memory. Their
+// sample tables are small in a fragmented file; anything bigger isn't one.
+const maxMP4HeaderBox = 16 << 20
+
+var errNotFragmented = errors.New("not a fragmented MP4")
+
+// mp4Box is a box's type and where it sits in the file, header included.
+type mp4Box struct {
+	kind   string
+	offset int64
+	size   int64
+	// header is how many bytes of size the box header takes.
+	header int64
+}
+
+// readMP4Box reads the header of the box at offset in a file of size
+// bytes.
+func readMP4Box(r io.ReaderAt, offset, size int64) (mp4Box, error) {
+	var buf [16]byte
+	if _, err := r.ReadAt(buf[:8], offset); err != nil {
+		return mp4Box{}, err
+	}
+	box := mp4Box{
+		kind:   string(buf[4:8]),
+		offset: offset,
+		size:   int64(binary.BigEndian.Uint32(buf[:4])),
+		header: 8,
+	}
+	switch box.size {
+	case 0:
+		box.size = size - offset
+	case 1:
+		if _, err := r.ReadAt(buf[8:16], offset+8); err != nil {
+			return mp4Box{}, err
+		}
+		box.size = int64(binary.BigEndian.Uint64(buf[8:16]))
+		box.header = 16
+	}
+	if box.size < box.header || offset+box.size > size {
+		return mp4Box{}, fmt.Errorf("%s box at %d has an invalid size", box.kind, offset)
+	}
+	return box, nil
+}
+
+// mp4Child is a box read into memory, without its header.
+type mp4Child struct {
+	kind string
+	data []byte
+}
+
+// childBoxes splits the payload of a box already in memory into its
+// children, in order of appearance.
+func childBoxes(data []byte) ([]mp4Child, error) {
+	var children []mp4Child
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, errors.New("truncated box")
+		}
+		size := int(binary.BigEndian.Uint32(data[:4]))
+		kind := string(data[4:8])
+		header := 8
+		switch size {
+		case 0:
+			size = len(data)
+		case 1:
+			if len(data) < 16 {
+				return nil, errors.New("truncated box")
+			}
+			size = int(binary.BigEndian.Uint64(data[8:16]))
+			header = 16
+		}
+		if size < header || size > len(data) {
+			return nil, fmt.Errorf("%s box has an invalid size", kind)
+		}
+		children = append(children, mp4Child{kind, data[header:size]})
+		data = data[size:]
+	}
+	return children, nil
+}
+
+// mp4Track is what fragment parsing needs from a track's moov entry.
+type mp4Track struct {
+	handler   string
+	timescale uint32
+	// defaultDuration and defaultSize come from the track's trex box.
+	defaultDuration uint32
+	defaultSize     uint32
+}
+
+// indexFragmentedMP4 finds the init section and the fragments of a
+// fragmented MP4 of size bytes, as ffmpeg writes with
+// -movflags frag_keyframe+empty_moov+default_base_moof: a moov without
+// samples, then a moof and mdat per keyframe. Each fragment's duration
+// and leading keyframe come from its video track.
+func indexFragmentedMP4(r io.ReaderAt, size int64) (database.HLSIndex, error) {
+	index := database.HLSIndex{Segments: []database.HLSSegment{}}
+	var tracks map[uint32]*mp4Track
+	var videoTrack uint32
+	var current *database.HLSSegment
+
+	for offset := int64(0); offset < size; {
+		box, err := readMP4Box(r, offset, size)
+		if err != nil {
+			return database.HLSIndex{}, err
+		}
+		offset += box.size
+
+		switch box.kind {
+		case "moov":
+			payload, err := readMP4Payload(r, box)
+			if err != nil {
+				return database.HLSIndex{}, err
+			}
+			tracks, err = parseMP4Tracks(payload)
+			if err != nil {
+				return database.HLSIndex{}, err
+			}
+			for id, track := range tracks {
+				if track.handler == "vide" && (videoTrack == 0 || id < videoTrack) {
+					videoTrack = id
+				}
+			}
+			if videoTrack == 0 {
+				return database.HLSIndex{}, errors.New("no video track")
+			}
+			index.Init = database.ByteRange{Offset: 0, Length: offset}
+		case "moof":
+			if tracks == nil {
+				return database.HLSIndex{}, fmt.Errorf("%w: moof before moov", errNotFragmented)
+			}
+			payload, err := readMP4Payload(r, box)
+			if err != nil {
+				return database.HLSIndex{}, err
+			}
+			duration, keyframeEnd, err := parseMP4Fragment(payload, box.offset, tracks, videoTrack)
+			if err != nil {
+				return database.HLSIndex{}, err
+			}
+			index.Segments = append(index.Segments, database.HLSSegment{
+				Duration: duration,
+				Range:    database.ByteRange{Offset: box.offset, Length: box.size},
+				Keyframe: database.ByteRange{Offset: box.offset, Length: keyframeEnd - box.offset},
+			})
+			current = &index.Segments[len(index.Segments)-1]
+		case "mdat":
+			if current == nil {
+				if tracks != nil {
+					return database.HLSIndex{}, fmt.Errorf("%w: samples outside fragments", errNotFragmented)
+				}
+				continue
+			}
+			// A fragment runs through the mdats that follow its moof.
+			current.Range.Length = offset - current.Range.Offset
+		default:
+			// Boxes after a fragment, such as mfra, aren't part of it.
+			current = nil
+		}
+	}
+	if tracks == nil || len(index.Segments) == 0 {
+		return database.HLSIndex{}, errNotFragmented
+	}
+	for _, segment := range index.Segments {
+		if segment.Keyframe.Length > segment.Range.Length {
+			return database.HLSIndex{}, fmt.Errorf("keyframe at %d runs past its fragment", segment.Range.Offset)
+		}
+	}
+	return index, nil
+}
+
+func readMP4Payload(r io.ReaderAt, box mp4Box) ([]byte, error) {
+	if box.size > maxMP4HeaderBox {
+		return nil, fmt.Errorf("%s box at %d is too large", box.kind, box.offset)
+	}
+	payload := make([]byte, box.size-box.header)
+	if _, err := r.ReadAt(payload, box.offset+box.header); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// parseMP4Tracks reads the tracks of a moov box's payload.
+func parseMP4Tracks(moov []byte) (map[uint32]*mp4Track, error) {
+	tracks := map[uint32]*mp4Track{}
+	children, err := childBoxes(moov)
+	if err != nil {
+		return nil, err
+	}
+	var trexes [][]byte
+	for _, child := range children {
+		switch child.kind {
+		case "trak":
+			id, track, err := parseMP4Trak(child.data)
+			if err != nil {
+				return nil, err
+			}
+			tracks[id] = track
+		case "mvex":
+			mvex, err := childBoxes(child.data)
+			if err != nil {
+				return nil, err
+			}
+			for _, c := range mvex {
+				if c.kind == "trex" {
+					trexes = append(trexes, c.data)
+				}
+			}
+		}
+	}
+	if len(trexes) == 0 {
+		return nil, fmt.Errorf("%w: no mvex box", errNotFragmented)
+	}
+	for _, trex := range trexes {
+		// version/flags, track_ID, default_sample_description_index,
+		// default_sample_duration, default_sample_size, default_sample_flags
+		if len(trex) < 24 {
+			return nil, errors.New("truncated trex box")
+		}
+		if track := tracks[binary.BigEndian.Uint32(trex[4:8])]; track != nil {
+			track.defaultDuration = binary.BigEndian.Uint32(trex[12:16])
+			track.defaultSize = binary.BigEndian.Uint32(trex[16:20])
+		}
+	}
+	return tracks, nil
+}
+
+func parseMP4Trak(trak []byte) (uint32, *mp4Track, error) {
+	var id uint32
+	track := &mp4Track{}
+	children, err := childBoxes(trak)
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, child := range children {
+		switch child.kind {
+		case "tkhd":
+			data := child.data
+			// track_ID follows the creation and modification times,
+			// which are 64-bit in version 1.
+			at := 12
+			if len(data) > 0 && data[0] == 1 {
+				at = 20
+			}
+			if len(data) < at+4 {
+				return 0, nil, errors.New("truncated tkhd box")
+			}
+			id = binary.BigEndian.Uint32(data[at : at+4])
+		case "mdia":
+			mdia, err := childBoxes(child.data)
+			if err != nil {
+				return 0, nil, err
+			}
+			for _, c := range mdia {
+				data := c.data
+				switch c.kind {
+				case "mdhd":
+					at := 12
+					if len(data) > 0 && data[0] == 1 {
+						at = 20
+					}
+					if len(data) < at+4 {
+						return 0, nil, errors.New("truncated mdhd box")
+					}
+					track.timescale = binary.BigEndian.Uint32(data[at : at+4])
+				case "hdlr":
+					if len(data) < 12 {
+						return 0, nil, errors.New("truncated hdlr box")
+					}
+					track.handler = string(data[8:12])
+				}
+			}
+		}
+	}
+	if id == 0 || track.timescale == 0 {
+		return 0, nil, errors.New("track without an ID or timescale")
+	}
+	return id, track, nil
+}
+
+// parseMP4Fragment reads a moof box's payload, with the moof at
+// moofOffset, returning the duration of its video samples in seconds and
+// the file offset just past its first video sample.
+func parseMP4Fragment(moof []byte, moofOffset int64, tracks map[uint32]*mp4Track, videoTrack uint32) (float64, int64, error) {
+	children, err := childBoxes(moof)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, child := range children {
+		if child.kind != "traf" {
+			continue
+		}
+		traf, err := childBoxes(child.data)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		var tfhd []byte
+		var truns [][]byte
+		for _, c := range traf {
+			switch c.kind {
+			case "tfhd":
+				tfhd = c.data
+			case "trun":
+				truns = append(truns, c.data)
+			}
+		}
+		if len(tfhd) < 8 {
+			return 0, 0, errors.New("traf without a tfhd box")
+		}
+		if binary.BigEndian.Uint32(tfhd[4:8]) != videoTrack {
+			continue
+		}
+		track := tracks[videoTrack]
+
+		// tfhd: version/flags and track_ID, then optional fields.
+		flags := binary.BigEndian.Uint32(tfhd[0:4]) & 0xffffff
+		base := moofOffset
+		defaultDuration, defaultSize := track.defaultDuration, track.defaultSize
+		at := 8
+		field := func(flag uint32, width int) (uint64, bool, error) {
+			if flags&flag == 0 {
+				return 0, false, nil
+			}
+			if len(tfhd) < at+width {
+				return 0, false, errors.New("truncated tfhd box")
+			}
+			var v uint64
+			if width == 8 {
+				v = binary.BigEndian.Uint64(tfhd[at : at+8])
+			} else {
+				v = uint64(binary.BigEndian.Uint32(tfhd[at : at+4]))
+			}
+			at += width
+			return v, true, nil
+		}
+		if v, ok, err := field(0x01, 8); err != nil {
+			return 0, 0, err
+		} else if ok {
+			base = int64(v)
+		}
+		if _, _, err := field(0x02, 4); err != nil {
+			return 0, 0, err
+		}
+		if v, ok, err := field(0x08, 4); err != nil {
+			return 0, 0, err
+		} else if ok {
+			defaultDuration = uint32(v)
+		}
+		if v, ok, err := field(0x10, 4); err != nil {
+			return 0, 0, err
+		} else if ok {
+			defaultSize = uint32(v)
+		}
+
+		var ticks uint64
+		keyframeEnd := int64(-1)
+		for _, trun := range truns {
+			duration, first, err := parseMP4Trun(trun, base, defaultDuration, defaultSize)
+			if err != nil {
+				return 0, 0, err
+			}
+			ticks += duration
+			if keyframeEnd < 0 {
+				keyframeEnd = first
+			}
+		}
+		if keyframeEnd < 0 {
+			return 0, 0, errors.New("fragment has no video samples")
+		}
+		return float64(ticks) / float64(track.timescale), keyframeEnd, nil
+	}
+	return 0, 0, errors.New("fragment has no video track")
+}
+
+// parseMP4Trun sums the durations of a trun box's samples and returns the
+// file offset just past its first sample, with data offsets relative to
+// base.
+func parseMP4Trun(trun []byte, base int64, defaultDuration, defaultSize uint32) (uint64, int64, error) {
+	if len(trun) < 8 {
+		return 0, 0, errors.New("truncated trun box")
+	}
+	flags := binary.BigEndian.Uint32(trun[0:4]) & 0xffffff
+	count := binary.BigEndian.Uint32(trun[4:8])
+	at := 8
+	dataOffset := int64(0)
+	if flags&0x01 != 0 {
+		if len(trun) < at+4 {
+			return 0, 0, errors.New("truncated trun box")
+		}
+		dataOffset = int64(int32(binary.BigEndian.Uint32(trun[at : at+4])))
+		at += 4
+	}
+	if flags&0x04 != 0 {
+		at += 4
+	}
+
+	perSample := 0
+	for _, flag := range []uint32{0x100, 0x200, 0x400, 0x800} {
+		if flags&flag != 0 {
+			perSample += 4
+		}
+	}
+	if count == 0 || len(trun) < at+int(count)*perSample {
+		return 0, 0, errors.New("truncated trun box")
+	}
+
+	var ticks uint64
+	var firstSize uint32
+	for i := range int(count) {
+		duration, size := defaultDuration, defaultSize
+		if flags&0x100 != 0 {
+			duration = binary.BigEndian.Uint32(trun[at : at+4])
+			at += 4
+		}
+		if flags&0x200 != 0 {
+			size = binary.BigEndian.Uint32(trun[at : at+4])
+			at += 4
+		}
+		if flags&0x400 != 0 {
+			at += 4
+		}
+		if flags&0x800 != 0 {
+			at += 4
+		}
+		ticks += uint64(duration)
+		if i == 0 {
+			firstSize = size
+		}
+	}
+	return ticks, base + dataOffset + int64(firstSize), nil
+}
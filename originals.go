@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// archiveStorageClasses take minutes to hours to restore before an object
+// can be read, in exchange for the cheapest storage.
+var archiveStorageClasses = []string{"GLACIER", "DEEP_ARCHIVE"}
+
+const (
+	originalAvailable = "available"
+	originalArchived  = "archived"
+	originalRestoring = "restoring"
+)
+
+// parseOriginalStorageClass reads ORIGINAL_STORAGE_CLASS. Unlike
+// thumbnails, originals are rarely read, so archive classes are allowed.
+func parseOriginalStorageClass(key, value string) string {
+	class := strings.ToUpper(strings.TrimSpace(value))
+	if class != "" && !slices.Contains(instantStorageClasses, class) && !slices.Contains(archiveStorageClasses, class) {
+		log.Fatalf("%s must be one of %s", key, strings.Join(append(slices.Clone(instantStorageClasses), archiveStorageClasses...), ", "))
+	}
+	return class
+}
+
+// originalArchivedIn reports whether a video's kept original is in an
+// archive class, so reading or copying it needs a restore first.
+func originalArchivedIn(video database.Video) bool {
+	return video.OriginalURL != nil && video.OriginalStorageClass != nil &&
+		slices.Contains(archiveStorageClasses, *video.OriginalStorageClass)
+}
+
+// originalStatus is what the owner needs to know before fetching a kept
+// original.
+type originalStatus struct {
+	URL          string `json:"url"`
+	StorageClass string `json:"storage_class"`
+	// Status is "available", "archived" until a restore is asked for, or
+	// "restoring" while one is underway.
+	Status string `json:"status"`
+	// RestoredUntil is when a restored copy of an archived original goes
+	// away again.
+	RestoredUntil *time.Time `json:"restored_until,omitempty"`
+}
+
+// handlerVideoOriginal reports whether a video's kept original can be
+// downloaded now or has to be restored from archive storage first.
+func (cfg *apiConfig) handlerVideoOriginal(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.originalForRequest(w, r)
+	if !ok {
+		return
+	}
+	status, err := cfg.originalStatus(r, video)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't check the original", err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, status)
+}
+
+// handlerVideoOriginalRestore asks S3 to bring an archived original back
+// for ORIGINAL_RESTORE_DAYS. Restores take minutes to hours depending on
+// the class, so it answers 202 and the client polls GET .../original until
+// the status is "available".
+func (cfg *apiConfig) handlerVideoOriginalRestore(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.originalForRequest(w, r)
+	if !ok {
+		return
+	}
+	if !originalArchivedIn(video) {
+		status, err := cfg.originalStatus(r, video)
+		if err != nil {
+			respondWithError(w, http.StatusBadGateway, "Couldn't check the original", err)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, status)
+		return
+	}
+
+	store := cfg.forVideo(video)
+	key, err := cfg.s3KeyFromURL(*video.OriginalURL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find the original", err)
+		return
+	}
+	_, err = store.s3Client.RestoreObject(r.Context(), &s3.RestoreObjectInput{
+		Bucket: &store.s3Bucket,
+		Key:    &key,
+		RestoreRequest: &types.RestoreRequest{
+			Days:                 aws.Int32(int32(cfg.transcode.originalRestoreDays)),
+			GlacierJobParameters: &types.GlacierJobParameters{Tier: types.TierStandard},
+		},
+	})
+	var apiErr smithy.APIError
+	if err != nil && !(errors.As(err, &apiErr) && apiErr.ErrorCode() == "RestoreAlreadyInProgress") {
+		respondWithError(w, http.StatusBadGateway, "Couldn't restore the original", err)
+		return
+	}
+	log.Printf("Restoring original of video %s from %s", video.ID, *video.OriginalStorageClass)
+
+	status, err := cfg.originalStatus(r, video)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't check the original", err)
+		return
+	}
+	code := http.StatusAccepted
+	if status.Status == originalAvailable {
+		// Restored already, perhaps by an earlier request; S3 just
+		// extended how long it stays.
+		code = http.StatusOK
+	}
+	respondWithJSON(w, code, status)
+}
+
+// originalForRequest loads the video named in the path and checks the
+// caller owns it and it has a kept original, writing an error if not.
+func (cfg *apiConfig) originalForRequest(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	videoID, err := cfg.parseVideoID(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return database.Video{}, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Video{}, false
+	}
+	userID, err := cfg.validateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Video{}, false
+	}
+
+	video, err := cfg.getVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithDBError(w, http.StatusNotFound, "Couldn't get video", err)
+		return database.Video{}, false
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return database.Video{}, false
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "Only the owner can get the original", nil)
+		return database.Video{}, false
+	}
+	if video.OriginalURL == nil {
+		respondWithError(w, http.StatusNotFound, "No original was kept for this video", nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
+
+// originalStatus checks with S3 whether an archived original has been
+// restored. Originals in other classes are always available.
+func (cfg *apiConfig) originalStatus(r *http.Request, video database.Video) (originalStatus, error) {
+	status := originalStatus{
+		URL:          *video.OriginalURL,
+		StorageClass: "STANDARD",
+		Status:       originalAvailable,
+	}
+	if video.OriginalStorageClass != nil {
+		status.StorageClass = *video.OriginalStorageClass
+	}
+	if !originalArchivedIn(video) {
+		return status, nil
+	}
+
+	store := cfg.forVideo(video)
+	key, err := cfg.s3KeyFromURL(*video.OriginalURL)
+	if err != nil {
+		return originalStatus{}, err
+	}
+	head, err := store.s3Client.HeadObject(r.Context(), &s3.HeadObjectInput{
+		Bucket: &store.s3Bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return originalStatus{}, err
+	}
+	status.Status, status.RestoredUntil = parseRestoreHeader(aws.ToString(head.Restore))
+	return status, nil
+}
+
+// parseRestoreHeader reads the x-amz-restore header of an archived object:
+// absent when no restore was asked for, ongoing-request="true" while one
+// runs, and ongoing-request="false" with the expiry-date of the copy once
+// it's done.
+func parseRestoreHeader(header string) (string, *time.Time) {
+	if header == "" {
+		return originalArchived, nil
+	}
+	if strings.Contains(header, `ongoing-request="true"`) {
+		return originalRestoring, nil
+	}
+	_, expiry, ok := strings.Cut(header, `expiry-date="`)
+	if !ok {
+		return originalAvailable, nil
+	}
+	expiry, _, _ = strings.Cut(expiry, `"`)
+	until, err := http.ParseTime(expiry)
+	if err != nil {
+		return originalAvailable, nil
+	}
+	until = until.UTC()
+	return originalAvailable, &until
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestOriginalColdStorage(t *testing.T) {
+	cfg, store, _ := newTestConfig(t)
+	cfg.transcode.originalStorageClass = "DEEP_ARCHIVE"
+	cfg.transcode.originalRestoreDays = 7
+	user, token := createTestUser(t, cfg)
+	video := createTestVideo(t, cfg, user.ID)
+
+	req := newUploadRequest(t, "/api/video_upload/"+video.ID.String(), "video", "clip.mp4", "video/mp4", testMP4)
+	if rec := uploadVideo(cfg, req, video.ID.String(), token); rec.Code != http.StatusOK {
+		t.Fatalf("upload: status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	video, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.OriginalURL == nil || !strings.Contains(*video.OriginalURL, "/originals/") {
+		t.Fatalf("original_url = %v, want a key under originals/", video.OriginalURL)
+	}
+	if video.OriginalStorageClass == nil || *video.OriginalStorageClass != "DEEP_ARCHIVE" {
+		t.Errorf("original_storage_class = %v, want DEEP_ARCHIVE", video.OriginalStorageClass)
+	}
+	objectKey := "tubely-test/" + strings.TrimPrefix(*video.OriginalURL, "https://cdn.example/")
+	if class := store.objects[objectKey].storageClass; class != types.StorageClassDeepArchive {
+		t.Errorf("stored original in %q, want DEEP_ARCHIVE", class)
+	}
+
+	call := func(method, path string, h http.HandlerFunc) (int, originalStatus) {
+		t.Helper()
+		req := httptest.NewRequest(method, "/api/videos/"+video.ID.String()+path, nil)
+		req.SetPathValue("videoID", video.ID.String())
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		var status originalStatus
+		json.Unmarshal(rec.Body.Bytes(), &status)
+		return rec.Code, status
+	}
+
+	if code, status := call(http.MethodGet, "/original", cfg.handlerVideoOriginal); code != http.StatusOK || status.Status != originalArchived {
+		t.Errorf("before restoring: %d %q, want 200 archived", code, status.Status)
+	}
+	if code, status := call(http.MethodPost, "/original/restore", cfg.handlerVideoOriginalRestore); code != http.StatusAccepted || status.Status != originalRestoring {
+		t.Errorf("restore: %d %q, want 202 restoring", code, status.Status)
+	}
+
+	obj := store.objects[objectKey]
+	obj.restore = `ongoing-request="false", expiry-date="Fri, 23 Oct 2026 00:00:00 GMT"`
+	store.objects[objectKey] = obj
+	code, status := call(http.MethodGet, "/original", cfg.handlerVideoOriginal)
+	if code != http.StatusOK || status.Status != originalAvailable {
+		t.Errorf("after restoring: %d %q, want 200 available", code, status.Status)
+	}
+	if want := time.Date(2026, 10, 23, 0, 0, 0, 0, time.UTC); status.RestoredUntil == nil || !status.RestoredUntil.Equal(want) {
+		t.Errorf("restored_until = %v, want %v", status.RestoredUntil, want)
+	}
+
+	video = createTestVideo(t, cfg, user.ID)
+	if code, _ := call(http.MethodGet, "/original", cfg.handlerVideoOriginal); code != http.StatusNotFound {
+		t.Errorf("video without an original: status = %d, want 404", code)
+	}
+}
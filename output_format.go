@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// outputFormat is the container and codecs published videos are stored
+// in.
+type outputFormat struct {
+	name        string
+	ext         string
+	contentType string
+	// args are the ffmpeg codec and muxer options, placed before the
+	// output file.
+	args []string
+}
+
+var outputFormats = map[string]outputFormat{
+	// mp4 copies the streams as uploaded and only moves the moov atom to
+	// the front, so it's cheap but leaves the size alone.
+	"mp4": {
+		name:        "mp4",
+		ext:         ".mp4",
+		contentType: "video/mp4",
+		args:        []string{"-c", "copy", "-movflags", "faststart", "-f", "mp4"},
+	},
+	// webm re-encodes to VP9/Opus, which is much slower but typically a
+	// good deal smaller at the same quality.
+	"webm": {
+		name:        "webm",
+		ext:         ".webm",
+		contentType: "video/webm",
+		args: []string{
+			"-c:v", "libvpx-vp9",
+			"-crf", "32",
+			"-b:v", "0",
+			"-row-mt", "1",
+			"-c:a", "libopus",
+			"-b:a", "128k",
+			"-f", "webm",
+		},
+	},
+}
+
+func parseOutputFormat(name string) (outputFormat, error) {
+	if name == "" {
+		name = "mp4"
+	}
+	format, ok := outputFormats[strings.ToLower(name)]
+	if !ok {
+		names := []string{}
+		for n := range outputFormats {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return outputFormat{}, fmt.Errorf("unsupported VIDEO_OUTPUT_FORMAT %q, want one of %s", name, strings.Join(names, ", "))
+	}
+	return format, nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+)
+
+const maxPageSize = 100
+
+// parsePagination reads the optional limit and offset query parameters.
+// A missing limit means "everything", which is what list endpoints
+// returned before they were paginated.
+func parsePagination(query url.Values) (limit, offset int, err error) {
+	if s := query.Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return 0, 0, errors.New("limit must be between 1 and 100")
+		}
+	}
+	if s := query.Get("offset"); s != "" {
+		offset, err = strconv.Atoi(s)
+		if err != nil || offset < 0 {
+			return 0, 0, errors.New("offset must be a non-negative integer")
+		}
+	}
+	return limit, offset, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"log"
+)
+
+// dHash returns a 64-bit difference hash of the image in r. The image is
+// shrunk to 9x8 gray cells and each bit records whether a cell is brighter
+// than the one to its right, so re-encoding, rescaling or a slight color
+// shift barely changes it, unlike a checksum.
+func dHash(r io.Reader) (uint64, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return 0, err
+	}
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 9 || height < 8 {
+		return 0, fmt.Errorf("image is too small to hash (%dx%d)", width, height)
+	}
+	// Sample about 8x8 pixels per cell; that's plenty to average out
+	// compression noise.
+	step := max(1, min(width/72, height/64))
+
+	var sums [8][9]int
+	var counts [8][9]int
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		row := (y - bounds.Min.Y) * 8 / height
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			col := (x - bounds.Min.X) * 9 / width
+			sums[row][col] += int(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+			counts[row][col]++
+		}
+	}
+
+	var hash uint64
+	for row := range 8 {
+		for col := range 8 {
+			hash <<= 1
+			// Cross-multiplied to compare the averages without rounding.
+			if sums[row][col]*counts[row][col+1] > sums[row][col+1]*counts[row][col] {
+				hash |= 1
+			}
+		}
+	}
+	return hash, nil
+}
+
+// thumbnailHash is the perceptual hash of a thumbnail being stored as 16
+// hex digits, or nil when they're turned off or it can't be worked out, as
+// for formats we can't decode like AVIF.
+func (cfg *apiConfig) thumbnailHash(r io.ReadSeeker) *string {
+	if !cfg.thumbnailStore.perceptualHash {
+		return nil
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		log.Printf("warning: couldn't read thumbnail for its perceptual hash: %v", err)
+		return nil
+	}
+	hash, err := dHash(r)
+	if err != nil {
+		log.Printf("warning: couldn't hash thumbnail: %v", err)
+		return nil
+	}
+	hex := fmt.Sprintf("%016x", hash)
+	return &hex
+}
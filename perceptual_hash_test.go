@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/bits"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// testPicture draws a few blocks of gray on a w by h canvas, scaled so
+// every size shows the same picture.
+func testPicture(w, h int, invert bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			v := uint8((x*4/w)*60 + (y*3/h)*30)
+			if invert {
+				v = 255 - v
+			}
+			img.Set(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	return img
+}
+
+func TestDHash(t *testing.T) {
+	hash := func(encode func(*bytes.Buffer) error) uint64 {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := encode(&buf); err != nil {
+			t.Fatal(err)
+		}
+		h, err := dHash(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	original := hash(func(b *bytes.Buffer) error { return png.Encode(b, testPicture(640, 360, false)) })
+	reencoded := hash(func(b *bytes.Buffer) error {
+		return jpeg.Encode(b, testPicture(320, 180, false), &jpeg.Options{Quality: 40})
+	})
+	different := hash(func(b *bytes.Buffer) error { return png.Encode(b, testPicture(640, 360, true)) })
+
+	if d := bits.OnesCount64(original ^ reencoded); d > defaultSimilarDistance {
+		t.Errorf("re-encoded copy is %d bits away, want at most %d", d, defaultSimilarDistance)
+	}
+	if d := bits.OnesCount64(original ^ different); d <= database.MaxSimilarDistance {
+		t.Errorf("different picture is only %d bits away", d)
+	}
+
+	if _, err := dHash(bytes.NewReader(testAVIF)); err == nil {
+		t.Error("dHash of an undecodable image succeeded")
+	}
+}
+
+func TestHandlerVideoSimilar(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	user, token := createTestUser(t, cfg)
+	other, _ := createTestUser(t, cfg)
+
+	withHash := func(userID uuid.UUID, hash string) database.Video {
+		t.Helper()
+		video := createTestVideo(t, cfg, userID)
+		if hash != "" {
+			video.PerceptualHash = &hash
+			if err := cfg.db.UpdateVideo(video); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return video
+	}
+	video := withHash(user.ID, "00ff00ff00ff00ff")
+	oneBitOff := withHash(user.ID, "00ff00ff00ff00fe")
+	// Differs in every band, but only by one bit each.
+	eightBitsOff := withHash(user.ID, "01fe01fe01fe01fe")
+	withHash(user.ID, "ff00ff00ff00ff00")
+	withHash(other.ID, "00ff00ff00ff00ff")
+	unhashed := withHash(user.ID, "")
+
+	similar := func(video database.Video, query string) (int, []uuid.UUID) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/videos/"+video.ID.String()+"/similar"+query, nil)
+		req.SetPathValue("videoID", video.ID.String())
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		cfg.handlerVideoSimilar(rec, req)
+		if rec.Code != http.StatusOK {
+			return rec.Code, nil
+		}
+		var matches []database.SimilarVideo
+		if err := json.Unmarshal(rec.Body.Bytes(), &matches); err != nil {
+			t.Fatal(err)
+		}
+		ids := []uuid.UUID{}
+		for _, m := range matches {
+			ids = append(ids, m.ID)
+		}
+		return rec.Code, ids
+	}
+
+	if _, got := similar(video, ""); !slices.Equal(got, []uuid.UUID{oneBitOff.ID}) {
+		t.Errorf("similar = %v, want just %s", got, oneBitOff.ID)
+	}
+	if _, got := similar(oneBitOff, "?max_distance=7"); !slices.Equal(got, []uuid.UUID{video.ID, eightBitsOff.ID}) {
+		t.Errorf("similar within 7 bits = %v, want %s then %s", got, video.ID, eightBitsOff.ID)
+	}
+	if code, _ := similar(video, "?max_distance=8"); code != http.StatusBadRequest {
+		t.Errorf("max_distance past the bands: status = %d, want 400", code)
+	}
+	if code, _ := similar(unhashed, ""); code != http.StatusConflict {
+		t.Errorf("video without a hash: status = %d, want 409", code)
+	}
+}
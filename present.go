@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// presentVideo fills in response-only fields. The default thumbnail is
+// substituted here rather than stored, so changing the placeholder updates
+// every video and a later upload takes precedence. Per-video overrides
+// belong here too.
+func (cfg *apiConfig) presentVideo(video database.Video) database.Video {
+	if video.ThumbnailURL == nil && cfg.defaultThumbnail != "" {
+		url := cfg.defaultThumbnail
+		video.ThumbnailURL = &url
+		video.ThumbnailIsDefault = true
+	}
+	return video
+}
+
+func (cfg *apiConfig) presentVideos(videos []database.Video) []database.Video {
+	presented := make([]database.Video, len(videos))
+	for i, video := range videos {
+		presented[i] = cfg.presentVideo(video)
+	}
+	return presented
+}
+
+// presentVideoTo is presentVideo for a response read by viewer, who is
+// uuid.Nil when anonymous. Only the owner sees the kept original, and when
+// downloads are off nobody else gets the permanent file URLs either; they
+// play the video through the download endpoint, which only hands out
+// short-lived streaming links.
+func (cfg *apiConfig) presentVideoTo(video database.Video, viewer uuid.UUID) database.Video {
+	video = cfg.presentVideo(video)
+	if viewer != uuid.Nil && viewer == video.UserID {
+		return video
+	}
+	video.OriginalURL = nil
+	if !video.DownloadAllowed {
+		video.VideoURL = nil
+		video.Variants = nil
+	}
+	return video
+}
+
+func (cfg *apiConfig) presentVideosTo(videos []database.Video, viewer uuid.UUID) []database.Video {
+	presented := make([]database.Video, len(videos))
+	for i, video := range videos {
+		presented[i] = cfg.presentVideoTo(video, viewer)
+	}
+	return presented
+}
+
+// parseFieldSelection reads ?fields=title,video_url. It returns nil when
+// the parameter is absent, meaning every field. Names are trimmed and
+// lowercased; unknown ones are left for projectFields to drop.
+func parseFieldSelection(query url.Values) map[string]bool {
+	if !query.Has("fields") {
+		return nil
+	}
+	fields := map[string]bool{"id": true}
+	for _, name := range strings.Split(query.Get("fields"), ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
+// projectFields limits a JSON object, or an array of them, to the selected
+// keys. The id is always kept so list items can still be told apart.
+// Working on the marshaled form means the JSON tags are the field names and
+// response-only fields such as thumbnail_is_default can be selected too.
+func projectFields(payload any, fields map[string]bool) (any, error) {
+	if fields == nil {
+		return payload, nil
+	}
+	dat, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	project := func(obj map[string]json.RawMessage) map[string]json.RawMessage {
+		for key := range obj {
+			if !fields[key] {
+				delete(obj, key)
+			}
+		}
+		return obj
+	}
+	if len(dat) > 0 && dat[0] == '[' {
+		var objs []map[string]json.RawMessage
+		if err := json.Unmarshal(dat, &objs); err != nil {
+			return nil, err
+		}
+		for _, obj := range objs {
+			project(obj)
+		}
+		return objs, nil
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(dat, &obj); err != nil {
+		return nil, err
+	}
+	return project(obj), nil
+}
+
+// respondWithVideoFields responds with payload limited to the request's
+// ?fields= selection.
+func respondWithVideoFields(w http.ResponseWriter, r *http.Request, payload any) {
+	projected, err := projectFields(payload, parseFieldSelection(r.URL.Query()))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't select fields", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, projected)
+}
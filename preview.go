@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// previewConfig controls the short looping clip shown on hover.
+type previewConfig struct {
+	length time.Duration
+	// start is where the clip begins; 0 centers it in the video.
+	start  time.Duration
+	format string
+	width  int
+}
+
+var errVideoTooShort = errors.New("video is too short")
+
+var previewMediaTypes = map[string]string{
+	"webp": "image/webp",
+	"gif":  "image/gif",
+}
+
+// generatePreview cuts a clip out of the video at videoPath and encodes it
+// as a small looping animation. It returns the path of the animation, which
+// the caller must remove.
+func (cfg *apiConfig) generatePreview(ctx context.Context, videoPath string) (string, error) {
+	duration, err := cfg.getVideoDuration(ctx, videoPath)
+	if err != nil {
+		return "", fmt.Errorf("couldn't get video duration: %w", err)
+	}
+
+	length := cfg.preview.length.Seconds()
+	if duration < length {
+		return "", fmt.Errorf("%w: %.1fs is shorter than the %.1fs preview", errVideoTooShort, duration, length)
+	}
+	start := (duration - length) / 2
+	if cfg.preview.start > 0 {
+		start = cfg.preview.start.Seconds()
+		if start+length > duration {
+			start = duration - length
+		}
+	}
+
+	outputPath := videoPath + ".preview." + cfg.preview.format
+	scale := fmt.Sprintf("fps=10,scale=%d:-2:flags=lanczos", cfg.preview.width)
+
+	args := []string{
+		"-y",
+		"-ss", strconv.FormatFloat(start, 'f', 3, 64),
+		"-t", strconv.FormatFloat(length, 'f', 3, 64),
+		"-i", videoPath,
+		"-an",
+		"-loop", "0",
+	}
+	switch cfg.preview.format {
+	case "gif":
+		// A per-clip palette keeps GIFs from banding badly.
+		args = append(args, "-vf", scale+",split[a][b];[a]palettegen[p];[b][p]paletteuse")
+	default:
+		args = append(args, "-vf", scale, "-c:v", "libwebp", "-quality", "60")
+	}
+	args = append(args, cfg.ffmpeg.outputArgs()...)
+	args = append(args, "-f", cfg.preview.format, outputPath)
+
+	if err := cfg.ffmpeg.run(ctx, exec.CommandContext(ctx, "ffmpeg", args...)); err != nil {
+		os.Remove(outputPath)
+		return "", fmt.Errorf("ffmpeg preview generation failed: %w", err)
+	}
+	return outputPath, nil
+}
+
+// uploadPreview generates a preview for the video at videoPath, stores it
+// in S3 and returns its URL.
+func (cfg *apiConfig) uploadPreview(ctx context.Context, videoPath string) (string, error) {
+	previewPath, err := cfg.generatePreview(ctx, videoPath)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(previewPath)
+
+	fileName, err := randomFileName("." + cfg.preview.format)
+	if err != nil {
+		return "", err
+	}
+	key := cfg.s3KeyPrefix + "previews/" + fileName
+	return cfg.uploadFileToS3(ctx, previewPath, key, previewMediaTypes[cfg.preview.format])
+}
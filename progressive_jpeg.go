@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// isProgressiveJPEG reports whether the JPEG read from r is progressive,
+// going by its start-of-frame marker: SOF2 (or SOF6, SOF10, SOF14) rather
+// than the baseline SOF0.
+func isProgressiveJPEG(r io.Reader) (bool, error) {
+	br := bufio.NewReader(r)
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil || soi != [2]byte{0xFF, 0xD8} {
+		return false, errors.New("not a JPEG")
+	}
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return false, err
+		}
+		if b != 0xFF {
+			return false, errors.New("corrupt JPEG: expected a marker")
+		}
+		marker, err := br.ReadByte()
+		for err == nil && marker == 0xFF { // fill bytes
+			marker, err = br.ReadByte()
+		}
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case marker >= 0xD0 && marker <= 0xD7, marker == 0x01:
+			// Standalone markers have no length.
+			continue
+		case marker == 0xDA, marker == 0xD9:
+			return false, errors.New("JPEG has no start-of-frame marker")
+		case marker >= 0xC0 && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC:
+			return marker == 0xC2 || marker == 0xC6 || marker == 0xCA || marker == 0xCE, nil
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(br, length[:]); err != nil {
+			return false, err
+		}
+		n := int(length[0])<<8 | int(length[1])
+		if n < 2 {
+			return false, errors.New("corrupt JPEG: bad segment length")
+		}
+		if _, err := br.Discard(n - 2); err != nil {
+			return false, err
+		}
+	}
+}
+
+// progressiveJPEG rewrites the JPEG at path as progressive with jpegtran,
+// which reorders the existing coefficients rather than re-encoding, so no
+// quality is lost. It returns the new file's path, which the caller must
+// remove, or "" if the image was already progressive.
+func (cfg *apiConfig) progressiveJPEG(ctx context.Context, path string) (string, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	progressive, err := isProgressiveJPEG(in)
+	in.Close()
+	if err != nil {
+		return "", err
+	}
+	if progressive {
+		return "", nil
+	}
+
+	outputPath := path + ".progressive.jpg"
+	cmd := exec.CommandContext(ctx, "jpegtran", "-progressive", "-optimize", "-copy", "all", "-outfile", outputPath, path)
+	if err := cfg.ffmpeg.run(ctx, cmd); err != nil {
+		os.Remove(outputPath)
+		return "", fmt.Errorf("jpegtran failed: %w", err)
+	}
+
+	out, err := os.Open(outputPath)
+	if err != nil {
+		return "", err
+	}
+	progressive, err = isProgressiveJPEG(out)
+	out.Close()
+	if err == nil && !progressive {
+		err = errors.New("jpegtran output isn't progressive")
+	}
+	if err != nil {
+		os.Remove(outputPath)
+		return "", err
+	}
+	return outputPath, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// testBaselineJPEG encodes a small image; image/jpeg only writes baseline.
+func testBaselineJPEG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 16, 16)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// asProgressive returns data with its SOF0 marker swapped for SOF2, which
+// is all isProgressiveJPEG looks at.
+func asProgressive(t *testing.T, data []byte) []byte {
+	t.Helper()
+	i := bytes.Index(data, []byte{0xFF, 0xC0})
+	if i < 0 {
+		t.Fatal("no SOF0 marker")
+	}
+	out := bytes.Clone(data)
+	out[i+1] = 0xC2
+	return out
+}
+
+func TestIsProgressiveJPEG(t *testing.T) {
+	baseline := testBaselineJPEG(t)
+	progressive := asProgressive(t, baseline)
+	// A progressive frame header preceded by an APP0 segment, fill bytes
+	// and standalone markers, none of which carry a length.
+	withPadding := []byte{
+		0xFF, 0xD8,
+		0xFF, 0xE0, 0x00, 0x04, 'J', 'F',
+		0xFF, 0xFF, 0xFF, 0xD0,
+		0xFF, 0x01,
+		0xFF, 0xC2, 0x00, 0x08, 0x08, 0x00, 0x10, 0x00, 0x10, 0x01,
+	}
+	sof := bytes.Index(baseline, []byte{0xFF, 0xC0})
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    bool
+		wantErr bool
+	}{
+		{"baseline", baseline, false, false},
+		{"progressive", progressive, true, false},
+		{"fill bytes and standalone markers", withPadding, true, false},
+		{"huffman table isn't a frame", []byte{0xFF, 0xD8, 0xFF, 0xC4, 0x00, 0x02, 0xFF, 0xC0, 0x00, 0x02}, false, false},
+		{"empty", nil, false, true},
+		{"not a JPEG", []byte("\x89PNG\r\n\x1a\n"), false, true},
+		{"truncated before the frame", baseline[:sof], false, true},
+		{"truncated inside a segment length", []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00}, false, true},
+		{"truncated inside a segment", []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x10, 'J'}, false, true},
+		{"bad segment length", []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x01}, false, true},
+		{"garbage between segments", []byte{0xFF, 0xD8, 0x00, 0xC2}, false, true},
+		{"scan before any frame", []byte{0xFF, 0xD8, 0xFF, 0xDA, 0x00, 0x02}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := isProgressiveJPEG(bytes.NewReader(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("isProgressiveJPEG = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProgressiveThumbnail(t *testing.T) {
+	baseline := testBaselineJPEG(t)
+
+	tests := []struct {
+		name          string
+		input         []byte
+		jpegtran      func(cmd *exec.Cmd) error
+		want          []byte
+		wantConverted bool
+	}{
+		{
+			name:  "jpegtran missing",
+			input: baseline,
+			jpegtran: func(cmd *exec.Cmd) error {
+				return &exec.Error{Name: "jpegtran", Err: exec.ErrNotFound}
+			},
+			want: baseline,
+		},
+		{
+			name:  "jpegtran output still baseline",
+			input: baseline,
+			jpegtran: func(cmd *exec.Cmd) error {
+				return os.WriteFile(cmd.Args[len(cmd.Args)-2], baseline, 0o600)
+			},
+			want: baseline,
+		},
+		{
+			name:  "converted",
+			input: baseline,
+			jpegtran: func(cmd *exec.Cmd) error {
+				return os.WriteFile(cmd.Args[len(cmd.Args)-2], asProgressive(t, baseline), 0o600)
+			},
+			want:          asProgressive(t, baseline),
+			wantConverted: true,
+		},
+		{
+			name:  "already progressive",
+			input: asProgressive(t, baseline),
+			jpegtran: func(cmd *exec.Cmd) error {
+				t.Error("ran jpegtran on a progressive JPEG")
+				return nil
+			},
+			want: asProgressive(t, baseline),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, _, _ := newTestConfig(t)
+			cfg.ffmpeg.runner = tt.jpegtran
+
+			thumbnail, converted, cleanup, err := cfg.progressiveThumbnail(context.Background(), bytes.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cleanup()
+			got, err := io.ReadAll(thumbnail)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if converted != tt.wantConverted || !bytes.Equal(got, tt.want) {
+				t.Errorf("converted = %v with %d bytes, want %v with %d bytes", converted, len(got), tt.wantConverted, len(tt.want))
+			}
+		})
+	}
+
+	// The upload itself still succeeds and stores the original.
+	cfg, store, _ := newTestConfig(t)
+	cfg.thumbnailStore = thumbnailStoreConfig{useS3: true, progressiveJPEG: true, keyScheme: thumbnailKeysVideo}
+	cfg.ffmpeg.runner = tests[0].jpegtran
+	user, _ := createTestUser(t, cfg)
+	video := createTestVideo(t, cfg, user.ID)
+	if _, err := cfg.storeThumbnail(context.Background(), video.ID, bytes.NewReader(baseline), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	stored := false
+	for key, obj := range store.objects {
+		if strings.Contains(key, "thumbnails/"+video.ID.String()) {
+			stored = bytes.Equal(obj.body, baseline)
+		}
+	}
+	if !stored {
+		t.Errorf("thumbnail wasn't stored as uploaded; objects: %v", store.keys())
+	}
+}
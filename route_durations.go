@@ -0,0 +1,39 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+// routeDurations is a duration setting with optional per-route overrides,
+// keyed by mux pattern such as "POST /api/video_upload/{videoID}".
+type routeDurations struct {
+	// def applies to routes without an override.
+	def    time.Duration
+	routes map[string]time.Duration
+}
+
+func (d routeDurations) forPattern(pattern string) time.Duration {
+	if v, ok := d.routes[pattern]; ok {
+		return v
+	}
+	return d.def
+}
+
+// parseRouteDurations reads entries of the form "<pattern>=<duration>".
+func parseRouteDurations(key string, entries []string) map[string]time.Duration {
+	routes := map[string]time.Duration{}
+	for _, entry := range entries {
+		i := strings.LastIndex(entry, "=")
+		if i <= 0 {
+			log.Fatalf("%s entry %q must look like \"GET /path=5s\"", key, entry)
+		}
+		d, err := time.ParseDuration(entry[i+1:])
+		if err != nil {
+			log.Fatalf("%s entry %q: %v", key, entry, err)
+		}
+		routes[strings.TrimSpace(entry[:i])] = d
+	}
+	return routes
+}
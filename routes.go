@@ -0,0 +1,101 @@
+package main
+
+import "net/http"
+
+// routes registers every endpoint. The app and assets are served from
+// filepathRoot and assetsRoot.
+func (cfg *apiConfig) routes(filepathRoot, assetsRoot string) *http.ServeMux {
+	mux := http.NewServeMux()
+	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
+	mux.Handle("/app/", appHandler)
+
+	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
+	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
+
+	mux.HandleFunc("GET /api/config/upload", cfg.handlerUploadConfig)
+	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
+	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
+	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
+
+	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
+	mux.HandleFunc("GET /api/users/{userID}/feed.xml", cfg.handlerUserFeed)
+
+	mux.HandleFunc("POST /api/api_keys", cfg.handlerAPIKeysCreate)
+	mux.HandleFunc("GET /api/api_keys", cfg.handlerAPIKeysRetrieve)
+	mux.HandleFunc("DELETE /api/api_keys/{keyID}", cfg.handlerAPIKeysRevoke)
+
+	mux.HandleFunc("POST /api/folders", cfg.handlerFoldersCreate)
+	mux.HandleFunc("GET /api/folders", cfg.handlerFoldersRetrieve)
+
+	mux.HandleFunc("GET /api/storage/objects", cfg.handlerStorageObjects)
+	mux.HandleFunc("GET /api/storage/usage", cfg.handlerStorageUsage)
+
+	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
+	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
+	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
+	mux.HandleFunc("POST /api/video_upload/{videoID}/sessions", cfg.handlerUploadSessionCreate)
+	mux.HandleFunc("GET /api/uploads/{uploadID}", cfg.handlerUploadProgress)
+	mux.HandleFunc("PUT /api/uploads/{uploadID}", cfg.handlerUploadRange)
+	mux.HandleFunc("PUT /api/uploads/{uploadID}/parts/{partNumber}", cfg.handlerUploadPart)
+	mux.HandleFunc("POST /api/uploads/{uploadID}/complete", cfg.handlerUploadComplete)
+	mux.HandleFunc("DELETE /api/uploads/{uploadID}", cfg.handlerUploadAbort)
+	mux.HandleFunc("OPTIONS /api/tus", tusHandler(cfg.handlerTusOptions))
+	mux.HandleFunc("POST /api/tus", tusHandler(cfg.handlerTusCreate))
+	mux.HandleFunc("HEAD /api/tus/{uploadID}", tusHandler(cfg.handlerTusHead))
+	mux.HandleFunc("PATCH /api/tus/{uploadID}", tusHandler(cfg.handlerTusPatch))
+	mux.HandleFunc("DELETE /api/tus/{uploadID}", tusHandler(cfg.handlerTusDelete))
+	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
+	mux.HandleFunc("GET /api/videos/search", cfg.handlerSearchVideos)
+	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
+	mux.HandleFunc("GET /api/videos/{videoID}/download", cfg.handlerVideoDownload)
+	mux.HandleFunc("HEAD /api/videos/{videoID}/download", cfg.handlerVideoDownload)
+	mux.HandleFunc("GET /api/videos/{videoID}/url", cfg.handlerVideoURL)
+	mux.HandleFunc("GET /api/videos/{videoID}/hls/master.m3u8", cfg.handlerHLSMaster)
+	mux.HandleFunc("GET /api/videos/{videoID}/hls/media.m3u8", cfg.handlerHLSMedia)
+	mux.HandleFunc("GET /api/videos/{videoID}/hls/iframes.m3u8", cfg.handlerHLSIFrames)
+	mux.HandleFunc("PUT /api/videos/{videoID}/embed_origins", cfg.handlerVideoEmbedOriginsUpdate)
+	mux.HandleFunc("GET /embed/{videoID}", cfg.handlerEmbed)
+	mux.HandleFunc("GET /watch/{videoID}", cfg.handlerWatch)
+	mux.HandleFunc("GET /oembed", cfg.handlerOEmbed)
+	mux.HandleFunc("GET /.well-known/jwks.json", cfg.handlerJWKS)
+	mux.HandleFunc("GET /api/thumbnails/{videoID}", cfg.handlerThumbnailServe)
+	mux.HandleFunc("GET /api/thumbnails/{videoID}/bytes", cfg.handlerGetThumbnailBytes)
+	mux.HandleFunc("POST /api/videos/{videoID}/preview", cfg.handlerVideoPreviewCreate)
+	mux.HandleFunc("POST /api/videos/{videoID}/contact_sheet", cfg.handlerContactSheetCreate)
+	mux.HandleFunc("GET /api/videos/{videoID}/thumbnails.vtt", cfg.handlerThumbnailsVTT)
+	mux.HandleFunc("POST /api/videos/{videoID}/thumbnail/frame", cfg.handlerThumbnailFromFrame)
+	mux.HandleFunc("POST /api/videos/{videoID}/clips", cfg.handlerPreviewClip)
+	mux.HandleFunc("POST /api/videos/{videoID}/duplicate", cfg.handlerDuplicateVideo)
+	mux.HandleFunc("GET /api/videos/{videoID}/similar", cfg.handlerVideoSimilar)
+	mux.HandleFunc("GET /api/videos/{videoID}/original", cfg.handlerVideoOriginal)
+	mux.HandleFunc("POST /api/videos/{videoID}/original/restore", cfg.handlerVideoOriginalRestore)
+	mux.HandleFunc("PUT /api/videos/{videoID}/visibility", cfg.handlerVideoVisibilityUpdate)
+	mux.HandleFunc("PUT /api/videos/{videoID}/download_allowed", cfg.handlerVideoDownloadAllowedUpdate)
+	mux.HandleFunc("PUT /api/videos/{videoID}/tags", cfg.handlerVideoTagsUpdate)
+	mux.HandleFunc("POST /api/videos/{videoID}/transcript", cfg.handlerVideoTranscriptUpload)
+	mux.HandleFunc("PUT /api/videos/{videoID}/file", cfg.handlerReplaceVideoFile)
+	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	mux.HandleFunc("POST /api/videos/bulk_delete", cfg.handlerBulkDeleteVideos)
+	mux.HandleFunc("POST /api/videos/{videoID}/short_links", cfg.handlerShortLinkCreate)
+	mux.HandleFunc("GET /s/{code}", cfg.handlerShortLinkResolve)
+
+	// Admin routes additionally require a request signature when
+	// ADMIN_SIGNING_SECRET is set.
+	adminRoute := func(pattern string, handler http.HandlerFunc) {
+		mux.Handle(pattern, cfg.adminSignatureMiddleware(handler))
+	}
+	adminRoute("POST /admin/reset", cfg.handlerReset)
+	adminRoute("POST /admin/videos/aspect_ratios", cfg.handlerBackfillAspectRatios)
+	adminRoute("POST /admin/videos/thumbnails", cfg.handlerBackfillThumbnails)
+	adminRoute("POST /admin/videos/aspect_prefixes", cfg.handlerMigrateAspectPrefixes)
+	adminRoute("POST /admin/videos/content_types", cfg.handlerRepairContentTypes)
+	adminRoute("POST /admin/storage/reconcile", cfg.handlerReconcileStorage)
+	adminRoute("GET /admin/metrics", cfg.handlerMetrics)
+	return mux
+}
+
+// serverHandler wraps the routes in the middleware every request goes
+// through, outermost first.
+func (cfg *apiConfig) serverHandler(mux *http.ServeMux) http.Handler {
+	return cfg.apiKeyMiddleware(mux, cfg.slowRequestMiddleware(mux, cfg.connUploadLimitMiddleware(mux, cfg.timeoutMiddleware(mux))))
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// s3API is the subset of *s3.Client the server uses, so handlers can be
+// exercised against an in-memory fake.
+type s3API interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error)
+	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+	ListMultipartUploads(ctx context.Context, params *s3.ListMultipartUploadsInput, optFns ...func(*s3.Options)) (*s3.ListMultipartUploadsOutput, error)
+	CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	UploadPartCopy(ctx context.Context, params *s3.UploadPartCopyInput, optFns ...func(*s3.Options)) (*s3.UploadPartCopyOutput, error)
+	CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+	RestoreObject(ctx context.Context, params *s3.RestoreObjectInput, optFns ...func(*s3.Options)) (*s3.RestoreObjectOutput, error)
+}
+
+// s3Presigner is satisfied by *s3.PresignClient.
+type s3Presigner interface {
+	PresignGetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error)
+}
+
+const (
+	s3VerifyMaxWait  = 10 * time.Second
+	s3VerifyMinDelay = 200 * time.Millisecond
+	s3VerifyMaxDelay = 2 * time.Second
+)
+
+// waitForObject blocks until key is readable with HeadObject. AWS S3 is
+// strongly consistent so this is opt-in, but some S3-compatible stores
+// (MinIO, R2) can briefly 404 right after a PutObject.
+func (cfg *apiConfig) waitForObject(ctx context.Context, key string) error {
+	if !cfg.s3VerifyUploads {
+		return nil
+	}
+
+	waiter := s3.NewObjectExistsWaiter(cfg.s3Client, func(o *s3.ObjectExistsWaiterOptions) {
+		o.MinDelay = s3VerifyMinDelay
+		o.MaxDelay = s3VerifyMaxDelay
+	})
+	err := waiter.Wait(ctx, &s3.HeadObjectInput{
+		Bucket: &cfg.s3Bucket,
+		Key:    &key,
+	}, s3VerifyMaxWait)
+	if err != nil {
+		return fmt.Errorf("object %s not readable after upload: %w", key, err)
+	}
+	return nil
+}
+
+// s3KeyFromURL recovers the object key from a URL built by objectURL. The
+// key includes s3KeyPrefix since the URL path does. URLs on a bucket
+// tier's distribution are recognized too; which bucket holds the key is
+// up to the caller, usually through forVideo.
+func (cfg *apiConfig) s3KeyFromURL(url string) (string, error) {
+	distributions := []string{cfg.s3CfDistribution}
+	for _, target := range cfg.s3Buckets {
+		distributions = append(distributions, target.distribution)
+	}
+	for _, distribution := range distributions {
+		if key, ok := strings.CutPrefix(url, "https://"+distribution+"/"); ok && key != "" {
+			return key, nil
+		}
+	}
+	return "", errors.New("URL does not point at a configured distribution")
+}
+
+const (
+	downloadVerifyOff      = "off"
+	downloadVerifySize     = "size"
+	downloadVerifyChecksum = "checksum"
+)
+
+var errDownloadMismatch = errors.New("downloaded object doesn't match S3's metadata")
+
+// downloadObjectToTemp copies an object into a temp file and returns its
+// path. The caller is responsible for removing it. Unless verification is
+// off, a download that doesn't match the object's length (and, in checksum
+// mode, its digest) is retried once, so a truncated body is never
+// processed.
+func (cfg *apiConfig) downloadObjectToTemp(ctx context.Context, key string) (string, error) {
+	path, err := cfg.fetchObjectToTemp(ctx, key)
+	if errors.Is(err, errDownloadMismatch) {
+		log.Printf("warning: %v; retrying", err)
+		path, err = cfg.fetchObjectToTemp(ctx, key)
+	}
+	return path, err
+}
+
+func (cfg *apiConfig) fetchObjectToTemp(ctx context.Context, key string) (string, error) {
+	input := &s3.GetObjectInput{
+		Bucket: &cfg.s3Bucket,
+		Key:    &key,
+	}
+	if cfg.s3VerifyDownloads == downloadVerifyChecksum {
+		input.ChecksumMode = types.ChecksumModeEnabled
+	}
+	out, err := cfg.s3Client.GetObject(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("couldn't get object %s: %w", key, err)
+	}
+	defer out.Body.Close()
+
+	tempFile, err := os.CreateTemp("", "tubely-download")
+	if err != nil {
+		return "", err
+	}
+	defer tempFile.Close()
+
+	md5Hash, sha256Hash := md5.New(), sha256.New()
+	var dst io.Writer = tempFile
+	if cfg.s3VerifyDownloads == downloadVerifyChecksum {
+		dst = io.MultiWriter(tempFile, md5Hash, sha256Hash)
+	}
+	n, err := io.Copy(dst, out.Body)
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return "", fmt.Errorf("couldn't download object %s: %w", key, err)
+	}
+	if err := cfg.verifyDownload(out, n, md5Hash, sha256Hash); err != nil {
+		os.Remove(tempFile.Name())
+		return "", fmt.Errorf("%w: object %s: %v", errDownloadMismatch, key, err)
+	}
+	return tempFile.Name(), nil
+}
+
+// verifyDownload checks n bytes hashed into md5Hash and sha256Hash against
+// what GetObject reported. A stored full-object SHA-256 is preferred; the
+// ETag is only an MD5 for objects that weren't uploaded in parts or with
+// SSE-KMS, so anything else is checked by length alone.
+func (cfg *apiConfig) verifyDownload(out *s3.GetObjectOutput, n int64, md5Hash, sha256Hash hash.Hash) error {
+	if cfg.s3VerifyDownloads == downloadVerifyOff {
+		return nil
+	}
+	if out.ContentLength != nil && n != *out.ContentLength {
+		return fmt.Errorf("got %d bytes, expected %d", n, *out.ContentLength)
+	}
+	if cfg.s3VerifyDownloads != downloadVerifyChecksum {
+		return nil
+	}
+
+	if sum := aws.ToString(out.ChecksumSHA256); sum != "" && out.ChecksumType == types.ChecksumTypeFullObject {
+		if !digestMatches(sum, sha256Hash) {
+			return errors.New("SHA-256 doesn't match")
+		}
+		return nil
+	}
+	etag := strings.Trim(aws.ToString(out.ETag), `"`)
+	if digest, err := hex.DecodeString(etag); err == nil && len(digest) == md5.Size {
+		if !bytes.Equal(digest, md5Hash.Sum(nil)) {
+			return errors.New("MD5 doesn't match the ETag")
+		}
+	}
+	return nil
+}
+
+// objectURL is the public URL an object is served from, on the
+// distribution of cfg's bucket.
+func (cfg *apiConfig) objectURL(key string) string {
+	distribution := cfg.s3CfDistribution
+	if target, ok := cfg.s3Buckets[cfg.s3Bucket]; ok {
+		distribution = target.distribution
+	}
+	return fmt.Sprintf("https://%s/%s", distribution, key)
+}
+
+// uploadFileToS3 puts the file at path under key and returns its URL.
+func (cfg *apiConfig) uploadFileToS3(ctx context.Context, path, key, contentType string, optFns ...func(*s3.PutObjectInput)) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return cfg.putObject(ctx, key, f, contentType, optFns...)
+}
+
+// putObject uploads body under key and returns its URL. optFns can set
+// further fields of the request, such as checksums.
+func (cfg *apiConfig) putObject(ctx context.Context, key string, body io.Reader, contentType string, optFns ...func(*s3.PutObjectInput)) (string, error) {
+	input := &s3.PutObjectInput{
+		Bucket:      &cfg.s3Bucket,
+		Key:         &key,
+		Body:        body,
+		ContentType: &contentType,
+	}
+	for _, fn := range optFns {
+		fn(input)
+	}
+	_, err := cfg.s3Client.PutObject(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("couldn't upload %s: %w", key, conditionalWriteError(err))
+	}
+	if err := cfg.waitForObject(ctx, key); err != nil {
+		return "", err
+	}
+	return cfg.objectURL(key), nil
+}
+
+// presignOptions customizes a presigned GET. The response overrides make
+// S3 send those headers instead of the ones stored with the object.
+type presignOptions struct {
+	expires            time.Duration
+	contentDisposition string
+	contentType        string
+}
+
+// presignGetObject returns a URL that grants read access to key until it
+// expires, for objects in a private bucket.
+func (cfg *apiConfig) presignGetObject(ctx context.Context, key string, opts presignOptions) (string, error) {
+	input := &s3.GetObjectInput{
+		Bucket: &cfg.s3Bucket,
+		Key:    &key,
+	}
+	if opts.contentDisposition != "" {
+		input.ResponseContentDisposition = &opts.contentDisposition
+	}
+	if opts.contentType != "" {
+		input.ResponseContentType = &opts.contentType
+	}
+
+	req, err := cfg.s3Presigner.PresignGetObject(ctx, input, s3.WithPresignExpires(opts.expires))
+	if err != nil {
+		return "", fmt.Errorf("couldn't presign %s: %w", key, err)
+	}
+	return req.URL, nil
+}
+
+// deleteObjectURL removes the object behind a stored URL. URLs that don't
+// point into our bucket are ignored.
+func (cfg *apiConfig) deleteObjectURL(ctx context.Context, url string) error {
+	key, err := cfg.s3KeyFromURL(url)
+	if err != nil {
+		return nil
+	}
+	_, err = cfg.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: &cfg.s3Bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't delete %s: %w", key, err)
+	}
+	return nil
+}
+
+// errWriteConflict means a conditional write lost to a concurrent one.
+var errWriteConflict = errors.New("conflicting write")
+
+// ifAbsent makes a PutObject fail rather than overwrite an existing object.
+func ifAbsent(input *s3.PutObjectInput) {
+	input.IfNoneMatch = aws.String("*")
+}
+
+// conditionalWriteError turns S3's failed-precondition errors into
+// errWriteConflict.
+func conditionalWriteError(err error) error {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "PreconditionFailed", "ConditionalRequestConflict":
+			return fmt.Errorf("%w: %v", errWriteConflict, err)
+		}
+	}
+	return err
+}
+
+// warnIfAccelerationDisabled checks that Transfer Acceleration is turned on
+// for the bucket, since requests to the accelerate endpoint fail when it
+// isn't. Served URLs go through CloudFront and are unaffected either way.
+func warnIfAccelerationDisabled(ctx context.Context, client *s3.Client, bucket string) {
+	out, err := client.GetBucketAccelerateConfiguration(ctx, &s3.GetBucketAccelerateConfigurationInput{
+		Bucket: &bucket,
+	})
+	if err != nil {
+		log.Printf("warning: S3_ACCELERATE is on but couldn't check bucket %s: %v", bucket, err)
+		return
+	}
+	if out.Status != types.BucketAccelerateStatusEnabled {
+		log.Printf("warning: S3_ACCELERATE is on but Transfer Acceleration isn't enabled on bucket %s", bucket)
+	}
+}
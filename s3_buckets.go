@@ -0,0 +1,105 @@
+package main
+
+import (
+	"log"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// s3BucketTarget is an extra bucket videos can be sharded into, with a
+// client for its region and the distribution that serves it.
+type s3BucketTarget struct {
+	bucket       string
+	distribution string
+	client       s3API
+	presigner    s3Presigner
+}
+
+// bucketTierSpec is one S3_BUCKET_TIERS entry before its client is built.
+type bucketTierSpec struct {
+	name         string
+	bucket       string
+	region       string
+	distribution string
+}
+
+// parseBucketTiers reads entries of the form
+// "<tier>=<bucket>@<region>@<distribution>". The distribution is required
+// since the default one only serves S3_BUCKET.
+func parseBucketTiers(entries []string) []bucketTierSpec {
+	specs := []bucketTierSpec{}
+	for _, entry := range entries {
+		name, target, ok := strings.Cut(entry, "=")
+		parts := strings.Split(target, "@")
+		if !ok || name == "" || len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			log.Fatalf("S3_BUCKET_TIERS entry %q must look like \"eu=my-bucket@eu-west-1@d1234.cloudfront.net\"", entry)
+		}
+		specs = append(specs, bucketTierSpec{name: name, bucket: parts[0], region: parts[1], distribution: parts[2]})
+	}
+	return specs
+}
+
+// parseAspectTiers maps aspect ratios to the bucket of the named tier, from
+// entries of the form "<aspect ratio>=<tier>".
+func parseAspectTiers(entries []string, tiers []bucketTierSpec) map[string]string {
+	buckets := map[string]string{}
+	for _, entry := range entries {
+		ratio, tier, ok := strings.Cut(entry, "=")
+		if !ok || !isKnownAspectRatio(ratio) {
+			log.Fatalf("S3_TIER_BY_ASPECT_RATIO entry %q must look like \"9:16=<tier>\"", entry)
+		}
+		found := false
+		for _, spec := range tiers {
+			if spec.name == tier {
+				buckets[ratio] = spec.bucket
+				found = true
+			}
+		}
+		if !found {
+			log.Fatalf("S3_TIER_BY_ASPECT_RATIO refers to unknown tier %q", tier)
+		}
+	}
+	return buckets
+}
+
+// withBucket returns a copy of cfg whose S3 helpers operate on bucket. The
+// default bucket, or one that's no longer configured, returns cfg itself.
+func (cfg *apiConfig) withBucket(bucket string) *apiConfig {
+	if bucket == "" || bucket == cfg.s3Bucket {
+		return cfg
+	}
+	target, ok := cfg.s3Buckets[bucket]
+	if !ok {
+		log.Printf("warning: bucket %s isn't configured in S3_BUCKET_TIERS, using %s", bucket, cfg.s3Bucket)
+		return cfg
+	}
+	c := *cfg
+	c.s3Bucket = target.bucket
+	c.s3Client = target.client
+	c.s3Presigner = target.presigner
+	return &c
+}
+
+// forVideo returns a cfg that targets the bucket holding video's file.
+// Thumbnails, previews and clips always live in the default bucket.
+func (cfg *apiConfig) forVideo(video database.Video) *apiConfig {
+	if video.S3Bucket == nil {
+		return cfg
+	}
+	return cfg.withBucket(*video.S3Bucket)
+}
+
+// bucketStores returns cfg followed by a cfg for each extra bucket, for
+// jobs that go over every bucket.
+func (cfg *apiConfig) bucketStores() []*apiConfig {
+	stores := []*apiConfig{cfg}
+	for _, bucket := range slices.Sorted(maps.Keys(cfg.s3Buckets)) {
+		if bucket != cfg.s3Bucket {
+			stores = append(stores, cfg.withBucket(bucket))
+		}
+	}
+	return stores
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// withTestTier adds a bucket tier with its own fake S3 and distribution,
+// and sends 16:9 videos to it.
+func withTestTier(cfg *apiConfig) *fakeS3 {
+	store := newFakeS3()
+	cfg.s3Buckets = map[string]s3BucketTarget{
+		"tubely-tier": {
+			bucket:       "tubely-tier",
+			distribution: "tier.cdn.example",
+			client:       store,
+			presigner:    fakePresigner{},
+		},
+	}
+	cfg.s3AspectBuckets = map[string]string{"16:9": "tubely-tier"}
+	return store
+}
+
+func TestParseBucketTiers(t *testing.T) {
+	got := parseBucketTiers([]string{"eu=tubely-eu@eu-west-1@eu.cdn.example"})
+	want := []bucketTierSpec{{name: "eu", bucket: "tubely-eu", region: "eu-west-1", distribution: "eu.cdn.example"}}
+	if !slices.Equal(got, want) {
+		t.Errorf("parseBucketTiers = %+v, want %+v", got, want)
+	}
+}
+
+func TestUploadToBucketTier(t *testing.T) {
+	cfg, defaultStore, _ := newTestConfig(t)
+	tierStore := withTestTier(cfg)
+	user, token := createTestUser(t, cfg)
+	video := createTestVideo(t, cfg, user.ID)
+
+	req := newUploadRequest(t, "/api/video_upload/"+video.ID.String(), "video", "clip.mp4", "video/mp4", testMP4)
+	if rec := uploadVideo(cfg, req, video.ID.String(), token); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	stored, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.VideoURL == nil || !strings.HasPrefix(*stored.VideoURL, "https://tier.cdn.example/") {
+		t.Fatalf("video_url = %v, want it on the tier's distribution", stored.VideoURL)
+	}
+	if stored.S3Bucket == nil || *stored.S3Bucket != "tubely-tier" {
+		t.Errorf("s3_bucket = %v, want tubely-tier", stored.S3Bucket)
+	}
+	if keys := defaultStore.keys(); len(keys) != 0 {
+		t.Errorf("default bucket has %v, want nothing", keys)
+	}
+	key, err := cfg.s3KeyFromURL(*stored.VideoURL)
+	if err != nil {
+		t.Fatalf("s3KeyFromURL: %v", err)
+	}
+	if keys := tierStore.keys(); !slices.Equal(keys, []string{"tubely-tier/" + key}) {
+		t.Errorf("tier bucket has %v, want tubely-tier/%s", keys, key)
+	}
+
+	url, _, err := cfg.signedVideoURL(context.Background(), stored, downloadModeInline, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(url, "https://presigned.example/tubely-tier/"+key) {
+		t.Errorf("signed URL = %s, want one for the tier bucket", url)
+	}
+}
+
+func TestDeleteOrphanedObjectsAllBuckets(t *testing.T) {
+	cfg, defaultStore, _ := newTestConfig(t)
+	tierStore := withTestTier(cfg)
+	cfg.s3Cleanup.delete = true
+	user, _ := createTestUser(t, cfg)
+
+	kept := createTestVideo(t, cfg, user.ID)
+	storeTestVideo(t, cfg, defaultStore, &kept)
+	// A video stored on its tier with a URL on the default distribution,
+	// as tiers were before they had their own.
+	tierKept := createTestVideo(t, cfg, user.ID)
+	bucket := "tubely-tier"
+	tierKept.S3Bucket = &bucket
+	storeTestVideo(t, cfg, tierStore, &tierKept)
+	tierKey := strings.TrimPrefix(*tierKept.VideoURL, "https://"+cfg.s3CfDistribution+"/")
+
+	defaultStore.put(cfg.s3Bucket, "landscape/orphan.mp4", testMP4, "video/mp4")
+	tierStore.put("tubely-tier", "landscape/orphan.mp4", testMP4, "video/mp4")
+
+	if err := cfg.deleteOrphanedObjects(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	keptKey := strings.TrimPrefix(*kept.VideoURL, "https://"+cfg.s3CfDistribution+"/")
+	if got, want := defaultStore.keys(), []string{cfg.s3Bucket + "/" + keptKey}; !slices.Equal(got, want) {
+		t.Errorf("default bucket has %v, want %v", got, want)
+	}
+	if got, want := tierStore.keys(), []string{"tubely-tier/" + tierKey}; !slices.Equal(got, want) {
+		t.Errorf("tier bucket has %v, want %v", got, want)
+	}
+}
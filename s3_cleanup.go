@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// s3CleanupConfig controls the background job that removes storage we're
+// paying for but no longer using. It only logs what it would delete unless
+// delete is set.
+type s3CleanupConfig struct {
+	interval time.Duration
+	minAge   time.Duration
+	delete   bool
+}
+
+func (cfg *apiConfig) runS3Cleanup() {
+	ticker := time.NewTicker(cfg.s3Cleanup.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx := context.Background()
+		for _, store := range cfg.bucketStores() {
+			if err := store.abortStaleMultipartUploads(ctx); err != nil {
+				log.Printf("s3 cleanup: aborting stale multipart uploads in %s failed: %v", store.s3Bucket, err)
+			}
+		}
+		if err := cfg.deleteOrphanedObjects(ctx); err != nil {
+			log.Printf("s3 cleanup: orphaned object pass failed: %v", err)
+		}
+	}
+}
+
+// abortStaleMultipartUploads aborts multipart uploads that were started more
+// than minAge ago and never completed, since their parts are billed.
+func (cfg *apiConfig) abortStaleMultipartUploads(ctx context.Context) error {
+	cutoff := time.Now().Add(-cfg.s3Cleanup.minAge)
+	input := &s3.ListMultipartUploadsInput{
+		Bucket: &cfg.s3Bucket,
+		Prefix: aws.String(cfg.s3KeyPrefix),
+	}
+
+	for {
+		out, err := cfg.s3Client.ListMultipartUploads(ctx, input)
+		if err != nil {
+			return err
+		}
+
+		for _, upload := range out.Uploads {
+			if upload.Initiated == nil || upload.Initiated.After(cutoff) {
+				continue
+			}
+			key := aws.ToString(upload.Key)
+			if !cfg.s3Cleanup.delete {
+				log.Printf("s3 cleanup (dry run): would abort multipart upload %s for %s started %s", aws.ToString(upload.UploadId), key, upload.Initiated)
+				continue
+			}
+			_, err := cfg.s3Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+				Bucket:   &cfg.s3Bucket,
+				Key:      upload.Key,
+				UploadId: upload.UploadId,
+			})
+			if err != nil {
+				log.Printf("s3 cleanup: couldn't abort multipart upload %s for %s: %v", aws.ToString(upload.UploadId), key, err)
+				continue
+			}
+			log.Printf("s3 cleanup: aborted multipart upload %s for %s", aws.ToString(upload.UploadId), key)
+		}
+
+		if !aws.ToBool(out.IsTruncated) {
+			return nil
+		}
+		input.KeyMarker = out.NextKeyMarker
+		input.UploadIdMarker = out.NextUploadIdMarker
+	}
+}
+
+// deleteOrphanedObjects removes objects that no video record references,
+// from every bucket. Objects younger than minAge are skipped so we never
+// race an upload that hasn't written its URL to the db yet.
+func (cfg *apiConfig) deleteOrphanedObjects(ctx context.Context) error {
+	referenced, err := cfg.referencedKeys()
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-cfg.s3Cleanup.minAge)
+	for _, store := range cfg.bucketStores() {
+		paginator := s3.NewListObjectsV2Paginator(store.s3Client, &s3.ListObjectsV2Input{
+			Bucket: &store.s3Bucket,
+			Prefix: aws.String(cfg.s3KeyPrefix),
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return fmt.Errorf("couldn't list bucket %s: %w", store.s3Bucket, err)
+			}
+			for _, obj := range page.Contents {
+				key := aws.ToString(obj.Key)
+				if obj.LastModified == nil || obj.LastModified.After(cutoff) || referenced[key] {
+					continue
+				}
+				if !cfg.s3Cleanup.delete {
+					log.Printf("s3 cleanup (dry run): would delete orphaned object %s from %s", key, store.s3Bucket)
+					continue
+				}
+				_, err := store.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+					Bucket: &store.s3Bucket,
+					Key:    obj.Key,
+				})
+				if err != nil {
+					log.Printf("s3 cleanup: couldn't delete orphaned object %s from %s: %v", key, store.s3Bucket, err)
+					continue
+				}
+				log.Printf("s3 cleanup: deleted orphaned object %s from %s", key, store.s3Bucket)
+			}
+		}
+	}
+	return nil
+}
+
+// referencedKeys returns the keys of the objects the db refers to. They're
+// matched without their bucket: keys are random, and videos uploaded to a
+// tier before it had a distribution of its own have URLs on the default
+// one.
+func (cfg *apiConfig) referencedKeys() (map[string]bool, error) {
+	urls, err := cfg.db.GetReferencedObjectURLs()
+	if err != nil {
+		return nil, err
+	}
+	referenced := make(map[string]bool, len(urls))
+	for _, url := range urls {
+		if key, err := cfg.s3KeyFromURL(url); err == nil {
+			referenced[key] = true
+		}
+	}
+	return referenced, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// CopyObject refuses sources larger than 5 GiB.
+	s3MaxSingleCopySize = 5 << 30
+	s3CopyPartSize      = 512 << 20
+)
+
+// copySource builds the URL-encoded bucket/key form CopyObject expects.
+func copySource(bucket, key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return bucket + "/" + strings.Join(segments, "/")
+}
+
+// copyObject copies srcKey to dstKey inside the bucket without the bytes
+// passing through us, switching to a multipart copy for large objects.
+func (cfg *apiConfig) copyObject(ctx context.Context, srcKey, dstKey string) error {
+	head, err := cfg.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &cfg.s3Bucket,
+		Key:    &srcKey,
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't stat %s: %w", srcKey, err)
+	}
+
+	size := aws.ToInt64(head.ContentLength)
+	if size <= s3MaxSingleCopySize {
+		_, err := cfg.s3Client.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:     &cfg.s3Bucket,
+			Key:        &dstKey,
+			CopySource: aws.String(copySource(cfg.s3Bucket, srcKey)),
+		})
+		if err != nil {
+			return fmt.Errorf("couldn't copy %s to %s: %w", srcKey, dstKey, err)
+		}
+		return nil
+	}
+
+	return cfg.multipartCopyObject(ctx, srcKey, dstKey, size, head.ContentType)
+}
+
+func (cfg *apiConfig) multipartCopyObject(ctx context.Context, srcKey, dstKey string, size int64, contentType *string) error {
+	upload, err := cfg.s3Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      &cfg.s3Bucket,
+		Key:         &dstKey,
+		ContentType: contentType,
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't start multipart copy to %s: %w", dstKey, err)
+	}
+
+	abort := func(cause error) error {
+		_, err := cfg.s3Client.AbortMultipartUpload(context.Background(), &s3.AbortMultipartUploadInput{
+			Bucket:   &cfg.s3Bucket,
+			Key:      &dstKey,
+			UploadId: upload.UploadId,
+		})
+		if err != nil {
+			return fmt.Errorf("%w (and aborting the upload failed: %v)", cause, err)
+		}
+		return cause
+	}
+
+	parts := []types.CompletedPart{}
+	for start, partNumber := int64(0), int32(1); start < size; start, partNumber = start+s3CopyPartSize, partNumber+1 {
+		end := min(start+s3CopyPartSize, size) - 1
+		out, err := cfg.s3Client.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+			Bucket:          &cfg.s3Bucket,
+			Key:             &dstKey,
+			UploadId:        upload.UploadId,
+			PartNumber:      aws.Int32(partNumber),
+			CopySource:      aws.String(copySource(cfg.s3Bucket, srcKey)),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+		})
+		if err != nil {
+			return abort(fmt.Errorf("couldn't copy part %d of %s: %w", partNumber, srcKey, err))
+		}
+		parts = append(parts, types.CompletedPart{
+			ETag:       out.CopyPartResult.ETag,
+			PartNumber: aws.Int32(partNumber),
+		})
+	}
+
+	_, err = cfg.s3Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          &cfg.s3Bucket,
+		Key:             &dstKey,
+		UploadId:        upload.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return abort(fmt.Errorf("couldn't complete multipart copy to %s: %w", dstKey, err))
+	}
+	return nil
+}
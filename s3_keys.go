@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Layouts for video keys below S3_KEY_PREFIX. Dates are the UTC upload
+// date as yyyy/mm/dd, which keeps listings small and lets lifecycle rules
+// match on a prefix. Keys are only ever built here; everything that reads
+// or deletes an object takes the key from the stored URL.
+const (
+	keySchemeAspect     = "aspect"
+	keySchemeDate       = "date"
+	keySchemeDateAspect = "date/aspect"
+	keySchemeAspectDate = "aspect/date"
+)
+
+func parseKeyScheme(s string) (string, error) {
+	switch s {
+	case "":
+		return keySchemeAspect, nil
+	case keySchemeAspect, keySchemeDate, keySchemeDateAspect, keySchemeAspectDate:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown S3_KEY_SCHEME %q, want %s, %s, %s or %s",
+		s, keySchemeAspect, keySchemeDate, keySchemeDateAspect, keySchemeAspectDate)
+}
+
+// videoKeyPrefix is the directory a new video object goes in, ending in a
+// slash.
+func (cfg *apiConfig) videoKeyPrefix(aspectRatio string, userID uuid.UUID, folderID *uuid.UUID, now time.Time) string {
+	aspect := cfg.aspectKeySegment(aspectRatio) + "/"
+	date := now.UTC().Format("2006/01/02/")
+
+	var prefix string
+	switch cfg.s3KeyScheme {
+	case keySchemeDate:
+		prefix = date
+	case keySchemeDateAspect:
+		prefix = date + aspect
+	case keySchemeAspectDate:
+		prefix = aspect + date
+	default:
+		prefix = aspect
+	}
+
+	if cfg.s3FolderKeys && folderID != nil {
+		prefix = "folders/" + folderID.String() + "/" + prefix
+	}
+	if cfg.s3UserKeys {
+		prefix = userKeyPrefix(userID) + prefix
+	}
+	return prefix
+}
+
+// userKeyPrefix is the directory below S3_KEY_PREFIX holding a user's
+// videos when S3_USER_KEYS is on.
+func userKeyPrefix(userID uuid.UUID) string {
+	return "users/" + userID.String() + "/"
+}
+
+// defaultAspectKeySegments is the directory each aspect ratio is filed
+// under unless ASPECT_KEY_PREFIXES says otherwise.
+var defaultAspectKeySegments = map[string]string{
+	"16:9":  "landscape",
+	"9:16":  "portrait",
+	"other": "other",
+}
+
+// parseAspectKeySegments reads ASPECT_KEY_PREFIXES entries such as
+// "9:16=vertical" over the defaults. Each directory must be a single path
+// segment that can't be mistaken for another part of a key, and no two
+// ratios may share one, so a key always tells which ratio it was filed
+// as. Every ratio getVideoAspectRatio can produce must end up with a
+// directory.
+func parseAspectKeySegments(key string, entries []string) (map[string]string, error) {
+	segments := maps.Clone(defaultAspectKeySegments)
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		ratio, segment, ok := strings.Cut(entry, "=")
+		ratio = strings.TrimSpace(ratio)
+		segment = strings.Trim(strings.TrimSpace(segment), "/")
+		if !ok || ratio == "" || segment == "" {
+			return nil, fmt.Errorf("%s entry %q must look like \"16:9=landscape\"", key, entry)
+		}
+		if !isKnownAspectRatio(ratio) {
+			return nil, fmt.Errorf("%s entry %q: videos are only classified as %s", key, entry, strings.Join(knownAspectRatios, ", "))
+		}
+		if seen[ratio] {
+			return nil, fmt.Errorf("%s sets %s more than once", key, ratio)
+		}
+		seen[ratio] = true
+		if err := checkAspectKeySegment(segment); err != nil {
+			return nil, fmt.Errorf("%s entry %q: %w", key, entry, err)
+		}
+		segments[ratio] = segment
+	}
+
+	owners := map[string]string{}
+	for _, ratio := range knownAspectRatios {
+		segment, ok := segments[ratio]
+		if !ok {
+			return nil, fmt.Errorf("%s has no directory for %s", key, ratio)
+		}
+		if other, ok := owners[segment]; ok {
+			return nil, fmt.Errorf("%s files both %s and %s under %s/", key, other, ratio, segment)
+		}
+		owners[segment] = ratio
+	}
+	return segments, nil
+}
+
+// checkAspectKeySegment rejects directory names that rekeyForAspectRatio
+// couldn't tell apart from the rest of a key.
+func checkAspectKeySegment(segment string) error {
+	switch {
+	case strings.Contains(segment, "/"):
+		return errors.New("the directory must be a single path segment")
+	case segment == "." || segment == "..":
+		return errors.New("the directory can't be . or ..")
+	case segment == "users" || segment == "folders":
+		return fmt.Errorf("%s/ is already used in keys", segment)
+	case strings.Trim(segment, "0123456789") == "":
+		return errors.New("the directory can't be all digits, like the date directories")
+	}
+	return nil
+}
+
+// aspectKeySegment is the directory name an aspect ratio is filed under.
+// Ratios we don't know go in with "other".
+func (cfg *apiConfig) aspectKeySegment(aspectRatio string) string {
+	if segment, ok := cfg.aspectKeySegments[aspectRatio]; ok {
+		return segment
+	}
+	return cfg.aspectKeySegments["other"]
+}
+
+// isAspectKeySegment reports whether a key directory is where some aspect
+// ratio is filed, now or under the default layout, so objects filed before
+// ASPECT_KEY_PREFIXES changed can still be found and moved.
+func (cfg *apiConfig) isAspectKeySegment(segment string) bool {
+	for _, segments := range []map[string]string{cfg.aspectKeySegments, defaultAspectKeySegments} {
+		for _, s := range segments {
+			if s == segment {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rekeyForAspectRatio returns key with its aspect directory swapped for the
+// one aspectRatio belongs in. ok is false when the key has no aspect
+// directory, as with the date scheme, and changed is false when it already
+// has the right one. Only the directories between S3_KEY_PREFIX and the
+// file name are considered, so a user ID, folder ID or file name can't
+// match.
+func (cfg *apiConfig) rekeyForAspectRatio(key, aspectRatio string) (newKey string, changed, ok bool) {
+	rest, hasPrefix := strings.CutPrefix(key, cfg.s3KeyPrefix)
+	if !hasPrefix {
+		return "", false, false
+	}
+	segments := strings.Split(rest, "/")
+	start := 0
+	if len(segments) > start+2 && segments[start] == "users" {
+		start += 2
+	}
+	if len(segments) > start+2 && segments[start] == "folders" {
+		start += 2
+	}
+	want := cfg.aspectKeySegment(aspectRatio)
+	for i := start; i < len(segments)-1; i++ {
+		if !cfg.isAspectKeySegment(segments[i]) {
+			continue
+		}
+		if segments[i] == want {
+			return key, false, true
+		}
+		segments[i] = want
+		return cfg.s3KeyPrefix + strings.Join(segments, "/"), true, true
+	}
+	return "", false, false
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestParseAspectKeySegments(t *testing.T) {
+	segments, err := parseAspectKeySegments("ASPECT_KEY_PREFIXES", []string{"9:16=vertical/", " other = misc "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"16:9": "landscape", "9:16": "vertical", "other": "misc"}
+	for ratio, segment := range want {
+		if segments[ratio] != segment {
+			t.Errorf("%s filed under %q, want %q", ratio, segments[ratio], segment)
+		}
+	}
+	if defaultAspectKeySegments["9:16"] != "portrait" {
+		t.Error("parsing changed the defaults")
+	}
+
+	// Swapping two directories is fine as long as no two ratios share one.
+	if _, err := parseAspectKeySegments("ASPECT_KEY_PREFIXES", []string{"16:9=portrait", "9:16=landscape"}); err != nil {
+		t.Errorf("swapped directories: %v", err)
+	}
+
+	for _, entries := range [][]string{
+		{"16:9"},
+		{"16:9="},
+		{"=wide"},
+		{"4:3=standard"},
+		{"16:9=wide", "16:9=widescreen"},
+		{"16:9=portrait"},
+		{"16:9=wide/screen"},
+		{"16:9=.."},
+		{"16:9=users"},
+		{"16:9=2024"},
+	} {
+		if _, err := parseAspectKeySegments("ASPECT_KEY_PREFIXES", entries); err == nil {
+			t.Errorf("%q was accepted", entries)
+		}
+	}
+}
+
+func TestAspectKeySegmentsConfigured(t *testing.T) {
+	segments, err := parseAspectKeySegments("ASPECT_KEY_PREFIXES", []string{"16:9=wide", "9:16=tall"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{s3KeyPrefix: "prod/", s3KeyScheme: keySchemeDateAspect, aspectKeySegments: segments}
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	if got := cfg.videoKeyPrefix("9:16", uuid.Nil, nil, now); got != "2026/10/16/tall/" {
+		t.Errorf("prefix = %q, want 2026/10/16/tall/", got)
+	}
+	if got := cfg.videoKeyPrefix("5:4", uuid.Nil, nil, now); got != "2026/10/16/other/" {
+		t.Errorf("prefix of an unknown ratio = %q, want 2026/10/16/other/", got)
+	}
+
+	tests := []struct {
+		key, ratio, want string
+		changed, ok      bool
+	}{
+		{"prod/2026/10/16/wide/a.mp4", "16:9", "prod/2026/10/16/wide/a.mp4", false, true},
+		{"prod/2026/10/16/wide/a.mp4", "9:16", "prod/2026/10/16/tall/a.mp4", true, true},
+		// Filed under the default layout before it was configured.
+		{"prod/2026/10/16/landscape/a.mp4", "16:9", "prod/2026/10/16/wide/a.mp4", true, true},
+		{"prod/2026/10/16/a.mp4", "16:9", "", false, false},
+	}
+	for _, tt := range tests {
+		got, changed, ok := cfg.rekeyForAspectRatio(tt.key, tt.ratio)
+		if got != tt.want || changed != tt.changed || ok != tt.ok {
+			t.Errorf("rekeyForAspectRatio(%q, %s) = %q, %t, %t, want %q, %t, %t", tt.key, tt.ratio, got, changed, ok, tt.want, tt.changed, tt.ok)
+		}
+	}
+}
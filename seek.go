@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// seekMode is how ffmpeg gets to a timestamp before extracting from it.
+type seekMode string
+
+const (
+	// seekFast puts -ss before -i, so ffmpeg jumps through the container's
+	// index to a keyframe near the timestamp. It's quick however far in
+	// the timestamp is, but can land a few seconds off in videos with
+	// sparse keyframes.
+	seekFast seekMode = "fast"
+	// seekAccurate puts -ss after -i, so ffmpeg decodes from the start and
+	// discards frames until the timestamp. It's exact, B-frames included,
+	// but takes longer the further in the timestamp is.
+	seekAccurate seekMode = "accurate"
+)
+
+// parseSeekMode reads a ?seek= style choice, falling back to def when
+// none was made.
+func parseSeekMode(s string, def seekMode) (seekMode, error) {
+	switch mode := seekMode(s); mode {
+	case "":
+		return def, nil
+	case seekFast, seekAccurate:
+		return mode, nil
+	}
+	return "", fmt.Errorf("seek must be %q or %q", seekFast, seekAccurate)
+}
+
+// seekInputArgs returns the ffmpeg arguments that open input at start
+// seconds, limited to length seconds unless length is 0, ordered for mode.
+func seekInputArgs(mode seekMode, input string, start, length float64) []string {
+	seek := []string{"-ss", strconv.FormatFloat(start, 'f', 3, 64)}
+	if length > 0 {
+		seek = append(seek, "-t", strconv.FormatFloat(length, 'f', 3, 64))
+	}
+	if mode == seekAccurate {
+		return append([]string{"-i", input}, seek...)
+	}
+	return append(seek, "-i", input)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSeekInputArgs(t *testing.T) {
+	tests := []struct {
+		mode   seekMode
+		length float64
+		want   []string
+	}{
+		{seekFast, 0, []string{"-ss", "12.500", "-i", "in.mp4"}},
+		{seekFast, 3, []string{"-ss", "12.500", "-t", "3.000", "-i", "in.mp4"}},
+		{seekAccurate, 0, []string{"-i", "in.mp4", "-ss", "12.500"}},
+		{seekAccurate, 3, []string{"-i", "in.mp4", "-ss", "12.500", "-t", "3.000"}},
+	}
+	for _, tt := range tests {
+		if got := seekInputArgs(tt.mode, "in.mp4", 12.5, tt.length); !slices.Equal(got, tt.want) {
+			t.Errorf("seekInputArgs(%s, length %v) = %v, want %v", tt.mode, tt.length, got, tt.want)
+		}
+	}
+
+	for in, want := range map[string]seekMode{"": seekAccurate, "fast": seekFast, "accurate": seekAccurate} {
+		if got, err := parseSeekMode(in, seekAccurate); err != nil || got != want {
+			t.Errorf("parseSeekMode(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	if _, err := parseSeekMode("exact", seekFast); err == nil {
+		t.Error("parseSeekMode accepted an unknown mode")
+	}
+}
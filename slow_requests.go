@@ -0,0 +1,42 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+// slowRequestMiddleware logs a warning for requests that run past their
+// route's threshold. The route is looked up on mux up front because
+// handlers further down may only see a copy of r.
+func (cfg *apiConfig) slowRequestMiddleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		elapsed := time.Since(start)
+
+		threshold := cfg.slowRequests.forPattern(pattern)
+		if threshold <= 0 || elapsed < threshold {
+			return
+		}
+		log.Printf("warning: slow request: %s %s user=%s took %s (threshold %s)",
+			r.Method, r.URL.Path, requestUserID(r, cfg.jwtKeys()), elapsed.Round(time.Millisecond), threshold)
+	})
+}
+
+// requestUserID returns the authenticated user for logging, or "-" when the
+// request has no valid JWT.
+func requestUserID(r *http.Request, keys auth.Keys) string {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return "-"
+	}
+	userID, err := keys.Validate(token)
+	if err != nil {
+		return "-"
+	}
+	return userID.String()
+}
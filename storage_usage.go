@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+// defaultStoragePrices are S3 us-east-1 list prices in USD per GB-month,
+// used for classes STORAGE_PRICES doesn't set.
+var defaultStoragePrices = map[string]float64{
+	"STANDARD":            0.023,
+	"INTELLIGENT_TIERING": 0.023,
+	"STANDARD_IA":         0.0125,
+	"ONEZONE_IA":          0.01,
+	"GLACIER_IR":          0.004,
+	"GLACIER":             0.0036,
+	"DEEP_ARCHIVE":        0.00099,
+}
+
+// parseStoragePrices reads "CLASS=price" entries on top of the defaults.
+func parseStoragePrices(key string, entries []string) map[string]float64 {
+	prices := map[string]float64{}
+	for class, price := range defaultStoragePrices {
+		prices[class] = price
+	}
+	for _, entry := range entries {
+		class, s, ok := strings.Cut(entry, "=")
+		price, err := strconv.ParseFloat(s, 64)
+		if !ok || err != nil || price < 0 {
+			log.Fatalf("%s entry %q must look like \"STANDARD=0.023\"", key, entry)
+		}
+		prices[strings.ToUpper(strings.TrimSpace(class))] = price
+	}
+	return prices
+}
+
+type storageUsageConfig struct {
+	// refresh is how often the bucket listing behind usage reports is
+	// rebuilt; reports are at most this stale. 0 lists on every report.
+	refresh time.Duration
+	// prices per GB-month by storage class.
+	prices map[string]float64
+}
+
+type storedObject struct {
+	size  int64
+	class string
+}
+
+// storageIndex is a cached listing of every object below S3_KEY_PREFIX in
+// each configured bucket. Listing is the expensive part of a usage report,
+// so it's shared by all users and rebuilt in the background; a report is
+// then just lookups of the user's keys.
+type storageIndex struct {
+	mu      sync.Mutex
+	objects map[string]map[string]storedObject // bucket -> key -> object
+	builtAt time.Time
+	// building serializes rebuilds so concurrent first requests list once.
+	building sync.Mutex
+}
+
+// storageObjects returns the index, building it first if it has never been
+// built or is older than the refresh interval.
+func (cfg *apiConfig) storageObjects(ctx context.Context) (map[string]map[string]storedObject, time.Time, error) {
+	idx := cfg.storageIndex
+	idx.mu.Lock()
+	objects, builtAt := idx.objects, idx.builtAt
+	idx.mu.Unlock()
+	if objects != nil && time.Since(builtAt) < cfg.storageUsage.refresh {
+		return objects, builtAt, nil
+	}
+
+	idx.building.Lock()
+	defer idx.building.Unlock()
+	idx.mu.Lock()
+	objects, builtAt = idx.objects, idx.builtAt
+	idx.mu.Unlock()
+	if objects != nil && time.Since(builtAt) < cfg.storageUsage.refresh {
+		return objects, builtAt, nil
+	}
+	return cfg.rebuildStorageIndex(ctx)
+}
+
+// rebuildStorageIndex must be called with idx.building held.
+func (cfg *apiConfig) rebuildStorageIndex(ctx context.Context) (map[string]map[string]storedObject, time.Time, error) {
+	objects := map[string]map[string]storedObject{}
+	for _, store := range cfg.bucketStores() {
+		bucketObjects := map[string]storedObject{}
+		paginator := s3.NewListObjectsV2Paginator(store.s3Client, &s3.ListObjectsV2Input{
+			Bucket: &store.s3Bucket,
+			Prefix: aws.String(cfg.s3KeyPrefix),
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, time.Time{}, fmt.Errorf("couldn't list bucket %s: %w", store.s3Bucket, err)
+			}
+			for _, obj := range page.Contents {
+				class := string(obj.StorageClass)
+				if class == "" {
+					class = "STANDARD"
+				}
+				bucketObjects[aws.ToString(obj.Key)] = storedObject{size: aws.ToInt64(obj.Size), class: class}
+			}
+		}
+		objects[store.s3Bucket] = bucketObjects
+	}
+
+	builtAt := time.Now()
+	idx := cfg.storageIndex
+	idx.mu.Lock()
+	idx.objects, idx.builtAt = objects, builtAt
+	idx.mu.Unlock()
+	return objects, builtAt, nil
+}
+
+// runStorageIndexRefresh keeps the index fresh so reports don't wait on a
+// listing. Nothing is listed until the first report asks for it.
+func (cfg *apiConfig) runStorageIndexRefresh() {
+	ticker := time.NewTicker(cfg.storageUsage.refresh)
+	defer ticker.Stop()
+	for range ticker.C {
+		cfg.storageIndex.mu.Lock()
+		built := cfg.storageIndex.objects != nil
+		cfg.storageIndex.mu.Unlock()
+		if !built {
+			continue
+		}
+		cfg.storageIndex.building.Lock()
+		_, _, err := cfg.rebuildStorageIndex(context.Background())
+		cfg.storageIndex.building.Unlock()
+		if err != nil {
+			log.Printf("storage usage: refreshing object listing failed: %v", err)
+		}
+	}
+}
+
+// storageCost is the monthly cost of bytes stored in class.
+func (cfg *apiConfig) storageCost(bytes int64, class string) float64 {
+	price, ok := cfg.storageUsage.prices[class]
+	if !ok {
+		price = cfg.storageUsage.prices["STANDARD"]
+	}
+	return float64(bytes) / (1 << 30) * price
+}
+
+// handlerStorageUsage reports the bytes stored for the user's videos and
+// what they cost a month, broken down by kind of object, aspect ratio and
+// storage class. Only objects the user's videos reference are counted, so
+// it works with any key layout. Figures are as of the listing in as_of.
+func (cfg *apiConfig) handlerStorageUsage(w http.ResponseWriter, r *http.Request) {
+	type usage struct {
+		Bytes       int64   `json:"bytes"`
+		Objects     int     `json:"objects"`
+		MonthlyCost float64 `json:"monthly_cost"`
+	}
+	type response struct {
+		usage
+		Currency      string           `json:"currency"`
+		ByKind        map[string]usage `json:"by_kind"`
+		ByAspectRatio map[string]usage `json:"by_aspect_ratio"`
+		ByClass       map[string]usage `json:"by_storage_class"`
+		AsOf          time.Time        `json:"as_of"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.validateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	videos, err := cfg.db.GetVideos(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+	objects, builtAt, err := cfg.storageObjects(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't list stored objects", err)
+		return
+	}
+
+	resp := response{
+		Currency:      "USD",
+		ByKind:        map[string]usage{},
+		ByAspectRatio: map[string]usage{},
+		ByClass:       map[string]usage{},
+		AsOf:          builtAt.UTC(),
+	}
+	add := func(m map[string]usage, name string, obj storedObject, cost float64) {
+		u := m[name]
+		u.Bytes += obj.size
+		u.Objects++
+		u.MonthlyCost += cost
+		m[name] = u
+	}
+	type objectRef struct {
+		kind  string
+		url   *string
+		store *apiConfig
+	}
+	seen := map[string]bool{}
+	for _, video := range videos {
+		aspect := "unknown"
+		if video.AspectRatio != nil {
+			aspect = *video.AspectRatio
+		}
+		videoStore := cfg.forVideo(video)
+		refs := []objectRef{
+			{"video", video.VideoURL, videoStore},
+			{"original", video.OriginalURL, videoStore},
+			{"hls", video.HLSURL, videoStore},
+			{"thumbnail", video.ThumbnailURL, cfg},
+			{"preview", video.PreviewURL, cfg},
+			{"contact_sheet", video.ContactSheetURL, cfg},
+			{"thumbnails_vtt", video.ThumbnailsVTTURL, cfg},
+		}
+		for _, url := range variantURLs(video) {
+			refs = append(refs, objectRef{"variant", url, videoStore})
+		}
+		for _, ref := range refs {
+			if ref.url == nil {
+				continue
+			}
+			key, err := cfg.s3KeyFromURL(*ref.url)
+			if err != nil {
+				continue
+			}
+			// Duplicated videos can share objects; count each once.
+			id := ref.store.s3Bucket + "/" + key
+			obj, ok := objects[ref.store.s3Bucket][key]
+			if !ok || seen[id] {
+				continue
+			}
+			seen[id] = true
+
+			cost := cfg.storageCost(obj.size, obj.class)
+			resp.Bytes += obj.size
+			resp.Objects++
+			resp.MonthlyCost += cost
+			add(resp.ByKind, ref.kind, obj, cost)
+			add(resp.ByAspectRatio, aspect, obj, cost)
+			add(resp.ByClass, obj.class, obj, cost)
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
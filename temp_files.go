@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// tempJanitor decides what happens to an upload's temp files once the
+// request is done with them. With no grace period they're removed at once;
+// otherwise they're moved into dir and a background sweep removes them
+// grace after they were kept, leaving time to inspect a problem upload.
+// The sweep goes by mtime, so files kept before a restart still expire.
+type tempJanitor struct {
+	dir   string
+	grace time.Duration
+}
+
+func newTempJanitor(dir string, grace time.Duration) (*tempJanitor, error) {
+	if grace <= 0 {
+		return &tempJanitor{}, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("couldn't create kept temp file dir: %w", err)
+	}
+	return &tempJanitor{dir: dir, grace: grace}, nil
+}
+
+// remove disposes of path, which the caller no longer needs.
+func (j *tempJanitor) remove(path string) {
+	if j == nil || j.grace <= 0 {
+		os.Remove(path)
+		return
+	}
+
+	// Names are prefixed so files from concurrent uploads can't collide.
+	dst := filepath.Join(j.dir, fmt.Sprintf("%d-%s", time.Now().UnixNano(), filepath.Base(path)))
+	if err := os.Rename(path, dst); err != nil {
+		// A different filesystem; keep a copy instead.
+		if err := linkOrCopy(path, dst); err != nil {
+			log.Printf("warning: couldn't keep temp file %s: %v", path, err)
+			os.Remove(dst)
+		}
+		os.Remove(path)
+	}
+	now := time.Now()
+	os.Chtimes(dst, now, now)
+}
+
+// run sweeps expired files until the process exits.
+func (j *tempJanitor) run() {
+	interval := min(j.grace, time.Minute)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		j.sweep()
+	}
+}
+
+func (j *tempJanitor) sweep() {
+	entries, err := os.ReadDir(j.dir)
+	if err != nil {
+		log.Printf("warning: couldn't list kept temp files: %v", err)
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() {
+			continue
+		}
+		if time.Since(info.ModTime()) > j.grace {
+			os.Remove(filepath.Join(j.dir, entry.Name()))
+		}
+	}
+}
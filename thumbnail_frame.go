@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image/color"
+	"image/jpeg"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+// Thumbnails are usually picked by eye from a scrubber, where landing on a
+// nearby keyframe is fine, so by default they seek fast.
+const defaultThumbnailSeek = seekFast
+
+// frameInputTTL bounds the presigned URL ffmpeg reads from. It only has to
+// last while ffmpeg seeks to and decodes one frame.
+const frameInputTTL = 2 * time.Minute
+
+// handlerThumbnailFromFrame sets the thumbnail to a frame of the uploaded
+// video, ?at= seconds in. Without ?at= the frame is picked automatically.
+// ?seek=accurate takes exactly that frame rather than the nearest
+// keyframe, at the cost of decoding everything before it.
+func (cfg *apiConfig) handlerThumbnailFromFrame(w http.ResponseWriter, r *http.Request) {
+	videoID, err := cfg.parseVideoID(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.validateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	at := -1.0
+	if s := r.URL.Query().Get("at"); s != "" {
+		at, err = strconv.ParseFloat(s, 64)
+		if err != nil || at < 0 {
+			respondWithError(w, http.StatusBadRequest, "at must be a non-negative number of seconds", err)
+			return
+		}
+	}
+	seek, err := parseSeekMode(r.URL.Query().Get("seek"), defaultThumbnailSeek)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	video, err := cfg.getVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithDBError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		cfg.audit(r, userID, video.ID, auditUpdate, auditDenied, "not owner")
+		respondWithError(w, http.StatusForbidden, "You can't update this video", nil)
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusBadRequest, "Video has not been uploaded yet", nil)
+		return
+	}
+
+	key, err := cfg.s3KeyFromURL(*video.VideoURL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't locate video file", err)
+		return
+	}
+
+	framePath, err := cfg.forVideo(video).extractObjectFrame(r.Context(), key, at, seek)
+	if err != nil {
+		respondWithProcessingError(w, "Couldn't extract frame", err)
+		return
+	}
+	defer os.Remove(framePath)
+
+	frame, err := os.Open(framePath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read frame", err)
+		return
+	}
+	defer frame.Close()
+
+	oldURL := video.ThumbnailURL
+	thumbnailURL, err := cfg.storeThumbnail(r.Context(), video.ID, frame, "image/jpeg")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to store thumbnail", err)
+		return
+	}
+	video.ThumbnailURL = &thumbnailURL
+	video.DominantColor = thumbnailColor(frame)
+	video.PerceptualHash = cfg.thumbnailHash(frame)
+	if err := cfg.updateVideo(r.Context(), video); err != nil {
+		respondWithDBError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	cfg.removeReplacedThumbnail(r.Context(), oldURL, thumbnailURL)
+
+	cfg.audit(r, userID, video.ID, auditUpload, auditAllowed, "thumbnail frame")
+	respondWithJSON(w, http.StatusOK, cfg.presentVideo(video))
+}
+
+// extractObjectFrame grabs the frame at seconds from the object at key as a
+// JPEG and returns its path, which the caller must remove. A negative
+// seconds picks the best of several candidate frames instead, which are
+// always seeked fast.
+func (cfg *apiConfig) extractObjectFrame(ctx context.Context, key string, seconds float64, seek seekMode) (string, error) {
+	return cfg.withObjectInput(ctx, key, func(input string) (string, error) {
+		if seconds < 0 {
+			return cfg.extractBestFrame(ctx, input)
+		}
+		return cfg.extractFrame(ctx, input, seconds, seek)
+	})
+}
+
+// withObjectInput runs extract with an ffmpeg input for the object at key.
+// ffmpeg reads the object through a presigned URL so that seeking only
+// fetches the ranges it needs. If that fails, as with a store that ignores
+// Range, the whole object is downloaded instead.
+func (cfg *apiConfig) withObjectInput(ctx context.Context, key string, extract func(input string) (string, error)) (string, error) {
+	if cfg.thumbnailStore.frameRangeReads {
+		input, err := cfg.presignGetObject(ctx, key, presignOptions{expires: frameInputTTL})
+		if err == nil {
+			err = checkPresignedInput(input, cfg.s3Bucket, key, frameInputTTL)
+		}
+		if err == nil {
+			var path string
+			path, err = extract(input)
+			if err == nil {
+				return path, nil
+			}
+		}
+		if ctx.Err() != nil || errors.Is(err, errFFmpegBusy) {
+			return "", err
+		}
+		log.Printf("warning: reading frame of %s by range failed, downloading it instead: %v", key, err)
+	}
+
+	videoPath, err := cfg.downloadObjectToTemp(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(videoPath)
+	return extract(videoPath)
+}
+
+// extractBestFrame samples frames spread evenly through input and keeps
+// the one with the most detail, which skips the black frames of a fade-in
+// and solid title cards. Videos too short to sample, or whose duration
+// can't be read, get their first frame.
+func (cfg *apiConfig) extractBestFrame(ctx context.Context, input string) (string, error) {
+	candidates := cfg.thumbnailStore.frameCandidates
+	duration, err := cfg.getVideoDuration(ctx, input)
+	if err != nil || duration < 1 || candidates <= 1 {
+		if err != nil && (ctx.Err() != nil || errors.Is(err, errFFmpegBusy)) {
+			return "", err
+		}
+		return cfg.extractFrame(ctx, input, 0, seekFast)
+	}
+
+	best, bestScore := "", -1.0
+	for i := 1; i <= candidates; i++ {
+		at := duration * float64(i) / float64(candidates+1)
+		path, err := cfg.extractFrame(ctx, input, at, seekFast)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, errFFmpegBusy) {
+				os.Remove(best)
+				return "", err
+			}
+			log.Printf("warning: skipping thumbnail candidate at %.3fs: %v", at, err)
+			continue
+		}
+		score, err := frameDetail(path)
+		if err != nil || score <= bestScore {
+			os.Remove(path)
+			continue
+		}
+		if best != "" {
+			os.Remove(best)
+		}
+		best, bestScore = path, score
+	}
+	if best == "" {
+		return cfg.extractFrame(ctx, input, 0, seekFast)
+	}
+	return best, nil
+}
+
+// frameDetail scores a JPEG by the standard deviation of its luma, sampled
+// on a grid. Near-black and near-white frames are heavily discounted, since
+// compression noise can give them some variance.
+func frameDetail(path string) (float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	img, err := jpeg.Decode(f)
+	if err != nil {
+		return 0, err
+	}
+
+	const step = 4
+	bounds := img.Bounds()
+	var sum, sumSq, n float64
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			luma := float64(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+			sum += luma
+			sumSq += luma * luma
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, errors.New("empty frame")
+	}
+	mean := sum / n
+	score := math.Sqrt(max(sumSq/n-mean*mean, 0))
+	if mean < 20 || mean > 235 {
+		score /= 10
+	}
+	return score, nil
+}
+
+// extractFrame writes one frame of input, a path or URL, to a temp JPEG.
+// Accurate seeking of a presigned URL reads the object from the start.
+func (cfg *apiConfig) extractFrame(ctx context.Context, input string, seconds float64, seek seekMode) (string, error) {
+	out, err := os.CreateTemp("", "tubely-frame-*.jpg")
+	if err != nil {
+		return "", err
+	}
+	out.Close()
+
+	args := append([]string{"-y"}, seekInputArgs(seek, input, seconds, 0)...)
+	args = append(args, "-frames:v", "1", "-q:v", "2")
+	args = append(args, cfg.ffmpeg.outputArgs()...)
+	args = append(args, out.Name())
+
+	if err := cfg.ffmpeg.run(ctx, exec.CommandContext(ctx, "ffmpeg", args...)); err != nil {
+		os.Remove(out.Name())
+		return "", fmt.Errorf("ffmpeg frame extraction failed: %w", err)
+	}
+	if info, err := os.Stat(out.Name()); err != nil || info.Size() == 0 {
+		os.Remove(out.Name())
+		return "", fmt.Errorf("no frame at %.3fs", seconds)
+	}
+	return out.Name(), nil
+}
+
+// checkPresignedInput makes sure a URL about to be handed to ffmpeg only
+// grants a GET of the one object and expires within maxTTL, since ffmpeg
+// logs its inputs.
+func checkPresignedInput(rawURL, bucket, key string, maxTTL time.Duration) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" {
+		return errors.New("presigned URL isn't https")
+	}
+	path := strings.TrimPrefix(u.Path, "/")
+	if path != key && path != bucket+"/"+key {
+		return fmt.Errorf("presigned URL is for %q, not %q", path, key)
+	}
+	expires, err := strconv.Atoi(u.Query().Get("X-Amz-Expires"))
+	if err != nil || time.Duration(expires)*time.Second > maxTTL {
+		return fmt.Errorf("presigned URL must expire within %s", maxTTL)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const thumbnailTierInterval = 6 * time.Hour
+
+// instantStorageClasses can be read with a plain GetObject. Archive classes
+// need a restore first, which would break serving thumbnails on demand.
+var instantStorageClasses = []string{
+	"STANDARD",
+	"INTELLIGENT_TIERING",
+	"STANDARD_IA",
+	"ONEZONE_IA",
+	"GLACIER_IR",
+}
+
+// parseStorageClass reads a thumbnail storage class setting, which may be
+// empty for the bucket's default.
+func parseStorageClass(key, value string) string {
+	class := strings.ToUpper(strings.TrimSpace(value))
+	if class != "" && !slices.Contains(instantStorageClasses, class) {
+		log.Fatalf("%s must be one of %s", key, strings.Join(instantStorageClasses, ", "))
+	}
+	return class
+}
+
+// withStorageClass stores a new object in class, if one is set.
+func withStorageClass(class string) func(*s3.PutObjectInput) {
+	return func(input *s3.PutObjectInput) {
+		if class != "" {
+			input.StorageClass = types.StorageClass(class)
+		}
+	}
+}
+
+// thumbnailStorageClass is the class a video's S3 thumbnail belongs in:
+// cold once nobody has watched the video for coldAfter, going by its last
+// view or, if it was never viewed, its creation.
+func (cfg *apiConfig) thumbnailStorageClass(video database.Video, now time.Time) string {
+	hot := cfg.thumbnailStore.storageClass
+	if hot == "" {
+		hot = "STANDARD"
+	}
+	lastActive := video.CreatedAt
+	if video.LastViewedAt != nil && video.LastViewedAt.After(lastActive) {
+		lastActive = *video.LastViewedAt
+	}
+	if now.Sub(lastActive) >= cfg.thumbnailStore.coldAfter {
+		return cfg.thumbnailStore.coldStorageClass
+	}
+	return hot
+}
+
+// runThumbnailTiering periodically moves S3 thumbnails of idle videos to
+// the cold storage class, and back again once they're viewed.
+func (cfg *apiConfig) runThumbnailTiering() {
+	ticker := time.NewTicker(thumbnailTierInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		moved, err := cfg.tierThumbnails(context.Background())
+		if moved > 0 {
+			log.Printf("thumbnail tiering: moved %d thumbnails", moved)
+		}
+		if err != nil {
+			log.Printf("thumbnail tiering: pass failed: %v", err)
+		}
+	}
+}
+
+// tierThumbnails makes one pass over every video, comparing each S3
+// thumbnail's class in the storage listing with the one it belongs in.
+func (cfg *apiConfig) tierThumbnails(ctx context.Context) (int, error) {
+	objects, _, err := cfg.storageObjects(ctx)
+	if err != nil {
+		return 0, err
+	}
+	stored := objects[cfg.s3Bucket]
+
+	moved := 0
+	after := uuid.Nil
+	for {
+		videos, err := cfg.db.GetVideosWithObjects(after, aspectRatioBackfillMaxLimit)
+		if err != nil {
+			return moved, err
+		}
+		now := time.Now()
+		for _, video := range videos {
+			if video.ThumbnailURL == nil {
+				continue
+			}
+			key, err := cfg.s3KeyFromURL(*video.ThumbnailURL)
+			if err != nil {
+				// Stored on local disk.
+				continue
+			}
+			obj, ok := stored[key]
+			if !ok {
+				continue
+			}
+			want := cfg.thumbnailStorageClass(video, now)
+			if obj.class == want {
+				continue
+			}
+			if err := cfg.setStorageClass(ctx, key, want); err != nil {
+				log.Printf("thumbnail tiering: video %s: %v", video.ID, err)
+				continue
+			}
+			moved++
+		}
+		if len(videos) < aspectRatioBackfillMaxLimit {
+			return moved, nil
+		}
+		after = videos[len(videos)-1].ID
+	}
+}
+
+// setStorageClass moves the object at key to class by copying it onto
+// itself, keeping its metadata.
+func (cfg *apiConfig) setStorageClass(ctx context.Context, key, class string) error {
+	_, err := cfg.s3Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:            &cfg.s3Bucket,
+		Key:               &key,
+		CopySource:        aws.String(copySource(cfg.s3Bucket, key)),
+		MetadataDirective: types.MetadataDirectiveCopy,
+		StorageClass:      types.StorageClass(class),
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't move %s to %s: %w", key, class, err)
+	}
+	return nil
+}
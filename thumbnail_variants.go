@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// thumbnailVariant is an alternate encoding we can produce for browsers that
+// advertise support for it.
+type thumbnailVariant struct {
+	mediaType string
+	ext       string
+	// format is the ffmpeg muxer name.
+	format string
+	args   []string
+}
+
+var thumbnailVariants = []thumbnailVariant{
+	{
+		mediaType: "image/avif",
+		ext:       ".avif",
+		format:    "avif",
+		args:      []string{"-c:v", "libaom-av1", "-still-picture", "1", "-crf", "32"},
+	},
+	{
+		mediaType: "image/webp",
+		ext:       ".webp",
+		format:    "webp",
+		args:      []string{"-c:v", "libwebp", "-quality", "80"},
+	},
+}
+
+func variantFilePath(originalPath string, variant thumbnailVariant) string {
+	return strings.TrimSuffix(originalPath, filepath.Ext(originalPath)) + ".variant" + variant.ext
+}
+
+var (
+	variantLocks sync.Map
+	// variantFailures remembers variants ffmpeg couldn't produce (usually a
+	// missing encoder) so we don't retry on every request.
+	variantFailures sync.Map
+)
+
+// thumbnailVariantPath returns the path of the requested encoding of the
+// thumbnail at originalPath, generating it with ffmpeg the first time it's
+// asked for. Variants are written next to the original as
+// <name>.variant.<ext>.
+func (cfg *apiConfig) thumbnailVariantPath(ctx context.Context, originalPath string, variant thumbnailVariant) (string, error) {
+	variantPath := variantFilePath(originalPath, variant)
+
+	lock, _ := variantLocks.LoadOrStore(variantPath, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	if _, err := os.Stat(variantPath); err == nil {
+		return variantPath, nil
+	}
+	if err, ok := variantFailures.Load(variantPath); ok {
+		return "", err.(error)
+	}
+
+	tmpPath := variantPath + ".tmp"
+	args := []string{"-y", "-i", originalPath, "-frames:v", "1"}
+	args = append(args, variant.args...)
+	args = append(args, cfg.ffmpeg.outputArgs()...)
+	args = append(args, "-f", variant.format, tmpPath)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	if err := cfg.ffmpeg.run(ctx, cmd); err != nil {
+		os.Remove(tmpPath)
+		err = fmt.Errorf("couldn't encode %s thumbnail: %w", variant.mediaType, err)
+		// A canceled request or a busy server says nothing about whether
+		// the encode works.
+		if ctx.Err() == nil && !errors.Is(err, errFFmpegBusy) {
+			variantFailures.Store(variantPath, err)
+		}
+		return "", err
+	}
+	if err := os.Rename(tmpPath, variantPath); err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	return variantPath, nil
+}
+
+// acceptedMediaTypes parses an Accept header into the set of explicitly
+// listed media types with a non-zero quality. Wildcards are ignored: a
+// browser sending */* hasn't told us it can decode AVIF.
+func acceptedMediaTypes(header string) map[string]bool {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || strings.Contains(mediaType, "*") {
+			continue
+		}
+		if q, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(q, 64); err != nil || f <= 0 {
+				continue
+			}
+		}
+		accepted[mediaType] = true
+	}
+	return accepted
+}
+
+// negotiateThumbnail picks the smallest file among the original and the
+// variants the client accepts.
+func (cfg *apiConfig) negotiateThumbnail(ctx context.Context, originalPath, accept string) (string, error) {
+	best := originalPath
+	info, err := os.Stat(originalPath)
+	if err != nil {
+		return "", err
+	}
+	bestSize := info.Size()
+
+	accepted := acceptedMediaTypes(accept)
+	for _, variant := range thumbnailVariants {
+		if !accepted[variant.mediaType] || strings.EqualFold(filepath.Ext(originalPath), variant.ext) {
+			continue
+		}
+		path, err := cfg.thumbnailVariantPath(ctx, originalPath, variant)
+		if err != nil {
+			log.Println("warning:", err)
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if info.Size() < bestSize {
+			best, bestSize = path, info.Size()
+		}
+	}
+	return best, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// uploadRoutes get the longer upload timeout by default since their run
+// time grows with the file size.
+var uploadRoutes = []string{
+	"POST /api/video_upload/{videoID}",
+	"POST /api/thumbnail_upload/{videoID}",
+	"PUT /api/videos/{videoID}/file",
+	"PUT /api/uploads/{uploadID}",
+	"PUT /api/uploads/{uploadID}/parts/{partNumber}",
+	"POST /api/uploads/{uploadID}/complete",
+	"PATCH /api/tus/{uploadID}",
+}
+
+// timeoutMiddleware bounds each request by its route's timeout. Handlers
+// get a request context with that deadline, which kills any ffmpeg started
+// with it and aborts S3 and database calls, and reading the body fails at
+// it too. A handler that fails after the deadline, before starting its
+// response, is answered with a 503 instead of whatever error it was about
+// to report. A success is never replaced: the work it reports is done.
+// Unlike http.TimeoutHandler nothing is buffered, so a handler already
+// streaming is left to notice the canceled context itself.
+func (cfg *apiConfig) timeoutMiddleware(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		timeout := cfg.requestTimeouts.forPattern(pattern)
+		if timeout <= 0 {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		deadline, _ := ctx.Deadline()
+		// Not every ResponseWriter supports it; the context still bounds
+		// everything but the body reads then.
+		http.NewResponseController(w).SetReadDeadline(deadline)
+
+		tw := &timeoutWriter{ResponseWriter: w, deadline: deadline}
+		mux.ServeHTTP(tw, r.WithContext(ctx))
+		if !tw.wroteHeader && tw.pastDeadline() {
+			tw.timeout()
+		}
+	})
+}
+
+// timeoutWriter replaces a handler's error response with a 503 if the
+// request's deadline passed before the handler started it.
+type timeoutWriter struct {
+	http.ResponseWriter
+	deadline    time.Time
+	wroteHeader bool
+	timedOut    bool
+}
+
+// pastDeadline goes by the clock rather than the context, whose timer may
+// not have fired yet when a body read has already failed at the same
+// deadline.
+func (tw *timeoutWriter) pastDeadline() bool {
+	return !time.Now().Before(tw.deadline)
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	if tw.wroteHeader {
+		return
+	}
+	if code >= http.StatusBadRequest && tw.pastDeadline() {
+		tw.timeout()
+		return
+	}
+	tw.wroteHeader = true
+	tw.ResponseWriter.WriteHeader(code)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	return tw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the connection, e.g. to
+// flush a streamed response.
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+func (tw *timeoutWriter) timeout() {
+	tw.wroteHeader, tw.timedOut = true, true
+	// These described the response the handler didn't get to send.
+	for _, key := range []string{"Content-Length", "Content-Disposition", "Content-Encoding", "Location", "ETag", "Last-Modified"} {
+		tw.ResponseWriter.Header().Del(key)
+	}
+	respondWithError(tw.ResponseWriter, http.StatusServiceUnavailable, "Request timed out", context.DeadlineExceeded)
+}
+
+func defaultRequestTimeouts(uploadTimeout time.Duration) map[string]time.Duration {
+	routes := map[string]time.Duration{}
+	for _, pattern := range uploadRoutes {
+		routes[pattern] = uploadTimeout
+	}
+	return routes
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTimeoutTestMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /fast", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("done"))
+	})
+	mux.HandleFunc("GET /slow", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		// What a handler typically does when its S3 call or ffmpeg is
+		// canceled.
+		w.Header().Set("Content-Length", "100")
+		respondWithError(w, http.StatusInternalServerError, "Couldn't process video", r.Context().Err())
+	})
+	mux.HandleFunc("GET /late-created", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		// Work that finished just after the deadline, such as a row
+		// committed before the context was checked.
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	})
+	mux.HandleFunc("GET /late-ok", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		w.Write([]byte("saved"))
+	})
+	mux.HandleFunc("GET /stream", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("partial"))
+		<-r.Context().Done()
+		w.Write([]byte(" more"))
+	})
+	mux.HandleFunc("GET /unbounded", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Deadline(); ok {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	mux.HandleFunc("POST /upload", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't read upload", err)
+			return
+		}
+	})
+	return mux
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	mux := newTimeoutTestMux()
+	cfg := &apiConfig{requestTimeouts: routeDurations{
+		def:    50 * time.Millisecond,
+		routes: map[string]time.Duration{"GET /unbounded": 0},
+	}}
+	handler := cfg.timeoutMiddleware(mux)
+
+	tests := []struct {
+		path     string
+		want     int
+		wantBody string
+	}{
+		{"/fast", http.StatusOK, "done"},
+		{"/slow", http.StatusServiceUnavailable, "Request timed out"},
+		// A success is never turned into a timeout.
+		{"/late-created", http.StatusCreated, "created"},
+		{"/late-ok", http.StatusOK, "saved"},
+		// A response already under way is left alone.
+		{"/stream", http.StatusOK, "partial more"},
+		{"/unbounded", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if tt.want == http.StatusServiceUnavailable {
+				var body struct {
+					Error string `json:"error"`
+				}
+				if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+					t.Fatalf("body %q isn't a JSON error: %v", rec.Body, err)
+				}
+				if body.Error != tt.wantBody {
+					t.Errorf("error = %q, want %q", body.Error, tt.wantBody)
+				}
+				if got := rec.Header().Get("Content-Length"); got != "" {
+					t.Errorf("Content-Length = %s, want the handler's dropped", got)
+				}
+				return
+			}
+			if got := rec.Body.String(); got != tt.wantBody {
+				t.Errorf("body = %q, want %q", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestTimeoutMiddlewareSlowBody(t *testing.T) {
+	cfg := &apiConfig{requestTimeouts: routeDurations{def: 50 * time.Millisecond}}
+	ts := httptest.NewServer(cfg.timeoutMiddleware(newTimeoutTestMux()))
+	defer ts.Close()
+
+	// The body never ends, so only the read deadline can stop the handler.
+	body, pw := io.Pipe()
+	defer pw.Close()
+	go pw.Write([]byte(strings.Repeat("x", 1024)))
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Post(ts.URL+"/upload", "application/octet-stream", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", resp.StatusCode)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// transcodeConfig controls re-encoding of uploads whose bitrate is far
+// beyond what our viewers need. It's off while maxBitrate is 0.
+type transcodeConfig struct {
+	maxBitrate    int64
+	targetBitrate int64
+	maxHeight     int
+	keepOriginal  bool
+	// originalStorageClass, when set, keeps the original of every upload,
+	// not only downscaled ones, in that S3 storage class, so renditions can
+	// be made again later without asking for the file again.
+	originalStorageClass string
+	// originalRestoreDays is how long an archived original stays readable
+	// once restored.
+	originalRestoreDays int
+	// variants are the heights extra renditions are made at, ascending.
+	variants []int
+	// hlsByteRange also stores a fragmented copy of each video for HLS
+	// playlists to address by byte range.
+	hlsByteRange bool
+}
+
+// downscaleIfNeeded re-encodes the video at filePath when its bitrate is
+// above the configured ceiling. It returns the path of the re-encoded file,
+// or "" when the video was left alone.
+func (cfg *apiConfig) downscaleIfNeeded(ctx context.Context, filePath string) (string, error) {
+	if cfg.transcode.maxBitrate <= 0 {
+		return "", nil
+	}
+
+	bitrate, err := cfg.getVideoBitrate(ctx, filePath)
+	if err != nil {
+		return "", fmt.Errorf("couldn't detect bitrate: %w", err)
+	}
+	if bitrate <= cfg.transcode.maxBitrate {
+		return "", nil
+	}
+	log.Printf("downscaling %s: bitrate %d exceeds ceiling %d", filePath, bitrate, cfg.transcode.maxBitrate)
+
+	target := strconv.FormatInt(cfg.transcode.targetBitrate, 10)
+	bufsize := strconv.FormatInt(2*cfg.transcode.targetBitrate, 10)
+	outputPath := filePath + ".downscaled"
+
+	args := []string{
+		"-y",
+		"-i", filePath,
+		"-c:v", "libx264",
+		"-preset", "medium",
+		"-b:v", target,
+		"-maxrate", target,
+		"-bufsize", bufsize,
+		// Never upscale; -2 keeps the width even as x264 requires.
+		"-vf", fmt.Sprintf("scale=-2:'min(ih,%d)'", cfg.transcode.maxHeight),
+		"-c:a", "aac",
+		"-b:a", "128k",
+	}
+	args = append(args, cfg.ffmpeg.outputArgs()...)
+	args = append(args, "-f", "mp4", outputPath)
+
+	if err := cfg.ffmpeg.run(ctx, exec.CommandContext(ctx, "ffmpeg", args...)); err != nil {
+		os.Remove(outputPath)
+		return "", fmt.Errorf("ffmpeg downscale failed: %w", err)
+	}
+	return outputPath, nil
+}
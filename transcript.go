@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	transcriptFormatSRT  = "srt"
+	transcriptFormatVTT  = "vtt"
+	transcriptFormatText = "text"
+
+	transcriptSourceUpload      = "upload"
+	transcriptSourceTranscriber = "transcriber"
+
+	maxTranscriptSize = 5 << 20
+)
+
+var errNoTranscriber = errors.New("no transcription backend is configured")
+
+// Transcriber turns a video's speech into a transcript, for deployments
+// that have a speech-to-text backend. mediaURL is a short-lived URL the
+// backend can fetch the video from. It returns the transcript and its
+// format, one of the transcriptFormat constants.
+type Transcriber interface {
+	Transcribe(ctx context.Context, video database.Video, mediaURL string) (content, format string, err error)
+}
+
+// uploadOnlyTranscriber is the default Transcriber, for deployments
+// without a backend: transcripts can only be uploaded.
+type uploadOnlyTranscriber struct{}
+
+func (uploadOnlyTranscriber) Transcribe(context.Context, database.Video, string) (string, string, error) {
+	return "", "", errNoTranscriber
+}
+
+// detectTranscriptFormat tells SubRip and WebVTT captions apart from plain
+// text by their header, cue timings and the uploaded file name.
+func detectTranscriptFormat(filename, content string) string {
+	switch {
+	case strings.HasPrefix(strings.TrimPrefix(content, "\ufeff"), "WEBVTT"):
+		return transcriptFormatVTT
+	case strings.EqualFold(path.Ext(filename), ".srt"), strings.Contains(content, "-->"):
+		return transcriptFormatSRT
+	}
+	return transcriptFormatText
+}
+
+var captionTagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// transcriptText extracts the spoken words from a transcript, dropping the
+// cue numbers, timings, headers and styling of caption formats.
+func transcriptText(format, content string) string {
+	if format == transcriptFormatText {
+		return content
+	}
+	content = strings.TrimPrefix(strings.ReplaceAll(content, "\r\n", "\n"), "\ufeff")
+	var lines []string
+	skipBlock := false
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			skipBlock = false
+		case skipBlock, strings.Contains(line, "-->"), isCueNumber(line):
+		case format == transcriptFormatVTT && (strings.HasPrefix(line, "WEBVTT") ||
+			strings.HasPrefix(line, "NOTE") || line == "STYLE" || line == "REGION"):
+			skipBlock = true
+		default:
+			lines = append(lines, captionTagPattern.ReplaceAllString(line, ""))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func isCueNumber(line string) bool {
+	return strings.IndexFunc(line, func(r rune) bool { return !unicode.IsDigit(r) }) == -1
+}
+
+// transcriptTerms splits text into the distinct lowercased words that
+// transcripts are indexed and searched by. Single characters are too
+// common to be worth indexing.
+func transcriptTerms(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	terms := []string{}
+	for _, word := range words {
+		if len([]rune(word)) > 1 {
+			terms = append(terms, word)
+		}
+	}
+	slices.Sort(terms)
+	return slices.Compact(terms)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	testSRT = "1\r\n00:00:01,000 --> 00:00:03,000\r\nWelcome to <i>Tubely</i>\r\n\r\n2\r\n00:00:03,500 --> 00:00:05,000\r\nLet's upload a video\r\n"
+	testVTT = "WEBVTT\n\nNOTE written by hand\nnot spoken\n\n00:00.000 --> 00:02.000\nHello from the boot.dev team\n"
+)
+
+func TestTranscriptText(t *testing.T) {
+	tests := []struct {
+		name       string
+		filename   string
+		content    string
+		wantFormat string
+		wantText   string
+	}{
+		{"srt", "talk.srt", testSRT, transcriptFormatSRT, "Welcome to Tubely\nLet's upload a video"},
+		{"srt without extension", "talk.txt", testSRT, transcriptFormatSRT, "Welcome to Tubely\nLet's upload a video"},
+		{"vtt", "talk.vtt", testVTT, transcriptFormatVTT, "Hello from the boot.dev team"},
+		{"vtt with BOM", "talk", "\ufeff" + testVTT, transcriptFormatVTT, "Hello from the boot.dev team"},
+		{"text", "talk.txt", "Just words\n42 of them", transcriptFormatText, "Just words\n42 of them"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format := detectTranscriptFormat(tt.filename, tt.content)
+			if format != tt.wantFormat {
+				t.Fatalf("format = %s, want %s", format, tt.wantFormat)
+			}
+			if got := transcriptText(format, tt.content); got != tt.wantText {
+				t.Errorf("text = %q, want %q", got, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestTranscriptTerms(t *testing.T) {
+	got := transcriptTerms("Let's upload a VIDEO, then upload another video: 4K!")
+	want := []string{"4k", "another", "let", "then", "upload", "video"}
+	if !slices.Equal(got, want) {
+		t.Errorf("terms = %v, want %v", got, want)
+	}
+}
+
+func TestHandlerVideoTranscriptSearch(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	cfg.transcriber = uploadOnlyTranscriber{}
+	user, token := createTestUser(t, cfg)
+	other, otherToken := createTestUser(t, cfg)
+	talk := createTestVideo(t, cfg, user.ID)
+	quiet := createTestVideo(t, cfg, user.ID)
+	theirs := createTestVideo(t, cfg, other.ID)
+
+	upload := func(video database.Video, token, filename, content string) *httptest.ResponseRecorder {
+		req := newUploadRequest(t, "/api/videos/"+video.ID.String()+"/transcript", "transcript", filename, "text/plain", []byte(content))
+		req.SetPathValue("videoID", video.ID.String())
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		cfg.handlerVideoTranscriptUpload(rec, req)
+		return rec
+	}
+	if rec := upload(talk, token, "talk.srt", testSRT); rec.Code != http.StatusOK {
+		t.Fatalf("upload: status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if rec := upload(quiet, token, "quiet.txt", "nothing about that here"); rec.Code != http.StatusOK {
+		t.Fatalf("upload: status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if rec := upload(theirs, otherToken, "theirs.srt", testSRT); rec.Code != http.StatusOK {
+		t.Fatalf("upload: status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if rec := upload(theirs, token, "theirs.srt", testSRT); rec.Code != http.StatusForbidden {
+		t.Errorf("upload to another user's video: status = %d, want 403", rec.Code)
+	}
+
+	// No transcriber backend, so generating one isn't possible.
+	req := httptest.NewRequest(http.MethodPost, "/api/videos/"+talk.ID.String()+"/transcript", nil)
+	req.SetPathValue("videoID", talk.ID.String())
+	req.Header.Set("Authorization", "Bearer "+token)
+	url := "https://" + cfg.s3CfDistribution + "/landscape/" + talk.ID.String() + ".mp4"
+	talk.VideoURL = &url
+	if err := cfg.db.UpdateVideo(talk); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	cfg.handlerVideoTranscriptUpload(rec, req)
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("generate: status = %d, want 501", rec.Code)
+	}
+
+	search := func(q string) []uuid.UUID {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/videos/search?q="+q, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		cfg.handlerSearchVideos(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("search %q: status = %d, want 200: %s", q, rec.Code, rec.Body)
+		}
+		var videos []database.Video
+		if err := json.Unmarshal(rec.Body.Bytes(), &videos); err != nil {
+			t.Fatal(err)
+		}
+		ids := []uuid.UUID{}
+		for _, v := range videos {
+			ids = append(ids, v.ID)
+		}
+		return ids
+	}
+	if got := search("UPLOAD+video"); !slices.Equal(got, []uuid.UUID{talk.ID}) {
+		t.Errorf("search for words in one transcript = %v, want just %s", got, talk.ID)
+	}
+	if got := search("upload+nothing"); len(got) != 0 {
+		t.Errorf("search needing words from two transcripts = %v, want none", got)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+var errVideoTooLong = errors.New("video is too long")
+
+// uploadLimits bounds what clients may upload. They're published at
+// GET /api/config/upload so clients can check files before sending them.
+type uploadLimits struct {
+	videoSize     int64
+	thumbnailSize int64
+	// videoDuration of 0 allows videos of any length.
+	videoDuration time.Duration
+}
+
+// handlerUploadConfig reports the accepted media types, limits and form
+// field names. It needs no auth since none of it is sensitive.
+func (cfg *apiConfig) handlerUploadConfig(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		VideoMediaTypes         []string `json:"video_media_types"`
+		ImageMediaTypes         []string `json:"image_media_types"`
+		MaxVideoSize            int64    `json:"max_video_size"`
+		MaxThumbnailSize        int64    `json:"max_thumbnail_size"`
+		MaxVideoDurationSeconds float64  `json:"max_video_duration_seconds,omitempty"`
+		MaxUploadPartSize       int64    `json:"max_upload_part_size"`
+		VideoField              string   `json:"video_field"`
+		ThumbnailField          string   `json:"thumbnail_field"`
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		VideoMediaTypes:         cfg.allowedVideoTypes,
+		ImageMediaTypes:         cfg.allowedImageTypes,
+		MaxVideoSize:            cfg.uploadLimits.videoSize,
+		MaxThumbnailSize:        cfg.uploadLimits.thumbnailSize,
+		MaxVideoDurationSeconds: cfg.uploadLimits.videoDuration.Seconds(),
+		MaxUploadPartSize:       maxUploadPartSize,
+		VideoField:              cfg.uploadFields.video,
+		ThumbnailField:          cfg.uploadFields.thumbnail,
+	})
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// defaultUserTier is the tier of users USER_TIERS doesn't list.
+const defaultUserTier = "default"
+
+var errUploadPolicy = errors.New("upload policy violated")
+
+// uploadPolicy is one UPLOAD_POLICIES rule: limits on the uploads of a
+// tier of users, of a media type, or both. "*" matches any tier or type.
+// Zero limits don't apply, and every matching rule is enforced, so the
+// strictest limit wins.
+type uploadPolicy struct {
+	tier      string
+	mediaType string
+	// maxLongSide and maxShortSide bound the resolution whatever the
+	// orientation, so "1920x1080" allows 1080x1920 portrait video too.
+	maxLongSide  int
+	maxShortSide int
+	// maxBitrate is in bits per second.
+	maxBitrate  int64
+	maxDuration time.Duration
+	maxSize     int64
+}
+
+func (p uploadPolicy) name() string {
+	return p.tier + "@" + p.mediaType
+}
+
+func (p uploadPolicy) matches(tier, mediaType string) bool {
+	return (p.tier == "*" || p.tier == tier) && (p.mediaType == "*" || p.mediaType == mediaType)
+}
+
+// parseUploadPolicies reads UPLOAD_POLICIES entries such as
+// "free@*:resolution=1920x1080;bitrate=10000000" or
+// "*@video/quicktime:duration=10m;size_mb=500".
+func parseUploadPolicies(key string, entries []string) ([]uploadPolicy, error) {
+	policies := []uploadPolicy{}
+	for _, entry := range entries {
+		selector, limits, ok := strings.Cut(entry, ":")
+		tier, mediaType, hasType := strings.Cut(strings.TrimSpace(selector), "@")
+		if !ok || !hasType || tier == "" || mediaType == "" {
+			return nil, fmt.Errorf("%s entry %q must look like \"free@*:resolution=1920x1080;bitrate=10000000\"", key, entry)
+		}
+		policy := uploadPolicy{tier: tier, mediaType: mediaType}
+		for _, limit := range strings.Split(limits, ";") {
+			name, value, ok := strings.Cut(strings.TrimSpace(limit), "=")
+			if !ok {
+				return nil, fmt.Errorf("%s entry %q: limit %q must look like name=value", key, entry, limit)
+			}
+			if err := policy.setLimit(strings.TrimSpace(name), strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("%s entry %q: %w", key, entry, err)
+			}
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+func (p *uploadPolicy) setLimit(name, value string) error {
+	switch name {
+	case "resolution":
+		w, h, ok := strings.Cut(value, "x")
+		width, werr := strconv.Atoi(w)
+		height, herr := strconv.Atoi(h)
+		if !ok || werr != nil || herr != nil || width <= 0 || height <= 0 {
+			return fmt.Errorf("resolution %q must look like 1920x1080", value)
+		}
+		p.maxLongSide, p.maxShortSide = max(width, height), min(width, height)
+	case "bitrate":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("bitrate %q must be a positive number of bits per second", value)
+		}
+		p.maxBitrate = n
+	case "duration":
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("duration %q must be a positive duration such as 10m", value)
+		}
+		p.maxDuration = d
+	case "size_mb":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("size_mb %q must be a positive number of megabytes", value)
+		}
+		p.maxSize = n << 20
+	default:
+		return fmt.Errorf("unknown limit %q, want resolution, bitrate, duration or size_mb", name)
+	}
+	return nil
+}
+
+// parseUserTiers reads USER_TIERS entries of the form "<user id>=<tier>".
+func parseUserTiers(key string, entries []string) (map[uuid.UUID]string, error) {
+	tiers := map[uuid.UUID]string{}
+	for _, entry := range entries {
+		s, tier, ok := strings.Cut(entry, "=")
+		tier = strings.TrimSpace(tier)
+		if !ok || tier == "" {
+			return nil, fmt.Errorf("%s entry %q must look like \"<user id>=pro\"", key, entry)
+		}
+		id, err := uuid.Parse(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("%s entry %q has an invalid user ID: %w", key, entry, err)
+		}
+		tiers[id] = tier
+	}
+	return tiers, nil
+}
+
+func (cfg *apiConfig) userTier(userID uuid.UUID) string {
+	if tier, ok := cfg.userTiers[userID]; ok {
+		return tier
+	}
+	return defaultUserTier
+}
+
+// checkUploadPolicy checks the upload at path against the UPLOAD_POLICIES
+// rules for its owner's tier and its media type. The file is only probed
+// for what those rules limit, so without rules nothing is checked. A
+// violation wraps errUploadPolicy and names the rule.
+func (cfg *apiConfig) checkUploadPolicy(ctx context.Context, userID uuid.UUID, path, mediaType string) error {
+	tier := cfg.userTier(userID)
+	var policies []uploadPolicy
+	for _, p := range cfg.uploadPolicies {
+		if p.matches(tier, mediaType) {
+			policies = append(policies, p)
+		}
+	}
+	if len(policies) == 0 {
+		return nil
+	}
+
+	var needSize, needFormat, needStream bool
+	for _, p := range policies {
+		needSize = needSize || p.maxSize > 0
+		needFormat = needFormat || p.maxBitrate > 0 || p.maxDuration > 0
+		needStream = needStream || p.maxLongSide > 0
+	}
+
+	var size int64
+	if needSize {
+		info, err := os.Stat(path)
+		if err != nil {
+			return &publishError{"Couldn't read video size", err}
+		}
+		size = info.Size()
+	}
+	var format ffprobeFormat
+	if needFormat {
+		var err error
+		if format, err = cfg.probeFormat(ctx, path); err != nil {
+			return &publishError{"Couldn't probe video", err}
+		}
+	}
+	var stream ffprobeStream
+	if needStream {
+		var err error
+		if stream, err = cfg.probeVideoStream(ctx, path); err != nil {
+			return &publishError{"Couldn't probe video", err}
+		}
+	}
+
+	for _, p := range policies {
+		violation := func(format string, args ...any) error {
+			return fmt.Errorf("%w: %s: %s", errUploadPolicy, p.name(), fmt.Sprintf(format, args...))
+		}
+		if p.maxSize > 0 && size > p.maxSize {
+			return violation("the file is %d bytes, the limit is %d", size, p.maxSize)
+		}
+		if p.maxLongSide > 0 {
+			long, short := max(stream.Width, stream.Height), min(stream.Width, stream.Height)
+			if long > p.maxLongSide || short > p.maxShortSide {
+				return violation("the resolution is %dx%d, the limit is %dx%d", stream.Width, stream.Height, p.maxLongSide, p.maxShortSide)
+			}
+		}
+		if p.maxBitrate > 0 {
+			bitrate, err := strconv.ParseInt(format.BitRate, 10, 64)
+			if err != nil {
+				return &publishError{"Couldn't read video bitrate", fmt.Errorf("ffprobe reported bitrate %q: %w", format.BitRate, err)}
+			}
+			if bitrate > p.maxBitrate {
+				return violation("the bitrate is %d bps, the limit is %d", bitrate, p.maxBitrate)
+			}
+		}
+		if p.maxDuration > 0 {
+			seconds, err := strconv.ParseFloat(format.Duration, 64)
+			if err != nil {
+				return &publishError{"Couldn't read video duration", fmt.Errorf("ffprobe reported duration %q: %w", format.Duration, err)}
+			}
+			if d := time.Duration(seconds * float64(time.Second)); d > p.maxDuration {
+				return violation("the video is %s long, the limit is %s", d.Round(time.Millisecond), p.maxDuration)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestParseUploadPolicies(t *testing.T) {
+	policies, err := parseUploadPolicies("UPLOAD_POLICIES", []string{
+		"free@*:resolution=1920x1080; bitrate=10000000",
+		"*@video/quicktime:duration=10m;size_mb=500",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policies) != 2 {
+		t.Fatalf("got %d policies, want 2", len(policies))
+	}
+	if p := policies[0]; p.maxLongSide != 1920 || p.maxShortSide != 1080 || p.maxBitrate != 10000000 || !p.matches("free", "video/mp4") || p.matches("pro", "video/mp4") {
+		t.Errorf("first policy = %+v", p)
+	}
+	if p := policies[1]; p.maxSize != 500<<20 || p.maxDuration.Minutes() != 10 || !p.matches("pro", "video/quicktime") || p.matches("pro", "video/mp4") {
+		t.Errorf("second policy = %+v", p)
+	}
+
+	for _, entry := range []string{
+		"free:bitrate=1",
+		"free@*",
+		"free@*:speed=2",
+		"free@*:resolution=1080p",
+		"free@*:bitrate=-1",
+		"free@*:duration=0s",
+	} {
+		if _, err := parseUploadPolicies("UPLOAD_POLICIES", []string{entry}); err == nil {
+			t.Errorf("%q was accepted", entry)
+		}
+	}
+}
+
+func TestHandlerUploadVideoPolicies(t *testing.T) {
+	// The fake probe reports a 1920x1080 video of 12.5s at 1Mbps.
+	tests := []struct {
+		name     string
+		policy   string
+		pro      bool
+		portrait bool
+		want     string
+	}{
+		{name: "no policy"},
+		{name: "within every limit", policy: "*@*:resolution=1920x1080;bitrate=1000000;duration=13s;size_mb=1"},
+		{name: "resolution", policy: "*@*:resolution=1280x720", want: "resolution is 1920x1080, the limit is 1280x720"},
+		{name: "portrait within a landscape limit", policy: "*@*:resolution=1920x1080", portrait: true},
+		{name: "bitrate", policy: "*@*:bitrate=999999", want: "bitrate is 1000000 bps, the limit is 999999"},
+		{name: "duration", policy: "*@*:duration=10s", want: "video is 12.5s long, the limit is 10s"},
+		{name: "tier", policy: "default@*:bitrate=500000", want: "default@*: the bitrate"},
+		{name: "other tier", policy: "default@*:bitrate=500000", pro: true},
+		{name: "media type", policy: "*@video/mp4:duration=10s", want: "*@video/mp4: the video is"},
+		{name: "other media type", policy: "*@video/webm:duration=10s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, store, ffmpeg := newTestConfig(t)
+			user, token := createTestUser(t, cfg)
+			if tt.policy != "" {
+				policies, err := parseUploadPolicies("UPLOAD_POLICIES", []string{tt.policy})
+				if err != nil {
+					t.Fatal(err)
+				}
+				cfg.uploadPolicies = policies
+			}
+			if tt.pro {
+				cfg.userTiers = map[uuid.UUID]string{user.ID: "pro"}
+			}
+			if tt.portrait {
+				ffmpeg.streams[0].Width, ffmpeg.streams[0].Height = 1080, 1920
+			}
+			video := createTestVideo(t, cfg, user.ID)
+
+			req := newUploadRequest(t, "/api/video_upload/"+video.ID.String(), "video", "clip.mp4", "video/mp4", testMP4)
+			rec := uploadVideo(cfg, req, video.ID.String(), token)
+			if tt.want == "" {
+				if rec.Code != http.StatusOK {
+					t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+				}
+				return
+			}
+			if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), tt.want) {
+				t.Fatalf("got %d %s, want 400 mentioning %q", rec.Code, rec.Body, tt.want)
+			}
+			if keys := store.keys(); len(keys) != 0 {
+				t.Errorf("stored objects = %v, want none after a rejected upload", keys)
+			}
+		})
+	}
+}
+
+func TestCheckUploadPolicySize(t *testing.T) {
+	cfg, _, ffmpeg := newTestConfig(t)
+	user, _ := createTestUser(t, cfg)
+	path := filepath.Join(t.TempDir(), "upload.mp4")
+	if err := os.WriteFile(path, make([]byte, 2<<20), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.uploadPolicies, _ = parseUploadPolicies("UPLOAD_POLICIES", []string{"*@*:size_mb=1"})
+	err := cfg.checkUploadPolicy(context.Background(), user.ID, path, "video/mp4")
+	if err == nil || !strings.Contains(err.Error(), "the file is 2097152 bytes, the limit is 1048576") {
+		t.Errorf("err = %v, want a size violation", err)
+	}
+	if n := len(ffmpeg.calls); n != 0 {
+		t.Errorf("ran %d probes, want none for a size limit", n)
+	}
+
+	cfg.uploadPolicies, _ = parseUploadPolicies("UPLOAD_POLICIES", []string{"*@*:size_mb=2"})
+	if err := cfg.checkUploadPolicy(context.Background(), user.ID, path, "video/mp4"); err != nil {
+		t.Errorf("err = %v at the limit", err)
+	}
+}
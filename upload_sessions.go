@@ -0,0 +1,480 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxUploadParts    = 10000
+	maxUploadPartSize = 100 << 20 // 100 MB
+)
+
+// defaultUploadSessionTTL is how long a session may go without receiving
+// anything before it's discarded along with its parts, unless
+// UPLOAD_SESSION_TTL says otherwise.
+const defaultUploadSessionTTL = 24 * time.Hour
+
+var (
+	errUploadIncomplete = errors.New("upload is missing parts")
+	errUploadOffset     = errors.New("upload offset doesn't match bytes received")
+	errUploadBusy       = errors.New("upload is already receiving data")
+	errUploadTooLarge   = errors.New("upload exceeds its declared length")
+	errUploadExpired    = errors.New("upload session has expired")
+	errUploadProtocol   = errors.New("upload session was started for a different protocol")
+)
+
+// uploadProtocol is how a session's data arrives. A session only takes
+// data the way it was started: numbered parts can come in any order, while
+// tus and ranged PUTs append to the bytes received so far, and mixing them
+// would leave gaps the appends can't see.
+type uploadProtocol string
+
+const (
+	uploadProtocolParts uploadProtocol = "parts"
+	uploadProtocolRange uploadProtocol = "range"
+	uploadProtocolTus   uploadProtocol = "tus"
+)
+
+// uploadSession tracks a video being uploaded in parts. Each part is kept
+// in its own file under the session's directory until the upload is
+// completed, so parts can arrive in any order and be retried.
+type uploadSession struct {
+	ID        uuid.UUID
+	VideoID   uuid.UUID
+	UserID    uuid.UUID
+	MediaType string
+	Filename  string
+	Protocol  uploadProtocol
+	// TotalSize is the size the client declared, or 0 if unknown.
+	TotalSize int64
+	// FolderID is applied to the video when the upload completes.
+	FolderID  *uuid.UUID
+	CreatedAt time.Time
+
+	dir       string
+	ttl       time.Duration
+	now       func() time.Time
+	mu        sync.Mutex
+	parts     map[int]int64
+	appending bool
+	// writers counts requests currently storing data, which keep the
+	// session from expiring however long they take.
+	writers    int
+	lastActive time.Time
+	expired    bool
+}
+
+// uploadProgress is what a resuming client needs to pick up where it left
+// off.
+type uploadProgress struct {
+	UploadID       uuid.UUID `json:"upload_id"`
+	VideoID        uuid.UUID `json:"video_id"`
+	CompletedParts []int     `json:"completed_parts"`
+	ReceivedBytes  int64     `json:"received_bytes"`
+	TotalBytes     int64     `json:"total_bytes,omitempty"`
+	// Protocol is how the session takes data: "parts", "range" or "tus".
+	Protocol uploadProtocol `json:"protocol"`
+	// ContiguousBytes counts the bytes from the start of the file that
+	// have been received with no gaps.
+	ContiguousBytes int64 `json:"contiguous_bytes"`
+	// ExpiresAt is when the session is discarded if nothing more arrives.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func (s *uploadSession) partPath(n int) string {
+	return filepath.Join(s.dir, strconv.Itoa(n)+".part")
+}
+
+// writePart stores part n from r, replacing any earlier attempt at it.
+func (s *uploadSession) writePart(n int, r io.Reader) (int64, error) {
+	if err := s.beginWrite(uploadProtocolParts); err != nil {
+		return 0, err
+	}
+	defer s.endWrite()
+
+	tmp, err := os.CreateTemp(s.dir, "incoming-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	size, err := io.Copy(tmp, io.LimitReader(r, maxUploadPartSize+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if size > maxUploadPartSize {
+		return 0, fmt.Errorf("part exceeds %d bytes", maxUploadPartSize)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Rename(tmp.Name(), s.partPath(n)); err != nil {
+		return 0, err
+	}
+	s.parts[n] = size
+	return size, nil
+}
+
+// appendAt adds the bytes from r as the next part, for protocols that
+// stream an upload sequentially rather than in numbered parts. offset must
+// equal the bytes received without gaps so far. Unlike writePart, bytes
+// that arrived before the connection failed are kept, so the client only
+// has to resend the rest. Nothing else may write to the session meanwhile.
+func (s *uploadSession) appendAt(protocol uploadProtocol, offset int64, r io.Reader) (int64, error) {
+	s.mu.Lock()
+	if err := s.checkWrite(protocol); err != nil {
+		s.mu.Unlock()
+		return 0, err
+	}
+	if s.writers > 0 {
+		s.mu.Unlock()
+		return 0, errUploadBusy
+	}
+	received, n := s.contiguous()
+	if offset != received {
+		s.mu.Unlock()
+		return 0, fmt.Errorf("%w: got %d, have %d", errUploadOffset, offset, received)
+	}
+	s.appending = true
+	s.writers++
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.appending = false
+		s.mu.Unlock()
+		s.endWrite()
+	}()
+
+	tmp, err := os.CreateTemp(s.dir, "incoming-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	remaining := s.TotalSize - received
+	size, err := io.Copy(tmp, io.LimitReader(r, remaining+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if size > remaining {
+		return 0, errUploadTooLarge
+	}
+	if size == 0 {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if renameErr := os.Rename(tmp.Name(), s.partPath(n)); renameErr != nil {
+		return 0, renameErr
+	}
+	s.parts[n] = size
+	return size, err
+}
+
+func (s *uploadSession) beginWrite(protocol uploadProtocol) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkWrite(protocol); err != nil {
+		return err
+	}
+	s.writers++
+	return nil
+}
+
+// checkWrite reports why data can't be written to the session the given
+// way right now, if it can't. It must be called with s.mu held.
+func (s *uploadSession) checkWrite(protocol uploadProtocol) error {
+	if s.expired {
+		return errUploadExpired
+	}
+	if s.appending {
+		return errUploadBusy
+	}
+	if protocol != s.Protocol {
+		return fmt.Errorf("%w: it takes %s uploads", errUploadProtocol, s.Protocol)
+	}
+	return nil
+}
+
+// contiguous returns how many bytes from the start of the file have been
+// received with no gaps, and the part that would follow them. It must be
+// called with s.mu held.
+func (s *uploadSession) contiguous() (received int64, next int) {
+	next = 1
+	for {
+		size, ok := s.parts[next]
+		if !ok {
+			return received, next
+		}
+		received += size
+		next++
+	}
+}
+
+func (s *uploadSession) endWrite() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writers--
+	s.lastActive = s.now()
+}
+
+// idle reports whether the session has gone ttl without activity. A
+// session with a request still writing to it is never idle.
+func (s *uploadSession) idle(now time.Time) bool {
+	return s.ttl > 0 && s.writers == 0 && now.Sub(s.lastActive) >= s.ttl
+}
+
+// expire marks an idle session expired so nothing more can be written to
+// it, and reports whether it did.
+func (s *uploadSession) expire(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.idle(now) {
+		return false
+	}
+	s.expired = true
+	return true
+}
+
+func (s *uploadSession) progress() uploadProgress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := uploadProgress{
+		UploadID:       s.ID,
+		VideoID:        s.VideoID,
+		CompletedParts: []int{},
+		TotalBytes:     s.TotalSize,
+		Protocol:       s.Protocol,
+	}
+	if s.ttl > 0 {
+		expiresAt := s.lastActive.Add(s.ttl).UTC()
+		p.ExpiresAt = &expiresAt
+	}
+	for n, size := range s.parts {
+		p.CompletedParts = append(p.CompletedParts, n)
+		p.ReceivedBytes += size
+	}
+	sort.Ints(p.CompletedParts)
+	p.ContiguousBytes, _ = s.contiguous()
+	return p
+}
+
+// assemble concatenates parts 1..N into a single file at dst. Parts must be
+// contiguous, and the result must match the declared size if there was one.
+func (s *uploadSession) assemble(dst string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.expired {
+		return errUploadExpired
+	}
+	s.lastActive = s.now()
+	if len(s.parts) == 0 {
+		return errUploadIncomplete
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	var total int64
+	for n := 1; n <= len(s.parts); n++ {
+		if _, ok := s.parts[n]; !ok {
+			return fmt.Errorf("%w: part %d not received", errUploadIncomplete, n)
+		}
+		in, err := os.Open(s.partPath(n))
+		if err != nil {
+			return err
+		}
+		written, err := io.Copy(out, in)
+		in.Close()
+		if err != nil {
+			return err
+		}
+		total += written
+	}
+	if s.TotalSize > 0 && total != s.TotalSize {
+		return fmt.Errorf("%w: received %d of %d bytes", errUploadIncomplete, total, s.TotalSize)
+	}
+	return out.Close()
+}
+
+// uploadSessionStore holds in-progress chunked uploads in memory, with
+// their parts on disk under dir. Sessions that go ttl without receiving
+// anything are discarded along with their parts; a ttl of 0 keeps them
+// until completed or aborted.
+type uploadSessionStore struct {
+	dir string
+	ttl time.Duration
+	// now is the clock sessions expire by, replaced in tests.
+	now func() time.Time
+
+	mu       sync.Mutex
+	sessions map[uuid.UUID]*uploadSession
+	// inFlight holds the user and content hash of each upload being
+	// processed, so the same file submitted again before the first is
+	// done, as a double-clicked upload button does, can be turned away.
+	inFlight map[inFlightUpload]bool
+}
+
+type inFlightUpload struct {
+	userID uuid.UUID
+	sha256 string
+}
+
+// newUploadSessionStore also removes the parts of sessions left behind by
+// an earlier process, since sessions don't outlive it.
+func newUploadSessionStore(dir string, ttl time.Duration) (*uploadSessionStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("couldn't create upload session dir: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list upload session dir: %w", err)
+	}
+	for _, entry := range entries {
+		// Only what looks like a session's directory, in case dir is
+		// shared with something else.
+		if _, err := uuid.Parse(entry.Name()); err != nil || !entry.IsDir() {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			log.Printf("warning: couldn't remove orphaned upload %s: %v", entry.Name(), err)
+		}
+	}
+	return &uploadSessionStore{
+		dir:      dir,
+		ttl:      ttl,
+		now:      time.Now,
+		sessions: map[uuid.UUID]*uploadSession{},
+		inFlight: map[inFlightUpload]bool{},
+	}, nil
+}
+
+func (st *uploadSessionStore) create(protocol uploadProtocol, videoID, userID uuid.UUID, mediaType, filename string, totalSize int64) (*uploadSession, error) {
+	now := st.now()
+	s := &uploadSession{
+		ID:         uuid.New(),
+		VideoID:    videoID,
+		UserID:     userID,
+		MediaType:  mediaType,
+		Filename:   filename,
+		Protocol:   protocol,
+		TotalSize:  totalSize,
+		CreatedAt:  now.UTC(),
+		ttl:        st.ttl,
+		now:        st.now,
+		parts:      map[int]int64{},
+		lastActive: now,
+	}
+	s.dir = filepath.Join(st.dir, s.ID.String())
+	if err := os.Mkdir(s.dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.sessions[s.ID] = s
+	return s, nil
+}
+
+// get returns nil if there is no session with that ID, including one that
+// has expired but not yet been swept.
+func (st *uploadSessionStore) get(id uuid.UUID) *uploadSession {
+	st.mu.Lock()
+	s := st.sessions[id]
+	st.mu.Unlock()
+
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.expired || s.idle(st.now()) {
+		return nil
+	}
+	return s
+}
+
+// remove forgets the session and deletes its parts.
+func (st *uploadSessionStore) remove(id uuid.UUID) {
+	st.mu.Lock()
+	s := st.sessions[id]
+	delete(st.sessions, id)
+	st.mu.Unlock()
+
+	if s != nil {
+		os.RemoveAll(s.dir)
+	}
+}
+
+// claimInFlight marks the user's upload of the content with the given hex
+// SHA-256 as processing. It returns false if an identical upload already
+// is; otherwise release must be called once processing is over.
+func (st *uploadSessionStore) claimInFlight(userID uuid.UUID, contentHash string) (release func(), ok bool) {
+	key := inFlightUpload{userID: userID, sha256: contentHash}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.inFlight[key] {
+		return nil, false
+	}
+	st.inFlight[key] = true
+	return func() {
+		st.mu.Lock()
+		defer st.mu.Unlock()
+		delete(st.inFlight, key)
+	}, true
+}
+
+// run sweeps expired sessions until the process exits. Without a ttl
+// nothing expires, so there's nothing to do.
+func (st *uploadSessionStore) run() {
+	if st.ttl <= 0 {
+		return
+	}
+	ticker := time.NewTicker(min(st.ttl, time.Minute))
+	defer ticker.Stop()
+	for range ticker.C {
+		st.sweep()
+	}
+}
+
+func (st *uploadSessionStore) sweep() {
+	now := st.now()
+	var expired []*uploadSession
+	st.mu.Lock()
+	for id, s := range st.sessions {
+		if s.expire(now) {
+			delete(st.sessions, id)
+			expired = append(expired, s)
+		}
+	}
+	st.mu.Unlock()
+
+	for _, s := range expired {
+		if err := os.RemoveAll(s.dir); err != nil {
+			log.Printf("warning: couldn't remove expired upload %s: %v", s.ID, err)
+		}
+	}
+	if len(expired) > 0 {
+		log.Printf("Expired %d abandoned upload session(s)", len(expired))
+	}
+}
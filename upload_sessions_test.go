@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestUploadSessionStoreConcurrent(t *testing.T) {
+	st, err := newUploadSessionStore(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const clients, parts = 8, 5
+	var wg sync.WaitGroup
+	sessions := make([]*uploadSession, clients)
+	for i := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s, err := st.create(uploadProtocolParts, uuid.New(), uuid.New(), "video/mp4", "clip.mp4", 0)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			sessions[i] = s
+			var parallel sync.WaitGroup
+			for n := 1; n <= parts; n++ {
+				parallel.Add(1)
+				go func() {
+					defer parallel.Done()
+					if _, err := st.get(s.ID).writePart(n, strings.NewReader("abc")); err != nil {
+						t.Error(err)
+					}
+				}()
+			}
+			parallel.Wait()
+		}()
+	}
+	// Sweeping alongside must not disturb sessions that are in use.
+	stop := make(chan struct{})
+	swept := make(chan struct{})
+	go func() {
+		defer close(swept)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				st.sweep()
+			}
+		}
+	}()
+	wg.Wait()
+	close(stop)
+	<-swept
+	if t.Failed() {
+		return
+	}
+
+	for _, s := range sessions {
+		if st.get(s.ID) == nil {
+			t.Fatalf("session %s went missing", s.ID)
+		}
+		p := s.progress()
+		if len(p.CompletedParts) != parts || p.ReceivedBytes != parts*3 {
+			t.Errorf("session %s has parts %v and %d bytes, want %d parts of 3", s.ID, p.CompletedParts, p.ReceivedBytes, parts)
+		}
+	}
+}
+
+func TestUploadSessionStoreExpiry(t *testing.T) {
+	dir := t.TempDir()
+	// Left behind by an earlier process.
+	leftover := filepath.Join(dir, uuid.NewString())
+	if err := os.Mkdir(leftover, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	unrelated := filepath.Join(dir, "keep-me")
+	if err := os.Mkdir(unrelated, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := newUploadSessionStore(dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+		t.Errorf("orphaned session dir survived startup: %v", err)
+	}
+	if _, err := os.Stat(unrelated); err != nil {
+		t.Errorf("unrelated dir was removed: %v", err)
+	}
+
+	now := time.Now()
+	st.now = func() time.Time { return now }
+	abandoned, err := st.create(uploadProtocolParts, uuid.New(), uuid.New(), "video/mp4", "a.mp4", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := abandoned.writePart(1, strings.NewReader("abc")); err != nil {
+		t.Fatal(err)
+	}
+	active, err := st.create(uploadProtocolParts, uuid.New(), uuid.New(), "video/mp4", "b.mp4", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(50 * time.Minute)
+	if _, err := active.writePart(1, strings.NewReader("abc")); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(10 * time.Minute)
+	if st.get(abandoned.ID) != nil {
+		t.Error("get returned a session idle for the whole ttl")
+	}
+	st.sweep()
+	if _, err := os.Stat(abandoned.dir); !os.IsNotExist(err) {
+		t.Errorf("expired session's parts weren't removed: %v", err)
+	}
+	if _, err := abandoned.writePart(2, strings.NewReader("abc")); err == nil {
+		t.Error("wrote to an expired session")
+	}
+	if st.get(active.ID) == nil {
+		t.Fatal("session with recent activity expired")
+	}
+
+	// A write that outlasts the ttl keeps its session alive.
+	release, done := make(chan struct{}), make(chan error)
+	go func() {
+		_, err := active.writePart(2, readerFunc(func(p []byte) (int, error) {
+			<-release
+			return 0, os.ErrClosed
+		}))
+		done <- err
+	}()
+	for {
+		active.mu.Lock()
+		writing := active.writers > 0
+		active.mu.Unlock()
+		if writing {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	now = now.Add(2 * time.Hour)
+	st.sweep()
+	if st.get(active.ID) == nil {
+		t.Error("session expired mid-write")
+	}
+	close(release)
+	<-done
+	now = now.Add(2 * time.Hour)
+	st.sweep()
+	if st.get(active.ID) != nil {
+		t.Error("session didn't expire once the write finished")
+	}
+}
+
+func TestUploadSessionStoreNoTTL(t *testing.T) {
+	st, err := newUploadSessionStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	st.now = func() time.Time { return now }
+	s, err := st.create(uploadProtocolParts, uuid.New(), uuid.New(), "video/mp4", "clip.mp4", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(365 * 24 * time.Hour)
+	st.sweep()
+	if st.get(s.ID) == nil {
+		t.Error("session expired without a ttl")
+	}
+	if s.progress().ExpiresAt != nil {
+		t.Error("session without a ttl reports an expiry")
+	}
+	// Returns at once rather than ticking forever.
+	st.run()
+}
+
+type readerFunc func([]byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
+
+func TestHandlerUploadCompleteConcurrent(t *testing.T) {
+	cfg, _, ffmpeg := newTestConfig(t)
+	user, token := createTestUser(t, cfg)
+	video := createTestVideo(t, cfg, user.ID)
+	session, err := cfg.uploads.create(uploadProtocolParts, video.ID, user.ID, "video/mp4", "clip.mp4", int64(len(testMP4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.writePart(1, bytes.NewReader(testMP4)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hold the first completion in ffmpeg until the second has been
+	// answered.
+	processing, proceed := make(chan struct{}), make(chan struct{})
+	var held atomic.Bool
+	cfg.ffmpeg.runner = func(cmd *exec.Cmd) error {
+		if commandKind(cmd.Args) == "ffmpeg" && held.CompareAndSwap(false, true) {
+			close(processing)
+			<-proceed
+		}
+		return ffmpeg.run(cmd)
+	}
+
+	complete := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/uploads/"+session.ID.String()+"/complete", nil)
+		r.SetPathValue("uploadID", session.ID.String())
+		r.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		cfg.handlerUploadComplete(rec, r)
+		return rec
+	}
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- complete() }()
+	<-processing
+	if rec := complete(); rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "duplicate upload in progress") {
+		t.Errorf("second completion: status = %d, want 409: %s", rec.Code, rec.Body)
+	}
+	close(proceed)
+	if rec := <-first; rec.Code != http.StatusOK {
+		t.Fatalf("first completion: status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if n := ffmpeg.ran("ffmpeg"); n != 1 {
+		t.Errorf("ffmpeg ran %d times, want the file processed once", n)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// parseVariantHeights reads the resolutions, as heights in pixels, that
+// videos are additionally rendered at.
+func parseVariantHeights(key string, entries []string) []int {
+	heights := []int{}
+	for _, entry := range entries {
+		h, err := strconv.Atoi(entry)
+		if err != nil || h < 2 || h%2 != 0 {
+			log.Fatalf("%s entry %q must be an even height in pixels, like 720", key, entry)
+		}
+		heights = append(heights, h)
+	}
+	slices.Sort(heights)
+	return slices.Compact(heights)
+}
+
+// renderVariants encodes the video at inputPath at each configured height
+// below sourceHeight, since a rendition at or above the source would only
+// be bigger, and uploads them to target under variants/. Variants are
+// always H.264 MP4 so any player can switch between them. A variant that
+// fails is logged and left out; the video is fine without it.
+func (cfg *apiConfig) renderVariants(ctx context.Context, target *apiConfig, inputPath string, sourceHeight int, baseName string) database.VariantList {
+	variants := database.VariantList{}
+	for _, height := range cfg.transcode.variants {
+		if height >= sourceHeight || ctx.Err() != nil {
+			break
+		}
+		url, err := cfg.renderVariant(ctx, target, inputPath, height, baseName)
+		if err != nil {
+			log.Printf("warning: skipping %dp variant: %v", height, err)
+			continue
+		}
+		variants = append(variants, database.VideoVariant{Height: height, URL: url})
+	}
+	return variants
+}
+
+func (cfg *apiConfig) renderVariant(ctx context.Context, target *apiConfig, inputPath string, height int, baseName string) (string, error) {
+	outputPath := fmt.Sprintf("%s.%dp.mp4", inputPath, height)
+
+	args := []string{
+		"-y",
+		"-i", inputPath,
+		"-c:v", "libx264",
+		"-preset", "medium",
+		"-crf", "23",
+		// -2 keeps the width even as x264 requires.
+		"-vf", fmt.Sprintf("scale=-2:%d", height),
+		"-c:a", "aac",
+		"-b:a", "128k",
+		"-movflags", "faststart",
+	}
+	args = append(args, cfg.ffmpeg.outputArgs()...)
+	args = append(args, "-f", "mp4", outputPath)
+	if err := cfg.ffmpeg.run(ctx, exec.CommandContext(ctx, "ffmpeg", args...)); err != nil {
+		os.Remove(outputPath)
+		return "", fmt.Errorf("ffmpeg variant encode failed: %w", err)
+	}
+	defer cfg.tempFiles.remove(outputPath)
+
+	key := fmt.Sprintf("%svariants/%s-%dp.mp4", cfg.s3KeyPrefix, baseName, height)
+	return target.uploadFileToS3(ctx, outputPath, key, "video/mp4", ifAbsent)
+}
+
+// variantURLs returns pointers to the URLs of the video's variants, for
+// code that handles all of a video's stored objects.
+func variantURLs(video database.Video) []*string {
+	urls := make([]*string, len(video.Variants))
+	for i := range video.Variants {
+		urls[i] = &video.Variants[i].URL
+	}
+	return urls
+}
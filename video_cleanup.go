@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// s3DeleteObjectsMax is the most keys one DeleteObjects call accepts.
+const s3DeleteObjectsMax = 1000
+
+// deleteVideoObjects removes everything stored for a deleted video: the
+// video and its original from the video's bucket, its clips, and the
+// thumbnail, preview and contact sheet from S3 or the assets dir along with
+// any thumbnail variants we generated. Objects that are already gone are fine.
+// Failures are only logged, since the orphan cleanup catches whatever is
+// left behind.
+func (cfg *apiConfig) deleteVideoObjects(ctx context.Context, video database.Video) {
+	cfg.deleteVideosObjects(ctx, []database.Video{video})
+}
+
+// deleteVideosObjects is deleteVideoObjects for many videos at once, with
+// one DeleteObjects call per bucket and batch of keys instead of a call per
+// object.
+func (cfg *apiConfig) deleteVideosObjects(ctx context.Context, videos []database.Video) {
+	stores := map[string]*apiConfig{}
+	keys := map[string][]string{}
+	addKey := func(store *apiConfig, key string) {
+		stores[store.s3Bucket] = store
+		keys[store.s3Bucket] = append(keys[store.s3Bucket], key)
+	}
+	add := func(store *apiConfig, url *string) {
+		if url == nil {
+			return
+		}
+		key, err := store.s3KeyFromURL(*url)
+		if err != nil {
+			return
+		}
+		addKey(store, key)
+	}
+
+	for _, video := range videos {
+		videoStore := cfg.forVideo(video)
+		add(videoStore, video.VideoURL)
+		add(videoStore, video.OriginalURL)
+		add(videoStore, video.HLSURL)
+		for _, url := range variantURLs(video) {
+			add(videoStore, url)
+		}
+
+		for _, url := range []*string{video.ThumbnailURL, video.PreviewURL, video.ContactSheetURL, video.ThumbnailsVTTURL} {
+			if url == nil {
+				continue
+			}
+			if path, ok := cfg.assetPathFromURL(*url); ok {
+				cfg.removeAsset(path)
+				continue
+			}
+			add(cfg, url)
+		}
+
+		// Clips aren't recorded on the video, so they're found by prefix.
+		clipKeys, err := cfg.listKeys(ctx, cfg.clipPrefix(video.ID))
+		if err != nil {
+			log.Printf("warning: listing clips of video %s: %v", video.ID, err)
+		}
+		for _, key := range clipKeys {
+			addKey(cfg, key)
+		}
+	}
+
+	for bucket, bucketKeys := range keys {
+		if err := stores[bucket].deleteObjectKeys(ctx, bucketKeys); err != nil {
+			log.Printf("warning: deleting objects from %s: %v", bucket, err)
+		}
+	}
+}
+
+// deleteObjectKeys deletes keys from the bucket in as few requests as S3
+// allows, returning the first failure after trying every key.
+func (cfg *apiConfig) deleteObjectKeys(ctx context.Context, keys []string) error {
+	var firstErr error
+	for start := 0; start < len(keys); start += s3DeleteObjectsMax {
+		batch := keys[start:min(start+s3DeleteObjectsMax, len(keys))]
+		objects := make([]types.ObjectIdentifier, len(batch))
+		for i, key := range batch {
+			objects[i] = types.ObjectIdentifier{Key: aws.String(key)}
+		}
+
+		out, err := cfg.s3Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: &cfg.s3Bucket,
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		for _, e := range out.Errors {
+			log.Printf("warning: couldn't delete %s: %s", aws.ToString(e.Key), aws.ToString(e.Message))
+		}
+	}
+	return firstErr
+}
+
+// listKeys returns the keys in the bucket that start with prefix.
+func (cfg *apiConfig) listKeys(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	paginator := s3.NewListObjectsV2Paginator(cfg.s3Client, &s3.ListObjectsV2Input{
+		Bucket: &cfg.s3Bucket,
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return keys, err
+		}
+		for _, obj := range page.Contents {
+			keys = append(keys, aws.ToString(obj.Key))
+		}
+	}
+	return keys, nil
+}
+
+// removeAsset deletes a local thumbnail and the variants generated from it.
+func (cfg *apiConfig) removeAsset(path string) {
+	paths := []string{path}
+	for _, variant := range thumbnailVariants {
+		paths = append(paths, variantFilePath(path, variant))
+	}
+	for _, p := range paths {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("warning: couldn't remove asset %s: %v", p, err)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+var (
+	errVideoIDMalformed = errors.New("video ID isn't a UUID")
+	errVideoIDNil       = errors.New("video ID is the nil UUID, which no video has")
+)
+
+// parseVideoID validates a video ID taken from a request. Every handler
+// goes through it so a bad ID gets the same message wherever it's sent.
+// The errors are safe to show to the client. With VIDEO_ID_VERSION set,
+// IDs of any other UUID version are rejected too.
+func (cfg *apiConfig) parseVideoID(s string) (uuid.UUID, error) {
+	id, err := uuid.Parse(s)
+	if err != nil {
+		return uuid.Nil, errVideoIDMalformed
+	}
+	if id == uuid.Nil {
+		return uuid.Nil, errVideoIDNil
+	}
+	if cfg.videoIDVersion != 0 && int(id.Version()) != cfg.videoIDVersion {
+		return uuid.Nil, fmt.Errorf("video ID is a version %d UUID, but video IDs are version %d", id.Version(), cfg.videoIDVersion)
+	}
+	return id, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestParseVideoID(t *testing.T) {
+	v4 := uuid.New()
+	v7 := uuid.Must(uuid.NewV7())
+
+	tests := []struct {
+		name    string
+		version int
+		input   string
+		want    uuid.UUID
+		wantErr error
+	}{
+		{"v4", 0, v4.String(), v4, nil},
+		{"v7", 0, v7.String(), v7, nil},
+		{"uppercase", 0, "9B2B6E7A-3F4C-4D7E-8A1B-2C3D4E5F6A7B", uuid.MustParse("9b2b6e7a-3f4c-4d7e-8a1b-2c3d4e5f6a7b"), nil},
+		{"nil", 0, "00000000-0000-0000-0000-000000000000", uuid.Nil, errVideoIDNil},
+		{"nil with a version required", 4, "00000000-0000-0000-0000-000000000000", uuid.Nil, errVideoIDNil},
+		{"empty", 0, "", uuid.Nil, errVideoIDMalformed},
+		{"not hex", 0, "not-a-uuid", uuid.Nil, errVideoIDMalformed},
+		{"truncated", 0, v4.String()[:35], uuid.Nil, errVideoIDMalformed},
+		{"trailing garbage", 0, v4.String() + "x", uuid.Nil, errVideoIDMalformed},
+		{"required version", 4, v4.String(), v4, nil},
+		{"wrong version", 4, v7.String(), uuid.Nil, errors.New("video ID is a version 7 UUID, but video IDs are version 4")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &apiConfig{videoIDVersion: tt.version}
+			got, err := cfg.parseVideoID(tt.input)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else if err == nil || err.Error() != tt.wantErr.Error() {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("id = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerVideoGetInvalidID(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	tests := []struct {
+		id   string
+		want error
+	}{
+		{"00000000-0000-0000-0000-000000000000", errVideoIDNil},
+		{"abc", errVideoIDMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/videos/"+tt.id, nil)
+			req.SetPathValue("videoID", tt.id)
+			rec := httptest.NewRecorder()
+			cfg.handlerVideoGet(rec, req)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400", rec.Code)
+			}
+			var body struct {
+				Error string `json:"error"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Error != tt.want.Error() {
+				t.Errorf("error = %q, want %q", body.Error, tt.want)
+			}
+		})
+	}
+}
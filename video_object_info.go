@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// loadVideoObjectInfo sets the video's cached ETag and Last-Modified from a
+// HeadObject of its video file, for clients and caches to validate against.
+// A missing object clears them. Other failures are logged and leave them
+// unset, since the file itself is fine; they're filled in on a later read.
+func (cfg *apiConfig) loadVideoObjectInfo(ctx context.Context, video *database.Video) {
+	video.VideoETag, video.VideoModifiedAt = nil, nil
+	if video.VideoURL == nil {
+		return
+	}
+	key, err := cfg.s3KeyFromURL(*video.VideoURL)
+	if err != nil {
+		return
+	}
+	store := cfg.forVideo(*video)
+	head, err := store.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &store.s3Bucket,
+		Key:    &key,
+	})
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return
+	}
+	if err != nil {
+		log.Printf("warning: couldn't read object info of video %s: %v", video.ID, err)
+		return
+	}
+	video.VideoETag = head.ETag
+	video.VideoModifiedAt = head.LastModified
+}
+
+// ensureVideoObjectInfo fills in the object info of videos uploaded before
+// it was recorded, and caches it so later reads don't need a HeadObject.
+// Videos flagged as missing their storage are left alone.
+func (cfg *apiConfig) ensureVideoObjectInfo(ctx context.Context, video *database.Video) {
+	if video.VideoURL == nil || video.VideoETag != nil || video.StorageMissing {
+		return
+	}
+	cfg.loadVideoObjectInfo(ctx, video)
+	if video.VideoETag == nil {
+		return
+	}
+	if err := cfg.db.SetVideoObjectInfo(ctx, video.ID, video.VideoETag, video.VideoModifiedAt); err != nil {
+		log.Printf("warning: couldn't cache object info of video %s: %v", video.ID, err)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	viewDebounceWindow = 30 * time.Minute
+	viewFlushInterval  = 10 * time.Second
+)
+
+// viewCounter collects views in memory and writes them to the db in batches,
+// so recording a view never blocks playback and repeated hits from the same
+// session inside the debounce window only count once.
+type viewCounter struct {
+	db      database.Client
+	window  time.Duration
+	mu      sync.Mutex
+	pending map[uuid.UUID]int64
+	seen    map[string]time.Time
+}
+
+func newViewCounter(db database.Client, window time.Duration) *viewCounter {
+	return &viewCounter{
+		db:      db,
+		window:  window,
+		pending: map[uuid.UUID]int64{},
+		seen:    map[string]time.Time{},
+	}
+}
+
+func (vc *viewCounter) record(videoID uuid.UUID, session string) {
+	key := videoID.String() + "|" + session
+	now := time.Now()
+
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	if last, ok := vc.seen[key]; ok && now.Sub(last) < vc.window {
+		return
+	}
+	vc.seen[key] = now
+	vc.pending[videoID]++
+}
+
+func (vc *viewCounter) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		vc.flush()
+	}
+}
+
+func (vc *viewCounter) flush() {
+	vc.mu.Lock()
+	batch := vc.pending
+	vc.pending = map[uuid.UUID]int64{}
+	now := time.Now()
+	for key, last := range vc.seen {
+		if now.Sub(last) >= vc.window {
+			delete(vc.seen, key)
+		}
+	}
+	vc.mu.Unlock()
+
+	for videoID, count := range batch {
+		if err := vc.db.IncrementViewCount(videoID, count); err != nil {
+			log.Printf("failed to flush %d views for video %s: %v", count, videoID, err)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// watermarkConfig overlays a logo on every published video. Since that
+// means re-encoding instead of a stream copy, it's off unless an image is
+// configured.
+type watermarkConfig struct {
+	// image is the default watermark; empty disables watermarking.
+	image string
+	// userDir may hold <userID>.png files that replace the default for
+	// that user's videos.
+	userDir  string
+	position string
+	opacity  float64
+	margin   int
+}
+
+// watermarkPositions maps a position to overlay's x:y expression, where
+// W/H is the video size, w/h the watermark size and M the margin.
+var watermarkPositions = map[string]string{
+	"top-left":     "M:M",
+	"top-right":    "W-w-M:M",
+	"bottom-left":  "M:H-h-M",
+	"bottom-right": "W-w-M:H-h-M",
+	"center":       "(W-w)/2:(H-h)/2",
+}
+
+// validate checks the settings and that the default image can be read
+// and is an image.
+func (wc watermarkConfig) validate() error {
+	if wc.image == "" {
+		return nil
+	}
+	if _, ok := watermarkPositions[wc.position]; !ok {
+		return fmt.Errorf("unknown WATERMARK_POSITION %q", wc.position)
+	}
+	if wc.opacity <= 0 || wc.opacity > 1 {
+		return fmt.Errorf("WATERMARK_OPACITY must be in (0, 1], got %v", wc.opacity)
+	}
+	return checkWatermarkImage(wc.image)
+}
+
+func checkWatermarkImage(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("couldn't open watermark image: %w", err)
+	}
+	defer f.Close()
+	header, err := readHeader(f)
+	if err != nil {
+		return fmt.Errorf("couldn't read watermark image: %w", err)
+	}
+	if mediaType := sniffMediaType(header); !strings.HasPrefix(mediaType, "image/") {
+		return fmt.Errorf("watermark %s is %s, not an image", path, mediaType)
+	}
+	return nil
+}
+
+// imageFor returns the watermark to use for userID's videos.
+func (wc watermarkConfig) imageFor(userID uuid.UUID) string {
+	if wc.userDir != "" {
+		path := filepath.Join(wc.userDir, userID.String()+".png")
+		if checkWatermarkImage(path) == nil {
+			return path
+		}
+	}
+	return wc.image
+}
+
+// watermarkIfNeeded overlays the watermark on the video at filePath. It
+// returns the path of the watermarked file, or "" when watermarking is
+// off.
+func (cfg *apiConfig) watermarkIfNeeded(ctx context.Context, filePath string, userID uuid.UUID) (string, error) {
+	if cfg.watermark.image == "" {
+		return "", nil
+	}
+	image := cfg.watermark.imageFor(userID)
+
+	position := strings.ReplaceAll(watermarkPositions[cfg.watermark.position], "M", strconv.Itoa(cfg.watermark.margin))
+	filter := fmt.Sprintf("[1:v]format=rgba,colorchannelmixer=aa=%s[wm];[0:v][wm]overlay=%s",
+		strconv.FormatFloat(cfg.watermark.opacity, 'f', -1, 64), position)
+
+	outputPath := filePath + ".watermarked"
+	args := []string{
+		"-y",
+		"-i", filePath,
+		"-i", image,
+		"-filter_complex", filter,
+		"-c:v", "libx264",
+		"-preset", "medium",
+		"-crf", "20",
+		"-c:a", "copy",
+	}
+	args = append(args, cfg.ffmpeg.outputArgs()...)
+	args = append(args, "-f", "mp4", outputPath)
+
+	if err := cfg.ffmpeg.run(ctx, exec.CommandContext(ctx, "ffmpeg", args...)); err != nil {
+		os.Remove(outputPath)
+		return "", fmt.Errorf("ffmpeg watermark failed: %w", err)
+	}
+	return outputPath, nil
+}